@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Morning list of employees without events on a working day, from the
+// absences maintained by every run
+func absenceReportCommand(args []string) {
+	fs := flag.NewFlagSet("report absences", flag.ExitOnError)
+	dayFlag := fs.String("day", "", "day to report, YYYY-MM-DD, today if empty")
+	division := fs.String("division", "", "only this division, all if empty")
+	out := fs.String("out", "", "output CSV file, stdout if empty")
+	notify := fs.Bool("notify", false, "also send the list to the notification channel")
+	fs.Parse(args)
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	if *dayFlag != "" {
+		var err error
+		day, err = time.Parse("2006-01-02", *dayFlag)
+		if err != nil {
+			log.Fatalf("invalid -day: %v", err)
+		}
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	absences, err := db.AbsencesOn(*division, day)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := infra.WriteAbsenceCSV(w, absences); err != nil {
+		log.Fatalln(err)
+	}
+	if err := finishReport(w, *out, day); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *notify {
+		if err := infra.NewNotifierFromEnv().NotifyAbsences(day, absences); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	log.Printf("%d absent on %s", len(absences), day.Format("2006-01-02"))
+}
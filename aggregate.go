@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Merges several site databases into the warehouse tables of the destination
+func aggregateCommand(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	spec := fs.String("sites", os.Getenv("AGGREGATE_SITES"), "site=dsn pairs separated by semicolons, dsn is a Postgres connection string or sqlite://path")
+	months := fs.Int("months", 2, "months of events and intervals merged again every time, a backfill further back needs a longer window")
+	batch := fs.Int("batch", infra.DefaultStreamBatchSize, "rows merged per statement")
+	fs.Parse(args)
+
+	sites, err := infra.ParseSiteSpecs(*spec)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(sites) == 0 {
+		log.Fatalln("no sites to aggregate, set -sites or AGGREGATE_SITES")
+	}
+	names := make([]string, 0, len(sites))
+	for site := range sites {
+		names = append(names, site)
+	}
+	sort.Strings(names)
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	since := time.Now().AddDate(0, -*months, 0)
+	var failed int
+	for _, site := range names {
+		// one unreachable site doesn't hold back the others
+		src, err := infra.OpenSiteSource(site, sites[site])
+		if err != nil {
+			log.Printf("error: %v", err)
+			failed++
+			continue
+		}
+		result, err := db.AggregateSite(src, since, *batch)
+		src.Close()
+		if err != nil {
+			log.Printf("error: %v", err)
+			failed++
+			continue
+		}
+		log.Printf("site %s: %d employees, %d events, %d intervals merged", site, result.Employees, result.Events, result.Intervals)
+	}
+
+	collisions, err := db.SiteCardCollisions()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, c := range collisions {
+		log.Printf("warning: card %s belongs to different people at %s: %s", c.Card, c.Sites, c.Names)
+	}
+
+	if failed > 0 {
+		log.Fatalf("%d of %d sites failed", failed, len(names))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Per-card usage report for the periodic badge audit
+func badgeReportCommand(args []string) {
+	fs := flag.NewFlagSet("report badges", flag.ExitOnError)
+	days := fs.Int("days", 90, "count days the card was used within the last n days")
+	dormantDays := fs.Int("dormant-days", 30, "flag cards not used for n days as dormant")
+	out := fs.String("out", "", "output CSV file, stdout if empty")
+	fs.Parse(args)
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	usage, err := db.BadgeUsageSince(now.AddDate(0, 0, -*days))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	dormantSince := now.AddDate(0, 0, -*dormantDays)
+	if err := infra.WriteBadgeUsageCSV(w, usage, dormantSince); err != nil {
+		log.Fatalln(err)
+	}
+	if err := finishReport(w, *out, now); err != nil {
+		log.Fatalln(err)
+	}
+
+	dormant := 0
+	for _, b := range usage {
+		if b.Dormant(dormantSince) {
+			dormant++
+		}
+	}
+	log.Printf("%d cards, %d dormant for more than %d days", len(usage), dormant, *dormantDays)
+}
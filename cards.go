@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// import-cards -file cards.csv [-replace] loads the additional cards of
+// employees whose events the next run merges into theirs
+func importCardsCommand(args []string) {
+	fs := flag.NewFlagSet("import-cards", flag.ExitOnError)
+	file := fs.String("file", "", "CSV with employee_card, card, valid_from and valid_to columns")
+	replace := fs.Bool("replace", false, "delete stored assignments, including those of merged employees, before importing")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalln("-file is required")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	assignments, err := infra.ReadCardAssignmentsCSV(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.ImportCardAssignments(assignments, *replace); err != nil {
+		log.Fatalln(err)
+	}
+	imported := 0
+	for _, cards := range assignments {
+		imported += len(cards)
+	}
+	log.Printf("%d card assignments of %d employees imported, they apply from the next run", imported, len(assignments))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// Every command loads .env before parsing its own flags, so flag defaults may
+// come from the environment
+var commands = []command{
+	{"run", "extract, transform and load once, or on a schedule with -daemon", runCommand},
+	{"backfill", "reload a longer history even if the source is unchanged", backfillCommand},
+	{"report", "write timesheet, badges, absences or roster reports, or mail them", reportCommand},
+	{"export", "write events and intervals as Parquet files for the data lake", exportCommand},
+	{"serve", "serve the JSON API and dashboard", serveCommand},
+	{"verify", "compare source and destination event counts", verifyCommand},
+	{"inspect", "list the MDB tables and guess a schema profile for them", inspectCommand},
+	{"migrate", "apply or list destination schema migrations", migrateCommand},
+	{"retention", "archive and delete rows older than the retention policy", retentionCommand},
+	{"reconstruct", "rebuild intervals as a past run formed them", reconstructCommand},
+	{"push", "forward a SQLite destination to the central Postgres", pushCommand},
+	{"aggregate", "merge site databases into the warehouse tables", aggregateCommand},
+	{"mqtt-ingest", "spool turnstile events received over MQTT", mqttIngestCommand},
+	{"import-mapping", "load canonical employee names per card from a CSV", importMappingCommand},
+	{"import-cards", "load additional cards of employees with their validity from a CSV", importCardsCommand},
+	{"import-roster", "load planned shifts per employee and day from a CSV or XLSX", importRosterCommand},
+	{"import-leaves", "load vacations and sick leaves from a CSV or 1C extract", importLeavesCommand},
+	{"import-legacy", "load paired intervals exported from the old attendance system", importLegacyCommand},
+	{"merge-employees", "fold a duplicate employee record into the canonical one", mergeEmployeesCommand},
+	{"onboard-division", "prepare the destination for a new division", onboardDivision},
+}
+
+var reportCommands = []command{
+	{"timesheet", "monthly per-day timesheet as XLSX or PDF", timesheetCommand},
+	{"badges", "per-card usage for the badge audit", badgeReportCommand},
+	{"absences", "employees without events on a working day", absenceReportCommand},
+	{"roster", "planned against worked hours per employee and day", rosterReportCommand},
+	{"mail", "mail the monthly timesheet and absences to department managers", mailReportsCommand},
+}
+
+// Command names from before the report group, kept for existing schedules
+var commandAliases = map[string][]string{
+	"timesheet":      {"report", "timesheet"},
+	"badge-report":   {"report", "badges"},
+	"absence-report": {"report", "absences"},
+}
+
+func findCommand(list []command, name string) (command, bool) {
+	for _, c := range list {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printCommands(prefix string, list []command) {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", prefix)
+	for _, c := range list {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun %s <command> -h for the flags of a command\n", prefix)
+}
+
+// report timesheet|badges|absences|roster|mail [flags]
+func reportCommand(args []string) {
+	if len(args) == 0 {
+		printCommands(filepath.Base(os.Args[0])+" report", reportCommands)
+		os.Exit(2)
+	}
+	c, ok := findCommand(reportCommands, args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown report: %s\n\n", args[0])
+		printCommands(filepath.Base(os.Args[0])+" report", reportCommands)
+		os.Exit(2)
+	}
+	c.run(args[1:])
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Runs the ETL on a schedule. SIGHUP, POST /sync-now or a change of the MDB file
+// with watch set trigger an immediate out-of-schedule sync, triggers arriving
+// during a sync are coalesced into one. Cancelling ctx lets the sync in progress
+// wind down and stops the daemon.
+func runDaemon(ctx context.Context, interval time.Duration, listen string, watch, settle time.Duration, notifier *infra.Notifier) {
+	trigger := make(chan string, 1)
+	requestSync := func(reason string) {
+		select {
+		case trigger <- reason:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			requestSync("SIGHUP")
+		}
+	}()
+
+	if watch > 0 {
+		path := os.Getenv("ACCESS_MDB_PATH")
+		log.Printf("watching %s every %s", path, watch)
+		go watchFile(path, watch, settle, func() { requestSync("MDB file change") })
+	}
+
+	feed := newPresenceFeed()
+	if listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/presence/changes", feed.handleChanges)
+		mux.HandleFunc("/sync-now", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			requestSync("POST /sync-now")
+			w.WriteHeader(http.StatusAccepted)
+		})
+		srv := &http.Server{Addr: listen, Handler: mux}
+		go func() {
+			log.Printf("daemon http listening on %s", listen)
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatalln(err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("daemon started, syncing every %s", interval)
+	requestSync("startup")
+	for {
+		select {
+		case reason := <-trigger:
+			log.Printf("sync triggered by %s", reason)
+		case <-ticker.C:
+			log.Println("scheduled sync")
+		case <-ctx.Done():
+			log.Println("daemon stopped")
+			return
+		}
+		if summary, ok := syncOnce(ctx, notifier); ok {
+			feed.update(summary.OnSite)
+		}
+	}
+}
+
+// A failed sync is reported but doesn't stop the daemon
+func syncOnce(ctx context.Context, notifier *infra.Notifier) (infra.RunSummary, bool) {
+	summary, err := runETL(ctx)
+	if ctx.Err() != nil {
+		// shutting down is not a failure worth alerting about
+		log.Printf("sync stopped: %v", err)
+		return summary, false
+	}
+	if werr := deliverWebhooks(); werr != nil {
+		// the payloads stay queued for the next sync
+		log.Printf("webhooks not delivered: %v", werr)
+	}
+	if err != nil || !summary.Skipped {
+		if perr := publishRun(summary, err, exitCode(err)); perr != nil {
+			log.Printf("error publishing run summary to nats: %v", perr)
+		}
+	}
+	if err != nil {
+		log.Printf("sync failed: %v", err)
+		if nerr := notifier.NotifyFailure(os.Getenv("CONTROLLER_DIVISION_NAME"), err); nerr != nil {
+			log.Printf("error sending failure notification: %v", nerr)
+		}
+		return summary, false
+	}
+	if summary.Skipped {
+		log.Println("MDB file unchanged, sync skipped")
+		return summary, false
+	}
+	if err := notifier.NotifySummary(summary); err != nil {
+		log.Printf("error sending run summary: %v", err)
+	}
+	log.Println("sync completed successfully")
+	return summary, true
+}
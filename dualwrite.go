@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+	database "github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+const mirrorEnvPrefix = "DUALWRITE_POSTGRES_"
+
+// Secondary destination receiving the same loads as the primary one during
+// a warehouse migration. Its failures are reported but never fail the run,
+// after the first failure it is skipped for the rest of the run.
+type mirror struct {
+	db *database.Repository
+	// users and events are loaded concurrently
+	mu  sync.Mutex
+	err error
+}
+
+// Nil when DUALWRITE_POSTGRES_HOST is not configured
+func connectMirror() (*mirror, error) {
+	if os.Getenv(mirrorEnvPrefix+"HOST") == "" {
+		return nil, nil
+	}
+
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	config, err := infra.PostgresConfigFromEnvPrefix(mirrorEnvPrefix, secrets)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := config.DSN()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := connectNamed(dsn)
+	if err != nil {
+		// an unreachable mirror is a failed destination, not a failed run
+		log.Printf("dual-write destination unavailable: %v", err)
+		return &mirror{err: fmt.Errorf("connecting: %w", err)}, nil
+	}
+	log.Println("dual-write destination connection established")
+	return &mirror{db: db}, nil
+}
+
+func (m *mirror) write(stage string, fn func(db *database.Repository) error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	if err := fn(m.db); err != nil {
+		m.err = fmt.Errorf("%s: %w", stage, err)
+		log.Printf("dual-write destination failed at %s: %v", stage, err)
+	}
+}
+
+func (m *mirror) close() {
+	if m != nil && m.db != nil {
+		m.db.Close()
+	}
+}
+
+func (m *mirror) results() []infra.DestinationResult {
+	if m == nil {
+		return nil
+	}
+	secondary := infra.DestinationResult{Name: "secondary"}
+	if m.err != nil {
+		secondary.Error = m.err.Error()
+	}
+	return []infra.DestinationResult{{Name: "primary"}, secondary}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Sync for sites without Postgres: employees, events and intervals go to the
+// local SQLite file and reach the central destination with push. Anomalies,
+// presence and the other derived tables are only built centrally.
+func runEdgeETL(ctx context.Context) (summary infra.RunSummary, err error) {
+	summary = infra.RunSummary{
+		Division: os.Getenv("CONTROLLER_DIVISION_NAME"),
+		Started:  time.Now(),
+	}
+
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		return summary, infra.ValidationFailure(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		return summary, infra.ValidationFailure(err)
+	}
+
+	store, err := infra.OpenSQLite(os.Getenv("SQLITE_PATH"))
+	if err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+	defer store.Close()
+
+	exporter, err := newExporter(os.Getenv("ACCESS_MDB_PATH"))
+	if err != nil {
+		return summary, infra.SourceFailure(err)
+	}
+	exporter.SetArchiveDir(os.Getenv("EVENT_ARCHIVE_DIR"))
+
+	users, err := exporter.ExportUsersFromDB()
+	if err != nil {
+		return summary, infra.SourceFailure(fmt.Errorf("error exporting users: %w", err))
+	}
+	entity.SortUsers(users)
+	if err := store.SyncEmployees(users); err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+	summary.UsersSynced = len(users)
+
+	router := entity.NewEventRouter(users)
+	err = exporter.StreamEvents(ctx, *selectEventsForMonths, *streamBatchSize, func(batch []entity.Event) error {
+		entity.SortEvents(batch)
+		inserted, err := store.InsertEvents(batch)
+		if err != nil {
+			return infra.DestinationFailure(err)
+		}
+		summary.EventsExported += len(batch)
+		summary.EventsInserted += inserted
+		for _, event := range batch {
+			router.Route(event)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, infra.SourceFailure(fmt.Errorf("error exporting events: %w", err))
+	}
+
+	now := calendar.SiteTime(time.Now())
+	if err := computeUsersFlow(ctx, users, now, *selectEventsForMonths, *workers, policy, nil); err != nil {
+		return summary, interrupted(ctx, "interval insert")
+	}
+	intervals := formIntervals(users, summary.Division, nil)
+	summary.IntervalsFormed = len(intervals)
+	summary.IntervalsInserted, err = store.InsertIntervals(intervals)
+	if err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+
+	summary.Finished = time.Now()
+	return summary, nil
+}
+
+// Forwards the rows of a SQLite destination not pushed yet to the central Postgres
+func pushCommand(args []string) {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	path := fs.String("sqlite", os.Getenv("SQLITE_PATH"), "path to the SQLite destination")
+	batch := fs.Int("batch", infra.DefaultStreamBatchSize, "rows pushed per batch")
+	fs.Parse(args)
+
+	// a stopped push resumes with the batches it didn't mark pushed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := infra.OpenSQLite(*path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer store.Close()
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	result, err := store.Push(ctx, db, *batch)
+	log.Printf("pushed %d employees, %d events, %d intervals", result.Employees, result.Events, result.Intervals)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
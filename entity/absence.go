@@ -0,0 +1,69 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+// a user without events for this many days before a day is not expected at work
+const AbsenceActiveDays = 30
+
+// Working day without a single event of an employed user
+type Absence struct {
+	Card       string
+	FirstName  string
+	LastName   string
+	Department string
+	Day        time.Time
+}
+
+/*
+ * Absences of every working day from from to to, both inclusive. A user counts
+ * as employed on a day when they badged within AbsenceActiveDays before it,
+ * so people who left don't show up as absent forever.
+ */
+func DetectAbsences(users []*User, from, to time.Time, calendar Calendar) []Absence {
+	from = from.Truncate(24 * time.Hour)
+	to = to.Truncate(24 * time.Hour)
+
+	result := make([]Absence, 0)
+	for _, user := range users {
+		days := make(map[time.Time]bool)
+		for _, event := range user.Events {
+			days[event.Time.Truncate(24*time.Hour)] = true
+		}
+		if len(days) == 0 {
+			continue
+		}
+
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			if days[day] || !calendar.IsWorkingDay(day) || !activeBefore(days, day) {
+				continue
+			}
+			result = append(result, Absence{
+				Card:       user.Card,
+				FirstName:  user.FirstName,
+				LastName:   user.LastName,
+				Department: user.Department,
+				Day:        day,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Day.Equal(result[j].Day) {
+			return result[i].Day.Before(result[j].Day)
+		}
+		return result[i].Card < result[j].Card
+	})
+	return result
+}
+
+func activeBefore(days map[time.Time]bool, day time.Time) bool {
+	for d := 1; d <= AbsenceActiveDays; d++ {
+		if days[day.AddDate(0, 0, -d)] {
+			return true
+		}
+	}
+	return false
+}
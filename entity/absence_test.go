@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectAbsences(t *testing.T) {
+	calendar, err := ParseCalendar([]byte(`{"country": "ru", "timezone": "Europe/Moscow", "holidays": ["2026-05-11"]}`))
+	assert.Nil(t, err)
+
+	day := func(s string, hour int) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d.Add(time.Duration(hour) * time.Hour)
+	}
+	present := &User{Card: "1", FirstName: "Ivan", Events: []Event{
+		{ID: 1, Card: "1", Time: day("2026-05-07", 8)},
+		{ID: 2, Card: "1", Time: day("2026-05-08", 8)},
+		{ID: 3, Card: "1", Time: day("2026-05-12", 8)},
+	}}
+	absent := &User{Card: "2", FirstName: "Anna", Events: []Event{
+		{ID: 4, Card: "2", Time: day("2026-05-07", 9)},
+	}}
+	left := &User{Card: "3", FirstName: "Oleg", Events: []Event{
+		{ID: 5, Card: "3", Time: day("2026-03-01", 9)},
+	}}
+	never := &User{Card: "4"}
+
+	absences := DetectAbsences([]*User{present, absent, left, never}, day("2026-05-08", 0), day("2026-05-12", 0), calendar)
+
+	// the 9th and 10th are a weekend, the 11th a holiday
+	assert.Equal(t, []Absence{
+		{Card: "2", FirstName: "Anna", Day: day("2026-05-08", 0)},
+		{Card: "2", FirstName: "Anna", Day: day("2026-05-12", 0)},
+	}, absences)
+}
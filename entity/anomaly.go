@@ -0,0 +1,131 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+const DOUBLE_ENTRY_WINDOW_SEC = 60
+
+type AnomalyReason string
+
+const (
+	AnomalyDoubleEntry      AnomalyReason = "double_entry"
+	AnomalyExitWithoutEntry AnomalyReason = "exit_without_entry"
+	AnomalyUnknownCard      AnomalyReason = "unknown_card"
+	AnomalyFutureEvent      AnomalyReason = "future_event"
+)
+
+type Anomaly struct {
+	Event  Event
+	Reason AnomalyReason
+}
+
+/*
+ * Detects anomalies in the raw, time sorted events of a single card:
+ * events timestamped after now and repeated badging within a minute
+ */
+func DetectEventAnomalies(events []Event, now time.Time) []Anomaly {
+	result := make([]Anomaly, 0)
+
+	for i, event := range events {
+		if event.Time.After(now) {
+			result = append(result, Anomaly{Event: event, Reason: AnomalyFutureEvent})
+			continue
+		}
+		if i > 0 && event.Time.Sub(events[i-1].Time).Seconds() < DOUBLE_ENTRY_WINDOW_SEC {
+			result = append(result, Anomaly{Event: event, Reason: AnomalyDoubleEntry})
+		}
+	}
+
+	return result
+}
+
+/*
+ * Exits must follow an entry. Only the reader channels tell, alternated
+ * directions always pair up, so the check works on the directions the
+ * readers reported and skips exits after an event of unknown direction. The
+ * direction before the first event is unknown, an exit there is flagged.
+ */
+func DetectDirectionAnomalies(events []Event) []Anomaly {
+	result := make([]Anomaly, 0)
+
+	for i, event := range events {
+		if event.ReaderDirection != EventTypeExt {
+			continue
+		}
+		if i == 0 || events[i-1].ReaderDirection == EventTypeExt {
+			result = append(result, Anomaly{Event: event, Reason: AnomalyExitWithoutEntry})
+		}
+	}
+
+	return result
+}
+
+// Cards of employees and visitors, including their additional cards
+type CardIndex map[string]bool
+
+func NewCardIndex(users []*User) CardIndex {
+	known := make(CardIndex, len(users))
+	for _, user := range users {
+		known[user.Card] = true
+		for _, c := range user.Cards {
+			known[c.Card] = true
+		}
+	}
+	return known
+}
+
+// Cards missing in the index, one anomaly for the first event of each card
+// and day rather than one per badge
+func DetectUnknownCards(events []Event, known CardIndex) []Anomaly {
+	type cardDay struct {
+		card string
+		day  time.Time
+	}
+	first := make(map[cardDay]int)
+	result := make([]Anomaly, 0)
+	for _, event := range events {
+		// records without card are not badge events
+		if event.Card == "" || known[event.Card] {
+			continue
+		}
+		key := cardDay{event.Card, day(event.Time)}
+		i, ok := first[key]
+		if !ok {
+			first[key] = len(result)
+			result = append(result, Anomaly{Event: event, Reason: AnomalyUnknownCard})
+			continue
+		}
+		if event.Time.Before(result[i].Event.Time) {
+			result[i].Event = event
+		}
+	}
+	return result
+}
+
+func ExcludeFutureEvents(events []Event, now time.Time) []Event {
+	result := make([]Event, 0, len(events))
+	for _, event := range events {
+		if !event.Time.After(now) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+func SortAnomalies(anomalies []Anomaly) {
+	sort.Slice(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if a.Event.Card != b.Event.Card {
+			return a.Event.Card < b.Event.Card
+		}
+		if !a.Event.Time.Equal(b.Event.Time) {
+			return a.Event.Time.Before(b.Event.Time)
+		}
+		if a.Event.ID != b.Event.ID {
+			return a.Event.ID < b.Event.ID
+		}
+		return a.Reason < b.Reason
+	})
+}
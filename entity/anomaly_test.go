@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectEventAnomalies(t *testing.T) {
+	now := time.Date(2021, 12, 15, 12, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 7050, Card: "1213363737", Time: now.Add(-4 * time.Hour)},
+		{ID: 7051, Card: "1213363737", Time: now.Add(-4*time.Hour + 30*time.Second)},
+		{ID: 7052, Card: "1213363737", Time: now.Add(-1 * time.Hour)},
+		{ID: 7053, Card: "1213363737", Time: now.Add(time.Hour)},
+	}
+
+	t.Run("double entry and future event", func(t *testing.T) {
+		anomalies := DetectEventAnomalies(events, now)
+
+		assert.Equal(t, 2, len(anomalies))
+		assert.Equal(t, 7051, anomalies[0].Event.ID)
+		assert.Equal(t, AnomalyDoubleEntry, anomalies[0].Reason)
+		assert.Equal(t, 7053, anomalies[1].Event.ID)
+		assert.Equal(t, AnomalyFutureEvent, anomalies[1].Reason)
+	})
+
+	t.Run("future events are excluded", func(t *testing.T) {
+		assert.Equal(t, 3, len(ExcludeFutureEvents(events, now)))
+	})
+}
+
+func TestDetectDirectionAnomalies(t *testing.T) {
+	t.Run("exits reported by the readers", func(t *testing.T) {
+		events := []Event{
+			{ID: 7050, ReaderDirection: EventTypeExt},
+			{ID: 7051, ReaderDirection: EventTypeEnt},
+			{ID: 7052, ReaderDirection: EventTypeExt},
+			{ID: 7053, ReaderDirection: EventTypeExt},
+			{ID: 7054},
+			{ID: 7055, ReaderDirection: EventTypeExt},
+		}
+
+		anomalies := DetectDirectionAnomalies(events)
+
+		assert.Equal(t, 2, len(anomalies))
+		assert.Equal(t, 7050, anomalies[0].Event.ID)
+		assert.Equal(t, 7053, anomalies[1].Event.ID)
+	})
+
+	t.Run("alternating pairing", func(t *testing.T) {
+		day := time.Date(2021, 12, 15, 0, 0, 0, 0, time.UTC)
+		events := []Event{
+			{ID: 7050, Time: day.Add(8 * time.Hour), ReaderDirection: EventTypeEnt},
+			{ID: 7051, Time: day.Add(12 * time.Hour), ReaderDirection: EventTypeExt},
+			{ID: 7052, Time: day.Add(17 * time.Hour), ReaderDirection: EventTypeExt},
+		}
+		SetEventDirection(events)
+
+		anomalies := DetectDirectionAnomalies(events)
+
+		assert.Equal(t, 1, len(anomalies))
+		assert.Equal(t, 7052, anomalies[0].Event.ID)
+	})
+}
+
+func TestDetectUnknownCards(t *testing.T) {
+	day := time.Date(2021, 12, 15, 0, 0, 0, 0, time.UTC)
+	known := NewCardIndex([]*User{{Card: "1", Cards: []CardAssignment{{Card: "3"}}}})
+	events := []Event{
+		{ID: 1, Card: "1", Time: day.Add(8 * time.Hour)},
+		{ID: 2, Card: "2", Time: day.Add(9 * time.Hour)},
+		{ID: 3, Card: "2", Time: day.Add(8 * time.Hour)},
+		{ID: 4, Card: "2", Time: day.Add(32 * time.Hour)},
+		{ID: 5, Card: "3", Time: day.Add(8 * time.Hour)},
+		{ID: 6, Card: "", Time: day.Add(8 * time.Hour)},
+	}
+
+	anomalies := DetectUnknownCards(events, known)
+
+	assert.Equal(t, 2, len(anomalies))
+	assert.Equal(t, 3, anomalies[0].Event.ID)
+	assert.Equal(t, AnomalyUnknownCard, anomalies[0].Reason)
+	assert.Equal(t, 4, anomalies[1].Event.ID)
+}
@@ -0,0 +1,134 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*
+ * Working day calendar and clock of a site. Controllers report local wall-clock
+ * time without a zone, so event times are that wall clock labelled as UTC.
+ */
+type Calendar struct {
+	Country  string `json:"country"`
+	Timezone string `json:"timezone"`
+	// holidays repeating every year, MM-DD
+	Annual []string `json:"annual"`
+	// one-off holidays and transferred days off, YYYY-MM-DD
+	Holidays []string `json:"holidays"`
+	// weekend days moved to working days by decree, YYYY-MM-DD
+	Workdays []string `json:"workdays"`
+	// pre-holiday working days one hour shorter, YYYY-MM-DD
+	Shortened []string `json:"shortened"`
+
+	location  *time.Location
+	annual    map[string]bool
+	holidays  map[string]bool
+	workdays  map[string]bool
+	shortened map[string]bool
+}
+
+type DayKind string
+
+const (
+	DayWorking   DayKind = "working"
+	DayShortened DayKind = "shortened"
+	DayOff       DayKind = "day_off"
+)
+
+func ParseCalendar(data []byte) (Calendar, error) {
+	var c Calendar
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Calendar{}, fmt.Errorf("parsing calendar: %w", err)
+	}
+	return c, c.init()
+}
+
+// Replaces the bundled timezone, e.g. for a site in another zone of the same country
+func (c Calendar) WithTimezone(name string) (Calendar, error) {
+	c.Timezone = name
+	return c, c.init()
+}
+
+func (c *Calendar) init() error {
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("calendar %s: %w", c.Country, err)
+	}
+	c.location = location
+
+	c.annual = toSet(c.Annual)
+	c.holidays = toSet(c.Holidays)
+	c.workdays = toSet(c.Workdays)
+	c.shortened = toSet(c.Shortened)
+	return nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// Site wall-clock time of the instant t, labelled as UTC like event times
+func (c Calendar) SiteTime(t time.Time) time.Time {
+	location := c.location
+	if location == nil {
+		location = time.UTC
+	}
+	local := t.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(),
+		local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+}
+
+func (c Calendar) Kind(day time.Time) DayKind {
+	date := day.Format("2006-01-02")
+	switch {
+	case c.shortened[date]:
+		return DayShortened
+	case c.workdays[date]:
+		return DayWorking
+	case c.holidays[date] || c.annual[day.Format("01-02")]:
+		return DayOff
+	case day.Weekday() == time.Saturday || day.Weekday() == time.Sunday:
+		return DayOff
+	}
+	return DayWorking
+}
+
+func (c Calendar) IsWorkingDay(day time.Time) bool {
+	return c.Kind(day) != DayOff
+}
+
+// Overrides the kind of a single date, e.g. with production calendar data
+func (c *Calendar) SetKind(day time.Time, kind DayKind) {
+	date := day.Format("2006-01-02")
+	c.Holidays = remove(c.Holidays, date)
+	c.Workdays = remove(c.Workdays, date)
+	c.Shortened = remove(c.Shortened, date)
+
+	switch kind {
+	case DayOff:
+		c.Holidays = append(c.Holidays, date)
+	case DayShortened:
+		c.Shortened = append(c.Shortened, date)
+	case DayWorking:
+		c.Workdays = append(c.Workdays, date)
+	}
+	c.holidays = toSet(c.Holidays)
+	c.workdays = toSet(c.Workdays)
+	c.shortened = toSet(c.Shortened)
+}
+
+func remove(values []string, value string) []string {
+	result := values[:0:0]
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
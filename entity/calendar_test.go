@@ -0,0 +1,63 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendar(t *testing.T) {
+	calendar, err := ParseCalendar([]byte(`{
+		"country": "ru",
+		"timezone": "Europe/Moscow",
+		"annual": ["01-01", "05-09"],
+		"holidays": ["2026-05-11"],
+		"workdays": ["2026-11-01"],
+		"shortened": ["2026-05-08"]
+	}`))
+	assert.Nil(t, err)
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	t.Run("working days", func(t *testing.T) {
+		assert.True(t, calendar.IsWorkingDay(day("2026-05-12")))
+		assert.False(t, calendar.IsWorkingDay(day("2026-05-16")), "saturday")
+		assert.False(t, calendar.IsWorkingDay(day("2027-01-01")), "annual holiday")
+		assert.False(t, calendar.IsWorkingDay(day("2026-05-11")), "one-off holiday")
+		assert.True(t, calendar.IsWorkingDay(day("2026-11-01")), "transferred working sunday")
+		assert.Equal(t, DayShortened, calendar.Kind(day("2026-05-08")))
+	})
+
+	t.Run("set kind overrides bundled data", func(t *testing.T) {
+		c := calendar
+		c.SetKind(day("2027-01-01"), DayWorking)
+		c.SetKind(day("2026-05-12"), DayOff)
+
+		assert.True(t, c.IsWorkingDay(day("2027-01-01")))
+		assert.False(t, c.IsWorkingDay(day("2026-05-12")))
+		assert.True(t, calendar.IsWorkingDay(day("2026-05-12")), "original is untouched")
+	})
+
+	t.Run("site time is wall clock labelled as UTC", func(t *testing.T) {
+		now := time.Date(2026, 5, 12, 9, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2026, 5, 12, 12, 0, 0, 0, time.UTC), calendar.SiteTime(now))
+	})
+
+	t.Run("unknown timezone", func(t *testing.T) {
+		_, err := calendar.WithTimezone("Mars/Olympus")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestClassifyCalendarDay(t *testing.T) {
+	policy := DefaultFlowPolicy()
+
+	assert.Equal(t, DayFull, ClassifyCalendarDay(7, DayShortened, policy))
+	assert.Equal(t, DayHalf, ClassifyCalendarDay(7, DayWorking, policy))
+	assert.Equal(t, DayOffWork, ClassifyCalendarDay(2, DayOff, policy))
+	assert.Equal(t, DayAbsent, ClassifyCalendarDay(0, DayOff, policy))
+}
@@ -0,0 +1,163 @@
+package entity
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Additional card of an employee, e.g. a lost badge replaced by a new one
+type CardAssignment struct {
+	Card      string
+	ValidFrom time.Time
+	// nil while the card is still valid
+	ValidTo *time.Time
+}
+
+func (c CardAssignment) Covers(t time.Time) bool {
+	if t.Before(c.ValidFrom) {
+		return false
+	}
+	return c.ValidTo == nil || t.Before(*c.ValidTo)
+}
+
+// Assignments as they were at at: later ones are dropped, ones that ended
+// after at were still valid then
+func CardAssignmentsAt(assignments map[string][]CardAssignment, at time.Time) map[string][]CardAssignment {
+	result := make(map[string][]CardAssignment, len(assignments))
+	for primary, cards := range assignments {
+		for _, c := range cards {
+			if c.ValidFrom.After(at) {
+				continue
+			}
+			if c.ValidTo != nil && c.ValidTo.After(at) {
+				c.ValidTo = nil
+			}
+			result[primary] = append(result[primary], c)
+		}
+	}
+	return result
+}
+
+/*
+ * Checks assignments keyed by the employee's current card: a period must end
+ * after it starts, and a card may belong to one employee at a time, so periods
+ * of the same card must not overlap, whoever they are assigned to.
+ */
+func ValidateCardAssignments(assignments map[string][]CardAssignment) error {
+	type owned struct {
+		employee   string
+		assignment CardAssignment
+	}
+	byCard := make(map[string][]owned)
+	for employee, cards := range assignments {
+		for _, c := range cards {
+			if c.ValidTo != nil && !c.ValidTo.After(c.ValidFrom) {
+				return fmt.Errorf("card %s of %s: valid_to %s is not after valid_from %s",
+					c.Card, employee, c.ValidTo.Format(time.DateTime), c.ValidFrom.Format(time.DateTime))
+			}
+			byCard[c.Card] = append(byCard[c.Card], owned{employee, c})
+		}
+	}
+	for card, periods := range byCard {
+		sort.Slice(periods, func(i, j int) bool {
+			return periods[i].assignment.ValidFrom.Before(periods[j].assignment.ValidFrom)
+		})
+		for i := 1; i < len(periods); i++ {
+			previous, next := periods[i-1], periods[i]
+			if previous.assignment.Covers(next.assignment.ValidFrom) {
+				return fmt.Errorf("card %s: period of %s from %s overlaps the one of %s from %s", card,
+					next.employee, next.assignment.ValidFrom.Format(time.DateTime),
+					previous.employee, previous.assignment.ValidFrom.Format(time.DateTime))
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * Attaches assignments keyed by the employee's current card. Users whose card is
+ * an assignment of another employee are dropped, their events move to that employee.
+ */
+func AttachCardAssignments(users []*User, assignments map[string][]CardAssignment) []*User {
+	aliases := make(map[string]bool)
+	for primary, cards := range assignments {
+		for _, c := range cards {
+			if c.Card != primary {
+				aliases[c.Card] = true
+			}
+		}
+	}
+
+	result := make([]*User, 0, len(users))
+	for _, user := range users {
+		if aliases[user.Card] {
+			continue
+		}
+		user.Cards = assignments[user.Card]
+		result = append(result, user)
+	}
+	return result
+}
+
+/*
+ * Hands streamed events to the users collecting them, the way CollectEvents
+ * picks them from a map of all events, so a run holds every event once with
+ * its user. The events are appended to the user's Events for the flow.
+ */
+type EventRouter struct {
+	owners map[string][]cardOwner
+}
+
+type cardOwner struct {
+	user *User
+	// nil for the user's current card
+	assignment *CardAssignment
+}
+
+func NewEventRouter(groups ...[]*User) *EventRouter {
+	r := &EventRouter{owners: make(map[string][]cardOwner)}
+	for _, users := range groups {
+		for _, user := range users {
+			r.owners[user.Card] = append(r.owners[user.Card], cardOwner{user: user})
+			for i, c := range user.Cards {
+				if c.Card != user.Card {
+					r.owners[c.Card] = append(r.owners[c.Card], cardOwner{user: user, assignment: &user.Cards[i]})
+				}
+			}
+		}
+	}
+	return r
+}
+
+// False for events of cards no user holds. Events of an assigned card outside
+// its validity go to no one, the card is still known.
+func (r *EventRouter) Route(event Event) bool {
+	owners, ok := r.owners[event.Card]
+	for _, owner := range owners {
+		if owner.assignment == nil || owner.assignment.Covers(event.Time) {
+			owner.user.Events = append(owner.user.Events, event)
+		}
+	}
+	return ok
+}
+
+// Events of the current card plus events of assigned cards within their validity
+func (u *User) CollectEvents(eventsmap map[string][]Event) []Event {
+	if len(u.Cards) == 0 {
+		return eventsmap[u.Card]
+	}
+
+	result := append([]Event{}, eventsmap[u.Card]...)
+	for _, c := range u.Cards {
+		if c.Card == u.Card {
+			continue
+		}
+		for _, event := range eventsmap[c.Card] {
+			if c.Covers(event.Time) {
+				result = append(result, event)
+			}
+		}
+	}
+	return result
+}
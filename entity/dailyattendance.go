@@ -0,0 +1,50 @@
+package entity
+
+import "time"
+
+// First entry and last exit of a card on one day, for departments that only
+// track when people arrive and leave. LastOut is nil while no interval of the
+// day is closed.
+type DailyAttendance struct {
+	Card    string
+	Day     time.Time
+	FirstIn time.Time
+	LastOut *time.Time
+}
+
+// Hours from the first entry to the last exit, breaks included
+func (d DailyAttendance) GrossHours() float64 {
+	if d.LastOut == nil {
+		return 0
+	}
+	return d.LastOut.Sub(d.FirstIn).Hours()
+}
+
+/*
+ * Summarizes the intervals entered from since on by day: an interval counts
+ * towards the day it starts on, so a night shift ends the day it began.
+ */
+func (u *User) DailyAttendance(since time.Time) []DailyAttendance {
+	result := make([]DailyAttendance, 0)
+	for _, interval := range u.Intervals {
+		t := interval.Ent.Time
+		if t.Before(since) {
+			continue
+		}
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		n := len(result)
+		if n == 0 || !result[n-1].Day.Equal(day) {
+			result = append(result, DailyAttendance{Card: u.Card, Day: day, FirstIn: t})
+			n++
+		}
+		d := &result[n-1]
+		if t.Before(d.FirstIn) {
+			d.FirstIn = t
+		}
+		if interval.Ext != nil && (d.LastOut == nil || interval.Ext.Time.After(*d.LastOut)) {
+			out := interval.Ext.Time
+			d.LastOut = &out
+		}
+	}
+	return result
+}
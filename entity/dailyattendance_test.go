@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDailyAttendance(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	at := func(days, hour, minute int) *Event {
+		return &Event{Time: day.AddDate(0, 0, days).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)}
+	}
+	u := &User{Card: "1001", Intervals: []Interval{
+		{Ent: at(-1, 8, 0), Ext: at(-1, 17, 0)},
+		{Ent: at(0, 7, 55), Ext: at(0, 12, 0)},
+		{Ent: at(0, 12, 40), Ext: at(0, 17, 10)},
+		{Ent: at(1, 22, 0), Ext: at(2, 6, 0)},
+		{Ent: at(3, 8, 0)},
+	}}
+
+	days := u.DailyAttendance(day)
+
+	assert.Equal(t, 3, len(days))
+	assert.Equal(t, day, days[0].Day)
+	assert.Equal(t, at(0, 7, 55).Time, days[0].FirstIn)
+	assert.Equal(t, at(0, 17, 10).Time, *days[0].LastOut)
+	assert.InDelta(t, 9.25, days[0].GrossHours(), 0.001)
+	assert.Equal(t, 8.0, days[1].GrossHours(), "a night shift belongs to the day it began")
+	assert.Nil(t, days[2].LastOut)
+	assert.Equal(t, 0.0, days[2].GrossHours())
+}
@@ -0,0 +1,41 @@
+package entity
+
+type DayPresence string
+
+const (
+	DayFull    DayPresence = "full"
+	DayHalf    DayPresence = "half"
+	DayPartial DayPresence = "partial"
+	DayAbsent  DayPresence = "absent"
+	// worked on a weekend or a holiday, not measured against the day norm
+	DayOffWork DayPresence = "day_off_work"
+)
+
+// Classifies a day by worked hours against the policy thresholds
+func ClassifyDay(hours float64, policy FlowPolicy) DayPresence {
+	switch {
+	case hours <= 0:
+		return DayAbsent
+	case hours >= policy.FullDayHours:
+		return DayFull
+	case hours >= policy.HalfDayHours:
+		return DayHalf
+	default:
+		return DayPartial
+	}
+}
+
+// Like ClassifyDay but aware of the day kind: the full day threshold of a
+// shortened day is an hour lower and hours on days off are never partial presence
+func ClassifyCalendarDay(hours float64, kind DayKind, policy FlowPolicy) DayPresence {
+	switch kind {
+	case DayOff:
+		if hours <= 0 {
+			return DayAbsent
+		}
+		return DayOffWork
+	case DayShortened:
+		policy.FullDayHours--
+	}
+	return ClassifyDay(hours, policy)
+}
@@ -0,0 +1,73 @@
+package entity
+
+import "strings"
+
+// Corporate directory account, e.g. from Active Directory
+type DirectoryAccount struct {
+	DN         string
+	Account    string
+	FirstName  string
+	LastName   string
+	Email      string
+	EmployeeID string
+	// DN of the manager's account
+	ManagerDN string
+}
+
+const (
+	MatchedByMapping = "mapping"
+	MatchedByName    = "name"
+)
+
+type DirectoryMatch struct {
+	Card      string
+	Account   DirectoryAccount
+	Manager   *DirectoryAccount
+	MatchedBy string
+}
+
+func normalizeName(first, last string) string {
+	name := strings.ToLower(strings.TrimSpace(first) + " " + strings.TrimSpace(last))
+	return strings.ReplaceAll(name, "ё", "е")
+}
+
+/*
+ * Matches users to directory accounts. An explicit card to account mapping wins,
+ * otherwise first and last name must match exactly one account. Ambiguous
+ * names are left unmatched rather than guessed.
+ */
+func MatchDirectory(users []*User, accounts []DirectoryAccount, mapping map[string]string) []DirectoryMatch {
+	byAccount := make(map[string]*DirectoryAccount, len(accounts))
+	byDN := make(map[string]*DirectoryAccount, len(accounts))
+	byName := make(map[string][]*DirectoryAccount)
+	for i := range accounts {
+		a := &accounts[i]
+		byAccount[strings.ToLower(a.Account)] = a
+		byDN[strings.ToLower(a.DN)] = a
+		key := normalizeName(a.FirstName, a.LastName)
+		byName[key] = append(byName[key], a)
+	}
+
+	matches := make([]DirectoryMatch, 0)
+	for _, user := range users {
+		var account *DirectoryAccount
+		matchedBy := MatchedByMapping
+		if name, ok := mapping[user.Card]; ok {
+			account = byAccount[strings.ToLower(name)]
+		} else if candidates := byName[normalizeName(user.FirstName, user.LastName)]; len(candidates) == 1 {
+			account = candidates[0]
+			matchedBy = MatchedByName
+		}
+		if account == nil {
+			continue
+		}
+
+		matches = append(matches, DirectoryMatch{
+			Card:      user.Card,
+			Account:   *account,
+			Manager:   byDN[strings.ToLower(account.ManagerDN)],
+			MatchedBy: matchedBy,
+		})
+	}
+	return matches
+}
@@ -0,0 +1,40 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDirectory(t *testing.T) {
+	accounts := []DirectoryAccount{
+		{DN: "CN=boss", Account: "boss", FirstName: "Olga", LastName: "Fedorova"},
+		{DN: "CN=ipetrov", Account: "ipetrov", FirstName: "Ivan", LastName: "Petrov", ManagerDN: "cn=boss"},
+		{DN: "CN=fedorov1", Account: "fedorov1", FirstName: "Pyotr", LastName: "Fedorov"},
+		{DN: "CN=fedorov2", Account: "fedorov2", FirstName: "Pyotr", LastName: "Fedorov"},
+		{DN: "CN=semenova", Account: "semenova", FirstName: "Алёна", LastName: "Семёнова"},
+	}
+	users := []*User{
+		{Card: "1", FirstName: "ivan", LastName: "petrov "},
+		{Card: "2", FirstName: "Pyotr", LastName: "Fedorov"},
+		{Card: "3", FirstName: "Pyotr", LastName: "Fedorov"},
+		{Card: "4", FirstName: "Алена", LastName: "Семенова"},
+	}
+
+	matches := MatchDirectory(users, accounts, map[string]string{"3": "FEDOROV2"})
+
+	assert.Len(t, matches, 3)
+	t.Run("by name with manager", func(t *testing.T) {
+		assert.Equal(t, "ipetrov", matches[0].Account.Account)
+		assert.Equal(t, MatchedByName, matches[0].MatchedBy)
+		assert.Equal(t, "boss", matches[0].Manager.Account)
+	})
+	t.Run("ambiguous name needs a mapping", func(t *testing.T) {
+		assert.Equal(t, "3", matches[1].Card)
+		assert.Equal(t, "fedorov2", matches[1].Account.Account)
+		assert.Equal(t, MatchedByMapping, matches[1].MatchedBy)
+	})
+	t.Run("yo is matched as ye", func(t *testing.T) {
+		assert.Equal(t, "semenova", matches[2].Account.Account)
+	})
+}
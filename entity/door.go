@@ -0,0 +1,60 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+const DOOR_OPENING_WINDOW_SEC = 30
+
+const AnomalyNoDoorOpening AnomalyReason = "no_door_opening"
+
+/*
+ * A badge is confirmed when the door at the same point was opened within
+ * the window after it. Unconfirmed badges are most likely reader glitches.
+ */
+func CrossCheckDoorOpenings(badges []Event, doorOpenings map[string][]time.Time) []Anomaly {
+	result := make([]Anomaly, 0)
+	window := time.Second * DOOR_OPENING_WINDOW_SEC
+
+	for _, badge := range badges {
+		openings := doorOpenings[badge.PointName]
+		i := sort.Search(len(openings), func(i int) bool {
+			return !openings[i].Before(badge.Time)
+		})
+		if i < len(openings) && openings[i].Sub(badge.Time) <= window {
+			continue
+		}
+		result = append(result, Anomaly{Event: badge, Reason: AnomalyNoDoorOpening})
+	}
+
+	return result
+}
+
+// Share of the interval events confirmed by a door opening
+func IntervalConfidence(interval Interval, unconfirmed map[int]bool) float64 {
+	events := []*Event{interval.Ent}
+	if interval.Ext != nil {
+		events = append(events, interval.Ext)
+	}
+
+	confirmed := 0
+	for _, event := range events {
+		if !unconfirmed[event.ID] {
+			confirmed++
+		}
+	}
+	return float64(confirmed) / float64(len(events))
+}
+
+// Groups door opening times by point, sorted for lookup by CrossCheckDoorOpenings
+func GroupDoorOpenings(events []Event) map[string][]time.Time {
+	result := make(map[string][]time.Time)
+	for _, event := range events {
+		result[event.PointName] = append(result[event.PointName], event.Time)
+	}
+	for _, openings := range result {
+		sort.Slice(openings, func(i, j int) bool { return openings[i].Before(openings[j]) })
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrossCheckDoorOpenings(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 27, 11, 0, time.UTC)
+	badges := []Event{
+		{ID: 7050, PointName: "КПП ЦЕНТР", Time: ts},
+		{ID: 7051, PointName: "КПП ЦЕНТР", Time: ts.Add(8 * time.Hour)},
+	}
+	openings := GroupDoorOpenings([]Event{
+		{PointName: "КПП ЦЕНТР", Time: ts.Add(8*time.Hour + 5*time.Minute)},
+		{PointName: "КПП ЦЕНТР", Time: ts.Add(3 * time.Second)},
+	})
+
+	anomalies := CrossCheckDoorOpenings(badges, openings)
+
+	assert.Equal(t, 1, len(anomalies))
+	assert.Equal(t, 7051, anomalies[0].Event.ID)
+	assert.Equal(t, AnomalyNoDoorOpening, anomalies[0].Reason)
+
+	t.Run("interval confidence", func(t *testing.T) {
+		interval := Interval{Ent: &badges[0], Ext: &badges[1]}
+		unconfirmed := map[int]bool{7051: true}
+
+		assert.Equal(t, 0.5, IntervalConfidence(interval, unconfirmed))
+	})
+}
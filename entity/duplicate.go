@@ -0,0 +1,161 @@
+package entity
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// names equal but for case, spacing or swapped first and last name
+	DuplicateSameName = "same_name"
+	// names equal once Cyrillic and Latin spellings are folded together
+	DuplicateTransliteration = "transliteration"
+	// folded names a single letter apart, Aleksandr and Alexander
+	DuplicateSimilarName = "similar_name"
+)
+
+// Card holder name as the destination knows it
+type EmployeeName struct {
+	Card      string
+	FirstName string
+	LastName  string
+}
+
+// Two cards that likely belong to one person. Card is the record seen first,
+// the canonical one when the two get merged.
+type DuplicateCandidate struct {
+	Card          string `db:"card" json:"card"`
+	DuplicateCard string `db:"duplicate_card" json:"duplicate_card"`
+	Reason        string `db:"reason" json:"reason"`
+}
+
+// Shortest folded name part compared by edit distance, shorter names are too
+// likely to be one letter apart by chance
+const similarNameMinLength = 5
+
+// Finds pairs of employees with the same or nearly the same name. The order of
+// employees decides which card of a pair is the canonical one, pass the oldest
+// first. Every pair is reported once.
+func FindDuplicateEmployees(employees []EmployeeName) []DuplicateCandidate {
+	type folded struct {
+		EmployeeName
+		plain string
+		parts [2]string
+	}
+	// every employee is indexed by both name parts, a pair shares at least one
+	byPart := make(map[string][]*folded)
+	candidates := make([]DuplicateCandidate, 0)
+	for _, e := range employees {
+		first, last := foldName(e.FirstName), foldName(e.LastName)
+		if first == "" || last == "" {
+			continue
+		}
+		// controllers swap first and last name, the order does not tell people apart
+		parts := [2]string{first, last}
+		if parts[1] < parts[0] {
+			parts[0], parts[1] = parts[1], parts[0]
+		}
+		current := &folded{EmployeeName: e, plain: plainName(e.FirstName, e.LastName), parts: parts}
+
+		compared := make(map[*folded]bool)
+		for _, part := range parts {
+			for _, seen := range byPart[part] {
+				if compared[seen] || seen.Card == e.Card {
+					continue
+				}
+				compared[seen] = true
+				if reason := duplicateReason(seen.plain, current.plain, seen.parts, parts); reason != "" {
+					candidates = append(candidates, DuplicateCandidate{Card: seen.Card, DuplicateCard: e.Card, Reason: reason})
+				}
+			}
+		}
+		byPart[parts[0]] = append(byPart[parts[0]], current)
+		if parts[1] != parts[0] {
+			byPart[parts[1]] = append(byPart[parts[1]], current)
+		}
+	}
+	return candidates
+}
+
+func duplicateReason(plainA, plainB string, a, b [2]string) string {
+	switch {
+	case plainA == plainB:
+		return DuplicateSameName
+	case a == b:
+		return DuplicateTransliteration
+	case a[0] == b[0] && len(b[1]) >= similarNameMinLength && withinOneEdit(a[1], b[1]),
+		a[1] == b[1] && len(b[0]) >= similarNameMinLength && withinOneEdit(a[0], b[0]):
+		return DuplicateSimilarName
+	}
+	return ""
+}
+
+// Lower case name with its parts sorted, for the same name check
+func plainName(first, last string) string {
+	parts := []string{strings.ToLower(strings.Join(strings.Fields(first), " ")), strings.ToLower(strings.Join(strings.Fields(last), " "))}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+}
+
+// Spellings the common transliteration schemes disagree on, folded in order
+var latinFolding = strings.NewReplacer(
+	"shch", "sh", "sch", "sh", "tch", "ch", "tz", "c", "ts", "c",
+	"kh", "h", "x", "ks", "w", "v", "j", "i", "y", "i",
+)
+
+// Name part transliterated to Latin with the ambiguous spellings folded
+// together, so Юрий, Yuriy, Iurii and Jurij fold to the same string
+func foldName(name string) string {
+	var latin strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if s, ok := cyrillicToLatin[r]; ok {
+			latin.WriteString(s)
+		} else if unicode.IsLetter(r) {
+			latin.WriteRune(r)
+		}
+	}
+	folded := latinFolding.Replace(latin.String())
+	folded = strings.ReplaceAll(folded, "iu", "u")
+	folded = strings.ReplaceAll(folded, "ia", "a")
+
+	// doubled letters are the other common disagreement, Iurii and Yuri
+	var b strings.Builder
+	var previous rune
+	for _, r := range folded {
+		if r != previous {
+			b.WriteRune(r)
+		}
+		previous = r
+	}
+	return b.String()
+}
+
+// Reports whether a becomes b by at most one inserted, deleted or replaced letter
+func withinOneEdit(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) {
+		return true
+	}
+	if len(a) == len(b) {
+		return a[i+1:] == b[i+1:]
+	}
+	return a[i:] == b[i+1:]
+}
@@ -0,0 +1,42 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFoldName(t *testing.T) {
+	for _, name := range []string{"Юрий", "Yuriy", "Iurii", "Jurij", "Yuri"} {
+		assert.Equal(t, foldName("Юрий"), foldName(name), name)
+	}
+	assert.Equal(t, foldName("Alexander"), foldName("Aleksander"))
+	assert.NotEqual(t, foldName("Ivan"), foldName("Anna"))
+}
+
+func TestFindDuplicateEmployees(t *testing.T) {
+	employees := []EmployeeName{
+		{Card: "1", FirstName: "Ivan", LastName: "Petrov"},
+		{Card: "2", FirstName: "Petrov", LastName: "ivan "},
+		{Card: "3", FirstName: "Юрий", LastName: "Кузнецов"},
+		{Card: "4", FirstName: "Yuriy", LastName: "Kuznetsov"},
+		{Card: "5", FirstName: "Aleksandr", LastName: "Orlov"},
+		{Card: "6", FirstName: "Aleksander", LastName: "Orlov"},
+		{Card: "7", FirstName: "Anna", LastName: "Petrova"},
+		{Card: "8", FirstName: "", LastName: "Petrov"},
+	}
+
+	assert.Equal(t, []DuplicateCandidate{
+		{Card: "1", DuplicateCard: "2", Reason: DuplicateSameName},
+		{Card: "3", DuplicateCard: "4", Reason: DuplicateTransliteration},
+		{Card: "5", DuplicateCard: "6", Reason: DuplicateSimilarName},
+	}, FindDuplicateEmployees(employees))
+}
+
+func TestWithinOneEdit(t *testing.T) {
+	assert.True(t, withinOneEdit("aleksandr", "aleksander"))
+	assert.True(t, withinOneEdit("petrov", "petrof"))
+	assert.True(t, withinOneEdit("petrov", "petrov"))
+	assert.False(t, withinOneEdit("petrov", "petrova1"))
+	assert.False(t, withinOneEdit("ivanov", "ivanvo"))
+}
@@ -2,6 +2,7 @@ package entity
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -20,8 +21,14 @@ type Event struct {
 	ID        int
 	Card      string
 	PointName string
+	// door id of the reader, zero when the source doesn't tell
+	ReaderID  int
 	Time      time.Time
 	Direction Direction
+	// direction of the reader that fired, empty when the controller doesn't tell
+	ReaderDirection Direction
+	// controller event code, door sensor records carry their own codes
+	EventType int
 }
 
 func NewEventFromDBRecord(record []string, index map[string]int) (Event, error) {
@@ -41,6 +48,30 @@ func NewEventFromDBRecord(record []string, index map[string]int) (Event, error)
 		return Event{}, fmt.Errorf("NewEventFromDBRecord: %w", err)
 	}
 
+	// ZKAccess reports the reader channel in state: 0 is the entry reader, 1 the exit one
+	if i, ok := index["state"]; ok {
+		switch record[i] {
+		case "0":
+			e.ReaderDirection = EventTypeEnt
+		case "1":
+			e.ReaderDirection = EventTypeExt
+		}
+	}
+
+	if i, ok := index["event_point_id"]; ok && record[i] != "" {
+		e.ReaderID, err = strconv.Atoi(record[i])
+		if err != nil {
+			return Event{}, fmt.Errorf("error parsing event_point_id: %w", err)
+		}
+	}
+
+	if i, ok := index["event_type"]; ok && record[i] != "" {
+		e.EventType, err = strconv.Atoi(record[i])
+		if err != nil {
+			return Event{}, fmt.Errorf("error parsing event_type: %w", err)
+		}
+	}
+
 	return e, nil
 }
 
@@ -52,12 +83,29 @@ func (e *Event) IsValid() bool {
 	return true
 }
 
+// Orders events by card and time, the event id breaks ties so the order is
+// the same across runs over identical input
+func SortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Card != events[j].Card {
+			return events[i].Card < events[j].Card
+		}
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		return events[i].ID < events[j].ID
+	})
+}
+
 func SelectEventsForNLastMonths(events []Event, n int) []Event {
+	return SelectEventsSince(events, time.Now().AddDate(0, -n, 0))
+}
+
+func SelectEventsSince(events []Event, from time.Time) []Event {
 	var result []Event
-	now := time.Now()
 
 	for _, event := range events {
-		if event.Time.After(now.AddDate(0, -n, 0)) {
+		if event.Time.After(from) {
 			result = append(result, event)
 		}
 	}
@@ -70,6 +118,10 @@ func SelectEventsForNLastMonths(events []Event, n int) []Event {
  * based on the module distance to the previous event
  */
 func SetEventDirection(events []Event) {
+	SetEventDirectionWithin(events, IDEAL_WORKSHIFT_DUR)
+}
+
+func SetEventDirectionWithin(events []Event, maxShiftHours float64) {
 	for i := range events {
 		if i+1 >= len(events) {
 			break
@@ -83,7 +135,7 @@ func SetEventDirection(events []Event) {
 		if i == 0 {
 			events[i].Direction = EventTypeEnt
 		}
-		if timedelta < IDEAL_WORKSHIFT_DUR && cur.Direction == EventTypeEnt {
+		if timedelta < maxShiftHours && cur.Direction == EventTypeEnt {
 			nextEvent.Direction = EventTypeExt
 		} else {
 			nextEvent.Direction = EventTypeEnt
@@ -91,16 +143,45 @@ func SetEventDirection(events []Event) {
 	}
 }
 
+/*
+ * Takes the direction reported by the reader and falls back to
+ * alternation for events of readers without channel information
+ */
+func SetEventDirectionFromReaders(events []Event, maxShiftHours float64) {
+	for i := range events {
+		cur := &events[i]
+		if cur.ReaderDirection != "" {
+			cur.Direction = cur.ReaderDirection
+			continue
+		}
+		if i == 0 {
+			cur.Direction = EventTypeEnt
+			continue
+		}
+
+		prev := &events[i-1]
+		if cur.Time.Sub(prev.Time).Hours() < maxShiftHours && prev.Direction == EventTypeEnt {
+			cur.Direction = EventTypeExt
+		} else {
+			cur.Direction = EventTypeEnt
+		}
+	}
+}
+
 /*
  * Anthicollision algorithm, descends into primary sampling algorithm
  * The essence of the algorithm is that if a person has 2 events at +- the same time
  * then the algorithm recursively skips their similar ones so as not to spoil the statistics
  */
 func ExcludeCollisions(events []Event) []Event {
+	return ExcludeCollisionsWithin(events, EVENT_COLLISION_JITTER_SEC)
+}
+
+func ExcludeCollisionsWithin(events []Event, jitterSec int) []Event {
 	result := make([]Event, 0, len(events))
 
 	for i := 0; i < len(events); {
-		goodEventIndex := CheckCollisionPresence(events, i)
+		goodEventIndex := checkCollisionPresenceWithin(events, i, float64(jitterSec))
 
 		result = append(result, events[goodEventIndex])
 		i = goodEventIndex + 1
@@ -110,6 +191,10 @@ func ExcludeCollisions(events []Event) []Event {
 }
 
 func CheckCollisionPresence(events []Event, i int) int {
+	return checkCollisionPresenceWithin(events, i, EVENT_COLLISION_JITTER_SEC)
+}
+
+func checkCollisionPresenceWithin(events []Event, i int, jitterSec float64) int {
 	if i+1 >= len(events) {
 		return i
 	}
@@ -118,8 +203,8 @@ func CheckCollisionPresence(events []Event, i int) int {
 	next := events[i+1]
 	timedelta := next.Time.Sub(cur.Time)
 
-	if timedelta.Seconds() < EVENT_COLLISION_JITTER_SEC {
-		return CheckCollisionPresence(events, i+1)
+	if timedelta.Seconds() < jitterSec {
+		return checkCollisionPresenceWithin(events, i+1, jitterSec)
 	}
 
 	return i
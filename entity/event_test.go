@@ -23,8 +23,16 @@ func TestEventFromCSV(t *testing.T) {
 		assert.Equal(t, "1213363737", event.Card)
 		assert.Equal(t, "КПП ЦЕНТР", event.PointName)
 		assert.Equal(t, time.Date(2021, 6, 19, 6, 21, 43, 0, time.UTC), event.Time)
+		assert.Equal(t, 0, event.ReaderID)
 	})
 
+	t.Run("reader id", func(t *testing.T) {
+		index := map[string]int{"id": 0, "time": 1, "card_no": 3, "event_point_id": 4, "event_point_name": 6}
+		event, err := NewEventFromDBRecord(raw, index)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, event.ReaderID)
+	})
 }
 
 func TestFilterEvents(t *testing.T) {
@@ -167,3 +175,47 @@ func TestConstructIntervals(t *testing.T) {
 		assert.NotNil(t, intervals[0].Ent)
 	})
 }
+
+func TestSortEvents(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 27, 11, 0, time.UTC)
+	events := []Event{
+		{ID: 7053, Card: "2", Time: ts},
+		{ID: 7052, Card: "1", Time: ts},
+		{ID: 7051, Card: "1", Time: ts},
+		{ID: 7050, Card: "1", Time: ts.Add(time.Hour)},
+	}
+
+	SortEvents(events)
+
+	assert.Equal(t, 7051, events[0].ID)
+	assert.Equal(t, 7052, events[1].ID)
+	assert.Equal(t, 7050, events[2].ID)
+	assert.Equal(t, 7053, events[3].ID)
+}
+
+func TestSetEventDirectionFromReaders(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 1, ReaderDirection: EventTypeEnt, Time: ts},
+		{ID: 2, ReaderDirection: EventTypeExt, Time: ts.Add(4 * time.Hour)},
+		{ID: 3, ReaderDirection: EventTypeExt, Time: ts.Add(4*time.Hour + 10*time.Minute)},
+		{ID: 4, Time: ts.Add(5 * time.Hour)},
+		{ID: 5, Time: ts.Add(9 * time.Hour)},
+	}
+
+	SetEventDirectionFromReaders(events, IDEAL_WORKSHIFT_DUR)
+
+	assert.Equal(t, EventTypeEnt, events[0].Direction)
+	assert.Equal(t, EventTypeExt, events[1].Direction)
+	assert.Equal(t, EventTypeExt, events[2].Direction)
+	assert.Equal(t, EventTypeEnt, events[3].Direction)
+	assert.Equal(t, EventTypeExt, events[4].Direction)
+
+	t.Run("second exit doesn't form an interval", func(t *testing.T) {
+		intervals := ConstructIntervals(events)
+
+		assert.Equal(t, 2, len(intervals))
+		assert.Equal(t, 2, intervals[0].Ext.ID)
+		assert.Equal(t, 5, intervals[1].Ext.ID)
+	})
+}
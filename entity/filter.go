@@ -0,0 +1,170 @@
+package entity
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Events kept out of interval formation: readers that don't mark presence,
+// like the canteen door, and service and test cards. The events stay in the
+// events table, they just don't form intervals. Operating hours don't drop
+// events, the intervals are clipped to them, see ClipIntervals. Empty lists
+// and hours keep everything.
+type EventFilter struct {
+	// readers by name or door id, when not empty only events of the
+	// included readers are kept
+	IncludeReaders []string `json:"include_readers"`
+	ExcludeReaders []string `json:"exclude_readers"`
+	// keeps only events of the readers marked as perimeter doors
+	PerimeterOnly bool `json:"perimeter_only"`
+	// door ids of the perimeter readers, filled from the readers table
+	PerimeterReaders []int `json:"-"`
+	// when not empty only events of these cards are kept
+	IncludeCards []string `json:"include_cards"`
+	ExcludeCards []string `json:"exclude_cards"`
+	// site wall clock operating hours as HH:MM, a window past midnight
+	// has From after To
+	OperatingFrom string `json:"operating_from"`
+	OperatingTo   string `json:"operating_to"`
+}
+
+func (f EventFilter) Validate() error {
+	if (f.OperatingFrom == "") != (f.OperatingTo == "") {
+		return fmt.Errorf("event filter needs both operating_from and operating_to")
+	}
+	if f.OperatingFrom == "" {
+		return nil
+	}
+	from, err := parseClock(f.OperatingFrom)
+	if err != nil {
+		return fmt.Errorf("event filter operating_from: %w", err)
+	}
+	to, err := parseClock(f.OperatingTo)
+	if err != nil {
+		return fmt.Errorf("event filter operating_to: %w", err)
+	}
+	if from == to {
+		return fmt.Errorf("event filter operating hours are empty, leave them out to keep all hours")
+	}
+	return nil
+}
+
+// Events that pass the filter, in their order
+func (f EventFilter) Apply(events []Event) []Event {
+	if f.empty() {
+		return events
+	}
+	includeReaders, excludeReaders := stringSet(f.IncludeReaders), stringSet(f.ExcludeReaders)
+	includeCards, excludeCards := stringSet(f.IncludeCards), stringSet(f.ExcludeCards)
+	perimeter := make(map[int]bool, len(f.PerimeterReaders))
+	for _, id := range f.PerimeterReaders {
+		perimeter[id] = true
+	}
+
+	kept := make([]Event, 0, len(events))
+	for _, event := range events {
+		if len(includeReaders) > 0 && !matchesReader(includeReaders, event) || matchesReader(excludeReaders, event) {
+			continue
+		}
+		if f.PerimeterOnly && !perimeter[event.ReaderID] {
+			continue
+		}
+		if len(includeCards) > 0 && !includeCards[event.Card] || excludeCards[event.Card] {
+			continue
+		}
+		kept = append(kept, event)
+	}
+	return kept
+}
+
+func (f EventFilter) empty() bool {
+	return len(f.IncludeReaders) == 0 && len(f.ExcludeReaders) == 0 &&
+		len(f.IncludeCards) == 0 && len(f.ExcludeCards) == 0 && !f.PerimeterOnly
+}
+
+// Intervals clipped to the operating hours: an entry before opening counts
+// from the opening, an exit after closing until the closing, and intervals
+// entirely outside the hours are dropped. Events outside the hours still take
+// part in pairing, dropping them would shift the directions of alternating
+// readers. Clipped bounds are copies of the events with the time moved.
+func (f EventFilter) ClipIntervals(intervals []Interval) []Interval {
+	if f.OperatingFrom == "" {
+		return intervals
+	}
+	// validated by the policy
+	from, _ := parseClock(f.OperatingFrom)
+	to, _ := parseClock(f.OperatingTo)
+
+	clipped := make([]Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		ent := *interval.Ent
+		ent.Time = nextOpening(ent.Time, from, to)
+		if interval.Ext == nil {
+			clipped = append(clipped, Interval{Ent: &ent})
+			continue
+		}
+		ext := *interval.Ext
+		ext.Time = lastClosing(ext.Time, from, to)
+		if !ext.Time.After(ent.Time) {
+			continue
+		}
+		clipped = append(clipped, Interval{Ent: &ent, Ext: &ext})
+	}
+	return clipped
+}
+
+func matchesReader(readers map[string]bool, event Event) bool {
+	return readers[event.PointName] || event.ReaderID != 0 && readers[strconv.Itoa(event.ReaderID)]
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// Time of day of an HH:MM clock
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Whether the time of day of t lies in [from, to), windows past midnight wrap
+func withinClock(t time.Time, from, to time.Duration) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	if from < to {
+		return clock >= from && clock < to
+	}
+	return clock >= from || clock < to
+}
+
+// t itself within the hours, otherwise the following opening
+func nextOpening(t time.Time, from, to time.Duration) time.Time {
+	if withinClock(t, from, to) {
+		return t
+	}
+	opening := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(from)
+	if opening.Before(t) {
+		opening = opening.AddDate(0, 0, 1)
+	}
+	return opening
+}
+
+// t itself within the hours, otherwise the preceding closing
+func lastClosing(t time.Time, from, to time.Duration) time.Time {
+	if withinClock(t, from, to) {
+		return t
+	}
+	closing := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(to)
+	if closing.After(t) {
+		closing = closing.AddDate(0, 0, -1)
+	}
+	return closing
+}
@@ -0,0 +1,114 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFilter(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 1, Card: "100", PointName: "Gate", ReaderID: 1, Time: ts},
+		{ID: 2, Card: "100", PointName: "Canteen", ReaderID: 7, Time: ts.Add(4 * time.Hour)},
+		{ID: 3, Card: "999", PointName: "Gate", ReaderID: 1, Time: ts.Add(5 * time.Hour)},
+		{ID: 4, Card: "100", PointName: "Gate", ReaderID: 2, Time: ts.Add(15 * time.Hour)},
+	}
+	ids := func(events []Event) []int {
+		result := make([]int, 0, len(events))
+		for _, e := range events {
+			result = append(result, e.ID)
+		}
+		return result
+	}
+
+	t.Run("empty filter keeps everything", func(t *testing.T) {
+		assert.Equal(t, []int{1, 2, 3, 4}, ids(EventFilter{}.Apply(events)))
+	})
+
+	t.Run("readers and cards", func(t *testing.T) {
+		filter := EventFilter{ExcludeReaders: []string{"Canteen"}, ExcludeCards: []string{"999"}}
+		assert.Equal(t, []int{1, 4}, ids(filter.Apply(events)))
+
+		filter = EventFilter{IncludeReaders: []string{"Canteen"}}
+		assert.Equal(t, []int{2}, ids(filter.Apply(events)))
+
+		// door ids work as well as names
+		filter = EventFilter{ExcludeReaders: []string{"7", "2"}}
+		assert.Equal(t, []int{1, 3}, ids(filter.Apply(events)))
+	})
+
+	t.Run("perimeter readers", func(t *testing.T) {
+		filter := EventFilter{PerimeterOnly: true, PerimeterReaders: []int{2, 7}}
+		assert.Equal(t, []int{2, 4}, ids(filter.Apply(events)))
+	})
+
+	t.Run("operating hours keep events", func(t *testing.T) {
+		filter := EventFilter{OperatingFrom: "06:00", OperatingTo: "22:00"}
+		assert.Equal(t, []int{1, 2, 3, 4}, ids(filter.Apply(events)))
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		assert.NotNil(t, EventFilter{OperatingFrom: "06:00"}.Validate())
+		assert.NotNil(t, EventFilter{OperatingFrom: "6am", OperatingTo: "22:00"}.Validate())
+		assert.Nil(t, EventFilter{OperatingFrom: "06:00", OperatingTo: "22:00"}.Validate())
+
+		_, err := ParseFlowPolicy([]byte(`{"filter": {"operating_from": "25:00", "operating_to": "22:00"}}`))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestClipIntervals(t *testing.T) {
+	day := time.Date(2021, 12, 15, 0, 0, 0, 0, time.UTC)
+	at := func(hours float64) time.Time { return day.Add(time.Duration(hours * float64(time.Hour))) }
+	interval := func(ent, ext float64) Interval {
+		return Interval{Ent: &Event{ID: 1, Time: at(ent)}, Ext: &Event{ID: 2, Time: at(ext)}}
+	}
+	bounds := func(intervals []Interval) [][2]time.Time {
+		result := make([][2]time.Time, 0, len(intervals))
+		for _, i := range intervals {
+			ext := time.Time{}
+			if i.Ext != nil {
+				ext = i.Ext.Time
+			}
+			result = append(result, [2]time.Time{i.Ent.Time, ext})
+		}
+		return result
+	}
+
+	t.Run("day hours", func(t *testing.T) {
+		filter := EventFilter{OperatingFrom: "06:00", OperatingTo: "22:00"}
+		intervals := []Interval{interval(5.5, 14), interval(15, 22.5), interval(22.5, 23), {Ent: &Event{Time: at(4)}}}
+
+		assert.Equal(t, [][2]time.Time{{at(6), at(14)}, {at(15), at(22)}, {at(6), {}}}, bounds(filter.ClipIntervals(intervals)))
+		// the events themselves are untouched
+		assert.Equal(t, at(5.5), intervals[0].Ent.Time)
+	})
+
+	t.Run("night hours wrap past midnight", func(t *testing.T) {
+		filter := EventFilter{OperatingFrom: "22:00", OperatingTo: "06:00"}
+		intervals := []Interval{interval(21, 30), interval(12, 13)}
+
+		assert.Equal(t, [][2]time.Time{{at(22), at(30)}}, bounds(filter.ClipIntervals(intervals)))
+	})
+
+	t.Run("alternating pairing keeps its directions", func(t *testing.T) {
+		user := &User{Card: "100"}
+		user.AddEvents([]Event{
+			{ID: 1, Card: "100", PointName: "Gate", Time: at(5.75)},
+			{ID: 2, Card: "100", PointName: "Gate", Time: at(12)},
+			{ID: 3, Card: "100", PointName: "Gate", Time: at(13)},
+			{ID: 4, Card: "100", PointName: "Gate", Time: at(22.5)},
+		})
+		policy := DefaultFlowPolicy()
+		policy.Pairing = PairingAlternating
+		policy.Filter = EventFilter{OperatingFrom: "06:00", OperatingTo: "22:00"}
+
+		user.RunFlowAt(at(24), 1, policy)
+
+		assert.Equal(t, [][2]time.Time{{at(6), at(12)}, {at(13), at(22)}}, bounds(user.Intervals))
+		assert.Equal(t, 1, user.Intervals[0].Ent.ID)
+		assert.Equal(t, 4, user.Intervals[1].Ext.ID)
+	})
+}
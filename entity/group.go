@@ -0,0 +1,42 @@
+package entity
+
+// Employees chosen by card, department or position, for configuration that
+// differs between groups of staff
+type EmployeeGroup struct {
+	Cards       []string `json:"cards"`
+	Departments []string `json:"departments"`
+	Positions   []string `json:"positions"`
+}
+
+func (g EmployeeGroup) hasCard(card string) bool {
+	return contains(g.Cards, card)
+}
+
+func (g EmployeeGroup) hasRole(department, position string) bool {
+	return (department != "" && contains(g.Departments, department)) || (position != "" && contains(g.Positions, position))
+}
+
+// Index of the group the employee belongs to, -1 for none. A group listing
+// the card wins over the first group of their department or position.
+func groupOf(groups []EmployeeGroup, card, department, position string) int {
+	for i, g := range groups {
+		if g.hasCard(card) {
+			return i
+		}
+	}
+	for i, g := range groups {
+		if g.hasRole(department, position) {
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	LeaveVacation     = "vacation"
+	LeaveSick         = "sick"
+	LeaveUnpaid       = "unpaid"
+	LeaveBusinessTrip = "business_trip"
+	LeaveOther        = "other"
+)
+
+// Timesheet codes of the unified T-13 form that 1C extracts carry
+var leaveCodes = map[string]string{
+	LeaveVacation:     "ОТ",
+	LeaveSick:         "Б",
+	LeaveUnpaid:       "ДО",
+	LeaveBusinessTrip: "К",
+	LeaveOther:        "НВ",
+}
+
+// Leave of an employee from From to To, both days inclusive. Working days
+// within it are explained, not absences.
+type Leave struct {
+	Card string    `db:"card" json:"card"`
+	Kind string    `db:"kind" json:"kind"`
+	From time.Time `db:"date_from" json:"from"`
+	To   time.Time `db:"date_to" json:"to"`
+}
+
+// Kind of a leave written as a kind name, its T-13 code or the Russian name
+// HR uses. Unknown values are an error so typos don't turn into other leaves.
+func ParseLeaveKind(value string) (string, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	for kind, code := range leaveCodes {
+		if v == kind || v == strings.ToLower(code) {
+			return kind, nil
+		}
+	}
+	switch {
+	case v == "од" || strings.HasPrefix(v, "отпуск") && !strings.Contains(v, "без сохранения"):
+		return LeaveVacation, nil
+	case strings.HasPrefix(v, "больничн") || strings.HasPrefix(v, "болезнь"):
+		return LeaveSick, nil
+	case strings.Contains(v, "без сохранения"):
+		return LeaveUnpaid, nil
+	case strings.HasPrefix(v, "командировка"):
+		return LeaveBusinessTrip, nil
+	}
+	return "", fmt.Errorf("unknown leave kind %q", value)
+}
+
+// T-13 timesheet code of the leave kind
+func LeaveCode(kind string) string {
+	if code, ok := leaveCodes[kind]; ok {
+		return code
+	}
+	return leaveCodes[LeaveOther]
+}
+
+func (l Leave) Validate() error {
+	if l.Card == "" {
+		return fmt.Errorf("leave without card")
+	}
+	if l.From.IsZero() || l.To.IsZero() {
+		return fmt.Errorf("leave of %s needs from and to", l.Card)
+	}
+	if l.To.Before(l.From) {
+		return fmt.Errorf("leave of %s ends before it starts", l.Card)
+	}
+	return nil
+}
+
+func (l Leave) Covers(day time.Time) bool {
+	day = day.Truncate(24 * time.Hour)
+	return !day.Before(l.From.Truncate(24*time.Hour)) && !day.After(l.To.Truncate(24*time.Hour))
+}
+
+// Leaves by card for lookups by day
+type LeaveIndex map[string][]Leave
+
+func NewLeaveIndex(leaves []Leave) LeaveIndex {
+	index := make(LeaveIndex)
+	for _, l := range leaves {
+		index[l.Card] = append(index[l.Card], l)
+	}
+	return index
+}
+
+func (index LeaveIndex) On(card string, day time.Time) (Leave, bool) {
+	for _, l := range index[card] {
+		if l.Covers(day) {
+			return l, true
+		}
+	}
+	return Leave{}, false
+}
+
+// Absences that no leave explains
+func ExcludeLeaves(absences []Absence, leaves []Leave) []Absence {
+	if len(leaves) == 0 {
+		return absences
+	}
+	index := NewLeaveIndex(leaves)
+	result := make([]Absence, 0, len(absences))
+	for _, a := range absences {
+		if _, ok := index.On(a.Card, a.Day); ok {
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
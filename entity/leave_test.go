@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLeaveKind(t *testing.T) {
+	for value, kind := range map[string]string{
+		"vacation":        LeaveVacation,
+		"ОТ":              LeaveVacation,
+		"Отпуск основной": LeaveVacation,
+		"Б":               LeaveSick,
+		"Больничный":      LeaveSick,
+		"ДО":              LeaveUnpaid,
+		"Отпуск без сохранения заработной платы": LeaveUnpaid,
+		"К": LeaveBusinessTrip,
+	} {
+		parsed, err := ParseLeaveKind(value)
+
+		assert.Nil(t, err, value)
+		assert.Equal(t, kind, parsed, value)
+	}
+
+	_, err := ParseLeaveKind("holiday")
+	assert.NotNil(t, err)
+}
+
+func TestExcludeLeaves(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	absences := []Absence{
+		{Card: "100", Day: day(4)},
+		{Card: "100", Day: day(11)},
+		{Card: "200", Day: day(4)},
+	}
+	leaves := []Leave{{Card: "100", Kind: LeaveVacation, From: day(1), To: day(10)}}
+
+	result := ExcludeLeaves(absences, leaves)
+
+	assert.Equal(t, []Absence{{Card: "100", Day: day(11)}, {Card: "200", Day: day(4)}}, result)
+
+	leave, ok := NewLeaveIndex(leaves).On("100", day(10))
+	assert.True(t, ok)
+	assert.Equal(t, "ОТ", LeaveCode(leave.Kind))
+}
@@ -0,0 +1,163 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Language of reports and the dashboard. Russian is what HR files and signs,
+// English goes to the group headquarters.
+type Locale string
+
+const (
+	LocaleRU Locale = "ru"
+	LocaleEN Locale = "en"
+)
+
+// Locale of a language tag such as ru, en or en-US
+func ParseLocale(value string) (Locale, error) {
+	tag := strings.ToLower(strings.TrimSpace(value))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	switch Locale(tag) {
+	case LocaleRU, LocaleEN:
+		return Locale(tag), nil
+	}
+	return "", fmt.Errorf("unknown language %q, expected ru or en", value)
+}
+
+// First supported language of an Accept-Language header, fallback when the
+// header names none. Quality values are ignored, browsers list by preference.
+func LocaleFromAcceptLanguage(header string, fallback Locale) Locale {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if locale, err := ParseLocale(tag); err == nil {
+			return locale
+		}
+	}
+	return fallback
+}
+
+var messages = map[string]map[Locale]string{
+	"timesheet.card":          {LocaleRU: "Табельный номер", LocaleEN: "Employee no."},
+	"timesheet.employee":      {LocaleRU: "Сотрудник", LocaleEN: "Employee"},
+	"timesheet.total":         {LocaleRU: "Итого", LocaleEN: "Total"},
+	"timesheet.day_off_hours": {LocaleRU: "В выходные и праздники", LocaleEN: "On weekends and holidays"},
+	"timesheet.no_department": {LocaleRU: "Без подразделения", LocaleEN: "No department"},
+	"timesheet.norm":          {LocaleRU: "Норма", LocaleEN: "Norm"},
+	"timesheet.balance":       {LocaleRU: "Отклонение от нормы", LocaleEN: "Balance"},
+	// absence markers, the Russian ones are codes of the unified T-13 form
+	"timesheet.absent":  {LocaleRU: "Н", LocaleEN: "A"},
+	"timesheet.day_off": {LocaleRU: "В", LocaleEN: "W"},
+
+	"summary.title":       {LocaleRU: "Сводка посещаемости за %s", LocaleEN: "Attendance summary for %s"},
+	"summary.employee":    {LocaleRU: "Сотрудник: %s", LocaleEN: "Employee: %s"},
+	"summary.card":        {LocaleRU: "Табельный номер: %s", LocaleEN: "Employee no.: %s"},
+	"summary.department":  {LocaleRU: "Подразделение: %s", LocaleEN: "Department: %s"},
+	"summary.position":    {LocaleRU: "Должность: %s", LocaleEN: "Position: %s"},
+	"summary.date":        {LocaleRU: "Дата", LocaleEN: "Date"},
+	"summary.weekday":     {LocaleRU: "День", LocaleEN: "Day"},
+	"summary.hours":       {LocaleRU: "Часы", LocaleEN: "Hours"},
+	"summary.marker":      {LocaleRU: "Отметка", LocaleEN: "Mark"},
+	"summary.shortened":   {LocaleRU: "сокр.", LocaleEN: "short"},
+	"summary.worked":      {LocaleRU: "Отработано дней: %d, часов: %.2f", LocaleEN: "Days worked: %d, hours: %.2f"},
+	"summary.day_off":     {LocaleRU: "В т.ч. в выходные и праздничные дни: %.2f ч", LocaleEN: "Of which on weekends and holidays: %.2f h"},
+	"summary.absent":      {LocaleRU: "Неявки в рабочие дни: %d", LocaleEN: "Absent on working days: %d"},
+	"summary.norm":        {LocaleRU: "Норма: %.2f ч, отклонение: %+.2f ч", LocaleEN: "Norm: %.2f h, balance: %+.2f h"},
+	"summary.signature":   {LocaleRU: "Сотрудник ____________________", LocaleEN: "Employee ____________________"},
+	"summary.responsible": {LocaleRU: "Ответственный ____________________", LocaleEN: "Approved by ____________________"},
+
+	"roster.day":        {LocaleRU: "Дата", LocaleEN: "Date"},
+	"roster.card":       {LocaleRU: "Табельный номер", LocaleEN: "Employee no."},
+	"roster.employee":   {LocaleRU: "Сотрудник", LocaleEN: "Employee"},
+	"roster.shift":      {LocaleRU: "Смена", LocaleEN: "Shift"},
+	"roster.planned":    {LocaleRU: "План", LocaleEN: "Planned"},
+	"roster.actual":     {LocaleRU: "Факт", LocaleEN: "Actual"},
+	"roster.difference": {LocaleRU: "Отклонение", LocaleEN: "Difference"},
+
+	"dashboard.title":          {LocaleRU: "Посещаемость %s", LocaleEN: "Attendance %s"},
+	"dashboard.last_load":      {LocaleRU: "Последняя загрузка", LocaleEN: "Last load"},
+	"dashboard.last_event":     {LocaleRU: "последнее событие", LocaleEN: "last event"},
+	"dashboard.none":           {LocaleRU: "нет", LocaleEN: "none"},
+	"dashboard.totals":         {LocaleRU: "сотрудников: %d, событий: %d, интервалов: %d", LocaleEN: "%d employees, %d events, %d intervals"},
+	"dashboard.worked_hours":   {LocaleRU: "Отработанные часы", LocaleEN: "Worked hours"},
+	"dashboard.employee":       {LocaleRU: "Сотрудник", LocaleEN: "Employee"},
+	"dashboard.open_intervals": {LocaleRU: "Открытые интервалы", LocaleEN: "Open intervals"},
+	"dashboard.card":           {LocaleRU: "Карта", LocaleEN: "Card"},
+	"dashboard.entered":        {LocaleRU: "Вход", LocaleEN: "Entered"},
+	"dashboard.anomalies":      {LocaleRU: "Аномалии", LocaleEN: "Anomalies"},
+	"dashboard.time":           {LocaleRU: "Время", LocaleEN: "Time"},
+	"dashboard.reason":         {LocaleRU: "Причина", LocaleEN: "Reason"},
+	"dashboard.event":          {LocaleRU: "Событие", LocaleEN: "Event"},
+}
+
+var anomalyDescriptions = map[AnomalyReason]map[Locale]string{
+	AnomalyDoubleEntry:      {LocaleRU: "повторный вход без выхода", LocaleEN: "entry repeated without exit"},
+	AnomalyExitWithoutEntry: {LocaleRU: "выход без входа", LocaleEN: "exit without entry"},
+	AnomalyUnknownCard:      {LocaleRU: "неизвестная карта", LocaleEN: "unknown card"},
+	AnomalyFutureEvent:      {LocaleRU: "событие в будущем", LocaleEN: "event in the future"},
+	AnomalyNoDoorOpening:    {LocaleRU: "проход без открытия двери", LocaleEN: "badge without door opening"},
+}
+
+// Leave markers of English reports, Russian ones use the T-13 codes
+var leaveCodesEN = map[string]string{
+	LeaveVacation:     "VAC",
+	LeaveSick:         "SL",
+	LeaveUnpaid:       "UL",
+	LeaveBusinessTrip: "BT",
+	LeaveOther:        "OA",
+}
+
+var weekdaysShort = map[Locale][7]string{
+	LocaleRU: {"вс", "пн", "вт", "ср", "чт", "пт", "сб"},
+	LocaleEN: {"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+}
+
+// Text of the message formatted with args. Unknown locales fall back to
+// Russian, unknown keys to the key itself.
+func (l Locale) T(key string, args ...any) string {
+	texts, ok := messages[key]
+	if !ok {
+		return key
+	}
+	text, ok := texts[l]
+	if !ok {
+		text = texts[LocaleRU]
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+func (l Locale) AnomalyDescription(reason AnomalyReason) string {
+	texts, ok := anomalyDescriptions[reason]
+	if !ok {
+		return string(reason)
+	}
+	if text, ok := texts[l]; ok {
+		return text
+	}
+	return texts[LocaleRU]
+}
+
+// Timesheet marker of the leave kind
+func (l Locale) LeaveCode(kind string) string {
+	if l != LocaleEN {
+		return LeaveCode(kind)
+	}
+	if code, ok := leaveCodesEN[kind]; ok {
+		return code
+	}
+	return leaveCodesEN[LeaveOther]
+}
+
+func (l Locale) Weekday(day time.Weekday) string {
+	names, ok := weekdaysShort[l]
+	if !ok {
+		names = weekdaysShort[LocaleRU]
+	}
+	return names[day]
+}
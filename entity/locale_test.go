@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLocale(t *testing.T) {
+	for value, locale := range map[string]Locale{"ru": LocaleRU, "EN": LocaleEN, "en-US": LocaleEN, "ru_RU": LocaleRU} {
+		parsed, err := ParseLocale(value)
+
+		assert.Nil(t, err, value)
+		assert.Equal(t, locale, parsed, value)
+	}
+
+	_, err := ParseLocale("de")
+	assert.NotNil(t, err)
+}
+
+func TestLocaleFromAcceptLanguage(t *testing.T) {
+	assert.Equal(t, LocaleEN, LocaleFromAcceptLanguage("de-DE,de;q=0.9,en-US;q=0.8", LocaleRU))
+	assert.Equal(t, LocaleRU, LocaleFromAcceptLanguage("fr", LocaleRU))
+	assert.Equal(t, LocaleEN, LocaleFromAcceptLanguage("", LocaleEN))
+}
+
+func TestLocaleTexts(t *testing.T) {
+	t.Run("formats messages", func(t *testing.T) {
+		assert.Equal(t, "Attendance summary for 03.2024", LocaleEN.T("summary.title", "03.2024"))
+		assert.Equal(t, "Итого", LocaleRU.T("timesheet.total"))
+	})
+
+	t.Run("falls back to russian and the key", func(t *testing.T) {
+		assert.Equal(t, "Итого", Locale("de").T("timesheet.total"))
+		assert.Equal(t, "missing.key", LocaleEN.T("missing.key"))
+	})
+
+	t.Run("leave codes", func(t *testing.T) {
+		assert.Equal(t, "ОТ", LocaleRU.LeaveCode(LeaveVacation))
+		assert.Equal(t, "VAC", LocaleEN.LeaveCode(LeaveVacation))
+		assert.Equal(t, "OA", LocaleEN.LeaveCode("unknown"))
+	})
+
+	t.Run("anomalies and weekdays", func(t *testing.T) {
+		assert.Equal(t, "unknown card", LocaleEN.AnomalyDescription(AnomalyUnknownCard))
+		assert.Equal(t, "custom", LocaleEN.AnomalyDescription("custom"))
+		assert.Equal(t, "пн", LocaleRU.Weekday(time.Monday))
+		assert.Equal(t, "Mon", LocaleEN.Weekday(time.Monday))
+	})
+}
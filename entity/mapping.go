@@ -0,0 +1,48 @@
+package entity
+
+import "strings"
+
+// Canonical name and employee number of a card holder, maintained by hand for
+// cards the controller stores garbage names for
+type CardMapping struct {
+	Card           string `db:"card"`
+	FirstName      string `db:"firstname"`
+	LastName       string `db:"lastname"`
+	EmployeeNumber string `db:"employee_number"`
+}
+
+// Replaces the names of users with a mapping of their card and returns how many
+// users got another name. Empty mapping names keep the user's name.
+func ApplyCardMappings(users []*User, mappings []CardMapping) int {
+	byCard := make(map[string]CardMapping, len(mappings))
+	for _, m := range mappings {
+		byCard[strings.TrimSpace(m.Card)] = m
+	}
+
+	changed := 0
+	for _, user := range users {
+		m, ok := byCard[user.Card]
+		if !ok {
+			continue
+		}
+		renamed := false
+		for _, f := range []struct {
+			value   *string
+			mapping string
+		}{
+			{&user.FirstName, m.FirstName},
+			{&user.LastName, m.LastName},
+		} {
+			mapping := strings.TrimSpace(f.mapping)
+			if mapping == "" || mapping == *f.value {
+				continue
+			}
+			*f.value = mapping
+			renamed = true
+		}
+		if renamed {
+			changed++
+		}
+	}
+	return changed
+}
@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyCardMappings(t *testing.T) {
+	users := []*User{
+		{Card: "1", FirstName: "???", LastName: "Petr0v"},
+		{Card: "2", FirstName: "Anna", LastName: "Smirnova"},
+		{Card: "3", FirstName: "Oleg", LastName: "Ivanov"},
+	}
+	mappings := []CardMapping{
+		{Card: "1", FirstName: "Ivan", LastName: "Petrov", EmployeeNumber: "T-001"},
+		{Card: " 2 ", FirstName: "Anna", LastName: "Smirnova"},
+		{Card: "3", LastName: "Ivanov-Sidorov"},
+	}
+
+	changed := ApplyCardMappings(users, mappings)
+
+	t.Run("mapping names win", func(t *testing.T) {
+		assert.Equal(t, "Ivan", users[0].FirstName)
+		assert.Equal(t, "Petrov", users[0].LastName)
+	})
+
+	t.Run("empty mapping names keep the controller name", func(t *testing.T) {
+		assert.Equal(t, "Oleg", users[2].FirstName)
+		assert.Equal(t, "Ivanov-Sidorov", users[2].LastName)
+	})
+
+	t.Run("only renamed users are counted", func(t *testing.T) {
+		assert.Equal(t, 2, changed)
+	})
+}
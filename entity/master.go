@@ -0,0 +1,63 @@
+package entity
+
+import "strings"
+
+// Employee record of the HR system, authoritative for names and departments
+type MasterEmployee struct {
+	Card       string `json:"card"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Department string `json:"department"`
+	Position   string `json:"position"`
+}
+
+const ConflictMissingInMaster = "record"
+
+// A field where the controller and the HR system disagree
+type EmployeeConflict struct {
+	Card       string
+	Field      string
+	Controller string
+	Master     string
+}
+
+// Replaces names, department and position of every user with its HR record and
+// reports the fields that differ. Fields HR leaves empty are not known there and
+// keep the controller value. Users unknown to HR keep controller data and are
+// reported with the record field, HR records without a controller user are ignored
+// since cards only come from the controller.
+func ApplyMasterData(users []*User, master []MasterEmployee) []EmployeeConflict {
+	byCard := make(map[string]MasterEmployee, len(master))
+	for _, m := range master {
+		byCard[strings.TrimSpace(m.Card)] = m
+	}
+
+	conflicts := make([]EmployeeConflict, 0)
+	for _, user := range users {
+		m, ok := byCard[user.Card]
+		if !ok {
+			conflicts = append(conflicts, EmployeeConflict{Card: user.Card, Field: ConflictMissingInMaster, Controller: user.Card})
+			continue
+		}
+
+		fields := []struct {
+			name   string
+			value  *string
+			master string
+		}{
+			{"firstname", &user.FirstName, m.FirstName},
+			{"lastname", &user.LastName, m.LastName},
+			{"department", &user.Department, m.Department},
+			{"position", &user.Position, m.Position},
+		}
+		for _, f := range fields {
+			master := strings.TrimSpace(f.master)
+			if master == "" || master == strings.TrimSpace(*f.value) {
+				continue
+			}
+			conflicts = append(conflicts, EmployeeConflict{Card: user.Card, Field: f.name, Controller: *f.value, Master: master})
+			*f.value = master
+		}
+	}
+	return conflicts
+}
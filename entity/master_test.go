@@ -0,0 +1,44 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyMasterData(t *testing.T) {
+	users := []*User{
+		{Card: "1", FirstName: "Ivan", LastName: "Petrov", Department: "Assembly"},
+		{Card: "2", FirstName: "Anna", LastName: "Smirnova"},
+		{Card: "4", FirstName: "Pavel", LastName: "Orlov", Department: "Paint", Position: "Painter"},
+	}
+	master := []MasterEmployee{
+		{Card: "1", FirstName: "Ivan", LastName: "Petrov", Department: "Welding", Position: "Welder"},
+		{Card: "3", FirstName: "Oleg", LastName: "Ivanov"},
+		{Card: "4", FirstName: "Pavel", LastName: "Orlov", Department: " "},
+	}
+
+	conflicts := ApplyMasterData(users, master)
+
+	t.Run("hr values win", func(t *testing.T) {
+		assert.Equal(t, "Welding", users[0].Department)
+		assert.Equal(t, "Welder", users[0].Position)
+	})
+
+	t.Run("conflicts are reported", func(t *testing.T) {
+		assert.Equal(t, []EmployeeConflict{
+			{Card: "1", Field: "department", Controller: "Assembly", Master: "Welding"},
+			{Card: "1", Field: "position", Controller: "", Master: "Welder"},
+			{Card: "2", Field: ConflictMissingInMaster, Controller: "2"},
+		}, conflicts)
+	})
+
+	t.Run("users unknown to hr keep controller data", func(t *testing.T) {
+		assert.Equal(t, "Anna", users[1].FirstName)
+	})
+
+	t.Run("fields hr leaves empty keep controller data", func(t *testing.T) {
+		assert.Equal(t, "Paint", users[2].Department)
+		assert.Equal(t, "Painter", users[2].Position)
+	})
+}
@@ -0,0 +1,92 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Weekly hours of a full-time five-day week
+const StandardWeeklyHours = 40
+
+/*
+ * Weekly working time norms. An employee listed by card in a group gets its
+ * norm, otherwise the first group matching their department or position, e.g.
+ * 36 hours for hazardous work or 20 for part-time. Everybody else works
+ * WeeklyHours.
+ */
+type WorkNorms struct {
+	WeeklyHours float64     `json:"weekly_hours"`
+	Groups      []NormGroup `json:"groups"`
+}
+
+type NormGroup struct {
+	Name        string  `json:"name"`
+	WeeklyHours float64 `json:"weekly_hours"`
+	EmployeeGroup
+}
+
+func DefaultWorkNorms() WorkNorms {
+	return WorkNorms{WeeklyHours: StandardWeeklyHours}
+}
+
+// Fields missing in the document keep their defaults
+func ParseWorkNorms(data []byte) (WorkNorms, error) {
+	norms := DefaultWorkNorms()
+	if err := json.Unmarshal(data, &norms); err != nil {
+		return WorkNorms{}, fmt.Errorf("parsing work norms: %w", err)
+	}
+	return norms, norms.Validate()
+}
+
+func (n WorkNorms) Validate() error {
+	if n.WeeklyHours <= 0 || n.WeeklyHours > 7*24 {
+		return fmt.Errorf("work norms weekly_hours must be within (0, 168]")
+	}
+	for _, g := range n.Groups {
+		if g.WeeklyHours <= 0 || g.WeeklyHours > 7*24 {
+			return fmt.Errorf("work norm group %q weekly_hours must be within (0, 168]", g.Name)
+		}
+	}
+	return nil
+}
+
+// Weekly hours the employee is expected to work
+func (n WorkNorms) Weekly(card, department, position string) float64 {
+	groups := make([]EmployeeGroup, len(n.Groups))
+	for i, g := range n.Groups {
+		groups[i] = g.EmployeeGroup
+	}
+	if i := groupOf(groups, card, department, position); i >= 0 {
+		return n.Groups[i].WeeklyHours
+	}
+	return n.WeeklyHours
+}
+
+// Norm of a day of the given kind: a fifth of the week on a working day, an
+// hour less on a shortened day, nothing on a day off
+func DayNorm(weekly float64, kind DayKind) float64 {
+	norm := weekly / 5
+	switch kind {
+	case DayOff:
+		return 0
+	case DayShortened:
+		if norm > 1 {
+			return norm - 1
+		}
+	}
+	return norm
+}
+
+// Norm of the days in [from, to) except the ones skip returns true for, such
+// as days on leave. skip may be nil.
+func PeriodNorm(weekly float64, calendar Calendar, from, to time.Time, skip func(day time.Time) bool) float64 {
+	var norm float64
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if skip != nil && skip(day) {
+			continue
+		}
+		norm += DayNorm(weekly, calendar.Kind(day))
+	}
+	return norm
+}
@@ -0,0 +1,42 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkNorms(t *testing.T) {
+	norms, err := ParseWorkNorms([]byte(`{"groups": [
+		{"name": "hazardous", "weekly_hours": 36, "departments": ["Plating"], "positions": ["Welder"]},
+		{"name": "part-time", "weekly_hours": 20, "cards": ["1042"]}
+	]}`))
+	assert.Nil(t, err)
+
+	t.Run("cards win over departments", func(t *testing.T) {
+		assert.Equal(t, 20.0, norms.Weekly("1042", "Plating", ""))
+		assert.Equal(t, 36.0, norms.Weekly("1001", "Plating", ""))
+		assert.Equal(t, 36.0, norms.Weekly("1001", "Assembly", "Welder"))
+		assert.Equal(t, 40.0, norms.Weekly("1001", "Assembly", "Fitter"))
+	})
+
+	t.Run("rejects a group without hours", func(t *testing.T) {
+		_, err := ParseWorkNorms([]byte(`{"groups": [{"name": "empty"}]}`))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPeriodNorm(t *testing.T) {
+	calendar := Calendar{Shortened: []string{"2024-03-07"}, Holidays: []string{"2024-03-08"}}
+	assert.Nil(t, calendar.init())
+	from := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+
+	assert.Equal(t, 31.0, PeriodNorm(40, calendar, from, to, nil))
+	assert.InDelta(t, 27.8, PeriodNorm(36, calendar, from, to, nil), 0.001)
+
+	monday := func(day time.Time) bool { return day.Equal(from) }
+	assert.Equal(t, 23.0, PeriodNorm(40, calendar, from, to, monday))
+}
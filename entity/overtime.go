@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// first hours over the shift norm of a working day, paid 1.5x
+	OvertimeRegular = "overtime"
+	// hours beyond OvertimeRegularHours, paid 2x
+	OvertimeExtended = "overtime_extended"
+	// every hour worked on a weekend or a holiday, paid 2x
+	OvertimeDayOff = "day_off_work"
+
+	OvertimeRegularHours = 2
+)
+
+var overtimeRates = map[string]float64{
+	OvertimeRegular:  1.5,
+	OvertimeExtended: 2,
+	OvertimeDayOff:   2,
+}
+
+type Overtime struct {
+	Card   string
+	Day    time.Time
+	Reason string
+	Hours  float64
+	Rate   float64
+}
+
+/*
+ * Overtime of every day with closed intervals, an interval counts towards
+ * the day it starts on. The norm of a working day is the shift length,
+ * an hour less on a shortened day, days off have no norm at all.
+ */
+func (u *User) Overtime(policy FlowPolicy, calendar Calendar) []Overtime {
+	worked := make(map[time.Time]time.Duration)
+	for _, interval := range u.Intervals {
+		if interval.Ext == nil {
+			continue
+		}
+		t := interval.Ent.Time
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		worked[day] += interval.Dur()
+	}
+
+	days := make([]time.Time, 0, len(worked))
+	for day := range worked {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	result := make([]Overtime, 0)
+	add := func(day time.Time, reason string, hours float64) {
+		if hours > 0 {
+			result = append(result, Overtime{Card: u.Card, Day: day, Reason: reason, Hours: hours, Rate: overtimeRates[reason]})
+		}
+	}
+	for _, day := range days {
+		hours := worked[day].Hours()
+
+		switch kind := calendar.Kind(day); kind {
+		case DayOff:
+			add(day, OvertimeDayOff, hours)
+		default:
+			norm := policy.ShiftHours
+			if kind == DayShortened {
+				norm--
+			}
+			over := hours - norm
+			if over <= 0 {
+				continue
+			}
+			regular := over
+			if regular > OvertimeRegularHours {
+				regular = OvertimeRegularHours
+			}
+			add(day, OvertimeRegular, regular)
+			add(day, OvertimeExtended, over-regular)
+		}
+	}
+	return result
+}
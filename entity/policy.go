@@ -0,0 +1,244 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// directions alternate, shaped by the workshift length
+	PairingAlternating = "alternating"
+	// directions come from the entry/exit reader channels
+	PairingDirection = "direction"
+)
+
+// Tunable interval formation rules, sites with different turnstile
+// behaviors load them from a policy file
+type FlowPolicy struct {
+	// events of a card closer than this are treated as one
+	CollisionJitterSec int `json:"collision_jitter_sec"`
+	// an entry followed by an event later than this is an entry with a forgotten exit
+	MaxShiftHours float64 `json:"max_shift_hours"`
+	// closed intervals shorter than this are dropped
+	MinIntervalSec int `json:"min_interval_sec"`
+	// grace period: consecutive intervals separated by a shorter gap, such as a
+	// smoke break, are merged into one and the merge is kept for the audit
+	MaxMergeGapSec int `json:"max_merge_gap_sec"`
+	// how event directions are determined
+	Pairing string `json:"pairing"`
+
+	// worked hours from which a day counts as a full or a half day, less is partial presence
+	FullDayHours float64 `json:"full_day_hours"`
+	HalfDayHours float64 `json:"half_day_hours"`
+	// worked hours of a working day above this are overtime
+	ShiftHours float64 `json:"shift_hours"`
+	// rounding of reported worked time, intervals are stored unrounded
+	Rounding Rounding `json:"rounding"`
+	// also summarize days as first entry and last exit in daily_attendance
+	DailySummary bool `json:"daily_summary"`
+
+	// events kept out of interval formation
+	Filter EventFilter `json:"filter"`
+	// readers between zones, presence per zone is only computed when set
+	Zones []ZoneTransition `json:"zones"`
+
+	// rules of groups of employees that differ from these, e.g. 24/7 crews
+	Profiles []PolicyProfile `json:"profiles"`
+}
+
+/*
+ * Policy of a group of employees, such as office staff with short breaks
+ * merged and rounded hours next to production crews on 12 hour shifts. Policy
+ * is a policy document whose fields replace those of the enclosing policy,
+ * the rest are inherited.
+ */
+type PolicyProfile struct {
+	Name string `json:"name"`
+	EmployeeGroup
+	Policy json.RawMessage `json:"policy"`
+}
+
+func DefaultFlowPolicy() FlowPolicy {
+	return FlowPolicy{
+		CollisionJitterSec: EVENT_COLLISION_JITTER_SEC,
+		MaxShiftHours:      IDEAL_WORKSHIFT_DUR,
+		Pairing:            PairingAlternating,
+		FullDayHours:       8,
+		HalfDayHours:       4,
+		ShiftHours:         8,
+	}
+}
+
+// Fields missing in the document keep their defaults
+func ParseFlowPolicy(data []byte) (FlowPolicy, error) {
+	policy := DefaultFlowPolicy()
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return FlowPolicy{}, fmt.Errorf("parsing flow policy: %w", err)
+	}
+	return policy, policy.Validate()
+}
+
+func (p FlowPolicy) Validate() error {
+	if p.CollisionJitterSec < 0 || p.MinIntervalSec < 0 || p.MaxMergeGapSec < 0 {
+		return fmt.Errorf("flow policy durations must not be negative")
+	}
+	if p.MaxShiftHours <= 0 {
+		return fmt.Errorf("flow policy max_shift_hours must be positive")
+	}
+	if p.HalfDayHours <= 0 || p.FullDayHours < p.HalfDayHours {
+		return fmt.Errorf("flow policy needs 0 < half_day_hours <= full_day_hours")
+	}
+	if p.ShiftHours <= 0 {
+		return fmt.Errorf("flow policy shift_hours must be positive")
+	}
+	switch p.Pairing {
+	case PairingAlternating, PairingDirection:
+	default:
+		return fmt.Errorf("unknown flow policy pairing: %q", p.Pairing)
+	}
+	if err := p.Rounding.Validate(); err != nil {
+		return err
+	}
+	if err := validateZones(p.Zones); err != nil {
+		return err
+	}
+	if err := p.Filter.Validate(); err != nil {
+		return err
+	}
+	for _, profile := range p.Profiles {
+		resolved, err := p.apply(profile)
+		if err != nil {
+			return err
+		}
+		if len(resolved.Profiles) > 0 {
+			return fmt.Errorf("flow policy profile %q: profiles can't be nested", profile.Name)
+		}
+		if err := resolved.Validate(); err != nil {
+			return fmt.Errorf("flow policy profile %q: %w", profile.Name, err)
+		}
+	}
+	return nil
+}
+
+// Policy of the employee: that of the profile they belong to, if any
+func (p FlowPolicy) For(card, department, position string) FlowPolicy {
+	if len(p.Profiles) == 0 {
+		return p
+	}
+	groups := make([]EmployeeGroup, len(p.Profiles))
+	for i, profile := range p.Profiles {
+		groups[i] = profile.EmployeeGroup
+	}
+	i := groupOf(groups, card, department, position)
+	if i < 0 {
+		return p
+	}
+	// validated with the policy
+	resolved, _ := p.apply(p.Profiles[i])
+	return resolved
+}
+
+func (p FlowPolicy) ForUser(u *User) FlowPolicy {
+	return p.For(u.Card, u.Department, u.Position)
+}
+
+// Whether test holds for the policy or the policy of any profile
+func (p FlowPolicy) Any(test func(FlowPolicy) bool) bool {
+	if test(p) {
+		return true
+	}
+	for _, profile := range p.Profiles {
+		if resolved, err := p.apply(profile); err == nil && test(resolved) {
+			return true
+		}
+	}
+	return false
+}
+
+// Slices are copied before the profile document is decoded onto them, json
+// reuses their arrays and would change the enclosing policy
+func (p FlowPolicy) apply(profile PolicyProfile) (FlowPolicy, error) {
+	resolved := p
+	resolved.Profiles = nil
+	resolved.Zones = append([]ZoneTransition(nil), p.Zones...)
+	resolved.Filter.IncludeReaders = append([]string(nil), p.Filter.IncludeReaders...)
+	resolved.Filter.ExcludeReaders = append([]string(nil), p.Filter.ExcludeReaders...)
+	resolved.Filter.IncludeCards = append([]string(nil), p.Filter.IncludeCards...)
+	resolved.Filter.ExcludeCards = append([]string(nil), p.Filter.ExcludeCards...)
+	if len(profile.Policy) == 0 {
+		return resolved, nil
+	}
+	if err := json.Unmarshal(profile.Policy, &resolved); err != nil {
+		return FlowPolicy{}, fmt.Errorf("parsing flow policy profile %q: %w", profile.Name, err)
+	}
+	return resolved, nil
+}
+
+// Short exit the grace period turned into part of a continuous interval
+type IntervalMerge struct {
+	Card string
+	// entry of the merged interval
+	Ent *Event
+	// the exit and the re-entry that no longer bound intervals
+	Exit    *Event
+	Reentry *Event
+}
+
+func (m IntervalMerge) Gap() time.Duration {
+	return m.Reentry.Time.Sub(m.Exit.Time)
+}
+
+/*
+ * Applies the interval rules of the policy: intervals separated by a gap
+ * shorter than MaxMergeGapSec are merged, then too short ones are dropped
+ */
+func ApplyIntervalRules(intervals []Interval, policy FlowPolicy) []Interval {
+	result, _ := ApplyIntervalRulesWithMerges(intervals, policy)
+	return result
+}
+
+// Like ApplyIntervalRules, also returning the merges of the intervals kept
+func ApplyIntervalRulesWithMerges(intervals []Interval, policy FlowPolicy) ([]Interval, []IntervalMerge) {
+	merged := make([]Interval, 0, len(intervals))
+	merges := make([]IntervalMerge, 0)
+	maxGap := time.Duration(policy.MaxMergeGapSec) * time.Second
+
+	for _, interval := range intervals {
+		if n := len(merged); n > 0 && maxGap > 0 {
+			prev := &merged[n-1]
+			if prev.Ext != nil && interval.Ent.Time.Sub(prev.Ext.Time) < maxGap {
+				merges = append(merges, IntervalMerge{Ent: prev.Ent, Exit: prev.Ext, Reentry: interval.Ent})
+				prev.Ext = interval.Ext
+				continue
+			}
+		}
+		merged = append(merged, interval)
+	}
+
+	minDur := time.Duration(policy.MinIntervalSec) * time.Second
+	result := make([]Interval, 0, len(merged))
+	kept := make(map[*Event]bool, len(merged))
+	for _, interval := range merged {
+		if interval.Ext != nil && interval.Dur() < minDur {
+			continue
+		}
+		result = append(result, interval)
+		kept[interval.Ent] = true
+	}
+	keptMerges := merges[:0]
+	for _, m := range merges {
+		if kept[m.Ent] {
+			keptMerges = append(keptMerges, m)
+		}
+	}
+	return result, keptMerges
+}
+
+func (p FlowPolicy) SetDirections(events []Event) {
+	if p.Pairing == PairingDirection {
+		SetEventDirectionFromReaders(events, p.MaxShiftHours)
+		return
+	}
+	SetEventDirectionWithin(events, p.MaxShiftHours)
+}
@@ -0,0 +1,161 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlowPolicy(t *testing.T) {
+	t.Run("missing fields keep defaults", func(t *testing.T) {
+		policy, err := ParseFlowPolicy([]byte(`{"min_interval_sec": 60}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, 60, policy.MinIntervalSec)
+		assert.Equal(t, EVENT_COLLISION_JITTER_SEC, policy.CollisionJitterSec)
+		assert.Equal(t, PairingAlternating, policy.Pairing)
+	})
+
+	t.Run("unknown pairing", func(t *testing.T) {
+		_, err := ParseFlowPolicy([]byte(`{"pairing": "random"}`))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid profile", func(t *testing.T) {
+		_, err := ParseFlowPolicy([]byte(`{"profiles": [{"name": "crews", "policy": {"shift_hours": -1}}]}`))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFlowPolicyProfiles(t *testing.T) {
+	policy, err := ParseFlowPolicy([]byte(`{
+		"min_interval_sec": 60,
+		"filter": {"exclude_readers": ["Canteen"]},
+		"profiles": [
+			{"name": "crews", "departments": ["Foundry"], "policy": {"shift_hours": 12, "filter": {"exclude_readers": ["Gate"]}}},
+			{"name": "office", "cards": ["1042"], "policy": {"daily_summary": true}}
+		]
+	}`))
+	assert.Nil(t, err)
+
+	t.Run("department profile overrides and inherits", func(t *testing.T) {
+		crews := policy.For("1001", "Foundry", "")
+
+		assert.Equal(t, 12.0, crews.ShiftHours)
+		assert.Equal(t, 60, crews.MinIntervalSec)
+		assert.Equal(t, []string{"Gate"}, crews.Filter.ExcludeReaders)
+		assert.Equal(t, []string{"Canteen"}, policy.Filter.ExcludeReaders)
+	})
+
+	t.Run("card profile wins", func(t *testing.T) {
+		office := policy.For("1042", "Foundry", "")
+
+		assert.True(t, office.DailySummary)
+		assert.Equal(t, 8.0, office.ShiftHours)
+	})
+
+	t.Run("others keep the policy", func(t *testing.T) {
+		assert.Equal(t, 8.0, policy.For("1001", "Assembly", "").ShiftHours)
+		assert.True(t, policy.Any(func(p FlowPolicy) bool { return p.DailySummary }))
+		assert.False(t, policy.Any(func(p FlowPolicy) bool { return !p.Rounding.IsZero() }))
+	})
+}
+
+func TestApplyIntervalRules(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 1, Time: ts},
+		{ID: 2, Time: ts.Add(4 * time.Hour)},
+		{ID: 3, Time: ts.Add(4*time.Hour + 5*time.Minute)},
+		{ID: 4, Time: ts.Add(8 * time.Hour)},
+		{ID: 5, Time: ts.Add(10 * time.Hour)},
+		{ID: 6, Time: ts.Add(10*time.Hour + 30*time.Second)},
+	}
+	intervals := []Interval{
+		{Ent: &events[0], Ext: &events[1]},
+		{Ent: &events[2], Ext: &events[3]},
+		{Ent: &events[4], Ext: &events[5]},
+	}
+	policy := DefaultFlowPolicy()
+	policy.MaxMergeGapSec = 600
+	policy.MinIntervalSec = 60
+
+	result := ApplyIntervalRules(intervals, policy)
+
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, 1, result[0].Ent.ID)
+	assert.Equal(t, 4, result[0].Ext.ID)
+}
+
+func TestApplyIntervalRulesWithMerges(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 1, Time: ts},
+		{ID: 2, Time: ts.Add(2 * time.Hour)},
+		{ID: 3, Time: ts.Add(2*time.Hour + 8*time.Minute)},
+		{ID: 4, Time: ts.Add(4 * time.Hour)},
+		{ID: 5, Time: ts.Add(5 * time.Hour)},
+		{ID: 6, Time: ts.Add(9 * time.Hour)},
+	}
+	intervals := []Interval{
+		{Ent: &events[0], Ext: &events[1]},
+		{Ent: &events[2], Ext: &events[3]},
+		{Ent: &events[4], Ext: &events[5]},
+	}
+	policy := DefaultFlowPolicy()
+	policy.MaxMergeGapSec = 600
+
+	result, merges := ApplyIntervalRulesWithMerges(intervals, policy)
+
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, 4, result[0].Ext.ID)
+	assert.Equal(t, 1, len(merges))
+	assert.Equal(t, 1, merges[0].Ent.ID)
+	assert.Equal(t, 2, merges[0].Exit.ID)
+	assert.Equal(t, 3, merges[0].Reentry.ID)
+	assert.Equal(t, 8*time.Minute, merges[0].Gap())
+}
+
+func TestClassifyDay(t *testing.T) {
+	policy := DefaultFlowPolicy()
+
+	assert.Equal(t, DayAbsent, ClassifyDay(0, policy))
+	assert.Equal(t, DayPartial, ClassifyDay(2.5, policy))
+	assert.Equal(t, DayHalf, ClassifyDay(4, policy))
+	assert.Equal(t, DayFull, ClassifyDay(8.2, policy))
+}
+
+func TestOvertime(t *testing.T) {
+	calendar, _ := ParseCalendar([]byte(`{"timezone": "UTC", "shortened": ["2026-05-08"]}`))
+	at := func(day string, hour int) *Event {
+		d, _ := time.Parse("2006-01-02", day)
+		return &Event{Time: d.Add(time.Duration(hour) * time.Hour)}
+	}
+	user := &User{Card: "1", Intervals: []Interval{
+		// working day, 3 hours over the norm
+		{Ent: at("2026-05-12", 7), Ext: at("2026-05-12", 18)},
+		// shortened day, an hour over its 7 hour norm
+		{Ent: at("2026-05-08", 8), Ext: at("2026-05-08", 16)},
+		// saturday
+		{Ent: at("2026-05-16", 9), Ext: at("2026-05-16", 13)},
+		// within the norm
+		{Ent: at("2026-05-13", 9), Ext: at("2026-05-13", 17)},
+		{Ent: at("2026-05-14", 9)},
+	}}
+
+	overtime := user.Overtime(DefaultFlowPolicy(), calendar)
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	assert.Equal(t, []Overtime{
+		{Card: "1", Day: day("2026-05-08"), Reason: OvertimeRegular, Hours: 1, Rate: 1.5},
+		{Card: "1", Day: day("2026-05-12"), Reason: OvertimeRegular, Hours: 2, Rate: 1.5},
+		{Card: "1", Day: day("2026-05-12"), Reason: OvertimeExtended, Hours: 1, Rate: 2},
+		{Card: "1", Day: day("2026-05-16"), Reason: OvertimeDayOff, Hours: 4, Rate: 2},
+	}, overtime)
+}
@@ -0,0 +1,88 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+// Data quality of a day: how well badge events pair into intervals
+type DayQuality struct {
+	Day             time.Time
+	Events          int
+	Duplicates      int
+	Anomalies       int
+	Intervals       int
+	ClosedIntervals int
+}
+
+/*
+ * Score in [0, 100]: the share of closed intervals among all intervals of the
+ * day, reduced by the share of events flagged as anomalies. A day with events
+ * but no intervals scores 0, a day without events is not scored.
+ */
+func (q DayQuality) Score() float64 {
+	if q.Intervals == 0 {
+		return 0
+	}
+	paired := float64(q.ClosedIntervals) / float64(q.Intervals)
+	clean := 1.0
+	if q.Events > 0 {
+		clean -= float64(q.Anomalies) / float64(q.Events)
+		if clean < 0 {
+			clean = 0
+		}
+	}
+	return 100 * paired * clean
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Aggregates the run results of users per day, extra anomalies are
+// the ones not bound to a user such as unknown cards
+func ComputeDayQuality(users []*User, extra []Anomaly) []DayQuality {
+	days := make(map[time.Time]*DayQuality)
+	get := func(t time.Time) *DayQuality {
+		d := day(t)
+		q, ok := days[d]
+		if !ok {
+			q = &DayQuality{Day: d}
+			days[d] = q
+		}
+		return q
+	}
+
+	for _, user := range users {
+		for _, event := range user.Events {
+			get(event.Time).Events++
+		}
+		for _, event := range user.Collapsed {
+			q := get(event.Time)
+			q.Events++
+			q.Duplicates++
+		}
+		for _, interval := range user.Intervals {
+			q := get(interval.Ent.Time)
+			q.Intervals++
+			if interval.Ext != nil {
+				q.ClosedIntervals++
+			}
+		}
+		for _, anomaly := range user.Anomalies {
+			get(anomaly.Event.Time).Anomalies++
+		}
+	}
+	for _, anomaly := range extra {
+		q := get(anomaly.Event.Time)
+		q.Events++
+		q.Anomalies++
+	}
+
+	result := make([]DayQuality, 0, len(days))
+	for _, q := range days {
+		result = append(result, *q)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Day.Before(result[j].Day) })
+	return result
+}
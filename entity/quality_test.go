@@ -0,0 +1,38 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeDayQuality(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 1, Card: "1", PointName: "gate", Time: ts},
+		{ID: 2, Card: "1", PointName: "gate", Time: ts.Add(30 * time.Second)},
+		{ID: 3, Card: "1", PointName: "gate", Time: ts.Add(8 * time.Hour)},
+		{ID: 4, Card: "1", PointName: "gate", Time: ts.Add(9 * time.Hour)},
+	}
+	user := &User{Card: "1"}
+	user.AddEvents(events)
+	user.RunFlowAt(ts.Add(10*time.Hour), 1, DefaultFlowPolicy())
+
+	quality := ComputeDayQuality([]*User{user}, []Anomaly{{Event: Event{ID: 5, Time: ts}, Reason: AnomalyUnknownCard}})
+
+	assert.Len(t, quality, 1)
+	q := quality[0]
+	assert.Equal(t, ts.Truncate(24*time.Hour), q.Day)
+	assert.Equal(t, 5, q.Events)
+	assert.Equal(t, 1, q.Duplicates)
+	assert.Equal(t, 2, q.Anomalies)
+	assert.Equal(t, 1, q.Intervals)
+	assert.Equal(t, 1, q.ClosedIntervals)
+	assert.InDelta(t, 60, q.Score(), 0.001)
+}
+
+func TestDayQualityScore(t *testing.T) {
+	assert.Equal(t, 100.0, DayQuality{Events: 2, Intervals: 1, ClosedIntervals: 1}.Score())
+	assert.Equal(t, 0.0, DayQuality{Events: 3}.Score())
+}
@@ -0,0 +1,27 @@
+package entity
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Door of a controller as ZKAccess keeps it in acc_door. Events carry the door
+// id as event_point_id and its name as event_point_name.
+type Reader struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	DeviceID int    `json:"device_id"`
+	DoorNo   int    `json:"door_no"`
+}
+
+func ReaderFromDBRecord(record []string, index map[string]int) (Reader, error) {
+	id, err := strconv.Atoi(optionalField(record, index, "id"))
+	if err != nil {
+		return Reader{}, fmt.Errorf("error parsing door id: %w", err)
+	}
+	r := Reader{ID: id, Name: optionalField(record, index, "door_name")}
+	// device and door numbers only describe the door, missing ones stay zero
+	r.DeviceID, _ = strconv.Atoi(optionalField(record, index, "device_id"))
+	r.DoorNo, _ = strconv.Atoi(optionalField(record, index, "door_no"))
+	return r, nil
+}
@@ -0,0 +1,24 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderFromDBRecord(t *testing.T) {
+	index := map[string]int{"id": 0, "device_id": 1, "door_no": 2, "door_name": 3}
+
+	t.Run("door", func(t *testing.T) {
+		reader, err := ReaderFromDBRecord([]string{"3", "1", "2", "КПП ЦЕНТР-2"}, index)
+
+		assert.Nil(t, err)
+		assert.Equal(t, Reader{ID: 3, Name: "КПП ЦЕНТР-2", DeviceID: 1, DoorNo: 2}, reader)
+	})
+
+	t.Run("missing id", func(t *testing.T) {
+		_, err := ReaderFromDBRecord([]string{"", "1", "2", "Canteen"}, index)
+
+		assert.NotNil(t, err)
+	})
+}
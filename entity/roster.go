@@ -0,0 +1,112 @@
+package entity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Planned shift of an employee on a day. Start and End are HH:MM, a shift
+// ending before it starts ends the next day. Hours are derived from them when
+// the roster doesn't state them.
+type RosterEntry struct {
+	Card  string    `db:"card" json:"card"`
+	Day   time.Time `db:"day" json:"day"`
+	Shift string    `db:"shift" json:"shift"`
+	Start string    `db:"start_time" json:"start,omitempty"`
+	End   string    `db:"end_time" json:"end,omitempty"`
+	Hours float64   `db:"hours" json:"hours"`
+}
+
+// Fills in the hours from the shift times and checks the entry
+func (e *RosterEntry) Normalize() error {
+	if e.Card == "" {
+		return fmt.Errorf("roster entry without card")
+	}
+	if e.Day.IsZero() {
+		return fmt.Errorf("roster entry of %s without day", e.Card)
+	}
+	if (e.Start == "") != (e.End == "") {
+		return fmt.Errorf("roster entry of %s on %s needs both start and end", e.Card, e.Day.Format("2006-01-02"))
+	}
+	if e.Start != "" {
+		start, err := parseClock(e.Start)
+		if err != nil {
+			return fmt.Errorf("roster entry of %s start: %w", e.Card, err)
+		}
+		end, err := parseClock(e.End)
+		if err != nil {
+			return fmt.Errorf("roster entry of %s end: %w", e.Card, err)
+		}
+		if end <= start {
+			end += 24 * time.Hour
+		}
+		if e.Hours == 0 {
+			e.Hours = (end - start).Hours()
+		}
+	}
+	if e.Hours < 0 || e.Hours > 24 {
+		return fmt.Errorf("roster entry of %s on %s plans %.1f hours", e.Card, e.Day.Format("2006-01-02"), e.Hours)
+	}
+	return nil
+}
+
+// Planned against worked hours of an employee on a day. Days worked without a
+// plan and planned days without work are compared against zero.
+type RosterComparison struct {
+	Card    string    `json:"card"`
+	Day     time.Time `json:"day"`
+	Shift   string    `json:"shift"`
+	Planned float64   `json:"planned"`
+	Actual  float64   `json:"actual"`
+	// actual minus planned hours
+	Difference float64 `json:"difference"`
+	// the difference is at least the threshold either way
+	Discrepancy bool `json:"discrepancy"`
+}
+
+// Compares the roster with the worked hours by card and day, ordered by day
+// and card. actual maps card to day to hours.
+func CompareRoster(roster []RosterEntry, actual map[string]map[time.Time]float64, threshold float64) []RosterComparison {
+	type key struct {
+		card string
+		day  time.Time
+	}
+	byKey := make(map[key]*RosterComparison)
+	for _, e := range roster {
+		k := key{e.Card, e.Day}
+		c, ok := byKey[k]
+		if !ok {
+			c = &RosterComparison{Card: e.Card, Day: e.Day}
+			byKey[k] = c
+		}
+		c.Shift = e.Shift
+		c.Planned += e.Hours
+	}
+	for card, days := range actual {
+		for day, hours := range days {
+			k := key{card, day}
+			c, ok := byKey[k]
+			if !ok {
+				c = &RosterComparison{Card: card, Day: day}
+				byKey[k] = c
+			}
+			c.Actual += hours
+		}
+	}
+
+	result := make([]RosterComparison, 0, len(byKey))
+	for _, c := range byKey {
+		c.Difference = c.Actual - c.Planned
+		c.Discrepancy = c.Difference != 0 && math.Abs(c.Difference) >= threshold
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Day.Equal(result[j].Day) {
+			return result[i].Day.Before(result[j].Day)
+		}
+		return result[i].Card < result[j].Card
+	})
+	return result
+}
@@ -0,0 +1,65 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRosterEntryNormalize(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("hours from shift times", func(t *testing.T) {
+		shift := RosterEntry{Card: "100", Day: day, Start: "08:00", End: "17:00"}
+		night := RosterEntry{Card: "100", Day: day, Start: "20:00", End: "08:00"}
+
+		assert.Nil(t, shift.Normalize())
+		assert.Nil(t, night.Normalize())
+		assert.Equal(t, 9.0, shift.Hours)
+		assert.Equal(t, 12.0, night.Hours)
+	})
+
+	t.Run("stated hours win", func(t *testing.T) {
+		e := RosterEntry{Card: "100", Day: day, Start: "08:00", End: "17:00", Hours: 8}
+
+		assert.Nil(t, e.Normalize())
+		assert.Equal(t, 8.0, e.Hours)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, e := range []RosterEntry{
+			{Day: day, Hours: 8},
+			{Card: "100", Hours: 8},
+			{Card: "100", Day: day, Start: "08:00"},
+			{Card: "100", Day: day, Hours: 25},
+		} {
+			assert.NotNil(t, e.Normalize())
+		}
+	})
+}
+
+func TestCompareRoster(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	roster := []RosterEntry{
+		{Card: "100", Day: day, Shift: "day", Hours: 8},
+		{Card: "200", Day: day, Shift: "day", Hours: 8},
+		{Card: "300", Day: day, Shift: "day", Hours: 8},
+	}
+	actual := map[string]map[time.Time]float64{
+		"100": {day: 7.5},
+		"200": {day: 5},
+		"400": {day: 3},
+	}
+
+	result := CompareRoster(roster, actual, 1)
+
+	assert.Equal(t, 4, len(result))
+	assert.Equal(t, RosterComparison{Card: "100", Day: day, Shift: "day", Planned: 8, Actual: 7.5, Difference: -0.5}, result[0])
+	assert.True(t, result[1].Discrepancy)
+	assert.Equal(t, -3.0, result[1].Difference)
+	// planned but not worked, worked but not planned
+	assert.Equal(t, -8.0, result[2].Difference)
+	assert.Equal(t, "400", result[3].Card)
+	assert.True(t, result[3].Discrepancy)
+}
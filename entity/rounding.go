@@ -0,0 +1,79 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	RoundUp      = "up"
+	RoundDown    = "down"
+	RoundNearest = "nearest"
+)
+
+/*
+ * Rounding of worked time agreed in the collective agreement, e.g. entries up
+ * to the next quarter hour and exits down. It applies when hours are reported,
+ * stored intervals keep the turnstile times. Steps divide a day, entries are
+ * rounded up and exits down unless the mode says otherwise.
+ */
+type Rounding struct {
+	EntryMinutes int    `json:"entry_minutes"`
+	Entry        string `json:"entry"`
+	ExitMinutes  int    `json:"exit_minutes"`
+	Exit         string `json:"exit"`
+}
+
+func (r Rounding) IsZero() bool {
+	return r.EntryMinutes == 0 && r.ExitMinutes == 0
+}
+
+func (r Rounding) Validate() error {
+	for _, step := range []struct {
+		name    string
+		minutes int
+		mode    string
+	}{{"entry", r.EntryMinutes, r.Entry}, {"exit", r.ExitMinutes, r.Exit}} {
+		if step.minutes < 0 || (step.minutes > 0 && 24*60%step.minutes != 0) {
+			return fmt.Errorf("rounding %s_minutes must divide a day", step.name)
+		}
+		switch step.mode {
+		case "", RoundUp, RoundDown, RoundNearest:
+		default:
+			return fmt.Errorf("unknown rounding %s mode: %q", step.name, step.mode)
+		}
+	}
+	return nil
+}
+
+// Rounded bounds of an interval, a rounded exit never precedes the entry
+func (r Rounding) Apply(ent, ext time.Time) (time.Time, time.Time) {
+	ent = roundTime(ent, r.EntryMinutes, r.Entry, RoundUp)
+	ext = roundTime(ext, r.ExitMinutes, r.Exit, RoundDown)
+	if ext.Before(ent) {
+		ext = ent
+	}
+	return ent, ext
+}
+
+// Wall-clock times are labelled as UTC, steps dividing a day align to midnight
+func roundTime(t time.Time, minutes int, mode, fallback string) time.Time {
+	if minutes <= 0 {
+		return t
+	}
+	step := time.Duration(minutes) * time.Minute
+	if mode == "" {
+		mode = fallback
+	}
+	switch down := t.Truncate(step); mode {
+	case RoundUp:
+		if down.Equal(t) {
+			return t
+		}
+		return down.Add(step)
+	case RoundNearest:
+		return t.Round(step)
+	default:
+		return down
+	}
+}
@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRounding(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 4, hour, minute, 0, 0, time.UTC)
+	}
+
+	t.Run("entry up and exit down by default", func(t *testing.T) {
+		r := Rounding{EntryMinutes: 15, ExitMinutes: 15}
+		ent, ext := r.Apply(at(7, 52), at(17, 7))
+
+		assert.Equal(t, at(8, 0), ent)
+		assert.Equal(t, at(17, 0), ext)
+	})
+
+	t.Run("times on a step stay", func(t *testing.T) {
+		r := Rounding{EntryMinutes: 15, ExitMinutes: 15}
+		ent, ext := r.Apply(at(8, 0), at(17, 0))
+
+		assert.Equal(t, at(8, 0), ent)
+		assert.Equal(t, at(17, 0), ext)
+	})
+
+	t.Run("nearest and exit only", func(t *testing.T) {
+		r := Rounding{ExitMinutes: 30, Exit: RoundNearest}
+		ent, ext := r.Apply(at(7, 52), at(17, 16))
+
+		assert.Equal(t, at(7, 52), ent)
+		assert.Equal(t, at(17, 30), ext)
+	})
+
+	t.Run("a short interval doesn't turn negative", func(t *testing.T) {
+		r := Rounding{EntryMinutes: 15, ExitMinutes: 15}
+		ent, ext := r.Apply(at(8, 2), at(8, 10))
+
+		assert.Equal(t, ent, ext)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		assert.NotNil(t, Rounding{EntryMinutes: 7}.Validate())
+		assert.NotNil(t, Rounding{EntryMinutes: 15, Entry: "sideways"}.Validate())
+		assert.Nil(t, Rounding{EntryMinutes: 15, Entry: RoundDown, ExitMinutes: 60}.Validate())
+	})
+}
@@ -0,0 +1,266 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tables of the controller database the exporter reads
+const (
+	TableEvents      = "acc_monitor_log"
+	TableUsers       = "USERINFO"
+	TableDepartments = "DEPARTMENTS"
+	TableDoors       = "acc_door"
+)
+
+// Columns the parsers read by table, by the names of the ZKAccess release the
+// parsers were written against. Required ones must resolve.
+var schemaColumns = map[string]struct{ required, optional []string }{
+	TableEvents:      {[]string{"id", "card_no", "event_point_name", "time"}, []string{"state", "event_point_id", "event_type"}},
+	TableUsers:       {[]string{"CardNo", "name", "lastname"}, []string{"DEFAULTDEPTID", "TITLE", UserKindColumn}},
+	TableDepartments: {[]string{"DEPTID", "DEPTNAME"}, nil},
+	TableDoors:       {[]string{"id"}, []string{"door_name", "device_id", "door_no"}},
+}
+
+// Names of the tables and columns in one version of the controller software.
+// Tables and columns it doesn't rename keep the parser names, column names
+// match regardless of case.
+type SchemaProfile struct {
+	Name string `json:"name"`
+	// table names by parser table name
+	Tables map[string]string `json:"tables,omitempty"`
+	// source column names by parser table and column name
+	Columns map[string]map[string]string `json:"columns,omitempty"`
+}
+
+// Profile of the ZKAccess release the parsers were written against
+func DefaultSchemaProfile() SchemaProfile {
+	return SchemaProfile{Name: "zkaccess"}
+}
+
+// Source name of the table
+func (p SchemaProfile) Table(table string) string {
+	if name, ok := p.Tables[table]; ok {
+		return name
+	}
+	return table
+}
+
+/*
+ * Maps the header of an exported table to the parser column names. Columns
+ * the parsers don't know are returned as unknown and otherwise ignored, so
+ * are optional columns the source lacks. A missing required column is an
+ * error naming the column the profile looked for.
+ */
+func (p SchemaProfile) Index(table string, header map[string]int) (index map[string]int, unknown []string, err error) {
+	byLower := make(map[string]int, len(header))
+	for name, i := range header {
+		byLower[strings.ToLower(name)] = i
+	}
+	columns := schemaColumns[table]
+	index = make(map[string]int, len(header))
+	used := make(map[int]bool)
+	resolve := func(column string) (string, bool) {
+		source := column
+		if name, ok := p.Columns[table][column]; ok {
+			source = name
+		}
+		i, ok := header[source]
+		if !ok {
+			i, ok = byLower[strings.ToLower(source)]
+		}
+		if ok {
+			index[column] = i
+			used[i] = true
+		}
+		return source, ok
+	}
+	for _, column := range columns.required {
+		if source, ok := resolve(column); !ok {
+			return nil, nil, fmt.Errorf("%s has no column %s for schema profile %s", p.Table(table), source, p.Name)
+		}
+	}
+	for _, column := range columns.optional {
+		resolve(column)
+	}
+	for name, i := range header {
+		if !used[i] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return index, unknown, nil
+}
+
+var ErrNoSchemaProfile = errors.New("no schema profile matches the controller database")
+
+// Tables whose columns tell the controller software versions apart
+var detectedTables = []string{TableEvents, TableUsers}
+
+// First profile the events and users tables resolve with. tables holds the
+// column names by source table name.
+func DetectSchemaProfile(profiles []SchemaProfile, tables map[string][]string) (SchemaProfile, error) {
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		if p.matches(tables) {
+			return p, nil
+		}
+		names = append(names, p.Name)
+	}
+	return SchemaProfile{}, fmt.Errorf("%w, tried %s", ErrNoSchemaProfile, strings.Join(names, ", "))
+}
+
+func (p SchemaProfile) matches(tables map[string][]string) bool {
+	for _, table := range detectedTables {
+		columns, ok := tables[p.Table(table)]
+		if !ok {
+			return false
+		}
+		header := make(map[string]int, len(columns))
+		for i, column := range columns {
+			header[column] = i
+		}
+		if _, _, err := p.Index(table, header); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Other names vendors give the columns the parsers read, compared after
+// normalizing like the column names
+var columnSynonyms = map[string]map[string][]string{
+	TableEvents: {
+		"id":               {"logid", "eventid", "recordid"},
+		"card_no":          {"cardno", "card", "cardnumber", "badge", "badgeno", "pin"},
+		"event_point_name": {"doorname", "door", "readername", "reader", "pointname"},
+		"time":             {"eventtime", "datetime", "timestamp", "checktime", "logtime"},
+		"state":            {"inoutstate", "direction", "checktype"},
+		"event_point_id":   {"doorid", "readerid", "pointid"},
+		"event_type":       {"eventtype", "eventcode", "type"},
+	},
+	TableUsers: {
+		"CardNo":        {"card_no", "card", "cardnumber", "badge", "badgeno"},
+		"name":          {"firstname", "givenname"},
+		"lastname":      {"surname", "familyname"},
+		"DEFAULTDEPTID": {"deptid", "departmentid", "department"},
+		"TITLE":         {"position", "jobtitle"},
+		"USERTYPE":      {"usertype", "kind", "type"},
+	},
+	TableDepartments: {
+		"DEPTID":   {"id", "departmentid"},
+		"DEPTNAME": {"name", "departmentname"},
+	},
+	TableDoors: {
+		"id":        {"doorid"},
+		"door_name": {"name", "doorname"},
+		"device_id": {"deviceid", "machineid"},
+		"door_no":   {"doorno", "doornumber"},
+	},
+}
+
+// Order the tables are guessed in, a source table is taken by one of them
+var guessedTables = []string{TableEvents, TableUsers, TableDepartments, TableDoors}
+
+// Source table guessed for a parser table, Source is empty when no table
+// has any of its required columns
+type TableGuess struct {
+	Table  string
+	Source string
+	// parser columns by source column
+	Columns map[string]string
+	Missing []string
+}
+
+/*
+ * Guesses which source tables and columns hold what the parsers read, for a
+ * database of unfamiliar controller software. Columns match by their name or
+ * a known synonym ignoring case and underscores, the table matching the most
+ * columns wins, required ones counting triple, and a table with the parser
+ * table name breaks ties. The profile only lists
+ * the names that differ.
+ */
+func GuessSchemaProfile(name string, tables map[string][]string) (SchemaProfile, []TableGuess) {
+	profile := SchemaProfile{Name: name, Tables: map[string]string{}, Columns: map[string]map[string]string{}}
+	sources := make([]string, 0, len(tables))
+	for table := range tables {
+		sources = append(sources, table)
+	}
+	sort.Strings(sources)
+
+	taken := make(map[string]bool)
+	guesses := make([]TableGuess, 0, len(guessedTables))
+	for _, table := range guessedTables {
+		best := TableGuess{Table: table, Missing: schemaColumns[table].required}
+		bestScore := 0
+		for _, source := range sources {
+			if taken[source] {
+				continue
+			}
+			guess := guessColumns(table, source, tables[source])
+			if len(guess.Missing) == len(schemaColumns[table].required) {
+				continue
+			}
+			required := len(schemaColumns[table].required) - len(guess.Missing)
+			score := 3*required + len(guess.Columns) - required
+			if normalizeColumn(source) == normalizeColumn(table) {
+				score++
+			}
+			if best.Source == "" || score > bestScore {
+				best, bestScore = guess, score
+			}
+		}
+		guesses = append(guesses, best)
+		if best.Source == "" {
+			continue
+		}
+		taken[best.Source] = true
+		if best.Source != table {
+			profile.Tables[table] = best.Source
+		}
+		for source, column := range best.Columns {
+			if !strings.EqualFold(source, column) {
+				if profile.Columns[table] == nil {
+					profile.Columns[table] = map[string]string{}
+				}
+				profile.Columns[table][column] = source
+			}
+		}
+	}
+	return profile, guesses
+}
+
+func guessColumns(table, source string, columns []string) TableGuess {
+	guess := TableGuess{Table: table, Source: source, Columns: map[string]string{}}
+	byName := make(map[string]string, len(columns))
+	for _, column := range columns {
+		byName[normalizeColumn(column)] = column
+	}
+	used := make(map[string]bool)
+	match := func(column string) bool {
+		names := append([]string{column}, columnSynonyms[table][column]...)
+		for _, name := range names {
+			if found, ok := byName[normalizeColumn(name)]; ok && !used[found] {
+				used[found] = true
+				guess.Columns[found] = column
+				return true
+			}
+		}
+		return false
+	}
+	for _, column := range schemaColumns[table].required {
+		if !match(column) {
+			guess.Missing = append(guess.Missing, column)
+		}
+	}
+	for _, column := range schemaColumns[table].optional {
+		match(column)
+	}
+	return guess
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", " ", "", "-", "").Replace(name))
+}
@@ -0,0 +1,96 @@
+package entity
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaProfileIndex(t *testing.T) {
+	t.Run("matches columns regardless of case", func(t *testing.T) {
+		header := map[string]int{"ID": 0, "Card_No": 1, "event_point_name": 2, "time": 3, "verified": 4}
+
+		index, unknown, err := DefaultSchemaProfile().Index(TableEvents, header)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]int{"id": 0, "card_no": 1, "event_point_name": 2, "time": 3}, index)
+		assert.Equal(t, []string{"verified"}, unknown)
+	})
+
+	t.Run("maps renamed columns", func(t *testing.T) {
+		profile := SchemaProfile{Name: "v2", Columns: map[string]map[string]string{
+			TableEvents: {"card_no": "cardno"},
+		}}
+		header := map[string]int{"id": 0, "cardno": 1, "event_point_name": 2, "time": 3, "state": 4}
+
+		index, unknown, err := profile.Index(TableEvents, header)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, index["card_no"])
+		assert.Equal(t, 4, index["state"])
+		assert.Empty(t, unknown)
+	})
+
+	t.Run("fails on a missing required column", func(t *testing.T) {
+		_, _, err := DefaultSchemaProfile().Index(TableEvents, map[string]int{"id": 0, "time": 1})
+
+		assert.ErrorContains(t, err, "acc_monitor_log has no column card_no")
+	})
+}
+
+func TestDetectSchemaProfile(t *testing.T) {
+	renamed := SchemaProfile{
+		Name:    "renamed",
+		Tables:  map[string]string{TableEvents: "acc_monitor_log2"},
+		Columns: map[string]map[string]string{TableEvents: {"card_no": "cardno"}},
+	}
+	profiles := []SchemaProfile{renamed, DefaultSchemaProfile()}
+	users := []string{"USERID", "CardNo", "name", "lastname"}
+
+	t.Run("picks the profile the tables resolve with", func(t *testing.T) {
+		p, err := DetectSchemaProfile(profiles, map[string][]string{
+			"acc_monitor_log": {"id", "card_no", "event_point_name", "time"},
+			"USERINFO":        users,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "zkaccess", p.Name)
+
+		p, err = DetectSchemaProfile(profiles, map[string][]string{
+			"acc_monitor_log2": {"id", "cardno", "event_point_name", "time"},
+			"USERINFO":         users,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "renamed", p.Name)
+	})
+
+	t.Run("fails when none does", func(t *testing.T) {
+		_, err := DetectSchemaProfile(profiles, map[string][]string{"USERINFO": users})
+
+		assert.True(t, errors.Is(err, ErrNoSchemaProfile))
+	})
+}
+
+func TestGuessSchemaProfile(t *testing.T) {
+	tables := map[string][]string{
+		"Employees":   {"EmpID", "BadgeNo", "FirstName", "Surname", "DeptID"},
+		"Departments": {"ID", "Name"},
+		"AccessLog":   {"LogID", "Badge", "DoorName", "EventTime", "Direction"},
+		"Settings":    {"Key", "Value"},
+	}
+
+	profile, guesses := GuessSchemaProfile("site", tables)
+
+	assert.Equal(t, "AccessLog", profile.Table(TableEvents))
+	assert.Equal(t, "Employees", profile.Table(TableUsers))
+	assert.Equal(t, "Departments", profile.Table(TableDepartments))
+	assert.Equal(t, map[string]string{
+		"id": "LogID", "card_no": "Badge", "event_point_name": "DoorName", "time": "EventTime", "state": "Direction",
+	}, profile.Columns[TableEvents])
+	assert.Equal(t, "Surname", profile.Columns[TableUsers]["lastname"])
+	assert.Equal(t, "", guesses[3].Source)
+
+	detected, err := DetectSchemaProfile([]SchemaProfile{profile}, tables)
+	assert.Nil(t, err)
+	assert.Equal(t, "site", detected.Name)
+}
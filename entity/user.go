@@ -3,14 +3,30 @@ package entity
 import (
 	"fmt"
 	"sort"
+	"time"
 )
 
 type User struct {
-	FirstName string
-	LastName  string
-	Card      string
-	Events    []Event
-	Intervals []Interval
+	FirstName    string
+	LastName     string
+	Card         string
+	DepartmentID string
+	Department   string
+	Position     string
+	Kind         string // employee, visitor or contractor
+	// name as the source reported it, before master data, the directory or
+	// card mappings replace it
+	SourceName string
+	Cards      []CardAssignment
+	Events     []Event
+	Intervals  []Interval
+	Anomalies  []Anomaly
+	// events dropped as collisions of a neighbouring event
+	Collapsed []Event
+	// short exits merged away by the grace period
+	Merges []IntervalMerge
+	// stays in the zones of the policy, nil without zones
+	Zones []ZoneInterval
 }
 
 func UserFromCSV(record []string, index map[string]int) (*User, error) {
@@ -18,6 +34,9 @@ func UserFromCSV(record []string, index map[string]int) (*User, error) {
 	u.FirstName = record[index["lastname"]]
 	u.LastName = record[index["name"]]
 	u.Card = record[index["CardNo"]]
+	u.DepartmentID = optionalField(record, index, "DEFAULTDEPTID")
+	u.Position = optionalField(record, index, "TITLE")
+	u.Kind = ParseUserKind(optionalField(record, index, UserKindColumn))
 	u.Intervals = make([]Interval, 0)
 
 	if u.Card == "" {
@@ -26,6 +45,15 @@ func UserFromCSV(record []string, index map[string]int) (*User, error) {
 	return u, nil
 }
 
+// Columns missing in older controller databases read as empty
+func optionalField(record []string, index map[string]int, name string) string {
+	i, ok := index[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
 func (u *User) AddEvents(ev []Event) {
 	u.Events = make([]Event, 0)
 
@@ -37,14 +65,70 @@ func (u *User) AddEvents(ev []Event) {
 	}
 
 	sort.Slice(u.Events, func(i, j int) bool {
-		return u.Events[i].Time.Before(u.Events[j].Time)
+		if !u.Events[i].Time.Equal(u.Events[j].Time) {
+			return u.Events[i].Time.Before(u.Events[j].Time)
+		}
+		return u.Events[i].ID < u.Events[j].ID
+	})
+}
+
+func SortUsers(users []*User) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Card < users[j].Card
 	})
 }
 
 func (u *User) RunFlow(selectEventsFor int) {
-	res := ExcludeCollisions(u.Events)
-	SetEventDirection(res)
+	u.RunFlowAt(time.Now(), selectEventsFor, DefaultFlowPolicy())
+}
+
+// Runs the flow with the given policy as if it was executed at now,
+// a past now reconstructs past states
+func (u *User) RunFlowAt(now time.Time, selectEventsFor int, policy FlowPolicy) {
+	u.Zones = u.ZoneIntervals(now, now.AddDate(0, -selectEventsFor, 0), policy)
+	u.Events = policy.Filter.Apply(u.Events)
+	u.Anomalies = DetectEventAnomalies(u.Events, now)
+
+	valid := ExcludeFutureEvents(u.Events, now)
+	res := ExcludeCollisionsWithin(valid, policy.CollisionJitterSec)
+	u.Collapsed = droppedEvents(valid, res)
+	policy.SetDirections(res)
+	u.Anomalies = append(u.Anomalies, DetectDirectionAnomalies(res)...)
+
+	u.Events = SelectEventsSince(res, now.AddDate(0, -selectEventsFor, 0))
+	u.Intervals, u.Merges = ApplyIntervalRulesWithMerges(policy.Filter.ClipIntervals(ConstructIntervals(res)), policy)
+	for i := range u.Merges {
+		u.Merges[i].Card = u.Card
+	}
+}
+
+// Returns the entry event of a user who is currently inside. The last event must be
+// an entry not older than a workshift, older ones are forgotten exits.
+func (u *User) OnSite(now time.Time) (*Event, bool) {
+	if len(u.Events) == 0 {
+		return nil, false
+	}
 
-	u.Events = SelectEventsForNLastMonths(res, selectEventsFor)
-	u.Intervals = ConstructIntervals(res)
+	last := &u.Events[len(u.Events)-1]
+	if last.Direction != EventTypeEnt || last.Time.After(now) {
+		return nil, false
+	}
+	if now.Sub(last.Time).Hours() >= IDEAL_WORKSHIFT_DUR {
+		return nil, false
+	}
+	return last, true
+}
+
+// Events of all missing in kept, kept is a subsequence of all
+func droppedEvents(all, kept []Event) []Event {
+	dropped := make([]Event, 0, len(all)-len(kept))
+	j := 0
+	for _, event := range all {
+		if j < len(kept) && kept[j].ID == event.ID && kept[j].Time.Equal(event.Time) {
+			j++
+			continue
+		}
+		dropped = append(dropped, event)
+	}
+	return dropped
 }
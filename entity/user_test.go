@@ -23,7 +23,17 @@ func TestUserFromCSV(t *testing.T) {
 		assert.Equal(t, "John", user.FirstName)
 		assert.Equal(t, "Doe", user.LastName)
 		assert.Equal(t, "1234567890", user.Card)
+		assert.Equal(t, "", user.Position)
+	})
+
+	t.Run("department and position", func(t *testing.T) {
+		index := map[string]int{"name": 3, "lastname": 1, "CardNo": 4, "DEFAULTDEPTID": 5, "TITLE": 2}
+
+		user, err := UserFromCSV(raw, index)
 
+		assert.Nil(t, err)
+		assert.Equal(t, "1", user.DepartmentID)
+		assert.Equal(t, "home", user.Position)
 	})
 
 	t.Run("empty card", func(t *testing.T) {
@@ -102,3 +112,152 @@ func TestUserAdd(t *testing.T) {
 		assert.Equal(t, 7052, user.Events[2].ID)
 	})
 }
+
+func TestUserOnSite(t *testing.T) {
+	now := time.Date(2021, 12, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Run("last event is a recent entry", func(t *testing.T) {
+		user := User{Events: []Event{
+			{ID: 1, Direction: EventTypeEnt, Time: now.Add(-30 * time.Hour)},
+			{ID: 2, Direction: EventTypeExt, Time: now.Add(-22 * time.Hour)},
+			{ID: 3, Direction: EventTypeEnt, Time: now.Add(-4 * time.Hour)},
+		}}
+
+		ent, ok := user.OnSite(now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 3, ent.ID)
+	})
+
+	t.Run("last event is an exit", func(t *testing.T) {
+		user := User{Events: []Event{
+			{ID: 1, Direction: EventTypeEnt, Time: now.Add(-8 * time.Hour)},
+			{ID: 2, Direction: EventTypeExt, Time: now.Add(-1 * time.Hour)},
+		}}
+
+		_, ok := user.OnSite(now)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("entry older than a workshift", func(t *testing.T) {
+		user := User{Events: []Event{
+			{ID: 1, Direction: EventTypeEnt, Time: now.Add(-(IDEAL_WORKSHIFT_DUR + 1) * time.Hour)},
+		}}
+
+		_, ok := user.OnSite(now)
+
+		assert.False(t, ok)
+	})
+}
+
+func TestUserCollectEvents(t *testing.T) {
+	replaced := time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC)
+	eventsmap := map[string][]Event{
+		"new": {{ID: 3, Card: "new", Time: replaced.Add(24 * time.Hour)}},
+		"old": {
+			{ID: 1, Card: "old", Time: replaced.Add(-24 * time.Hour)},
+			{ID: 2, Card: "old", Time: replaced.Add(time.Hour)},
+		},
+	}
+	users := []*User{{Card: "new"}, {Card: "old"}}
+	assignments := map[string][]CardAssignment{
+		"new": {{Card: "old", ValidFrom: replaced.AddDate(-1, 0, 0), ValidTo: &replaced}},
+	}
+
+	users = AttachCardAssignments(users, assignments)
+	events := users[0].CollectEvents(eventsmap)
+
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, 3, events[0].ID)
+	assert.Equal(t, 1, events[1].ID)
+}
+
+func TestEventRouter(t *testing.T) {
+	replaced := time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC)
+	users := AttachCardAssignments([]*User{{Card: "new"}, {Card: "old"}}, map[string][]CardAssignment{
+		"new": {{Card: "old", ValidFrom: replaced.AddDate(-1, 0, 0), ValidTo: &replaced}},
+	})
+	visitors := []*User{{Card: "guest"}}
+	router := NewEventRouter(users, visitors)
+
+	routed := []bool{
+		router.Route(Event{ID: 1, Card: "old", Time: replaced.Add(-24 * time.Hour)}),
+		router.Route(Event{ID: 2, Card: "old", Time: replaced.Add(time.Hour)}),
+		router.Route(Event{ID: 3, Card: "new", Time: replaced.Add(24 * time.Hour)}),
+		router.Route(Event{ID: 4, Card: "guest", Time: replaced}),
+		router.Route(Event{ID: 5, Card: "unknown", Time: replaced}),
+	}
+
+	assert.Equal(t, []bool{true, true, true, true, false}, routed)
+	assert.Equal(t, 2, len(users[0].Events))
+	assert.Equal(t, 1, users[0].Events[0].ID)
+	assert.Equal(t, 3, users[0].Events[1].ID)
+	assert.Equal(t, 4, visitors[0].Events[0].ID)
+}
+
+func TestCardAssignmentsAt(t *testing.T) {
+	replaced := time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC)
+	assignments := map[string][]CardAssignment{
+		"new": {
+			{Card: "old", ValidFrom: replaced.AddDate(-1, 0, 0), ValidTo: &replaced},
+			{Card: "spare", ValidFrom: replaced.AddDate(0, 1, 0)},
+		},
+	}
+
+	t.Run("before the replacement", func(t *testing.T) {
+		at := CardAssignmentsAt(assignments, replaced.Add(-time.Hour))
+
+		assert.Equal(t, 1, len(at["new"]))
+		assert.Equal(t, "old", at["new"][0].Card)
+		assert.Nil(t, at["new"][0].ValidTo)
+		assert.NotNil(t, assignments["new"][0].ValidTo)
+	})
+
+	t.Run("after the replacement", func(t *testing.T) {
+		at := CardAssignmentsAt(assignments, replaced.Add(time.Hour))
+
+		assert.Equal(t, 1, len(at["new"]))
+		assert.Equal(t, &replaced, at["new"][0].ValidTo)
+	})
+}
+
+func TestValidateCardAssignments(t *testing.T) {
+	day := time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC)
+	until := func(days int) *time.Time {
+		at := day.AddDate(0, 0, days)
+		return &at
+	}
+
+	tests := []struct {
+		name        string
+		assignments map[string][]CardAssignment
+		valid       bool
+	}{
+		{"consecutive periods of a card", map[string][]CardAssignment{
+			"a": {{Card: "spare", ValidFrom: day, ValidTo: until(5)}},
+			"b": {{Card: "spare", ValidFrom: day.AddDate(0, 0, 5)}},
+		}, true},
+		{"different cards at once", map[string][]CardAssignment{
+			"a": {{Card: "old", ValidFrom: day}, {Card: "spare", ValidFrom: day}},
+		}, true},
+		{"card of two employees at once", map[string][]CardAssignment{
+			"a": {{Card: "spare", ValidFrom: day, ValidTo: until(5)}},
+			"b": {{Card: "spare", ValidFrom: day.AddDate(0, 0, 4)}},
+		}, false},
+		{"open period followed by another", map[string][]CardAssignment{
+			"a": {{Card: "spare", ValidFrom: day}, {Card: "spare", ValidFrom: day.AddDate(0, 1, 0)}},
+		}, false},
+		{"period ending before it starts", map[string][]CardAssignment{
+			"a": {{Card: "spare", ValidFrom: day, ValidTo: until(0)}},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCardAssignments(tt.assignments)
+
+			assert.Equal(t, tt.valid, err == nil, err)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package entity
+
+import "strings"
+
+const (
+	UserEmployee   = "employee"
+	UserVisitor    = "visitor"
+	UserContractor = "contractor"
+)
+
+// USERINFO column flagging visitor and contractor cards, absent in databases
+// that only hold employees
+const UserKindColumn = "USERTYPE"
+
+// Kind of a card holder from the controller's type field, anything but a
+// visitor or contractor flag is an employee
+func ParseUserKind(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "visitor", "guest", "посетитель", "гость":
+		return UserVisitor
+	case "contractor", "подрядчик":
+		return UserContractor
+	default:
+		return UserEmployee
+	}
+}
+
+// Visitors and contractors are on site but not on the payroll
+func (u *User) IsVisitor() bool {
+	return u.Kind == UserVisitor || u.Kind == UserContractor
+}
+
+// Separates visitor and contractor cards from the employees
+func SplitVisitors(users []*User) (employees, visitors []*User) {
+	employees = make([]*User, 0, len(users))
+	visitors = make([]*User, 0)
+	for _, user := range users {
+		if user.IsVisitor() {
+			visitors = append(visitors, user)
+		} else {
+			employees = append(employees, user)
+		}
+	}
+	return employees, visitors
+}
@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserKind(t *testing.T) {
+	assert.Equal(t, UserVisitor, ParseUserKind(" Guest"))
+	assert.Equal(t, UserContractor, ParseUserKind("Подрядчик"))
+	assert.Equal(t, UserEmployee, ParseUserKind(""))
+	assert.Equal(t, UserEmployee, ParseUserKind("staff"))
+}
+
+func TestSplitVisitors(t *testing.T) {
+	users := []*User{
+		{Card: "1", Kind: UserEmployee},
+		{Card: "2", Kind: UserVisitor},
+		{Card: "3"},
+		{Card: "4", Kind: UserContractor},
+	}
+
+	employees, visitors := SplitVisitors(users)
+
+	assert.Equal(t, []*User{users[0], users[2]}, employees)
+	assert.Equal(t, []*User{users[1], users[3]}, visitors)
+}
@@ -0,0 +1,136 @@
+package entity
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Zone everyone starts in and returns to after a forgotten exit
+const ZoneOutside = "outside"
+
+// Reader between two zones. Its entry channel moves a card from From to To,
+// the exit channel back. Readers without channel information move the card
+// across to the other side, which anti-passback controllers guarantee is right.
+type ZoneTransition struct {
+	// reader name or door id
+	Reader string `json:"reader"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Stay of a card in a zone, open while Ext is nil
+type ZoneInterval struct {
+	Card string
+	Zone string
+	Ent  *Event
+	Ext  *Event
+	// the card was not in the zone the reader leads from, a transition in
+	// between was missed or passed back
+	Inferred bool
+}
+
+func (i ZoneInterval) Dur() time.Duration {
+	if i.Ext == nil {
+		return 0
+	}
+	return i.Ext.Time.Sub(i.Ent.Time)
+}
+
+func validateZones(transitions []ZoneTransition) error {
+	for _, t := range transitions {
+		if t.Reader == "" || t.From == "" || t.To == "" {
+			return fmt.Errorf("flow policy zones need reader, from and to")
+		}
+		if t.From == t.To {
+			return fmt.Errorf("flow policy zone reader %s leads from %s into itself", t.Reader, t.From)
+		}
+	}
+	return nil
+}
+
+/*
+ * Follows a card through the zones of the policy along its events, which must
+ * be sorted by time. Events of readers without a transition are ignored, so
+ * are repeated badges at the same reader within the collision jitter. A card
+ * silent for longer than MaxShiftHours is back outside, its last stay stays
+ * open. Time outside is not an interval.
+ */
+func ZoneIntervals(events []Event, policy FlowPolicy) []ZoneInterval {
+	byReader := make(map[string]ZoneTransition, len(policy.Zones))
+	for _, t := range policy.Zones {
+		byReader[t.Reader] = t
+	}
+	maxStay := time.Duration(policy.MaxShiftHours * float64(time.Hour))
+	jitter := time.Duration(policy.CollisionJitterSec) * time.Second
+
+	intervals := make([]ZoneInterval, 0)
+	current := ZoneOutside
+	var open *ZoneInterval
+	var last *Event
+	for i := range events {
+		event := &events[i]
+		t, ok := byReader[event.PointName]
+		if !ok && event.ReaderID != 0 {
+			t, ok = byReader[strconv.Itoa(event.ReaderID)]
+		}
+		if !ok {
+			continue
+		}
+		if last != nil && last.PointName == event.PointName && last.ReaderDirection == event.ReaderDirection &&
+			event.Time.Sub(last.Time) < jitter {
+			continue
+		}
+		if last != nil && event.Time.Sub(last.Time) > maxStay {
+			if open != nil {
+				intervals = append(intervals, *open)
+				open = nil
+			}
+			current = ZoneOutside
+		}
+		last = event
+
+		from, to := t.From, t.To
+		switch {
+		case event.ReaderDirection == EventTypeExt:
+			from, to = t.To, t.From
+		case event.ReaderDirection == "" && current == t.To:
+			from, to = t.To, t.From
+		}
+		inferred := current != from
+
+		if open != nil {
+			ext := *event
+			open.Ext = &ext
+			intervals = append(intervals, *open)
+			open = nil
+		}
+		current = to
+		if to != ZoneOutside {
+			ent := *event
+			open = &ZoneInterval{Card: event.Card, Zone: to, Ent: &ent, Inferred: inferred}
+		}
+	}
+	if open != nil {
+		intervals = append(intervals, *open)
+	}
+	return intervals
+}
+
+// Stays of the user in the zones entered since then, from the events added
+// before the flow filters them. The event filter of the policy doesn't apply,
+// doors it leaves out like the canteen one are transitions between zones.
+func (u *User) ZoneIntervals(now, since time.Time, policy FlowPolicy) []ZoneInterval {
+	if len(policy.Zones) == 0 {
+		return nil
+	}
+	intervals := make([]ZoneInterval, 0)
+	for _, interval := range ZoneIntervals(ExcludeFutureEvents(u.Events, now), policy) {
+		if interval.Ent.Time.Before(since) {
+			continue
+		}
+		interval.Card = u.Card
+		intervals = append(intervals, interval)
+	}
+	return intervals
+}
@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneIntervals(t *testing.T) {
+	ts := time.Date(2021, 12, 15, 8, 0, 0, 0, time.UTC)
+	policy := DefaultFlowPolicy()
+	policy.Zones = []ZoneTransition{
+		{Reader: "Gate", From: ZoneOutside, To: "lobby"},
+		{Reader: "Floor", From: "lobby", To: "floor"},
+	}
+	zones := func(intervals []ZoneInterval) []string {
+		result := make([]string, 0, len(intervals))
+		for _, i := range intervals {
+			result = append(result, i.Zone)
+		}
+		return result
+	}
+
+	t.Run("readers without channels toggle", func(t *testing.T) {
+		events := []Event{
+			{ID: 1, PointName: "Gate", Time: ts},
+			{ID: 2, PointName: "Floor", Time: ts.Add(10 * time.Minute)},
+			{ID: 3, PointName: "Canteen", Time: ts.Add(4 * time.Hour)},
+			{ID: 4, PointName: "Floor", Time: ts.Add(8 * time.Hour)},
+			{ID: 5, PointName: "Gate", Time: ts.Add(8*time.Hour + 10*time.Minute)},
+		}
+
+		result := ZoneIntervals(events, policy)
+
+		assert.Equal(t, []string{"lobby", "floor", "lobby"}, zones(result))
+		assert.Equal(t, 2, result[1].Ent.ID)
+		assert.Equal(t, 4, result[1].Ext.ID)
+		assert.Equal(t, 8*time.Hour-10*time.Minute, result[1].Dur())
+		assert.False(t, result[1].Inferred)
+	})
+
+	t.Run("reader channels and missed transitions", func(t *testing.T) {
+		events := []Event{
+			// the gate entry was missed
+			{ID: 1, PointName: "Floor", ReaderDirection: EventTypeEnt, Time: ts},
+			{ID: 2, PointName: "Floor", ReaderDirection: EventTypeExt, Time: ts.Add(2 * time.Hour)},
+		}
+
+		result := ZoneIntervals(events, policy)
+
+		assert.Equal(t, []string{"floor", "lobby"}, zones(result))
+		assert.True(t, result[0].Inferred)
+		assert.Nil(t, result[1].Ext)
+	})
+
+	t.Run("forgotten exit", func(t *testing.T) {
+		events := []Event{
+			{ID: 1, PointName: "Gate", Time: ts},
+			{ID: 2, PointName: "Gate", Time: ts.Add(2 * time.Minute)},
+			{ID: 3, PointName: "Gate", Time: ts.Add(24 * time.Hour)},
+		}
+
+		result := ZoneIntervals(events, policy)
+
+		// the repeated badge is a collision, the next day starts outside again
+		assert.Equal(t, []string{"lobby", "lobby"}, zones(result))
+		assert.Nil(t, result[0].Ext)
+		assert.False(t, result[1].Inferred)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		_, err := ParseFlowPolicy([]byte(`{"zones": [{"reader": "Gate", "from": "lobby", "to": "lobby"}]}`))
+
+		assert.NotNil(t, err)
+	})
+}
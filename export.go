@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// export -format parquet [-dir dir] [-tables events,intervals] [-from date] [-to date] [-mask profile]
+// writes destination tables as month partitioned files for the data lake
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "parquet", "file format, only parquet is supported")
+	dir := fs.String("dir", envOr("LAKE_EXPORT_DIR", "lake"), "root directory of the datasets")
+	tables := fs.String("tables", "events,intervals", "comma separated tables to export")
+	fromFlag := fs.String("from", "", "first day to export, YYYY-MM-DD, the start of the previous month if empty")
+	toFlag := fs.String("to", "", "day after the last exported one, YYYY-MM-DD, the start of the current month if empty")
+	maskSpec := fs.String("mask", "", "PII masking profile of cards (none, consultant, anonymous or a spec like card=4)")
+	fs.Parse(args)
+
+	if *format != "parquet" {
+		log.Fatalf("unsupported export format: %s", *format)
+	}
+
+	mask, err := infra.ParseMaskingProfile(*maskSpec)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// whole months by default, so a monthly schedule exports every row once
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -1, 0)
+	if *fromFlag != "" {
+		if from, err = time.Parse("2006-01-02", *fromFlag); err != nil {
+			log.Fatalf("invalid -from: %v", err)
+		}
+	}
+	if *toFlag != "" {
+		if to, err = time.Parse("2006-01-02", *toFlag); err != nil {
+			log.Fatalf("invalid -to: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	for _, table := range strings.Split(*tables, ",") {
+		files, err := db.ExportParquet(ctx, strings.TrimSpace(table), *dir, from, to, mask)
+		for _, file := range files {
+			log.Printf("wrote %s", file)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := uploadArtifacts(*dir, from, files...); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	log.Printf("exported %s to %s from %s to %s", *tables, *dir, from.Format("2006-01-02"), to.Format("2006-01-02"))
+}
@@ -0,0 +1,88 @@
+package infra
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const absenceInsertBatchSize = 1000
+
+type Absence struct {
+	Database   string    `db:"database" json:"database"`
+	Day        time.Time `db:"day" json:"day"`
+	Card       string    `db:"card" json:"card"`
+	FirstName  string    `db:"firstname" json:"firstname"`
+	LastName   string    `db:"lastname" json:"lastname"`
+	Department string    `db:"department" json:"department"`
+}
+
+// Replaces the division's absences from since on, like ReplaceOvertime
+func (db *Repository) ReplaceAbsences(database string, since time.Time, absences []entity.Absence) error {
+	// users sharing a card would otherwise hit the primary key twice
+	rows := make([]Absence, 0, len(absences))
+	seen := make(map[string]bool)
+	for _, a := range absences {
+		key := a.Card + "|" + a.Day.Format("2006-01-02")
+		if a.Day.Before(since) || seen[key] {
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, Absence{Database: database, Day: a.Day, Card: a.Card,
+			FirstName: a.FirstName, LastName: a.LastName, Department: a.Department})
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing absences: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.absences WHERE database = $1 AND day >= $2", database, since)
+	if err != nil {
+		return fmt.Errorf("replacing absences: %w", err)
+	}
+	for start := 0; start < len(rows); start += absenceInsertBatchSize {
+		end := start + absenceInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err = tx.NamedExec(`INSERT INTO attendance.absences (database, day, card, firstname, lastname, department)
+		VALUES (:database, :day, :card, :firstname, :lastname, :department)`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("replacing absences: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Absences of a day, of every division when database is empty. Leaves
+// imported after the run that detected the absences explain them as well.
+func (db *Repository) AbsencesOn(database string, day time.Time) ([]Absence, error) {
+	return db.Absences(database, day, day.AddDate(0, 0, 1))
+}
+
+// Absences of days in [from, to), like AbsencesOn
+func (db *Repository) Absences(database string, from, to time.Time) (absences []Absence, err error) {
+	err = db.Select(&absences, `SELECT database, day, card, firstname, lastname, department
+	FROM attendance.absences a WHERE day >= $1 AND day < $2 AND ($3 = '' OR database = $3)
+		AND NOT EXISTS (SELECT 1 FROM attendance.leaves l WHERE l.card = a.card AND a.day BETWEEN l.date_from AND l.date_to)
+	ORDER BY day, database, department, lastname, firstname, card`, from.Format("2006-01-02"), to.Format("2006-01-02"), database)
+	if err != nil {
+		return nil, fmt.Errorf("loading absences: %w", err)
+	}
+	return absences, nil
+}
+
+func WriteAbsenceCSV(w io.Writer, absences []Absence) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"division", "day", "card", "firstname", "lastname", "department"})
+	for _, a := range absences {
+		cw.Write([]string{a.Database, a.Day.Format("2006-01-02"), a.Card, a.FirstName, a.LastName, a.Department})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,225 @@
+package infra
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// A per-site destination, Postgres or a SQLite file of an offline site,
+// merged into the central warehouse tables
+type SiteSource struct {
+	Site string
+	db   *sqlx.DB
+	// Postgres keeps the tables in the attendance schema, SQLite has no schemas
+	prefix string
+}
+
+// dsn is a Postgres connection string or sqlite://path
+func OpenSiteSource(site, dsn string) (*SiteSource, error) {
+	if path, ok := strings.CutPrefix(dsn, "sqlite://"); ok {
+		db, err := sqlx.Connect("sqlite", path)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to site %s: %w", site, err)
+		}
+		return &SiteSource{Site: site, db: db}, nil
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to site %s: %w", site, err)
+	}
+	return &SiteSource{Site: site, db: db, prefix: "attendance."}, nil
+}
+
+func (s *SiteSource) Close() error {
+	return s.db.Close()
+}
+
+// Parses site=dsn pairs separated by semicolons
+func ParseSiteSpecs(spec string) (map[string]string, error) {
+	sites := make(map[string]string)
+	for _, pair := range strings.Split(spec, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		site, dsn, ok := strings.Cut(pair, "=")
+		if !ok || site == "" || dsn == "" {
+			return nil, fmt.Errorf("invalid site %q, expected site=dsn", pair)
+		}
+		if _, dup := sites[site]; dup {
+			return nil, fmt.Errorf("site %s is listed twice", site)
+		}
+		sites[site] = dsn
+	}
+	return sites, nil
+}
+
+type AggregateResult struct {
+	Employees int64
+	Events    int64
+	Intervals int64
+}
+
+// Merges a site into the warehouse. Employees take the site's current record.
+// Events are immutable, those from since on are copied again every time so a
+// backfill within the window isn't missed, and the first merge of a site copies
+// all of them. The site's intervals from since on replace the warehouse ones.
+func (db *Repository) AggregateSite(src *SiteSource, since time.Time, batchSize int) (AggregateResult, error) {
+	var result AggregateResult
+	if batchSize < 1 {
+		batchSize = DefaultStreamBatchSize
+	}
+
+	var employees []Employee
+	err := src.db.Select(&employees, fmt.Sprintf(`SELECT card, firstname, lastname, department, position
+	FROM %semployees`, src.prefix))
+	if err != nil {
+		return result, fmt.Errorf("loading employees of site %s: %w", src.Site, err)
+	}
+	now := time.Now()
+	for start := 0; start < len(employees); start += batchSize {
+		end := start + batchSize
+		if end > len(employees) {
+			end = len(employees)
+		}
+		rows := make([]map[string]interface{}, 0, end-start)
+		for _, e := range employees[start:end] {
+			rows = append(rows, map[string]interface{}{
+				"site": src.Site, "card": e.Card, "firstname": e.FirstName, "lastname": e.LastName,
+				"department": e.Department, "position": e.Position, "updated_at": now,
+			})
+		}
+		res, err := db.NamedExec(`INSERT INTO attendance.site_employees AS e
+			(site, card, firstname, lastname, department, position, updated_at)
+		VALUES (:site, :card, :firstname, :lastname, :department, :position, :updated_at)
+		ON CONFLICT (site, card) DO UPDATE SET firstname = EXCLUDED.firstname, lastname = EXCLUDED.lastname,
+			department = EXCLUDED.department, position = EXCLUDED.position, updated_at = EXCLUDED.updated_at
+		WHERE (e.firstname, e.lastname, e.department, e.position)
+			IS DISTINCT FROM (EXCLUDED.firstname, EXCLUDED.lastname, EXCLUDED.department, EXCLUDED.position)`, rows)
+		if err != nil {
+			return result, fmt.Errorf("merging employees of site %s: %w", src.Site, err)
+		}
+		n, _ := res.RowsAffected()
+		result.Employees += n
+	}
+
+	// a site loads events late, an MDB backfill or a spool drained after an
+	// outage, so ids and arrival order say nothing about what is new. The
+	// window is copied again every time, the first merge copies everything.
+	var merged bool
+	err = db.Get(&merged, "SELECT EXISTS (SELECT 1 FROM attendance.site_events WHERE site = $1)", src.Site)
+	if err != nil {
+		return result, fmt.Errorf("loading events of site %s: %w", src.Site, err)
+	}
+	watermark := time.Time{}
+	if merged {
+		watermark = since
+	}
+	afterTime, afterID := watermark, -1<<63
+	for {
+		var events []Event
+		err := src.db.Select(&events, src.db.Rebind(fmt.Sprintf(`SELECT id, card, timestamp, direction
+		FROM %sevents WHERE timestamp >= ? AND (timestamp, id) > (?, ?) ORDER BY timestamp, id LIMIT ?`, src.prefix)),
+			watermark, afterTime, afterID, batchSize)
+		if err != nil {
+			return result, fmt.Errorf("loading events of site %s: %w", src.Site, err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		rows := make([]map[string]interface{}, len(events))
+		for i, e := range events {
+			rows[i] = map[string]interface{}{
+				"site": src.Site, "id": e.ID, "card": e.Card, "timestamp": e.Timestamp, "direction": e.Direction,
+			}
+		}
+		res, err := db.NamedExec(`INSERT INTO attendance.site_events (site, id, card, timestamp, direction)
+		VALUES (:site, :id, :card, :timestamp, :direction) ON CONFLICT DO NOTHING`, rows)
+		if err != nil {
+			return result, fmt.Errorf("merging events of site %s: %w", src.Site, err)
+		}
+		n, _ := res.RowsAffected()
+		result.Events += n
+		last := events[len(events)-1]
+		afterTime, afterID = last.Timestamp, last.ID
+	}
+
+	var intervals []Interval
+	err = src.db.Select(&intervals, src.db.Rebind(fmt.Sprintf(`SELECT ent, ext, card, database, ent_event_id, ext_event_id, confidence
+	FROM %sintervals WHERE ent >= ? ORDER BY ent_event_id`, src.prefix)), since.Format("2006-01-02T15:04:05"))
+	if err != nil {
+		return result, fmt.Errorf("loading intervals of site %s: %w", src.Site, err)
+	}
+	// the warehouse keys intervals by entry event per site, the last one wins
+	byEntry := make(map[int]int, len(intervals))
+	unique := make([]Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		if i, ok := byEntry[interval.EntEventID]; ok {
+			unique[i] = interval
+			continue
+		}
+		byEntry[interval.EntEventID] = len(unique)
+		unique = append(unique, interval)
+	}
+
+	// the site rebuilds its window every run, intervals it dropped or reopened
+	// must not linger in the warehouse
+	tx, err := db.Beginx()
+	if err != nil {
+		return result, fmt.Errorf("merging intervals of site %s: %w", src.Site, err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM attendance.site_intervals WHERE site = $1 AND ent >= $2", src.Site, since)
+	if err != nil {
+		return result, fmt.Errorf("merging intervals of site %s: %w", src.Site, err)
+	}
+	for start := 0; start < len(unique); start += batchSize {
+		end := start + batchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		rows := make([]map[string]interface{}, 0, end-start)
+		for _, interval := range unique[start:end] {
+			rows = append(rows, map[string]interface{}{
+				"site": src.Site, "ent": interval.Ent, "ext": interval.Ext, "card": interval.Card,
+				"ent_event_id": interval.EntEventID, "ext_event_id": interval.ExtEventID, "confidence": interval.Confidence,
+			})
+		}
+		// an interval that began before the window is replaced as a whole
+		res, err := tx.NamedExec(`INSERT INTO attendance.site_intervals (site, ent, ext, card, ent_event_id, ext_event_id, confidence)
+		VALUES (:site, :ent, :ext, :card, :ent_event_id, :ext_event_id, :confidence)
+		ON CONFLICT (site, ent_event_id) DO UPDATE SET ent = EXCLUDED.ent, ext = EXCLUDED.ext,
+			card = EXCLUDED.card, ext_event_id = EXCLUDED.ext_event_id, confidence = EXCLUDED.confidence`, rows)
+		if err != nil {
+			return result, fmt.Errorf("merging intervals of site %s: %w", src.Site, err)
+		}
+		n, _ := res.RowsAffected()
+		result.Intervals += n
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("merging intervals of site %s: %w", src.Site, err)
+	}
+
+	return result, nil
+}
+
+// A card registered at several sites under different names
+type SiteCardCollision struct {
+	Card  string `db:"card"`
+	Sites string `db:"sites"`
+	Names string `db:"names"`
+}
+
+func (db *Repository) SiteCardCollisions() (collisions []SiteCardCollision, err error) {
+	err = db.Select(&collisions, `SELECT card,
+		string_agg(DISTINCT site, ', ') AS sites,
+		string_agg(DISTINCT firstname || ' ' || lastname, ', ') AS names
+	FROM attendance.site_employees
+	GROUP BY card
+	HAVING count(DISTINCT (firstname, lastname)) > 1
+	ORDER BY card`)
+	return collisions, err
+}
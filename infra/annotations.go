@@ -0,0 +1,85 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HR comment on an employee day or on a single interval. Intervals are
+// referenced by their entry event, which stays the same when they are recomputed.
+type Annotation struct {
+	ID             int       `db:"id" json:"id"`
+	IdempotencyKey string    `db:"idempotency_key" json:"key"`
+	Database       string    `db:"database" json:"database"`
+	Card           string    `db:"card" json:"card"`
+	Day            string    `db:"day" json:"day"`
+	EntEventID     *int64    `db:"ent_event_id" json:"ent_event_id,omitempty"`
+	Author         string    `db:"author" json:"author"`
+	Note           string    `db:"note" json:"note"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+}
+
+func (a Annotation) Validate() error {
+	if a.Card == "" || a.Database == "" {
+		return errors.New("card and database are required")
+	}
+	if _, err := time.Parse("2006-01-02", a.Day); err != nil {
+		return fmt.Errorf("day must be YYYY-MM-DD: %w", err)
+	}
+	if a.Note == "" {
+		return errors.New("note is required")
+	}
+	return nil
+}
+
+// Key of the annotation target, used when the client does not send its own.
+// Posting again for the same target replaces the note instead of adding one.
+func (a Annotation) DefaultKey() string {
+	if a.EntEventID != nil {
+		return fmt.Sprintf("interval:%s:%d", a.Database, *a.EntEventID)
+	}
+	return fmt.Sprintf("day:%s:%s:%s", a.Database, a.Card, a.Day)
+}
+
+func (db *Repository) UpsertAnnotation(a Annotation) (Annotation, error) {
+	if a.IdempotencyKey == "" {
+		a.IdempotencyKey = a.DefaultKey()
+	}
+	rows, err := db.NamedQuery(`INSERT INTO attendance.annotations
+		(idempotency_key, database, card, day, ent_event_id, author, note)
+	VALUES (:idempotency_key, :database, :card, :day, :ent_event_id, :author, :note)
+	ON CONFLICT (idempotency_key) DO UPDATE SET
+		database = EXCLUDED.database, card = EXCLUDED.card, day = EXCLUDED.day,
+		ent_event_id = EXCLUDED.ent_event_id, author = EXCLUDED.author, note = EXCLUDED.note,
+		updated_at = now()
+	RETURNING id, created_at, updated_at`, a)
+	if err != nil {
+		return a, fmt.Errorf("saving annotation: %w", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return a, fmt.Errorf("saving annotation: %w", err)
+	}
+	return a, nil
+}
+
+// Annotations of days in [from, to), of a single card when card is not empty
+func (db *Repository) Annotations(card string, from, to time.Time) (annotations []Annotation, err error) {
+	err = db.Select(&annotations, `SELECT id, idempotency_key, database, card, to_char(day, 'YYYY-MM-DD') AS day,
+		ent_event_id, author, note, created_at, updated_at
+	FROM attendance.annotations
+	WHERE day >= $1 AND day < $2 AND ($3 = '' OR card = $3)
+	ORDER BY day, card, id`, from, to, card)
+	if err != nil {
+		return nil, fmt.Errorf("loading annotations: %w", err)
+	}
+	return annotations, nil
+}
@@ -0,0 +1,66 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+type Anomaly struct {
+	EventID   int       `db:"event_id" json:"event_id"`
+	Card      string    `db:"card" json:"card"`
+	Database  string    `db:"database" json:"database"`
+	Timestamp time.Time `db:"timestamp" json:"timestamp"`
+	Reason    string    `db:"reason" json:"reason"`
+}
+
+// Returns the anomalies actually created, known ones are skipped
+func (db *Repository) InsertAnomalies(database string, anomalies []entity.Anomaly) ([]Anomaly, error) {
+	if len(anomalies) == 0 {
+		return nil, nil
+	}
+	rows := make([]Anomaly, len(anomalies))
+	for i, a := range anomalies {
+		rows[i] = Anomaly{
+			EventID:   a.Event.ID,
+			Card:      a.Event.Card,
+			Database:  database,
+			Timestamp: a.Event.Time,
+			Reason:    string(a.Reason),
+		}
+	}
+	res, err := db.NamedQuery(`INSERT INTO attendance.anomalies (event_id, card, database, timestamp, reason)
+	VALUES (:event_id, :card, :database, :timestamp, :reason) ON CONFLICT DO NOTHING
+	RETURNING event_id, reason`, rows)
+	if err != nil {
+		return nil, fmt.Errorf("inserting anomalies: %w", err)
+	}
+	defer res.Close()
+
+	type key struct {
+		id     int
+		reason string
+	}
+	created := make(map[key]bool)
+	for res.Next() {
+		var k key
+		if err := res.Scan(&k.id, &k.reason); err != nil {
+			return nil, fmt.Errorf("inserting anomalies: %w", err)
+		}
+		created[k] = true
+	}
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("inserting anomalies: %w", err)
+	}
+
+	result := make([]Anomaly, 0, len(created))
+	for _, row := range rows {
+		k := key{row.EventID, row.Reason}
+		if created[k] {
+			result = append(result, row)
+			delete(created, k)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package infra
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const (
+	archivePrefix     = "acc_monitor_log-"
+	archiveSuffix     = ".csv.gz"
+	archiveTimeLayout = "20060102T150405Z"
+)
+
+// Gzip file holding one raw acc_monitor_log export, named after the export time
+type EventArchiveWriter struct {
+	file *os.File
+	gz   *gzip.Writer
+	path string
+}
+
+func NewEventArchiveWriter(dir string, exportedAt time.Time) (*EventArchiveWriter, error) {
+	path := filepath.Join(dir, archivePrefix+exportedAt.UTC().Format(archiveTimeLayout)+archiveSuffix)
+	// written under a temporary name so readers never see partial archives
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, fmt.Errorf("creating event archive: %w", err)
+	}
+	return &EventArchiveWriter{file: f, gz: gzip.NewWriter(f), path: path}, nil
+}
+
+func (a *EventArchiveWriter) Write(p []byte) (int, error) {
+	return a.gz.Write(p)
+}
+
+// Publishes the archive when keep is set and discards it otherwise
+func (a *EventArchiveWriter) Close(keep bool) error {
+	gzErr := a.gz.Close()
+	fileErr := a.file.Close()
+	if !keep {
+		return os.Remove(a.file.Name())
+	}
+	if gzErr != nil {
+		return gzErr
+	}
+	if fileErr != nil {
+		return fileErr
+	}
+	return os.Rename(a.file.Name(), a.path)
+}
+
+type EventArchive struct {
+	Path       string
+	ExportedAt time.Time
+}
+
+// Archives in dir in export order
+func ListEventArchives(dir string) ([]EventArchive, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing event archives: %w", err)
+	}
+
+	result := make([]EventArchive, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, archivePrefix) || !strings.HasSuffix(name, archiveSuffix) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, archivePrefix), archiveSuffix)
+		exportedAt, err := time.Parse(archiveTimeLayout, ts)
+		if err != nil {
+			continue
+		}
+		result = append(result, EventArchive{Path: filepath.Join(dir, name), ExportedAt: exportedAt})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ExportedAt.Before(result[j].ExportedAt) })
+	return result, nil
+}
+
+func ReadEventArchive(path string) ([]entity.Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading event archive %s: %w", path, err)
+	}
+	defer gz.Close()
+
+	events := make([]entity.Event, 0)
+	err = StreamCSVInput(gz, entity.NewEventFromDBRecord, DefaultStreamBatchSize, func(batch []entity.Event) error {
+		events = append(events, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading event archive %s: %w", path, err)
+	}
+	return events, nil
+}
+
+/*
+ * Events known at asOf: the union of all archives exported up to asOf.
+ * Events that arrived in later exports are ignored even if timestamped earlier.
+ */
+func EventsKnownAt(dir string, asOf time.Time) ([]entity.Event, int, error) {
+	archives, err := ListEventArchives(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	seen := make(map[int]bool)
+	result := make([]entity.Event, 0)
+	used := 0
+	for _, archive := range archives {
+		if archive.ExportedAt.After(asOf) {
+			break
+		}
+		events, err := ReadEventArchive(archive.Path)
+		if err != nil {
+			return nil, 0, err
+		}
+		used++
+		for _, event := range events {
+			if seen[event.ID] || event.Time.After(asOf) {
+				continue
+			}
+			seen[event.ID] = true
+			result = append(result, event)
+		}
+	}
+	return result, used, nil
+}
@@ -0,0 +1,155 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Returned once the connection of an atomic run is gone, the work it held is
+// rolled back by the server and must not be continued on a new connection
+var ErrAtomicConnectionLost = errors.New("atomic run lost its database connection")
+
+/*
+ * Connects for a run whose writes all land in one transaction: employees,
+ * events and intervals become visible together on Commit or not at all. The
+ * repository has a single connection that opens the transaction, transactions
+ * of the repository methods become savepoints within it. Any failed statement
+ * outside of them aborts the transaction, so in this mode the run fails on it.
+ */
+func ConnectAtomic(dataSourceName string, naming Naming, pool PoolConfig) (*Repository, *AtomicRun, error) {
+	connector, err := pool.connector(dataSourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !naming.IsDefault() {
+		connector = namingConnector{Connector: connector, naming: naming}
+	}
+	run := &AtomicRun{connector: connector}
+
+	driverName := "postgres"
+	if pool.Driver == "pgx" {
+		driverName = "pgx"
+	}
+	// the transaction lives on the connection, it is never closed or replaced
+	db := sqlx.NewDb(sql.OpenDB(run), driverName)
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	run.db = db
+	return &Repository{db}, run, nil
+}
+
+// The transaction of an atomic run
+type AtomicRun struct {
+	connector driver.Connector
+	db        *sqlx.DB
+	mu        sync.Mutex
+	conn      *atomicConn
+}
+
+func (r *AtomicRun) Connect(ctx context.Context) (driver.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		return nil, ErrAtomicConnectionLost
+	}
+	conn, err := r.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &atomicConn{namingConn: namingConn{conn: conn, naming: DefaultNaming()}}
+	if _, err := c.exec(ctx, "BEGIN"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("beginning atomic run: %w", err)
+	}
+	c.open = true
+	r.conn = c
+	return c, nil
+}
+
+func (r *AtomicRun) Driver() driver.Driver {
+	return r.connector.Driver()
+}
+
+// Makes everything the run wrote visible. The repository keeps working
+// without the transaction afterwards, e.g. to record the run.
+func (r *AtomicRun) Commit() error {
+	return r.finish("COMMIT")
+}
+
+func (r *AtomicRun) Rollback() error {
+	return r.finish("ROLLBACK")
+}
+
+func (r *AtomicRun) finish(statement string) error {
+	conn, err := r.db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("finishing atomic run: %w", err)
+	}
+	defer conn.Close()
+	return conn.Raw(func(dc any) error {
+		c := dc.(*atomicConn)
+		if !c.open {
+			return nil
+		}
+		if _, err := c.exec(context.Background(), statement); err != nil {
+			return fmt.Errorf("finishing atomic run: %w", err)
+		}
+		c.open = false
+		return nil
+	})
+}
+
+// Connection inside the run transaction, transactions begun on it are
+// savepoints. Statements pass through unchanged, naming is applied below it.
+type atomicConn struct {
+	namingConn
+	// the run transaction is in progress
+	open       bool
+	savepoints int
+}
+
+func (c *atomicConn) exec(ctx context.Context, query string) (driver.Result, error) {
+	return c.ExecContext(ctx, query, nil)
+}
+
+func (c *atomicConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// Isolation and read only options of the savepoint are those of the run
+func (c *atomicConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if !c.open {
+		return c.namingConn.BeginTx(ctx, opts)
+	}
+	c.savepoints++
+	name := fmt.Sprintf("atomic_%d", c.savepoints)
+	if _, err := c.exec(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &savepointTx{conn: c, name: name}, nil
+}
+
+type savepointTx struct {
+	conn *atomicConn
+	name string
+}
+
+func (t *savepointTx) Commit() error {
+	_, err := t.conn.exec(context.Background(), "RELEASE SAVEPOINT "+t.name)
+	return err
+}
+
+func (t *savepointTx) Rollback() error {
+	_, err := t.conn.exec(context.Background(), "ROLLBACK TO SAVEPOINT "+t.name)
+	return err
+}
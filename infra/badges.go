@@ -0,0 +1,54 @@
+package infra
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type BadgeUsage struct {
+	Card      string       `db:"card"`
+	FirstName string       `db:"firstname"`
+	LastName  string       `db:"lastname"`
+	DaysUsed  int          `db:"days_used"`
+	LastUsed  sql.NullTime `db:"last_used"`
+}
+
+// A card is dormant when it wasn't used since the threshold or never at all
+func (b BadgeUsage) Dormant(threshold time.Time) bool {
+	return !b.LastUsed.Valid || b.LastUsed.Time.Before(threshold)
+}
+
+// Usage of every employee card, days used are counted since from
+func (db *Repository) BadgeUsageSince(from time.Time) (usage []BadgeUsage, err error) {
+	err = db.Select(&usage, `SELECT e.card, e.firstname, e.lastname,
+		count(DISTINCT ev.timestamp::date) FILTER (WHERE ev.timestamp >= $1) AS days_used,
+		max(ev.timestamp) AS last_used
+	FROM attendance.employees e LEFT JOIN attendance.events ev ON ev.card = e.card
+	GROUP BY e.card, e.firstname, e.lastname
+	ORDER BY e.card`, from)
+	if err != nil {
+		return nil, fmt.Errorf("loading badge usage: %w", err)
+	}
+	return usage, nil
+}
+
+func WriteBadgeUsageCSV(w io.Writer, usage []BadgeUsage, dormantSince time.Time) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"card", "firstname", "lastname", "days_used", "last_used", "dormant"})
+	for _, b := range usage {
+		lastUsed := ""
+		if b.LastUsed.Valid {
+			lastUsed = b.LastUsed.Time.Format("2006-01-02")
+		}
+		cw.Write([]string{
+			b.Card, b.FirstName, b.LastName, strconv.Itoa(b.DaysUsed), lastUsed,
+			strconv.FormatBool(b.Dormant(dormantSince)),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,129 @@
+package infra
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+//go:embed calendars/*.json
+var calendars embed.FS
+
+const DefaultCountry = "ru"
+
+// Bundled holiday calendar and timezone of a country, by ISO 3166 alpha-2 code
+func LoadCalendar(country string) (entity.Calendar, error) {
+	country = strings.ToLower(country)
+	data, err := calendars.ReadFile("calendars/" + country + ".json")
+	if err != nil {
+		return entity.Calendar{}, fmt.Errorf("no bundled calendar for country %q", country)
+	}
+	return entity.ParseCalendar(data)
+}
+
+// Division calendar: CALENDAR_FILE when set, the bundled one of DIVISION_COUNTRY
+// otherwise. DIVISION_TIMEZONE overrides its timezone. With PRODUCTION_CALENDAR_URL
+// set the current and previous year are refined with the production calendar API.
+func CalendarFromEnv() (entity.Calendar, error) {
+	var calendar entity.Calendar
+	var err error
+	if path := os.Getenv("CALENDAR_FILE"); path != "" {
+		data, rerr := os.ReadFile(path)
+		if rerr != nil {
+			return calendar, fmt.Errorf("reading calendar: %w", rerr)
+		}
+		calendar, err = entity.ParseCalendar(data)
+	} else {
+		country := os.Getenv("DIVISION_COUNTRY")
+		if country == "" {
+			country = DefaultCountry
+		}
+		calendar, err = LoadCalendar(country)
+	}
+	if err != nil {
+		return calendar, err
+	}
+
+	if tz := os.Getenv("DIVISION_TIMEZONE"); tz != "" {
+		calendar, err = calendar.WithTimezone(tz)
+		if err != nil {
+			return calendar, err
+		}
+	}
+
+	if base := os.Getenv("PRODUCTION_CALENDAR_URL"); base != "" {
+		api := ProductionCalendarAPI{BaseURL: base, client: &http.Client{Timeout: 10 * time.Second}}
+		year := time.Now().Year()
+		for _, y := range []int{year - 1, year} {
+			// the bundled calendar stays usable when the API is down
+			if err := api.Apply(&calendar, y); err != nil {
+				log.Printf("warning: production calendar for %d not loaded: %v", y, err)
+			}
+		}
+	}
+	return calendar, nil
+}
+
+// Client of the isdayoff.ru compatible production calendar API. It returns a digit
+// per day of the year: 0 working, 1 day off, 2 shortened pre-holiday day.
+type ProductionCalendarAPI struct {
+	BaseURL string
+	client  *http.Client
+}
+
+func (a ProductionCalendarAPI) Apply(calendar *entity.Calendar, year int) error {
+	query := url.Values{}
+	query.Set("year", fmt.Sprint(year))
+	query.Set("cc", calendar.Country)
+	query.Set("pre", "1")
+
+	client := a.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(strings.TrimRight(a.BaseURL, "/") + "/api/getdata?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("production calendar: unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+
+	codes := strings.TrimSpace(string(body))
+	first := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	if days := first.AddDate(1, 0, 0).Sub(first).Hours() / 24; len(codes) != int(days) {
+		return fmt.Errorf("production calendar: %d day codes for %d days", len(codes), int(days))
+	}
+
+	for i, code := range codes {
+		day := first.AddDate(0, 0, i)
+		kind := entity.DayWorking
+		switch code {
+		case '0':
+		case '1':
+			kind = entity.DayOff
+		case '2':
+			kind = entity.DayShortened
+		default:
+			// other codes mark non-standard regimes, the bundled data is kept
+			continue
+		}
+		if calendar.Kind(day) != kind {
+			calendar.SetKind(day, kind)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package infra
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Card assignments keyed by the employee's current card
+func (db *Repository) CardAssignments() (map[string][]entity.CardAssignment, error) {
+	return cardAssignments(db)
+}
+
+func cardAssignments(q sqlx.Queryer) (map[string][]entity.CardAssignment, error) {
+	var rows []struct {
+		EmployeeCard string       `db:"employee_card"`
+		Card         string       `db:"card"`
+		ValidFrom    time.Time    `db:"valid_from"`
+		ValidTo      sql.NullTime `db:"valid_to"`
+	}
+	err := sqlx.Select(q, &rows, `SELECT e.card AS employee_card, c.card, c.valid_from, c.valid_to
+	FROM attendance.employee_cards c JOIN attendance.employees e ON e.id = c.employee_id
+	ORDER BY e.card, c.valid_from`)
+	if err != nil {
+		return nil, fmt.Errorf("loading card assignments: %w", err)
+	}
+
+	result := make(map[string][]entity.CardAssignment)
+	for _, row := range rows {
+		c := entity.CardAssignment{Card: row.Card, ValidFrom: row.ValidFrom}
+		if row.ValidTo.Valid {
+			validTo := row.ValidTo.Time
+			c.ValidTo = &validTo
+		}
+		result[row.EmployeeCard] = append(result[row.EmployeeCard], c)
+	}
+	return result, nil
+}
+
+// Header names accepted for each card assignment column
+var cardAssignmentColumns = map[string][]string{
+	"employee_card": {"employee_card", "employee", "primary_card"},
+	"card":          {"card", "cardno", "card_number"},
+	"valid_from":    {"valid_from", "from"},
+	"valid_to":      {"valid_to", "to"},
+}
+
+// Site wall clock, a date alone is the start of the day
+var cardAssignmentLayouts = []string{"2006-01-02", "2006-01-02 15:04", time.DateTime, "2006-01-02T15:04:05", "02.01.2006"}
+
+/*
+ * Reads card assignments from a CSV with a header row and the columns
+ *
+ *	employee_card, card, valid_from[, valid_to]
+ *
+ * keyed by employee_card, the employee's current card. An empty valid_to
+ * leaves the card valid. The periods are checked with ValidateCardAssignments
+ * before importing.
+ */
+func ReadCardAssignmentsCSV(input io.Reader) (map[string][]entity.CardAssignment, error) {
+	reader := csv.NewReader(input)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("card assignment file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading card assignment header: %w", err)
+	}
+
+	index := make(map[string]int)
+	for i, field := range header {
+		field = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(field, "\ufeff")))
+		for column, names := range cardAssignmentColumns {
+			for _, name := range names {
+				if field == name {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for _, column := range []string{"employee_card", "card", "valid_from"} {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("card assignment file has no %s column", column)
+		}
+	}
+
+	field := func(record []string, column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	assignments := make(map[string][]entity.CardAssignment)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading card assignment file: %w", err)
+		}
+		employee := field(record, "employee_card")
+		c := entity.CardAssignment{Card: field(record, "card")}
+		if employee == "" || c.Card == "" {
+			return nil, fmt.Errorf("card assignment file line %d: employee_card and card are required", line)
+		}
+		if c.ValidFrom, err = parseCardAssignmentTime(field(record, "valid_from")); err != nil {
+			return nil, fmt.Errorf("card assignment file line %d: valid_from: %w", line, err)
+		}
+		if to := field(record, "valid_to"); to != "" {
+			validTo, err := parseCardAssignmentTime(to)
+			if err != nil {
+				return nil, fmt.Errorf("card assignment file line %d: valid_to: %w", line, err)
+			}
+			c.ValidTo = &validTo
+		}
+		assignments[employee] = append(assignments[employee], c)
+	}
+	return assignments, nil
+}
+
+func parseCardAssignmentTime(value string) (time.Time, error) {
+	for _, layout := range cardAssignmentLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+/*
+ * Upserts assignments keyed by the employee's current card by employee, card
+ * and valid_from, after checking the periods along with the stored ones the
+ * import keeps. With replace, stored assignments are deleted first, those
+ * merge-employees recorded included, so the table mirrors the import. Employees
+ * must exist in the destination.
+ */
+func (db *Repository) ImportCardAssignments(assignments map[string][]entity.CardAssignment, replace bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("importing card assignments: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the periods must not overlap the stored ones the import keeps
+	merged := make(map[string][]entity.CardAssignment)
+	if !replace {
+		if merged, err = cardAssignments(tx); err != nil {
+			return err
+		}
+	}
+	for employee, cards := range assignments {
+		for _, c := range cards {
+			kept := merged[employee][:0]
+			for _, stored := range merged[employee] {
+				if stored.Card != c.Card || !stored.ValidFrom.Equal(c.ValidFrom) {
+					kept = append(kept, stored)
+				}
+			}
+			merged[employee] = append(kept, c)
+		}
+	}
+	if err := entity.ValidateCardAssignments(merged); err != nil {
+		return fmt.Errorf("importing card assignments: %w", err)
+	}
+
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM attendance.employee_cards`); err != nil {
+			return fmt.Errorf("importing card assignments: %w", err)
+		}
+	}
+	for employee, cards := range assignments {
+		var id int
+		err := tx.Get(&id, `SELECT id FROM attendance.employees WHERE card = $1`, employee)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("importing card assignments: no employee with card %s", employee)
+		}
+		if err != nil {
+			return fmt.Errorf("importing card assignments: %w", err)
+		}
+		for _, c := range cards {
+			_, err := tx.Exec(`INSERT INTO attendance.employee_cards (employee_id, card, valid_from, valid_to)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (employee_id, card, valid_from) DO UPDATE SET valid_to = excluded.valid_to`,
+				id, c.Card, c.ValidFrom, c.ValidTo)
+			if err != nil {
+				return fmt.Errorf("importing card assignment of %s to %s: %w", c.Card, employee, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// A card whose holder name changed, the card was given to someone else
+type CardReissue struct {
+	Card     string
+	Previous Employee
+	Current  Employee
+}
+
+// Closes the period of the previous holder and opens one for the new holder at
+// at. A card reissued for the first time gets the previous holder's period
+// back to when the employee was created.
+func (db *Repository) RecordCardReissues(reissues []CardReissue, at time.Time) error {
+	if len(reissues) == 0 {
+		return nil
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("recording card history: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, r := range reissues {
+		res, err := tx.Exec(`UPDATE attendance.card_history SET valid_to = $2
+		WHERE card = $1 AND valid_to IS NULL`, r.Card, at)
+		if err != nil {
+			return fmt.Errorf("recording card history: %w", err)
+		}
+		if closed, _ := res.RowsAffected(); closed == 0 {
+			_, err := tx.Exec(`INSERT INTO attendance.card_history (card, firstname, lastname, valid_from, valid_to)
+			VALUES ($1, $2, $3, coalesce((SELECT created_at FROM attendance.employees WHERE card = $1), '-infinity'), $4)`,
+				r.Card, r.Previous.FirstName, r.Previous.LastName, at)
+			if err != nil {
+				return fmt.Errorf("recording card history: %w", err)
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO attendance.card_history (card, firstname, lastname, valid_from)
+		VALUES ($1, $2, $3, $4)`, r.Card, r.Current.FirstName, r.Current.LastName, at)
+		if err != nil {
+			return fmt.Errorf("recording card history: %w", err)
+		}
+	}
+	return tx.Commit()
+}
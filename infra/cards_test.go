@@ -0,0 +1,38 @@
+package infra
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCardAssignmentsCSV(t *testing.T) {
+	t.Run("assignments by employee card", func(t *testing.T) {
+		input := "Employee_Card,Card,Valid_From,Valid_To\n" +
+			"1001,2001,2021-12-01,2021-12-10 08:30\n" +
+			"1001,2002,10.12.2021,\n"
+
+		assignments, err := ReadCardAssignmentsCSV(strings.NewReader(input))
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(assignments["1001"]))
+		assert.Equal(t, "2001", assignments["1001"][0].Card)
+		assert.Equal(t, time.Date(2021, 12, 10, 8, 30, 0, 0, time.UTC), *assignments["1001"][0].ValidTo)
+		assert.Equal(t, time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC), assignments["1001"][1].ValidFrom)
+		assert.Nil(t, assignments["1001"][1].ValidTo)
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		_, err := ReadCardAssignmentsCSV(strings.NewReader("employee_card,card\n1001,2001\n"))
+
+		assert.EqualError(t, err, "card assignment file has no valid_from column")
+	})
+
+	t.Run("invalid time", func(t *testing.T) {
+		_, err := ReadCardAssignmentsCSV(strings.NewReader("employee_card,card,valid_from\n1001,2001,yesterday\n"))
+
+		assert.EqualError(t, err, `card assignment file line 2: valid_from: invalid time "yesterday"`)
+	})
+}
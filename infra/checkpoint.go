@@ -0,0 +1,50 @@
+package infra
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Stage a run of the division completed last, the data the later stages need
+// is in the stage directory
+type Checkpoint struct {
+	Division    string    `db:"division"`
+	RunStarted  time.Time `db:"run_started"`
+	Stage       string    `db:"stage"`
+	StageDir    string    `db:"stage_dir"`
+	CompletedAt time.Time `db:"completed_at"`
+}
+
+// Checkpoint of the division's unfinished run, ok is false when the last run finished
+func (db *Repository) LoadCheckpoint(division string) (c Checkpoint, ok bool, err error) {
+	err = db.Get(&c, `SELECT division, run_started, stage, stage_dir, completed_at
+	FROM attendance.etl_checkpoints WHERE division = $1`, division)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, false, nil
+	}
+	if err != nil {
+		return c, false, fmt.Errorf("loading checkpoint: %w", err)
+	}
+	return c, true, nil
+}
+
+func (db *Repository) SaveCheckpoint(c Checkpoint) error {
+	_, err := db.NamedExec(`INSERT INTO attendance.etl_checkpoints (division, run_started, stage, stage_dir, completed_at)
+	VALUES (:division, :run_started, :stage, :stage_dir, :completed_at)
+	ON CONFLICT (division) DO UPDATE SET run_started = EXCLUDED.run_started, stage = EXCLUDED.stage,
+		stage_dir = EXCLUDED.stage_dir, completed_at = EXCLUDED.completed_at`, c)
+	if err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Forgets the checkpoint once the run completed every stage
+func (db *Repository) ClearCheckpoint(division string) error {
+	if _, err := db.Exec(`DELETE FROM attendance.etl_checkpoints WHERE division = $1`, division); err != nil {
+		return fmt.Errorf("clearing checkpoint: %w", err)
+	}
+	return nil
+}
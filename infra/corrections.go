@@ -0,0 +1,200 @@
+package infra
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	CorrectionClose    = "close"
+	CorrectionSplit    = "split"
+	CorrectionAnnotate = "annotate"
+)
+
+const (
+	CorrectionPending  = "pending"
+	CorrectionApproved = "approved"
+	CorrectionRejected = "rejected"
+)
+
+// Manual correction of an interval. Corrections are kept as overlay records,
+// the intervals the ETL derived stay as they are and the corrected view applies
+// the latest approved close or split on top of them. A shift supervisor
+// requests a correction, HR approves or rejects it.
+type Correction struct {
+	ID           int        `db:"id" json:"id"`
+	Database     string     `db:"database" json:"database"`
+	IntervalKey  string     `db:"interval_key" json:"interval_key"`
+	Kind         string     `db:"kind" json:"kind"`
+	Ext          *time.Time `db:"ext" json:"ext,omitempty"`
+	SplitAt      *time.Time `db:"split_at" json:"split_at,omitempty"`
+	ResumeAt     *time.Time `db:"resume_at" json:"resume_at,omitempty"`
+	Reason       string     `db:"reason" json:"reason"`
+	RequestedBy  string     `db:"requested_by" json:"requested_by"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	Status       string     `db:"status" json:"status"`
+	ApprovedBy   *string    `db:"approved_by" json:"approved_by,omitempty"`
+	DecidedAt    *time.Time `db:"decided_at" json:"decided_at,omitempty"`
+	DecisionNote string     `db:"decision_note" json:"decision_note,omitempty"`
+}
+
+var (
+	ErrIntervalNotFound      = errors.New("interval not found")
+	ErrCorrectionNotFound    = errors.New("correction not found")
+	ErrCorrectionNotPending  = errors.New("correction is already decided")
+	ErrCorrectionSelfApprove = errors.New("corrections are approved by someone other than the requester")
+)
+
+// Correction that contradicts the interval it targets
+type InvalidCorrectionError struct {
+	Reason string
+}
+
+func (e InvalidCorrectionError) Error() string {
+	return e.Reason
+}
+
+func (c Correction) Validate() error {
+	if c.Database == "" || c.IntervalKey == "" {
+		return errors.New("database and interval_key are required")
+	}
+	if c.Reason == "" || c.RequestedBy == "" {
+		return errors.New("reason and requested_by are required")
+	}
+	switch c.Kind {
+	case CorrectionClose:
+		if c.Ext == nil {
+			return errors.New("ext is required to close an interval")
+		}
+	case CorrectionSplit:
+		if c.SplitAt == nil || c.ResumeAt == nil {
+			return errors.New("split_at and resume_at are required to split an interval")
+		}
+		if c.ResumeAt.Before(*c.SplitAt) {
+			return errors.New("resume_at must not be before split_at")
+		}
+	case CorrectionAnnotate:
+	default:
+		return fmt.Errorf("unknown correction kind %q, expected close, split or annotate", c.Kind)
+	}
+	return nil
+}
+
+// Checks the correction against the interval it targets
+func (c Correction) validateAgainst(ent time.Time, ext sql.NullTime) error {
+	switch c.Kind {
+	case CorrectionClose:
+		if !c.Ext.After(ent) {
+			return InvalidCorrectionError{"ext must be after the interval entry"}
+		}
+	case CorrectionSplit:
+		if !ext.Valid {
+			return InvalidCorrectionError{"open intervals are closed, not split"}
+		}
+		if !c.SplitAt.After(ent) || !c.ResumeAt.Before(ext.Time) {
+			return InvalidCorrectionError{"split_at and resume_at must lie within the interval"}
+		}
+	}
+	return nil
+}
+
+func (db *Repository) checkCorrection(c Correction) error {
+	var interval struct {
+		Ent time.Time    `db:"ent"`
+		Ext sql.NullTime `db:"ext"`
+	}
+	err := db.Get(&interval, `SELECT ent, ext FROM attendance.intervals WHERE database = $1 AND interval_key = $2`,
+		c.Database, c.IntervalKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrIntervalNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("loading interval: %w", err)
+	}
+	return c.validateAgainst(interval.Ent, interval.Ext)
+}
+
+// Records the correction as pending, the interval it targets must exist
+func (db *Repository) AddCorrection(c Correction) (Correction, error) {
+	if err := db.checkCorrection(c); err != nil {
+		return c, err
+	}
+
+	rows, err := db.NamedQuery(`INSERT INTO attendance.interval_corrections
+		(database, interval_key, kind, ext, split_at, resume_at, reason, requested_by, status)
+	VALUES (:database, :interval_key, :kind, :ext, :split_at, :resume_at, :reason, :requested_by, 'pending')
+	RETURNING id, created_at, status`, c)
+	if err != nil {
+		return c, fmt.Errorf("saving correction: %w", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&c.ID, &c.CreatedAt, &c.Status)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return c, fmt.Errorf("saving correction: %w", err)
+	}
+	return c, nil
+}
+
+// Approves or rejects a pending correction. Approval checks the correction
+// again, the interval may have been recomputed since it was requested.
+func (db *Repository) DecideCorrection(id int, approve bool, by, note string) (Correction, error) {
+	var c Correction
+	err := db.Get(&c, "SELECT "+correctionColumns+" FROM attendance.interval_corrections c WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCorrectionNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("loading correction: %w", err)
+	}
+	if c.Status != CorrectionPending {
+		return c, ErrCorrectionNotPending
+	}
+	if by == c.RequestedBy {
+		return c, ErrCorrectionSelfApprove
+	}
+	status := CorrectionRejected
+	if approve {
+		status = CorrectionApproved
+		if err := db.checkCorrection(c); err != nil {
+			return c, err
+		}
+	}
+
+	// the status condition keeps concurrent decisions from overwriting each other
+	err = db.Get(&c, `UPDATE attendance.interval_corrections AS c
+		SET status = $2, approved_by = $3, decided_at = now(), decision_note = $4
+	WHERE id = $1 AND status = 'pending'
+	RETURNING `+correctionColumns, id, status, by, note)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCorrectionNotPending
+	}
+	if err != nil {
+		return c, fmt.Errorf("deciding correction: %w", err)
+	}
+	return c, nil
+}
+
+const correctionColumns = `c.id, c.database, c.interval_key, c.kind, c.ext, c.split_at, c.resume_at, c.reason,
+	c.requested_by, c.created_at, c.status, c.approved_by, c.decided_at, c.decision_note`
+
+// Corrections of intervals entered in [from, to), of a single card when card is
+// not empty and in one status when status is not empty, oldest first so the
+// list reads as the audit trail
+func (db *Repository) Corrections(card, status string, from, to time.Time) (corrections []Correction, err error) {
+	err = db.Select(&corrections, `SELECT `+correctionColumns+`
+	FROM attendance.interval_corrections c
+	JOIN attendance.intervals i ON i.database = c.database AND i.interval_key = c.interval_key
+	WHERE i.ent >= $1 AND i.ent < $2 AND ($3 = '' OR i.card = $3) AND ($4 = '' OR c.status = $4)
+	ORDER BY c.created_at, c.id`, from, to, card, status)
+	if err != nil {
+		return nil, fmt.Errorf("loading corrections: %w", err)
+	}
+	return corrections, nil
+}
@@ -0,0 +1,76 @@
+package infra
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const dailyAttendanceInsertBatchSize = 1000
+
+type DailyAttendance struct {
+	Database   string       `db:"database" json:"database"`
+	Card       string       `db:"card" json:"card"`
+	FirstName  string       `db:"firstname" json:"firstname"`
+	LastName   string       `db:"lastname" json:"lastname"`
+	Day        time.Time    `db:"day" json:"day"`
+	FirstIn    time.Time    `db:"first_in" json:"first_in"`
+	LastOut    sql.NullTime `db:"last_out" json:"last_out"`
+	GrossHours float64      `db:"gross_hours" json:"gross_hours"`
+}
+
+// Replaces the division's first-in/last-out rows from since on
+func (db *Repository) ReplaceDailyAttendance(database string, since time.Time, days []entity.DailyAttendance) error {
+	rows := make([]DailyAttendance, 0, len(days))
+	for _, d := range days {
+		row := DailyAttendance{Database: database, Card: d.Card, Day: d.Day, FirstIn: d.FirstIn, GrossHours: d.GrossHours()}
+		if d.LastOut != nil {
+			row.LastOut = sql.NullTime{Time: *d.LastOut, Valid: true}
+		}
+		rows = append(rows, row)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing daily attendance: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.daily_attendance WHERE database = $1 AND day >= $2::date", database, since)
+	if err != nil {
+		return fmt.Errorf("replacing daily attendance: %w", err)
+	}
+	for start := 0; start < len(rows); start += dailyAttendanceInsertBatchSize {
+		end := start + dailyAttendanceInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err = tx.NamedExec(`INSERT INTO attendance.daily_attendance
+			(database, card, day, first_in, last_out, gross_hours)
+		VALUES (:database, :card, :day, :first_in, :last_out, :gross_hours)
+		ON CONFLICT DO NOTHING`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("replacing daily attendance: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// First-in/last-out rows of days in [from, to), of a single card when card
+// is not empty, named after whoever held the card at the first entry
+func (db *Repository) DailyAttendance(card string, from, to time.Time) (days []DailyAttendance, err error) {
+	err = db.Select(&days, `SELECT d.database, d.card, coalesce(h.firstname, e.firstname, '') AS firstname,
+		coalesce(h.lastname, e.lastname, '') AS lastname, d.day, d.first_in, d.last_out, d.gross_hours
+	FROM attendance.daily_attendance d
+	LEFT JOIN attendance.card_history h
+		ON h.card = d.card AND d.first_in >= h.valid_from AND (h.valid_to IS NULL OR d.first_in < h.valid_to)
+	LEFT JOIN attendance.employees e ON e.card = d.card
+	WHERE d.day >= $1 AND d.day < $2 AND ($3 = '' OR d.card = $3)
+	ORDER BY d.day, d.card`, from, to, card)
+	if err != nil {
+		return nil, fmt.Errorf("loading daily attendance: %w", err)
+	}
+	return days, nil
+}
@@ -0,0 +1,147 @@
+package infra
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+type LoadStatus struct {
+	LastEvent sql.NullTime `db:"last_event" json:"last_event"`
+	Events    int          `db:"events" json:"events"`
+	Intervals int          `db:"intervals" json:"intervals"`
+	Employees int          `db:"employees" json:"employees"`
+}
+
+type DailyHours struct {
+	Card      string  `db:"card" json:"card"`
+	FirstName string  `db:"firstname" json:"firstname"`
+	LastName  string  `db:"lastname" json:"lastname"`
+	Day       string  `db:"day" json:"day"`
+	Hours     float64 `db:"hours" json:"hours"`
+	// working, shortened or day_off, and the full, half, partial or
+	// day_off_work classification against it, both set by the caller
+	DayKind  string `db:"-" json:"day_kind"`
+	Presence string `db:"-" json:"presence"`
+}
+
+type OpenInterval struct {
+	Card      string    `db:"card" json:"card"`
+	FirstName string    `db:"firstname" json:"firstname"`
+	LastName  string    `db:"lastname" json:"lastname"`
+	Database  string    `db:"database" json:"database"`
+	Key       string    `db:"interval_key" json:"interval_key"`
+	Ent       time.Time `db:"ent" json:"ent"`
+}
+
+func (db *Repository) LoadStatus() (status LoadStatus, err error) {
+	err = db.Get(&status, `SELECT
+		(SELECT max(timestamp) FROM attendance.events) AS last_event,
+		(SELECT count(*) FROM attendance.events) AS events,
+		(SELECT count(*) FROM attendance.intervals) AS intervals,
+		(SELECT count(*) FROM attendance.employees) AS employees`)
+	if err != nil {
+		return status, fmt.Errorf("loading status: %w", err)
+	}
+	return status, nil
+}
+
+// Worked hours of closed intervals per employee per day in [from, to), with the
+// HR corrections and the rounding of each employee's policy applied. A reissued
+// card's hours go to whoever held it at the entry.
+func (db *Repository) DailyHours(from, to time.Time, policy entity.FlowPolicy) (hours []DailyHours, err error) {
+	if policy.Any(func(p entity.FlowPolicy) bool { return !p.Rounding.IsZero() }) {
+		return db.roundedDailyHours(from, to, policy)
+	}
+	err = db.Select(&hours, `SELECT card, firstname, lastname, to_char(ent, 'YYYY-MM-DD') AS day,
+		sum(extract(epoch FROM ext - ent)) / 3600 AS hours
+	FROM attendance.interval_holders
+	WHERE employee_id IS NOT NULL AND ent >= $1 AND ent < $2 AND ext IS NOT NULL
+	GROUP BY 1, 2, 3, 4
+	ORDER BY 3, 2, 1, 4`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading daily hours: %w", err)
+	}
+	return hours, nil
+}
+
+// Rounding applies to every interval, so they are summed here instead
+func (db *Repository) roundedDailyHours(from, to time.Time, policy entity.FlowPolicy) ([]DailyHours, error) {
+	var intervals []struct {
+		Card       string    `db:"card"`
+		FirstName  string    `db:"firstname"`
+		LastName   string    `db:"lastname"`
+		Department string    `db:"department"`
+		Position   string    `db:"position"`
+		Ent        time.Time `db:"ent"`
+		Ext        time.Time `db:"ext"`
+	}
+	err := db.Select(&intervals, `SELECT card, firstname, lastname, department, position, ent, ext
+	FROM attendance.interval_holders
+	WHERE employee_id IS NOT NULL AND ent >= $1 AND ent < $2 AND ext IS NOT NULL
+	ORDER BY lastname, firstname, card, ent`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading daily hours: %w", err)
+	}
+	hours := make([]DailyHours, 0)
+	var card string
+	var rounding entity.Rounding
+	for _, i := range intervals {
+		// intervals come by card, the policy is resolved once per employee
+		if i.Card != card {
+			card, rounding = i.Card, policy.For(i.Card, i.Department, i.Position).Rounding
+		}
+		ent, ext := rounding.Apply(i.Ent, i.Ext)
+		day := i.Ent.Format("2006-01-02")
+		if n := len(hours); n > 0 && hours[n-1].Card == i.Card && hours[n-1].Day == day {
+			hours[n-1].Hours += ext.Sub(ent).Hours()
+			continue
+		}
+		hours = append(hours, DailyHours{Card: i.Card, FirstName: i.FirstName, LastName: i.LastName, Day: day, Hours: ext.Sub(ent).Hours()})
+	}
+	return hours, nil
+}
+
+func (db *Repository) OpenIntervals(limit int) (intervals []OpenInterval, err error) {
+	err = db.Select(&intervals, `SELECT card, firstname, lastname, database, interval_key, ent
+	FROM attendance.interval_holders
+	WHERE ext IS NULL
+	ORDER BY ent DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("loading open intervals: %w", err)
+	}
+	return intervals, nil
+}
+
+type CorrectedInterval struct {
+	Card         string        `db:"card" json:"card"`
+	Database     string        `db:"database" json:"database"`
+	Key          string        `db:"interval_key" json:"interval_key"`
+	Ent          time.Time     `db:"ent" json:"ent"`
+	Ext          sql.NullTime  `db:"ext" json:"ext"`
+	CorrectionID sql.NullInt64 `db:"correction_id" json:"correction_id"`
+}
+
+// Intervals with the HR corrections applied that began in [from, to), of one
+// card when card is set
+func (db *Repository) CorrectedIntervals(from, to time.Time, card string, limit int) (intervals []CorrectedInterval, err error) {
+	err = db.Select(&intervals, `SELECT card, database, interval_key, ent, ext, correction_id
+	FROM attendance.corrected_intervals
+	WHERE ent >= $1 AND ent < $2 AND ($3 = '' OR card = $3)
+	ORDER BY ent, card LIMIT $4`, from, to, card, limit)
+	if err != nil {
+		return nil, fmt.Errorf("loading intervals: %w", err)
+	}
+	return intervals, nil
+}
+
+func (db *Repository) RecentAnomalies(limit int) (anomalies []Anomaly, err error) {
+	err = db.Select(&anomalies, `SELECT event_id, card, database, timestamp, reason
+	FROM attendance.anomalies ORDER BY timestamp DESC, event_id LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("loading anomalies: %w", err)
+	}
+	return anomalies, nil
+}
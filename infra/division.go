@@ -0,0 +1,53 @@
+package infra
+
+import (
+	"fmt"
+)
+
+type Shift struct {
+	Division string `db:"division"`
+	Name     string `db:"name"`
+	Start    string `db:"start_time"`
+	End      string `db:"end_time"`
+}
+
+func (db *Repository) SetDivisionConfig(division string, config map[string]string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("setting division config: %w", err)
+	}
+	defer tx.Rollback()
+
+	for key, value := range config {
+		_, err := tx.Exec(`INSERT INTO attendance.division_config (division, key, value) VALUES ($1, $2, $3)
+		ON CONFLICT (division, key) DO UPDATE SET value = EXCLUDED.value`, division, key, value)
+		if err != nil {
+			return fmt.Errorf("setting division config %s: %w", key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Repository) SeedReaders(readers []Reader) (int64, error) {
+	if len(readers) == 0 {
+		return 0, nil
+	}
+	res, err := db.NamedExec(`INSERT INTO attendance.readers (division, name)
+	VALUES (:division, :name) ON CONFLICT DO NOTHING`, readers)
+	if err != nil {
+		return 0, fmt.Errorf("seeding readers: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (db *Repository) SeedShifts(shifts []Shift) (int64, error) {
+	if len(shifts) == 0 {
+		return 0, nil
+	}
+	res, err := db.NamedExec(`INSERT INTO attendance.shifts (division, name, start_time, end_time)
+	VALUES (:division, :name, :start_time, :end_time) ON CONFLICT DO NOTHING`, shifts)
+	if err != nil {
+		return 0, fmt.Errorf("seeding shifts: %w", err)
+	}
+	return res.RowsAffected()
+}
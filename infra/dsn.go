@@ -0,0 +1,90 @@
+package infra
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+)
+
+type PostgresConfig struct {
+	User     string
+	Password string
+	Host     string
+	Port     string
+	DB       string
+
+	SSLMode        string
+	SSLRootCert    string
+	SSLCert        string
+	SSLKey         string
+	ChannelBinding string
+}
+
+// The password is resolved through the secret provider, everything else comes from env
+func PostgresConfigFromEnv(secrets SecretProvider) (PostgresConfig, error) {
+	return PostgresConfigFromEnvPrefix("POSTGRES_", secrets)
+}
+
+// Reads <prefix>USER, <prefix>HOST and so on, used for additional destinations
+func PostgresConfigFromEnvPrefix(prefix string, secrets SecretProvider) (PostgresConfig, error) {
+	password, err := secrets.Secret(prefix + "PASSWORD")
+	if err != nil {
+		return PostgresConfig{}, err
+	}
+
+	sslmode := os.Getenv(prefix + "SSLMODE")
+	if sslmode == "" {
+		sslmode = "disable"
+	}
+	return PostgresConfig{
+		User:           os.Getenv(prefix + "USER"),
+		Password:       password,
+		Host:           os.Getenv(prefix + "HOST"),
+		Port:           os.Getenv(prefix + "PORT"),
+		DB:             os.Getenv(prefix + "DB"),
+		SSLMode:        sslmode,
+		SSLRootCert:    os.Getenv(prefix + "SSLROOTCERT"),
+		SSLCert:        os.Getenv(prefix + "SSLCERT"),
+		SSLKey:         os.Getenv(prefix + "SSLKEY"),
+		ChannelBinding: os.Getenv(prefix + "CHANNEL_BINDING"),
+	}, nil
+}
+
+func (c PostgresConfig) DSN() (string, error) {
+	switch c.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		return "", fmt.Errorf("unsupported POSTGRES_SSLMODE: %s", c.SSLMode)
+	}
+
+	switch c.ChannelBinding {
+	case "", "disable", "prefer":
+	case "require":
+		// lib/pq authenticates with plain SCRAM-SHA-256 and can't bind to the TLS channel
+		return "", fmt.Errorf("POSTGRES_CHANNEL_BINDING=require is not supported by the postgres driver")
+	default:
+		return "", fmt.Errorf("unsupported POSTGRES_CHANNEL_BINDING: %s", c.ChannelBinding)
+	}
+
+	params := url.Values{}
+	params.Set("sslmode", c.SSLMode)
+	if c.SSLRootCert != "" {
+		params.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		params.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		params.Set("sslkey", c.SSLKey)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.DB,
+		RawQuery: params.Encode(),
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,60 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Merge candidate with the names of both records
+type DuplicateEmployee struct {
+	entity.DuplicateCandidate
+	FirstName          string    `db:"firstname" json:"firstname"`
+	LastName           string    `db:"lastname" json:"lastname"`
+	DuplicateFirstName string    `db:"duplicate_firstname" json:"duplicate_firstname"`
+	DuplicateLastName  string    `db:"duplicate_lastname" json:"duplicate_lastname"`
+	DetectedAt         time.Time `db:"detected_at" json:"detected_at"`
+}
+
+// Looks for likely duplicates among all employees and replaces the merge
+// candidates with them. The older record of a pair is the canonical one.
+func (db *Repository) DetectDuplicateEmployees(at time.Time) ([]entity.DuplicateCandidate, error) {
+	var names []entity.EmployeeName
+	err := db.Select(&names, `SELECT card, firstname, lastname FROM attendance.employees ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("loading employee names: %w", err)
+	}
+	candidates := entity.FindDuplicateEmployees(names)
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("replacing duplicate employees: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM attendance.duplicate_employees`); err != nil {
+		return nil, fmt.Errorf("replacing duplicate employees: %w", err)
+	}
+	for _, c := range candidates {
+		_, err := tx.Exec(`INSERT INTO attendance.duplicate_employees (card, duplicate_card, reason, detected_at)
+		VALUES ($1, $2, $3, $4)`, c.Card, c.DuplicateCard, c.Reason, at)
+		if err != nil {
+			return nil, fmt.Errorf("replacing duplicate employees: %w", err)
+		}
+	}
+	return candidates, tx.Commit()
+}
+
+func (db *Repository) DuplicateEmployees() (duplicates []DuplicateEmployee, err error) {
+	err = db.Select(&duplicates, `SELECT d.card, d.duplicate_card, d.reason, d.detected_at,
+		e.firstname, e.lastname, o.firstname AS duplicate_firstname, o.lastname AS duplicate_lastname
+	FROM attendance.duplicate_employees d
+	JOIN attendance.employees e ON e.card = d.card
+	JOIN attendance.employees o ON o.card = d.duplicate_card
+	ORDER BY d.card, d.duplicate_card`)
+	if err != nil {
+		return nil, fmt.Errorf("loading duplicate employees: %w", err)
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,79 @@
+package infra
+
+import (
+	"sync"
+)
+
+// Employees by card
+type EmployeeIndex map[string]Employee
+
+func IndexEmployees(employees []Employee) EmployeeIndex {
+	index := make(EmployeeIndex, len(employees))
+	for _, e := range employees {
+		index[e.Card] = e
+	}
+	return index
+}
+
+// Employees loaded at a revision of a destination's employees table
+type employeeSnapshot struct {
+	revision int64
+	index    EmployeeIndex
+}
+
+/*
+ * Snapshots kept for the life of the process by destination instance, so the
+ * daemon loads the employees again only after someone wrote them. Runs of a
+ * one-shot process load them once. Revisions are drawn from a sequence, a
+ * snapshot taken in an atomic run that rolled back never matches again.
+ */
+var employeeSnapshots = struct {
+	sync.Mutex
+	byInstance map[string]employeeSnapshot
+}{byInstance: make(map[string]employeeSnapshot)}
+
+/*
+ * Employees of the destination by card, from the snapshot of an earlier run
+ * while the employees revision is unchanged. Destinations without the
+ * revision table are loaded every time. The index is shared, callers must
+ * not modify it.
+ */
+func (db *Repository) EmployeeIndex() (EmployeeIndex, error) {
+	instance, revision, err := db.employeesRevision()
+	if err != nil {
+		employees, err := db.EmployeesAll()
+		if err != nil {
+			return nil, err
+		}
+		return IndexEmployees(employees), nil
+	}
+
+	employeeSnapshots.Lock()
+	snapshot, ok := employeeSnapshots.byInstance[instance]
+	employeeSnapshots.Unlock()
+	if ok && snapshot.revision == revision {
+		return snapshot.index, nil
+	}
+
+	employees, err := db.EmployeesAll()
+	if err != nil {
+		return nil, err
+	}
+	index := IndexEmployees(employees)
+	employeeSnapshots.Lock()
+	employeeSnapshots.byInstance[instance] = employeeSnapshot{revision: revision, index: index}
+	employeeSnapshots.Unlock()
+	return index, nil
+}
+
+// Read in a transaction of its own, which is a savepoint in an atomic run, so
+// a destination without the table doesn't abort the run
+func (db *Repository) employeesRevision() (instance string, revision int64, err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", 0, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("SELECT instance, revision FROM attendance.employees_revision").Scan(&instance, &revision)
+	return instance, revision, err
+}
@@ -0,0 +1,121 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Audit record of a single ETL run. Partial runs were interrupted by shutdown,
+// the stages before the interruption are stored.
+type ETLRun struct {
+	ID                int            `db:"id" json:"id"`
+	Division          string         `db:"division" json:"division"`
+	Source            string         `db:"source" json:"source"`
+	StartedAt         time.Time      `db:"started_at" json:"started_at"`
+	FinishedAt        time.Time      `db:"finished_at" json:"finished_at"`
+	WindowFrom        time.Time      `db:"window_from" json:"window_from"`
+	WindowTo          time.Time      `db:"window_to" json:"window_to"`
+	UsersSynced       int            `db:"users_synced" json:"users_synced"`
+	EventsExported    int            `db:"events_exported" json:"events_exported"`
+	EventsInserted    int64          `db:"events_inserted" json:"events_inserted"`
+	IntervalsFormed   int            `db:"intervals_formed" json:"intervals_formed"`
+	IntervalsInserted int64          `db:"intervals_inserted" json:"intervals_inserted"`
+	Anomalies         int            `db:"anomalies" json:"anomalies"`
+	Error             sql.NullString `db:"error" json:"-"`
+	Partial           bool           `db:"partial" json:"partial"`
+	Version           string         `db:"version" json:"version"`
+}
+
+func NewETLRun(summary RunSummary, source string, windowFrom time.Time, runErr error, version string) ETLRun {
+	finished := summary.Finished
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	run := ETLRun{
+		Division:          summary.Division,
+		Source:            source,
+		StartedAt:         summary.Started,
+		FinishedAt:        finished,
+		WindowFrom:        windowFrom,
+		WindowTo:          summary.Started,
+		UsersSynced:       summary.UsersSynced,
+		EventsExported:    summary.EventsExported,
+		EventsInserted:    summary.EventsInserted,
+		IntervalsFormed:   summary.IntervalsFormed,
+		IntervalsInserted: summary.IntervalsInserted,
+		Anomalies:         summary.Anomalies,
+		Version:           version,
+	}
+	if runErr != nil {
+		run.Error = sql.NullString{String: runErr.Error(), Valid: true}
+		run.Partial = errors.Is(runErr, context.Canceled) || FailureClassOf(runErr) == FailureInterrupted
+	}
+	return run
+}
+
+func (db *Repository) RecordETLRun(run ETLRun) error {
+	_, err := db.NamedExec(`INSERT INTO attendance.etl_runs
+		(division, source, started_at, finished_at, window_from, window_to, users_synced, events_exported,
+		events_inserted, intervals_formed, intervals_inserted, anomalies, error, partial, version)
+	VALUES (:division, :source, :started_at, :finished_at, :window_from, :window_to, :users_synced, :events_exported,
+		:events_inserted, :intervals_formed, :intervals_inserted, :anomalies, :error, :partial, :version)`, run)
+	if err != nil {
+		return fmt.Errorf("recording etl run: %w", err)
+	}
+	return nil
+}
+
+const etlRunColumns = `id, division, source, started_at, finished_at, window_from, window_to, users_synced,
+	events_exported, events_inserted, intervals_formed, intervals_inserted, anomalies, error, partial, version`
+
+// Last successful run of the division from the source
+func (db *Repository) LastSuccessfulETLRun(division, source string) (run ETLRun, ok bool, err error) {
+	err = db.Get(&run, `SELECT `+etlRunColumns+`
+	FROM attendance.etl_runs WHERE division = $1 AND source = $2 AND error IS NULL
+	ORDER BY started_at DESC LIMIT 1`, division, source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return run, false, nil
+	}
+	if err != nil {
+		return run, false, fmt.Errorf("loading last etl run: %w", err)
+	}
+	return run, true, nil
+}
+
+// Last successful run of the division from any source started by at, whose
+// intervals were the ones in place at that time
+func (db *Repository) ETLRunAt(division string, at time.Time) (run ETLRun, ok bool, err error) {
+	err = db.Get(&run, `SELECT `+etlRunColumns+`
+	FROM attendance.etl_runs WHERE division = $1 AND started_at <= $2 AND error IS NULL
+	ORDER BY started_at DESC LIMIT 1`, division, at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return run, false, nil
+	}
+	if err != nil {
+		return run, false, fmt.Errorf("loading etl run: %w", err)
+	}
+	return run, true, nil
+}
+
+// Months of events the run selected, its window reaches back that many
+// calendar months from the start
+func (run ETLRun) WindowMonths() int {
+	months := 0
+	for run.WindowTo.AddDate(0, -months, 0).After(run.WindowFrom) {
+		months++
+	}
+	return months
+}
+
+// Events a run over the window should export, scaled from the last successful
+// run by the window length. 0 when there is nothing to go by.
+func (run ETLRun) ExpectedEvents(windowFrom, windowTo time.Time) int {
+	span := run.WindowTo.Sub(run.WindowFrom)
+	if run.EventsExported == 0 || span <= 0 {
+		return 0
+	}
+	return int(float64(run.EventsExported) * float64(windowTo.Sub(windowFrom)) / float64(span))
+}
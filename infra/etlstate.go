@@ -0,0 +1,83 @@
+package infra
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Size, modification time and content hash of a source file
+type SourceFingerprint struct {
+	Size    int64     `db:"source_size"`
+	ModTime time.Time `db:"source_mtime"`
+	SHA256  string    `db:"source_sha256"`
+	// hash of what the run derives its tables from besides the source, see
+	// runInputs, empty until the run computes it
+	Inputs string `db:"inputs_sha256"`
+}
+
+func FingerprintFile(path string) (SourceFingerprint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SourceFingerprint{}, fmt.Errorf("fingerprinting source: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return SourceFingerprint{}, fmt.Errorf("fingerprinting source: %w", err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return SourceFingerprint{}, fmt.Errorf("fingerprinting source: %w", err)
+	}
+	return SourceFingerprint{
+		Size: info.Size(),
+		// postgres timestamps keep microseconds
+		ModTime: info.ModTime().UTC().Truncate(time.Microsecond),
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func (f SourceFingerprint) Equal(other SourceFingerprint) bool {
+	return f.SameSource(other) && f.Inputs == other.Inputs
+}
+
+// Whether both were taken of the same source file, whatever the run inputs
+func (f SourceFingerprint) SameSource(other SourceFingerprint) bool {
+	return f.Size == other.Size && f.ModTime.Equal(other.ModTime) && f.SHA256 == other.SHA256
+}
+
+// Fingerprint of the source at the start of the last successful run, ok is false
+// when the source was never loaded
+func (db *Repository) LastSourceFingerprint(division, source string) (fp SourceFingerprint, ok bool, err error) {
+	err = db.Get(&fp, `SELECT source_size, source_mtime, source_sha256, inputs_sha256 FROM attendance.etl_state
+		WHERE division = $1 AND source = $2`, division, source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fp, false, nil
+	}
+	if err != nil {
+		return fp, false, fmt.Errorf("loading etl state: %w", err)
+	}
+	fp.ModTime = fp.ModTime.UTC()
+	return fp, true, nil
+}
+
+func (db *Repository) RecordSourceFingerprint(division, source string, fp SourceFingerprint, at time.Time) error {
+	_, err := db.Exec(`INSERT INTO attendance.etl_state
+		(division, source, source_size, source_mtime, source_sha256, inputs_sha256, recorded_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (division, source) DO UPDATE SET
+		source_size = EXCLUDED.source_size, source_mtime = EXCLUDED.source_mtime,
+		source_sha256 = EXCLUDED.source_sha256, inputs_sha256 = EXCLUDED.inputs_sha256, recorded_at = EXCLUDED.recorded_at`,
+		division, source, fp.Size, fp.ModTime, fp.SHA256, fp.Inputs, at)
+	if err != nil {
+		return fmt.Errorf("recording etl state: %w", err)
+	}
+	return nil
+}
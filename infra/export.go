@@ -0,0 +1,61 @@
+package infra
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const ExportFormatClockify = "clockify"
+
+// Column set accepted by both Clockify and Toggl CSV time entry import
+var timeTrackingHeader = []string{
+	"User", "Email", "Project", "Description", "Billable",
+	"Start date", "Start time", "End date", "End time", "Duration",
+}
+
+func WriteTimeTrackingCSV(w io.Writer, project string, users []*entity.User, mask MaskingProfile) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(timeTrackingHeader); err != nil {
+		return fmt.Errorf("writing time tracking header: %w", err)
+	}
+
+	for _, user := range users {
+		for _, interval := range user.Intervals {
+			// open intervals have no duration and are rejected by the importers
+			if interval.Ext == nil {
+				continue
+			}
+
+			err := cw.Write([]string{
+				fmt.Sprintf("%s %s", mask.Name(user.FirstName), mask.Name(user.LastName)),
+				"",
+				project,
+				fmt.Sprintf("Card %s", mask.Card(user.Card)),
+				"No",
+				interval.Ent.Time.Format("2006-01-02"),
+				interval.Ent.Time.Format("15:04:05"),
+				interval.Ext.Time.Format("2006-01-02"),
+				interval.Ext.Time.Format("15:04:05"),
+				formatDuration(interval.Dur()),
+			})
+			if err != nil {
+				return fmt.Errorf("writing time tracking row: %w", err)
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+}
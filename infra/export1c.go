@@ -0,0 +1,64 @@
+package infra
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const ExportFormat1C = "1c"
+
+// Time code of worked presence hours in the 1C:ZUP time classifier
+const payrollCodeWorked = "Я"
+
+var payrollHeader = []string{"ТабельныйНомер", "Сотрудник", "Дата", "ВидВремени", "Часы"}
+
+// Writes worked hours per employee and day in the semicolon separated exchange
+// file the 1C:ZUP timesheet import reads. The card number is the personnel code,
+// an interval counts towards the day it starts on.
+func WritePayroll1C(w io.Writer, users []*entity.User) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+	if err := cw.Write(payrollHeader); err != nil {
+		return fmt.Errorf("writing payroll header: %w", err)
+	}
+
+	for _, user := range users {
+		hours := make(map[string]time.Duration)
+		for _, interval := range user.Intervals {
+			if interval.Ext == nil {
+				continue
+			}
+			hours[interval.Ent.Time.Format("2006-01-02")] += interval.Dur()
+		}
+
+		days := make([]string, 0, len(hours))
+		for day := range hours {
+			days = append(days, day)
+		}
+		sort.Strings(days)
+
+		for _, day := range days {
+			date, _ := time.Parse("2006-01-02", day)
+			err := cw.Write([]string{
+				user.Card,
+				fmt.Sprintf("%s %s", user.LastName, user.FirstName),
+				date.Format("02.01.2006"),
+				payrollCodeWorked,
+				// 1C expects a decimal comma
+				strings.Replace(fmt.Sprintf("%.2f", hours[day].Hours()), ".", ",", 1),
+			})
+			if err != nil {
+				return fmt.Errorf("writing payroll row: %w", err)
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
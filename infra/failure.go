@@ -0,0 +1,75 @@
+package infra
+
+import "errors"
+
+// Part of the pipeline a failed run is blamed on, wrappers branch on it
+type FailureClass string
+
+const (
+	FailureSource      FailureClass = "source"
+	FailureDestination FailureClass = "destination"
+	FailureValidation  FailureClass = "validation"
+	// stopped by a signal, whatever part of the pipeline gave up because of it
+	FailureInterrupted FailureClass = "interrupted"
+)
+
+type RunFailure struct {
+	Class FailureClass
+	Err   error
+}
+
+func (f RunFailure) Error() string {
+	return f.Err.Error()
+}
+
+func (f RunFailure) Unwrap() error {
+	return f.Err
+}
+
+// Marks err as a failure of reading the source, unless it is classified already
+func SourceFailure(err error) error {
+	return classifyFailure(FailureSource, err)
+}
+
+// Marks err as a failure of writing the destination, unless it is classified already
+func DestinationFailure(err error) error {
+	return classifyFailure(FailureDestination, err)
+}
+
+// Marks err as invalid configuration or data, unless it is classified already
+func ValidationFailure(err error) error {
+	return classifyFailure(FailureValidation, err)
+}
+
+// Marks err as the end of a run stopped by a signal, overriding the class the
+// failing part gave it
+func InterruptedFailure(err error) error {
+	if err == nil {
+		return nil
+	}
+	var f RunFailure
+	if errors.As(err, &f) {
+		err = f.Err
+	}
+	return RunFailure{Class: FailureInterrupted, Err: err}
+}
+
+func classifyFailure(class FailureClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	var f RunFailure
+	if errors.As(err, &f) {
+		return err
+	}
+	return RunFailure{Class: class, Err: err}
+}
+
+// Class of a failed run, empty for errors nobody classified
+func FailureClassOf(err error) FailureClass {
+	var f RunFailure
+	if errors.As(err, &f) {
+		return f.Class
+	}
+	return ""
+}
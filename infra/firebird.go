@@ -0,0 +1,150 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Firebird database of the controller software, queried with isql
+type firebirdClient struct {
+	isql     string
+	database string
+	user     string
+	password string
+}
+
+/*
+ * Reads FIREBIRD_DATABASE like host:/var/lib/firebird/access.fdb, FIREBIRD_USER,
+ * the FIREBIRD_PASSWORD secret and the FIREBIRD_USERS_QUERY and
+ * FIREBIRD_EVENTS_QUERY queries. FIREBIRD_ISQL overrides the isql command,
+ * isql-fb as Debian names it by default.
+ */
+func NewFirebirdSourceFromEnv(secrets SecretProvider) (*QuerySource, error) {
+	client := &firebirdClient{
+		isql:     os.Getenv("FIREBIRD_ISQL"),
+		database: os.Getenv("FIREBIRD_DATABASE"),
+		user:     os.Getenv("FIREBIRD_USER"),
+	}
+	if client.database == "" {
+		return nil, errors.New("FIREBIRD_DATABASE is not set")
+	}
+	if client.isql == "" {
+		client.isql = "isql-fb"
+		if runtime.GOOS == "windows" {
+			client.isql = "isql"
+		}
+	}
+	if client.user == "" {
+		client.user = "SYSDBA"
+	}
+	password, err := secrets.Secret("FIREBIRD_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	client.password = password
+
+	source := &QuerySource{
+		client:      client,
+		usersQuery:  os.Getenv("FIREBIRD_USERS_QUERY"),
+		eventsQuery: os.Getenv("FIREBIRD_EVENTS_QUERY"),
+	}
+	if source.usersQuery == "" || source.eventsQuery == "" {
+		return nil, errors.New("FIREBIRD_USERS_QUERY and FIREBIRD_EVENTS_QUERY are required")
+	}
+	return source, nil
+}
+
+// Runs the query in list mode, the password goes through ISC_PASSWORD so it
+// stays out of the process list
+func (c *firebirdClient) query(ctx context.Context, query string, row func(header, record []string) error) error {
+	script, err := os.CreateTemp("", "firebird-*.sql")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(script.Name())
+	_, err = fmt.Fprintf(script, "SET LIST ON;\n%s;\n", strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, c.isql, "-q", "-b", "-ch", "UTF8", "-u", c.user, "-i", script.Name(), c.database)
+	cmd.Env = append(os.Environ(), "ISC_PASSWORD="+c.password)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	readErr := readIsqlList(stdout, row)
+	if readErr != nil {
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("exec: %s %w", stderr.String(), err)
+	}
+	return readErr
+}
+
+/*
+ * Reads isql output of SET LIST ON: a line per column with the name and the
+ * value, a blank line after every row. NULL prints as <null> and reads as
+ * empty. Columns keep the order of the first row.
+ */
+func readIsqlList(r io.Reader, row func(header, record []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var header, record []string
+	first := true
+	emit := func() error {
+		if len(record) == 0 {
+			return nil
+		}
+		if first {
+			first = false
+		} else if len(record) != len(header) {
+			return fmt.Errorf("isql row has %d columns, expected %d", len(record), len(header))
+		}
+		err := row(header, record)
+		record = nil
+		return err
+	}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" {
+			if err := emit(); err != nil {
+				return err
+			}
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if value == "<null>" {
+			value = ""
+		}
+		if first {
+			header = append(header, name)
+		}
+		record = append(record, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return emit()
+}
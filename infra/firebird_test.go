@@ -0,0 +1,68 @@
+package infra
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Lines as isql prints them in list mode, names padded to a column
+func readIsqlFixture(lines ...string) ([][]string, error) {
+	return collectRows(readIsqlList, strings.Join(lines, "\n"))
+}
+
+func TestReadIsqlList(t *testing.T) {
+	t.Run("rows", func(t *testing.T) {
+		rows, err := readIsqlFixture(
+			"",
+			"CARD                            1001",
+			"NAME                            Petrov  Ivan",
+			"TIME                            2024-03-01 08:00:00.0000",
+			"",
+			"CARD                            1002",
+			"NAME                            Smirnova, Anna; Welding",
+			"TIME                            2024-03-01 08:05:00.0000   ",
+			"",
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, [][]string{
+			{"CARD", "NAME", "TIME"},
+			{"1001", "Petrov  Ivan", "2024-03-01 08:00:00.0000"},
+			{"1002", "Smirnova, Anna; Welding", "2024-03-01 08:05:00.0000"},
+		}, rows)
+	})
+
+	t.Run("null and empty values read as empty", func(t *testing.T) {
+		rows, err := readIsqlFixture(
+			"CARD                            1001",
+			"NAME                            <null>",
+			"",
+			"CARD                            1002",
+			"NAME                            ",
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1001", ""}, rows[1])
+		assert.Equal(t, []string{"1002", ""}, rows[2])
+	})
+
+	t.Run("empty result", func(t *testing.T) {
+		rows, err := readIsqlFixture("", "")
+
+		assert.Nil(t, err)
+		assert.Empty(t, rows)
+	})
+
+	t.Run("rows with missing columns", func(t *testing.T) {
+		_, err := readIsqlFixture(
+			"CARD                            1001",
+			"NAME                            Petrov Ivan",
+			"",
+			"CARD                            1002",
+		)
+
+		assert.EqualError(t, err, "isql row has 1 columns, expected 2")
+	})
+}
@@ -0,0 +1,94 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// HR REST API returning the employee master data as a JSON array of
+// {card, first_name, last_name, department, position}
+type HRSource struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// Nil when HR_API_URL is not configured, the bearer token is the HR_API_TOKEN secret
+func NewHRSourceFromEnv(secrets SecretProvider) (*HRSource, error) {
+	url := os.Getenv("HR_API_URL")
+	if url == "" {
+		return nil, nil
+	}
+	token, err := secrets.Secret("HR_API_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	return &HRSource{url: url, token: token, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *HRSource) Employees() ([]entity.MasterEmployee, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching hr employees: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching hr employees: unexpected status %s", resp.Status)
+	}
+
+	var employees []entity.MasterEmployee
+	if err := json.NewDecoder(resp.Body).Decode(&employees); err != nil {
+		return nil, fmt.Errorf("decoding hr employees: %w", err)
+	}
+	return employees, nil
+}
+
+type EmployeeConflict struct {
+	Database   string    `db:"database" json:"database"`
+	Card       string    `db:"card" json:"card"`
+	Field      string    `db:"field" json:"field"`
+	Controller string    `db:"controller_value" json:"controller_value"`
+	Master     string    `db:"hr_value" json:"hr_value"`
+	DetectedAt time.Time `db:"detected_at" json:"detected_at"`
+}
+
+// Replaces the division's conflict report with the disagreements of the last run
+func (db *Repository) ReplaceEmployeeConflicts(database string, conflicts []entity.EmployeeConflict, at time.Time) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing employee conflicts: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.employee_conflicts WHERE database = $1", database)
+	if err != nil {
+		return fmt.Errorf("replacing employee conflicts: %w", err)
+	}
+	if len(conflicts) > 0 {
+		rows := make([]EmployeeConflict, len(conflicts))
+		for i, c := range conflicts {
+			rows[i] = EmployeeConflict{Database: database, Card: c.Card, Field: c.Field, Controller: c.Controller, Master: c.Master, DetectedAt: at}
+		}
+		_, err = tx.NamedExec(`INSERT INTO attendance.employee_conflicts
+			(database, card, field, controller_value, hr_value, detected_at)
+		VALUES (:database, :card, :field, :controller_value, :hr_value, :detected_at)`, rows)
+		if err != nil {
+			return fmt.Errorf("replacing employee conflicts: %w", err)
+		}
+	}
+	return tx.Commit()
+}
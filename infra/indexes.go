@@ -0,0 +1,106 @@
+package infra
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type IndexSpec struct {
+	Name    string
+	Table   string
+	Columns []string
+}
+
+// Indexes backing the queries the ETL and reports run on every sync
+var HotIndexes = []IndexSpec{
+	{Name: "events_card_timestamp_idx", Table: "events", Columns: []string{"card", "timestamp"}},
+	{Name: "intervals_card_ent_idx", Table: "intervals", Columns: []string{"card", "ent"}},
+	{Name: "employees_card_idx", Table: "employees", Columns: []string{"card"}},
+}
+
+// Column lists of every index on the table, in index key order
+func (db *Repository) tableIndexColumns(table string) ([][]string, error) {
+	var rows []string
+	err := db.Select(&rows, fmt.Sprintf(`SELECT array_to_string(array_agg(a.attname ORDER BY k.n), ',')
+	FROM pg_index i
+	JOIN pg_class t ON t.oid = i.indrelid
+	CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, n)
+	JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+	WHERE t.oid = to_regclass('attendance.%s')
+	GROUP BY i.indexrelid`, table))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]string, len(rows))
+	for i, row := range rows {
+		result[i] = strings.Split(row, ",")
+	}
+	return result, nil
+}
+
+// An existing index backs the spec when the spec columns are its leading key columns
+func indexCovers(indexColumns, spec []string) bool {
+	if len(indexColumns) < len(spec) {
+		return false
+	}
+	for i, col := range spec {
+		if indexColumns[i] != col {
+			return false
+		}
+	}
+	return true
+}
+
+func (db *Repository) MissingIndexes() ([]IndexSpec, error) {
+	missing := make([]IndexSpec, 0)
+
+	for _, spec := range HotIndexes {
+		indexes, err := db.tableIndexColumns(spec.Table)
+		if err != nil {
+			return nil, fmt.Errorf("loading indexes of %s: %w", spec.Table, err)
+		}
+
+		var found bool
+		for _, columns := range indexes {
+			if indexCovers(columns, spec.Columns) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, spec)
+		}
+	}
+
+	return missing, nil
+}
+
+// Logs a warning for every missing hot index and creates it when create is set
+func (db *Repository) VerifyIndexes(create bool) error {
+	missing, err := db.MissingIndexes()
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range missing {
+		log.Printf("warning: missing index on attendance.%s (%s)", spec.Table, strings.Join(spec.Columns, ", "))
+		if !create {
+			continue
+		}
+
+		quoted := make([]string, len(spec.Columns))
+		for i, col := range spec.Columns {
+			quoted[i] = fmt.Sprintf("%q", col)
+		}
+		_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON attendance.%s (%s)",
+			spec.Name, spec.Table, strings.Join(quoted, ", ")))
+		if err != nil {
+			return fmt.Errorf("creating index %s: %w", spec.Name, err)
+		}
+		log.Printf("created index %s", spec.Name)
+	}
+
+	return nil
+}
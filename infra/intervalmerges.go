@@ -0,0 +1,79 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const intervalMergeInsertBatchSize = 1000
+
+type IntervalMerge struct {
+	Database       string    `db:"database" json:"database"`
+	Card           string    `db:"card" json:"card"`
+	Key            string    `db:"interval_key" json:"interval_key"`
+	ExitAt         time.Time `db:"exit_at" json:"exit_at"`
+	ReentryAt      time.Time `db:"reentry_at" json:"reentry_at"`
+	ExitEventID    int       `db:"exit_event_id" json:"exit_event_id"`
+	ReentryEventID int       `db:"reentry_event_id" json:"reentry_event_id"`
+	GapSec         int       `db:"gap_sec" json:"gap_sec"`
+}
+
+// Replaces the division's interval merges of exits from since on, merges
+// are recomputed with the intervals on every run
+func (db *Repository) ReplaceIntervalMerges(database string, since time.Time, merges []entity.IntervalMerge) error {
+	rows := make([]IntervalMerge, 0, len(merges))
+	for _, m := range merges {
+		if m.Exit.Time.Before(since) {
+			continue
+		}
+		rows = append(rows, IntervalMerge{
+			Database:       database,
+			Card:           m.Card,
+			Key:            IntervalKey(m.Card, m.Ent.ID),
+			ExitAt:         m.Exit.Time,
+			ReentryAt:      m.Reentry.Time,
+			ExitEventID:    m.Exit.ID,
+			ReentryEventID: m.Reentry.ID,
+			GapSec:         int(m.Gap().Seconds()),
+		})
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing interval merges: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.interval_merges WHERE database = $1 AND exit_at >= $2", database, since)
+	if err != nil {
+		return fmt.Errorf("replacing interval merges: %w", err)
+	}
+	for start := 0; start < len(rows); start += intervalMergeInsertBatchSize {
+		end := start + intervalMergeInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err = tx.NamedExec(`INSERT INTO attendance.interval_merges
+			(database, card, interval_key, exit_at, reentry_at, exit_event_id, reentry_event_id, gap_sec)
+		VALUES (:database, :card, :interval_key, :exit_at, :reentry_at, :exit_event_id, :reentry_event_id, :gap_sec)
+		ON CONFLICT DO NOTHING`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("replacing interval merges: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Merges of exits in [from, to), the audit trail of hours that include short
+// breaks, of a single card when card is not empty
+func (db *Repository) IntervalMerges(card string, from, to time.Time) (merges []IntervalMerge, err error) {
+	err = db.Select(&merges, `SELECT database, card, interval_key, exit_at, reentry_at, exit_event_id, reentry_event_id, gap_sec
+	FROM attendance.interval_merges WHERE exit_at >= $1 AND exit_at < $2 AND ($3 = '' OR card = $3)
+	ORDER BY exit_at, card`, from, to, card)
+	if err != nil {
+		return nil, fmt.Errorf("loading interval merges: %w", err)
+	}
+	return merges, nil
+}
@@ -0,0 +1,114 @@
+package infra
+
+import (
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrWrongMdbPassword = errors.New("wrong database password")
+
+// Access 2007+ encrypts every page of a password protected file with a key
+// derived from the password, unlike the Jet header mask. Decrypting them is
+// out of scope, such files are rejected.
+var ErrEncryptedACCDB = errors.New("password protected ACCDB files are encrypted and not supported")
+
+type AccessFormat string
+
+const (
+	AccessFormatJet3  AccessFormat = "jet3"
+	AccessFormatJet4  AccessFormat = "jet4"
+	AccessFormatACCDB AccessFormat = "accdb"
+)
+
+const (
+	jetSignatureOffset = 0x04
+	jetVersionOffset   = 0x14
+	jetMaskedOffset    = 0x18
+	jetMaskedLength    = 128
+	jetPasswordOffset  = 0x42
+	jetDateOffset      = 0x72
+)
+
+// Header fields of a Jet database file that matter before mdbtools gets to read it
+type JetHeader struct {
+	// 0 for Jet3, 1 for Jet4, 2 and above for ACCDB
+	Version  byte
+	Password string
+}
+
+// Reads the header page. Everything past 0x18 is masked with a fixed RC4 key
+// stream, the Jet4 password is additionally masked with the creation date.
+func ReadJetHeader(path string) (JetHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return JetHeader{}, err
+	}
+	defer f.Close()
+
+	page := make([]byte, jetMaskedOffset+jetMaskedLength)
+	if _, err := io.ReadFull(f, page); err != nil {
+		return JetHeader{}, fmt.Errorf("reading header of %s: %w", path, err)
+	}
+
+	cipher, err := rc4.NewCipher([]byte{0xc7, 0xda, 0x39, 0x6b})
+	if err != nil {
+		return JetHeader{}, err
+	}
+	masked := page[jetMaskedOffset:]
+	cipher.XORKeyStream(masked, masked)
+
+	header := JetHeader{Version: page[jetVersionOffset]}
+	if header.Version == 0 {
+		header.Password = strings.TrimRight(string(page[jetPasswordOffset:jetPasswordOffset+20]), "\x00")
+		return header, nil
+	}
+
+	password := page[jetPasswordOffset : jetPasswordOffset+40]
+	date := math.Float64frombits(binary.LittleEndian.Uint64(page[jetDateOffset:]))
+	var dateMask [4]byte
+	binary.LittleEndian.PutUint32(dateMask[:], uint32(int32(date)))
+	chars := make([]uint16, 0, len(password)/2)
+	for i := 0; i+1 < len(password); i += 2 {
+		c := binary.LittleEndian.Uint16([]byte{password[i] ^ dateMask[i%4], password[i+1] ^ dateMask[(i+1)%4]})
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	header.Password = string(utf16.Decode(chars))
+	return header, nil
+}
+
+// Tells Jet databases from Access 2007+ ones by the signature of the header page,
+// the file extension is often wrong on controller PCs
+func DetectAccessFormat(path string) (AccessFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	page := make([]byte, jetVersionOffset+1)
+	if _, err := io.ReadFull(f, page); err != nil {
+		return "", fmt.Errorf("reading header of %s: %w", path, err)
+	}
+	switch signature := string(page[jetSignatureOffset : jetSignatureOffset+15]); {
+	case signature == "Standard ACE DB":
+		return AccessFormatACCDB, nil
+	case signature != "Standard Jet DB":
+		return "", fmt.Errorf("%s is not an Access database", path)
+	case page[jetVersionOffset] == 0:
+		return AccessFormatJet3, nil
+	case page[jetVersionOffset] == 1:
+		return AccessFormatJet4, nil
+	default:
+		return AccessFormatACCDB, nil
+	}
+}
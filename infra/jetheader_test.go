@@ -0,0 +1,90 @@
+package infra
+
+import (
+	"crypto/rc4"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a header page as Access does: the password in place, masked with the
+// creation date for Jet4, and everything past 0x18 masked with the RC4 stream
+func writeJetHeader(t *testing.T, signature string, version byte, password string) string {
+	page := make([]byte, 4096)
+	copy(page[jetSignatureOffset:], signature)
+	page[jetVersionOffset] = version
+	date := 45000.25
+	binary.LittleEndian.PutUint64(page[jetDateOffset:], math.Float64bits(date))
+	if version == 0 {
+		copy(page[jetPasswordOffset:], password)
+	} else {
+		var dateMask [4]byte
+		binary.LittleEndian.PutUint32(dateMask[:], uint32(int32(date)))
+		for i, c := range utf16.Encode([]rune(password)) {
+			binary.LittleEndian.PutUint16(page[jetPasswordOffset+2*i:], c)
+		}
+		for i := 0; i < 40; i++ {
+			page[jetPasswordOffset+i] ^= dateMask[i%4]
+		}
+	}
+	cipher, err := rc4.NewCipher([]byte{0xc7, 0xda, 0x39, 0x6b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := page[jetMaskedOffset : jetMaskedOffset+jetMaskedLength]
+	cipher.XORKeyStream(masked, masked)
+
+	path := filepath.Join(t.TempDir(), "db.mdb")
+	if err := os.WriteFile(path, page, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUnlock(t *testing.T) {
+	t.Run("jet3 password", func(t *testing.T) {
+		exporter := NewMdbExporter(writeJetHeader(t, "Standard Jet DB", 0, "secret"))
+
+		assert.Nil(t, exporter.Unlock("secret"))
+		assert.ErrorIs(t, exporter.Unlock("wrong"), ErrWrongMdbPassword)
+	})
+
+	t.Run("jet4 password", func(t *testing.T) {
+		path := writeJetHeader(t, "Standard Jet DB", 1, "пароль1")
+		exporter := NewMdbExporter(path)
+
+		header, err := ReadJetHeader(path)
+		assert.Nil(t, err)
+		assert.Equal(t, JetHeader{Version: 1, Password: "пароль1"}, header)
+		assert.Nil(t, exporter.Unlock("пароль1"))
+		assert.ErrorIs(t, exporter.Unlock("пароль"), ErrWrongMdbPassword)
+	})
+
+	t.Run("password from secrets", func(t *testing.T) {
+		secrets := t.TempDir()
+		unprotected := NewMdbExporter(writeJetHeader(t, "Standard Jet DB", 1, ""))
+		protected := NewMdbExporter(writeJetHeader(t, "Standard Jet DB", 1, "secret"))
+
+		assert.Nil(t, unprotected.UnlockWith(FileSecrets{Dir: secrets}))
+		assert.Nil(t, protected.UnlockWith(FileSecrets{Dir: secrets}))
+		if err := os.WriteFile(filepath.Join(secrets, "MDB_PASSWORD"), []byte("secret\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, protected.UnlockWith(FileSecrets{Dir: secrets}))
+		assert.ErrorIs(t, unprotected.UnlockWith(FileSecrets{Dir: secrets}), ErrWrongMdbPassword)
+	})
+
+	t.Run("encrypted accdb is rejected", func(t *testing.T) {
+		path := writeJetHeader(t, "Standard ACE DB", 2, "secret")
+
+		format, err := DetectAccessFormat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, AccessFormatACCDB, format)
+		assert.ErrorIs(t, NewMdbExporter(path).Unlock("secret"), ErrEncryptedACCDB)
+	})
+}
@@ -0,0 +1,156 @@
+package infra
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const defaultLDAPFilter = "(&(objectCategory=person)(objectClass=user))"
+
+// Active Directory or another LDAP server holding the corporate accounts
+type Directory struct {
+	url      string
+	bindDN   string
+	password string
+	baseDN   string
+	filter   string
+}
+
+// Nil when LDAP_URL is not configured, the bind password is the LDAP_BIND_PASSWORD secret
+func NewDirectoryFromEnv(secrets SecretProvider) (*Directory, error) {
+	url := os.Getenv("LDAP_URL")
+	if url == "" {
+		return nil, nil
+	}
+	password, err := secrets.Secret("LDAP_BIND_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	filter := os.Getenv("LDAP_FILTER")
+	if filter == "" {
+		filter = defaultLDAPFilter
+	}
+	return &Directory{
+		url:      url,
+		bindDN:   os.Getenv("LDAP_BIND_DN"),
+		password: password,
+		baseDN:   os.Getenv("LDAP_BASE_DN"),
+		filter:   filter,
+	}, nil
+}
+
+func (d *Directory) Accounts() ([]entity.DirectoryAccount, error) {
+	conn, err := ldap.DialURL(d.url)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ldap: %w", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(30 * time.Second)
+
+	if err := conn.Bind(d.bindDN, d.password); err != nil {
+		return nil, fmt.Errorf("binding to ldap: %w", err)
+	}
+
+	request := ldap.NewSearchRequest(d.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, d.filter,
+		[]string{"sAMAccountName", "givenName", "sn", "mail", "employeeID", "manager"}, nil)
+	// AD returns at most 1000 entries per page
+	result, err := conn.SearchWithPaging(request, 500)
+	if err != nil {
+		return nil, fmt.Errorf("searching ldap: %w", err)
+	}
+
+	accounts := make([]entity.DirectoryAccount, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		accounts = append(accounts, entity.DirectoryAccount{
+			DN:         e.DN,
+			Account:    e.GetAttributeValue("sAMAccountName"),
+			FirstName:  e.GetAttributeValue("givenName"),
+			LastName:   e.GetAttributeValue("sn"),
+			Email:      e.GetAttributeValue("mail"),
+			EmployeeID: e.GetAttributeValue("employeeID"),
+			ManagerDN:  e.GetAttributeValue("manager"),
+		})
+	}
+	return accounts, nil
+}
+
+type EmployeeDirectory struct {
+	Card           string    `db:"card"`
+	Account        string    `db:"account"`
+	Email          string    `db:"email"`
+	EmployeeID     string    `db:"employee_id"`
+	ManagerAccount string    `db:"manager_account"`
+	ManagerEmail   string    `db:"manager_email"`
+	MatchedBy      string    `db:"matched_by"`
+	UpdatedAt      time.Time `db:"updated_at"`
+}
+
+// Card to account links maintained by hand for users whose names do not match
+func (db *Repository) DirectoryMapping() (map[string]string, error) {
+	var rows []struct {
+		Card    string `db:"card"`
+		Account string `db:"account"`
+	}
+	if err := db.Select(&rows, "SELECT card, account FROM attendance.directory_mapping"); err != nil {
+		return nil, fmt.Errorf("loading directory mapping: %w", err)
+	}
+	mapping := make(map[string]string, len(rows))
+	for _, r := range rows {
+		mapping[r.Card] = r.Account
+	}
+	return mapping, nil
+}
+
+func (db *Repository) UpsertEmployeeDirectory(matches []entity.DirectoryMatch, at time.Time) error {
+	if len(matches) == 0 {
+		return nil
+	}
+	rows := make([]EmployeeDirectory, 0, len(matches))
+	seen := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		if seen[m.Card] {
+			continue
+		}
+		seen[m.Card] = true
+		row := EmployeeDirectory{
+			Card:       m.Card,
+			Account:    m.Account.Account,
+			Email:      m.Account.Email,
+			EmployeeID: m.Account.EmployeeID,
+			MatchedBy:  m.MatchedBy,
+			UpdatedAt:  at,
+		}
+		if m.Manager != nil {
+			row.ManagerAccount = m.Manager.Account
+			row.ManagerEmail = m.Manager.Email
+		}
+		rows = append(rows, row)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("saving employee directory: %w", err)
+	}
+	defer tx.Rollback()
+	for start := 0; start < len(rows); start += employeeUpsertBatchSize {
+		end := start + employeeUpsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err := tx.NamedExec(`INSERT INTO attendance.employee_directory
+			(card, account, email, employee_id, manager_account, manager_email, matched_by, updated_at)
+		VALUES (:card, :account, :email, :employee_id, :manager_account, :manager_email, :matched_by, :updated_at)
+		ON CONFLICT (card) DO UPDATE SET
+			account = EXCLUDED.account, email = EXCLUDED.email, employee_id = EXCLUDED.employee_id,
+			manager_account = EXCLUDED.manager_account, manager_email = EXCLUDED.manager_email,
+			matched_by = EXCLUDED.matched_by, updated_at = EXCLUDED.updated_at`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("saving employee directory: %w", err)
+		}
+	}
+	return tx.Commit()
+}
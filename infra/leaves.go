@@ -0,0 +1,127 @@
+package infra
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Header names accepted for each leave column, the Russian ones are those of
+// 1C:ZUP extracts
+var leaveColumns = map[string][]string{
+	"card": {"card", "cardno", "card_number", "табельныйномер", "табельный номер"},
+	"kind": {"kind", "type", "видвремени", "вид времени", "видотсутствия", "вид отсутствия"},
+	"from": {"from", "date_from", "start", "датаначала", "дата начала"},
+	"to":   {"to", "date_to", "end", "датаокончания", "дата окончания"},
+}
+
+// Reads leaves from a CSV with a header row, comma separated or semicolon
+// separated as 1C writes it. Kinds may be given as T-13 codes.
+func ReadLeavesCSV(input io.Reader) ([]entity.Leave, error) {
+	buffered := bufio.NewReader(input)
+	first, err := buffered.Peek(buffered.Size())
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("reading leave file: %w", err)
+	}
+	header, _, _ := strings.Cut(string(first), "\n")
+
+	reader := csv.NewReader(buffered)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		reader.Comma = ';'
+	}
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading leave file: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("leave file is empty")
+	}
+
+	index := make(map[string]int)
+	for i, field := range rows[0] {
+		field = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(field, "\ufeff")))
+		for column, names := range leaveColumns {
+			for _, name := range names {
+				if field == name {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for _, column := range []string{"card", "kind", "from", "to"} {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("leave file has no %s column", column)
+		}
+	}
+
+	leaves := make([]entity.Leave, 0, len(rows)-1)
+	for i, record := range rows[1:] {
+		line := i + 2
+		field := func(column string) string {
+			i := index[column]
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+
+		l := entity.Leave{Card: field("card")}
+		if l.Kind, err = entity.ParseLeaveKind(field("kind")); err != nil {
+			return nil, fmt.Errorf("leave file line %d: %w", line, err)
+		}
+		if l.From, err = parseDay(field("from")); err != nil {
+			return nil, fmt.Errorf("leave file line %d: %w", line, err)
+		}
+		if l.To, err = parseDay(field("to")); err != nil {
+			return nil, fmt.Errorf("leave file line %d: %w", line, err)
+		}
+		if err := l.Validate(); err != nil {
+			return nil, fmt.Errorf("leave file line %d: %w", line, err)
+		}
+		leaves = append(leaves, l)
+	}
+	return leaves, nil
+}
+
+// Upserts leaves by card, start and kind, a leave extended or shortened in HR
+// keeps its start and gets the new end
+func (db *Repository) ImportLeaves(leaves []entity.Leave, source string, at time.Time) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("importing leaves: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, l := range leaves {
+		_, err := tx.Exec(`INSERT INTO attendance.leaves (card, kind, date_from, date_to, source, imported_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (card, date_from, kind) DO UPDATE SET date_to = excluded.date_to,
+			source = excluded.source, imported_at = excluded.imported_at`,
+			l.Card, l.Kind, l.From, l.To, source, at)
+		if err != nil {
+			return fmt.Errorf("importing leave of %s: %w", l.Card, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Leaves overlapping the days in [from, to)
+func (db *Repository) Leaves(from, to time.Time) (leaves []entity.Leave, err error) {
+	err = db.Select(&leaves, `SELECT card, kind, date_from, date_to FROM attendance.leaves
+	WHERE date_from < $2 AND date_to >= $1 ORDER BY card, date_from`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading leaves: %w", err)
+	}
+	return leaves, nil
+}
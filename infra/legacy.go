@@ -0,0 +1,296 @@
+package infra
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Interval of the old attendance system, paired there already
+type LegacyInterval struct {
+	Card       string
+	FirstName  string
+	LastName   string
+	Department string
+	Ent        time.Time
+	// zero when the old system left the interval open
+	Ext time.Time
+}
+
+// Header names accepted for each column of the old system's export, the
+// Russian ones are those of its report designer
+var legacyColumns = map[string][]string{
+	"card":       {"card", "cardno", "card_no", "пропуск", "номер пропуска"},
+	"date":       {"date", "day", "дата"},
+	"in":         {"in", "entry", "time_in", "вход", "время входа"},
+	"out":        {"out", "exit", "time_out", "выход", "время выхода"},
+	"lastname":   {"lastname", "last_name", "surname", "фамилия"},
+	"firstname":  {"firstname", "first_name", "name", "имя"},
+	"department": {"department", "dept", "подразделение", "отдел"},
+}
+
+var legacyDateLayouts = []string{"2006-01-02", "02.01.2006", "02.01.06"}
+
+var legacyTimeLayouts = []string{"15:04", "15:04:05"}
+
+/*
+ * Reads the export of the old attendance system: a CSV with a header row,
+ * comma or semicolon separated, one interval per row with the columns
+ *
+ *	card, date, in, out[, lastname, firstname, department]
+ *
+ * date as 2006-01-02 or 02.01.2006, in and out as 15:04 or 15:04:05 local time.
+ * An out before in ends the next day, an empty out leaves the interval open.
+ * The name and department columns fill in employees the destination lacks.
+ */
+func ReadLegacyCSV(input io.Reader) ([]LegacyInterval, error) {
+	buffered := bufio.NewReader(input)
+	first, err := buffered.Peek(buffered.Size())
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("reading legacy file: %w", err)
+	}
+	header, _, _ := strings.Cut(string(first), "\n")
+
+	reader := csv.NewReader(buffered)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		reader.Comma = ';'
+	}
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading legacy file: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("legacy file is empty")
+	}
+
+	index := make(map[string]int)
+	for i, field := range rows[0] {
+		field = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(field, "\ufeff")))
+		for column, names := range legacyColumns {
+			for _, name := range names {
+				if field == name {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for _, column := range []string{"card", "date", "in", "out"} {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("legacy file has no %s column", column)
+		}
+	}
+
+	intervals := make([]LegacyInterval, 0, len(rows)-1)
+	for i, record := range rows[1:] {
+		line := i + 2
+		field := func(column string) string {
+			i, ok := index[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+
+		l := LegacyInterval{
+			Card:       field("card"),
+			FirstName:  field("firstname"),
+			LastName:   field("lastname"),
+			Department: field("department"),
+		}
+		if l.Card == "" {
+			return nil, fmt.Errorf("legacy file line %d: card is empty", line)
+		}
+		day, err := parseLegacy(legacyDateLayouts, field("date"), "date")
+		if err != nil {
+			return nil, fmt.Errorf("legacy file line %d: %w", line, err)
+		}
+		ent, err := parseLegacy(legacyTimeLayouts, field("in"), "in")
+		if err != nil {
+			return nil, fmt.Errorf("legacy file line %d: %w", line, err)
+		}
+		l.Ent = onDay(day, ent)
+		if out := field("out"); out != "" {
+			ext, err := parseLegacy(legacyTimeLayouts, out, "out")
+			if err != nil {
+				return nil, fmt.Errorf("legacy file line %d: %w", line, err)
+			}
+			l.Ext = onDay(day, ext)
+			if l.Ext.Before(l.Ent) {
+				l.Ext = l.Ext.AddDate(0, 0, 1)
+			}
+		}
+		intervals = append(intervals, l)
+	}
+	return intervals, nil
+}
+
+func parseLegacy(layouts []string, value, column string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s %q", column, value)
+}
+
+func onDay(day, clock time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.UTC)
+}
+
+// Intervals per statement of a legacy import
+const legacyInsertBatchSize = 1000
+
+type legacyRow struct {
+	Interval
+	Source string `db:"source"`
+}
+
+// Entry of the first interval the ETL paired in the division, legacy history
+// must end before it. false when the ETL hasn't paired any yet.
+func (db *Repository) FirstETLInterval(database string) (time.Time, bool, error) {
+	return firstETLInterval(db.DB, database)
+}
+
+func firstETLInterval(q sqlx.Queryer, database string) (time.Time, bool, error) {
+	var first sql.NullTime
+	err := sqlx.Get(q, &first, `SELECT min(ent) FROM attendance.intervals
+	WHERE database = $1 AND source = 'etl'`, database)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("loading first etl interval: %w", err)
+	}
+	return first.Time, first.Valid, nil
+}
+
+// Intervals entering before the first ETL interval, the ETL's own history
+// takes over from there. Returns how many were dropped.
+func TrimLegacyIntervals(intervals []LegacyInterval, first time.Time) ([]LegacyInterval, int) {
+	kept := make([]LegacyInterval, 0, len(intervals))
+	for _, l := range intervals {
+		if l.Ent.Before(first) {
+			kept = append(kept, l)
+		}
+	}
+	return kept, len(intervals) - len(kept)
+}
+
+/*
+ * Legacy exports list an interval more than once when it was corrected in the
+ * old system, rows of a card and entry are one interval. The row with an exit
+ * wins over an open one, otherwise the first.
+ */
+func dedupeLegacyIntervals(intervals []LegacyInterval) []LegacyInterval {
+	type cardEntry struct {
+		card string
+		ent  time.Time
+	}
+	seen := make(map[cardEntry]int, len(intervals))
+	result := make([]LegacyInterval, 0, len(intervals))
+	for _, l := range intervals {
+		key := cardEntry{l.Card, l.Ent}
+		i, ok := seen[key]
+		if !ok {
+			seen[key] = len(result)
+			result = append(result, l)
+			continue
+		}
+		if result[i].Ext.IsZero() && !l.Ext.IsZero() {
+			result[i] = l
+		}
+	}
+	return result
+}
+
+/*
+ * Loads legacy intervals into the division with the source marker, next to
+ * the intervals the ETL pairs. Their entry event id is a negative id derived
+ * from card and entry, no source event can take it, so a repeated import
+ * replaces its own intervals only. Cards the destination doesn't know become
+ * employees, known ones keep their name and department. Intervals at or after
+ * the first ETL interval of the division are refused, trim them first.
+ */
+func (db *Repository) ImportLegacyIntervals(database, source string, intervals []LegacyInterval, at time.Time) error {
+	intervals = dedupeLegacyIntervals(intervals)
+	employees := make([]Employee, 0)
+	known := make(map[string]bool)
+	rows := make([]legacyRow, 0, len(intervals))
+	for _, l := range intervals {
+		if !known[l.Card] {
+			known[l.Card] = true
+			employees = append(employees, Employee{
+				FirstName:  l.FirstName,
+				LastName:   l.LastName,
+				Card:       l.Card,
+				Department: l.Department,
+				CreatedAt:  sql.NullString{String: at.Local().Format("2006-01-02T15:04:05"), Valid: true},
+			})
+		}
+		ent := l.Ent.Format("2006-01-02 15:04:05")
+		interval := Interval{
+			Ent:        ent,
+			Card:       l.Card,
+			Database:   database,
+			EntEventID: -DerivedEventID(source, l.Card, ent),
+		}
+		if !l.Ext.IsZero() {
+			interval.Ext = sql.NullString{String: l.Ext.Format("2006-01-02 15:04:05"), Valid: true}
+		}
+		interval.Key = IntervalKey(interval.Card, interval.EntEventID)
+		rows = append(rows, legacyRow{Interval: interval, Source: source})
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("importing legacy intervals: %w", err)
+	}
+	defer tx.Rollback()
+
+	first, ok, err := firstETLInterval(tx, database)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if _, trimmed := TrimLegacyIntervals(intervals, first); trimmed > 0 {
+			return fmt.Errorf("%d legacy intervals of %s enter at or after its first etl interval at %s",
+				trimmed, database, first.Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	for start := 0; start < len(employees); start += employeeUpsertBatchSize {
+		end := start + employeeUpsertBatchSize
+		if end > len(employees) {
+			end = len(employees)
+		}
+		_, err := tx.NamedExec(`INSERT INTO attendance.employees (firstname, lastname, card, created_at, department, position)
+		VALUES (:firstname, :lastname, :card, :created_at, :department, :position)
+		ON CONFLICT (card) DO NOTHING`, employees[start:end])
+		if err != nil {
+			return fmt.Errorf("importing legacy employees: %w", err)
+		}
+	}
+	for start := 0; start < len(rows); start += legacyInsertBatchSize {
+		end := start + legacyInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err := tx.NamedExec(`INSERT INTO attendance.intervals AS i (interval_key, ent, ext, card, database, ent_event_id, source)
+		VALUES (:interval_key, :ent, :ext, :card, :database, :ent_event_id, :source)
+		ON CONFLICT (database, interval_key) DO UPDATE SET ext = EXCLUDED.ext
+		WHERE i.source = EXCLUDED.source AND i.ext IS DISTINCT FROM EXCLUDED.ext`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("importing legacy intervals: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,34 @@
+package infra
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyIntervals(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	intervals := []LegacyInterval{
+		{Card: "1", Ent: day.Add(8 * time.Hour)},
+		{Card: "1", Ent: day.Add(8 * time.Hour), Ext: day.Add(17 * time.Hour)},
+		{Card: "1", Ent: day.Add(8 * time.Hour), Ext: day.Add(18 * time.Hour)},
+		{Card: "2", Ent: day.Add(8 * time.Hour), Ext: day.Add(17 * time.Hour)},
+		{Card: "2", Ent: day.AddDate(0, 0, 1).Add(8 * time.Hour)},
+	}
+
+	t.Run("rows of a card and entry are one interval", func(t *testing.T) {
+		deduped := dedupeLegacyIntervals(intervals)
+
+		assert.Equal(t, 3, len(deduped))
+		assert.Equal(t, day.Add(17*time.Hour), deduped[0].Ext)
+		assert.Equal(t, "2", deduped[1].Card)
+	})
+
+	t.Run("intervals from the first etl interval on are trimmed", func(t *testing.T) {
+		kept, trimmed := TrimLegacyIntervals(intervals, day.AddDate(0, 0, 1).Add(8*time.Hour))
+
+		assert.Equal(t, 4, len(kept))
+		assert.Equal(t, 1, trimmed)
+	})
+}
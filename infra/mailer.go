@@ -0,0 +1,308 @@
+package infra
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// SMTP server sending the monthly reports
+type MailConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_FROM and the SMTP_PASSWORD
+// secret. Mail is disabled when SMTP_HOST is empty.
+func MailConfigFromEnv(secrets SecretProvider) (MailConfig, bool, error) {
+	config := MailConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if config.Host == "" {
+		return config, false, nil
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+	if config.From == "" {
+		config.From = config.Username
+	}
+	if config.From == "" {
+		return config, false, errors.New("SMTP_FROM is required with SMTP_HOST")
+	}
+	if config.Username != "" {
+		password, err := secrets.Secret("SMTP_PASSWORD")
+		if err != nil {
+			return config, false, err
+		}
+		config.Password = password
+	}
+	return config, true, nil
+}
+
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+type Mail struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []MailAttachment
+}
+
+// Sends the mail. Port 465 speaks TLS from the start, other ports upgrade
+// with STARTTLS when the server offers it.
+func (c MailConfig) Send(mail Mail) error {
+	message, err := mail.message(c.From, time.Now())
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(c.Host, c.Port)
+	var conn net.Conn
+	if c.Port == "465" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && c.Port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return fmt.Errorf("starting tls with %s: %w", addr, err)
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return fmt.Errorf("authenticating with %s: %w", addr, err)
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	for _, to := range mail.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("sending mail to %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	return client.Quit()
+}
+
+// MIME message with a quoted-printable text body and base64 attachments
+func (m Mail) message(from string, at time.Time) ([]byte, error) {
+	token := make([]byte, 12)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(token)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", boundary)
+
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(m.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	b.WriteString("\r\n")
+
+	for _, a := range m.Attachments {
+		name := mime.BEncoding.Encode("utf-8", a.Name)
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s; name=\"%s\"\r\n", a.ContentType, name)
+		b.WriteString("Content-Transfer-Encoding: base64\r\n")
+		fmt.Fprintf(&b, "Content-Disposition: attachment; filename=\"%s\"\r\n\r\n", name)
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			b.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		b.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+// Who gets which monthly reports. Subject and body are text/template
+// templates executed with MailReportData, built-in ones in the recipient's
+// language when empty.
+type MailReportsConfig struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// language of the reports, ru when empty
+	Lang       string          `json:"lang"`
+	Recipients []MailRecipient `json:"recipients"`
+}
+
+// Manager receiving the reports of their departments, a recipient without
+// departments receives every department
+type MailRecipient struct {
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Departments []string `json:"departments"`
+	// overrides the language of the config
+	Lang string `json:"lang"`
+}
+
+type MailReportData struct {
+	Recipient   MailRecipient
+	Locale      entity.Locale
+	Month       time.Time
+	Departments []string
+	Employees   int
+	Absences    int
+}
+
+var defaultMailTemplates = map[entity.Locale][2]string{
+	entity.LocaleRU: {
+		"Табель учёта рабочего времени за {{.Month.Format \"01.2006\"}}",
+		"Здравствуйте{{with .Recipient.Name}}, {{.}}{{end}}!\n\n" +
+			"Во вложении табель за {{.Month.Format \"01.2006\"}} по подразделениям: {{join .Departments \", \"}}.\n" +
+			"Сотрудников: {{.Employees}}, неявок без оправдательных документов: {{.Absences}}.\n",
+	},
+	entity.LocaleEN: {
+		"Attendance timesheet for {{.Month.Format \"01.2006\"}}",
+		"Hello{{with .Recipient.Name}} {{.}}{{end}},\n\n" +
+			"attached is the timesheet for {{.Month.Format \"01.2006\"}} of the departments {{join .Departments \", \"}}.\n" +
+			"Employees: {{.Employees}}, unexplained absences: {{.Absences}}.\n",
+	},
+}
+
+func ReadMailReportsConfig(path string) (MailReportsConfig, error) {
+	var config MailReportsConfig
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("reading mail config: %w", err)
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("decoding mail config %s: %w", path, err)
+	}
+	if _, err := config.Locale(MailRecipient{}); err != nil {
+		return config, fmt.Errorf("mail config %s: %w", path, err)
+	}
+	for _, r := range config.Recipients {
+		if !strings.Contains(r.Email, "@") {
+			return config, fmt.Errorf("mail config %s: invalid recipient email %q", path, r.Email)
+		}
+		if _, err := config.Locale(r); err != nil {
+			return config, fmt.Errorf("mail config %s: recipient %s: %w", path, r.Email, err)
+		}
+	}
+	if _, _, err := config.Render(MailReportData{Locale: entity.LocaleRU}); err != nil {
+		return config, fmt.Errorf("mail config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Language of the recipient's reports
+func (c MailReportsConfig) Locale(recipient MailRecipient) (entity.Locale, error) {
+	switch {
+	case recipient.Lang != "":
+		return entity.ParseLocale(recipient.Lang)
+	case c.Lang != "":
+		return entity.ParseLocale(c.Lang)
+	}
+	return entity.LocaleRU, nil
+}
+
+// Subject and body for the data
+func (c MailReportsConfig) Render(data MailReportData) (subject, body string, err error) {
+	subjectText, bodyText := c.Subject, c.Body
+	defaults, ok := defaultMailTemplates[data.Locale]
+	if !ok {
+		defaults = defaultMailTemplates[entity.LocaleRU]
+	}
+	if subjectText == "" {
+		subjectText = defaults[0]
+	}
+	if bodyText == "" {
+		bodyText = defaults[1]
+	}
+	funcs := template.FuncMap{"join": strings.Join}
+	subjectTemplate, err := template.New("subject").Funcs(funcs).Parse(subjectText)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing mail subject: %w", err)
+	}
+	bodyTemplate, err := template.New("body").Funcs(funcs).Parse(bodyText)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing mail body: %w", err)
+	}
+	var s, b strings.Builder
+	if err := subjectTemplate.Execute(&s, data); err != nil {
+		return "", "", fmt.Errorf("rendering mail subject: %w", err)
+	}
+	if err := bodyTemplate.Execute(&b, data); err != nil {
+		return "", "", fmt.Errorf("rendering mail body: %w", err)
+	}
+	return strings.TrimSpace(s.String()), b.String(), nil
+}
+
+// Whether the recipient was already mailed the reports of the month
+func (db *Repository) ReportMailed(month time.Time, recipient string) (bool, error) {
+	var mailed bool
+	err := db.Get(&mailed, `SELECT EXISTS (SELECT 1 FROM attendance.report_mailings
+	WHERE month = $1 AND recipient = $2)`, month.Format("2006-01-02"), recipient)
+	if err != nil {
+		return false, fmt.Errorf("loading report mailings: %w", err)
+	}
+	return mailed, nil
+}
+
+func (db *Repository) RecordReportMailing(month time.Time, recipient string, at time.Time) error {
+	_, err := db.Exec(`INSERT INTO attendance.report_mailings (month, recipient, sent_at) VALUES ($1, $2, $3)
+	ON CONFLICT (month, recipient) DO UPDATE SET sent_at = excluded.sent_at`, month.Format("2006-01-02"), recipient, at)
+	if err != nil {
+		return fmt.Errorf("recording report mailing: %w", err)
+	}
+	return nil
+}
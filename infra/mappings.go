@@ -0,0 +1,124 @@
+package infra
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Header names accepted for each mapping column, HR spreadsheets are not consistent
+var cardMappingColumns = map[string][]string{
+	"card":            {"card", "cardno", "card_number"},
+	"firstname":       {"firstname", "first_name"},
+	"lastname":        {"lastname", "last_name"},
+	"employee_number": {"employee_number", "employee_no", "tab_number"},
+}
+
+// Reads card mappings from a CSV with a header row. The card column and at
+// least one of the name columns are required, the employee number is optional.
+func ReadCardMappingsCSV(input io.Reader) ([]entity.CardMapping, error) {
+	reader := csv.NewReader(input)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("mapping file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading mapping header: %w", err)
+	}
+
+	index := make(map[string]int)
+	for i, field := range header {
+		field = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(field, "\ufeff")))
+		for column, names := range cardMappingColumns {
+			for _, name := range names {
+				if field == name {
+					index[column] = i
+				}
+			}
+		}
+	}
+	if _, ok := index["card"]; !ok {
+		return nil, errors.New("mapping file has no card column")
+	}
+	_, first := index["firstname"]
+	_, last := index["lastname"]
+	if !first && !last {
+		return nil, errors.New("mapping file has neither a firstname nor a lastname column")
+	}
+
+	field := func(record []string, column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	mappings := make([]entity.CardMapping, 0)
+	seen := make(map[string]int)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading mapping file: %w", err)
+		}
+		m := entity.CardMapping{
+			Card:           field(record, "card"),
+			FirstName:      field(record, "firstname"),
+			LastName:       field(record, "lastname"),
+			EmployeeNumber: field(record, "employee_number"),
+		}
+		if m.Card == "" {
+			return nil, fmt.Errorf("mapping file line %d: card is empty", line)
+		}
+		if previous, ok := seen[m.Card]; ok {
+			return nil, fmt.Errorf("mapping file line %d: card %s is already mapped on line %d", line, m.Card, previous)
+		}
+		seen[m.Card] = line
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// Upserts the mappings by card. With replace, mappings of cards missing from
+// the import are deleted so the table mirrors the file.
+func (db *Repository) ImportCardMappings(mappings []entity.CardMapping, replace bool, at time.Time) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("importing card mappings: %w", err)
+	}
+	defer tx.Rollback()
+
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM attendance.card_mappings`); err != nil {
+			return fmt.Errorf("importing card mappings: %w", err)
+		}
+	}
+	for _, m := range mappings {
+		_, err := tx.Exec(`INSERT INTO attendance.card_mappings (card, firstname, lastname, employee_number, imported_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (card) DO UPDATE SET firstname = excluded.firstname, lastname = excluded.lastname,
+			employee_number = excluded.employee_number, imported_at = excluded.imported_at`,
+			m.Card, m.FirstName, m.LastName, m.EmployeeNumber, at)
+		if err != nil {
+			return fmt.Errorf("importing card mapping of %s: %w", m.Card, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Repository) CardMappings() (mappings []entity.CardMapping, err error) {
+	err = db.Select(&mappings, `SELECT card, firstname, lastname, employee_number FROM attendance.card_mappings ORDER BY card`)
+	if err != nil {
+		return nil, fmt.Errorf("loading card mappings: %w", err)
+	}
+	return mappings, nil
+}
@@ -0,0 +1,91 @@
+package infra
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field-level masking applied to data leaving the company: exports and API responses
+type MaskingProfile struct {
+	MaskNames bool
+	// number of trailing card digits kept, 0 keeps the whole card
+	CardDigits int
+}
+
+var MaskingProfiles = map[string]MaskingProfile{
+	"none":       {},
+	"consultant": {MaskNames: true, CardDigits: 4},
+	"anonymous":  {MaskNames: true, CardDigits: 2},
+}
+
+// Accepts a profile name or a custom spec such as "names,card=4"
+func ParseMaskingProfile(spec string) (MaskingProfile, error) {
+	if spec == "" {
+		return MaskingProfile{}, nil
+	}
+	if profile, ok := MaskingProfiles[spec]; ok {
+		return profile, nil
+	}
+
+	profile := MaskingProfile{}
+	for _, option := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "names":
+			profile.MaskNames = true
+		case "card":
+			digits, err := strconv.Atoi(value)
+			if err != nil || digits < 0 {
+				return MaskingProfile{}, fmt.Errorf("invalid card digits in masking spec: %q", option)
+			}
+			profile.CardDigits = digits
+		default:
+			return MaskingProfile{}, fmt.Errorf("unknown masking profile or option: %q", option)
+		}
+	}
+	return profile, nil
+}
+
+func (p MaskingProfile) Name(name string) string {
+	if !p.MaskNames || name == "" {
+		return name
+	}
+	first, _ := utf8.DecodeRuneInString(name)
+	return string(first) + "***"
+}
+
+func (p MaskingProfile) Card(card string) string {
+	if p.CardDigits == 0 || len(card) <= p.CardDigits {
+		return card
+	}
+	return strings.Repeat("*", len(card)-p.CardDigits) + card[len(card)-p.CardDigits:]
+}
+
+// Masks a destination column holding personal data, other columns pass unchanged
+func (p MaskingProfile) Column(column, value string) string {
+	switch column {
+	case "card":
+		return p.Card(value)
+	case "first_name", "last_name":
+		return p.Name(value)
+	case "interval_key":
+		// card:ent_event_id
+		if card, id, ok := strings.Cut(value, ":"); ok {
+			return p.Card(card) + ":" + id
+		}
+	}
+	return value
+}
+
+// Masks names and cards of timesheet rows, the sheets are modified in place
+func (p MaskingProfile) Timesheet(sheets []TimesheetSheet) {
+	for i := range sheets {
+		for j := range sheets[i].Rows {
+			row := &sheets[i].Rows[j]
+			row.Card = p.Card(row.Card)
+			row.Name = p.Name(row.Name)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMaskingProfile(t *testing.T) {
+	tests := []struct {
+		spec    string
+		profile MaskingProfile
+		failed  bool
+	}{
+		{"", MaskingProfile{}, false},
+		{"none", MaskingProfile{}, false},
+		{"consultant", MaskingProfile{MaskNames: true, CardDigits: 4}, false},
+		{"anonymous", MaskingProfile{MaskNames: true, CardDigits: 2}, false},
+		{"names", MaskingProfile{MaskNames: true}, false},
+		{"card=3", MaskingProfile{CardDigits: 3}, false},
+		{"names, card=4", MaskingProfile{MaskNames: true, CardDigits: 4}, false},
+		{"card=-1", MaskingProfile{}, true},
+		{"card=x", MaskingProfile{}, true},
+		{"card", MaskingProfile{}, true},
+		{"phones", MaskingProfile{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			profile, err := ParseMaskingProfile(tt.spec)
+
+			assert.Equal(t, tt.profile, profile)
+			assert.Equal(t, tt.failed, err != nil)
+		})
+	}
+}
+
+func TestMaskingProfileColumn(t *testing.T) {
+	consultant := MaskingProfiles["consultant"]
+	tests := []struct {
+		name    string
+		profile MaskingProfile
+		column  string
+		value   string
+		masked  string
+	}{
+		{"card keeps trailing digits", consultant, "card", "0012345678", "******5678"},
+		{"short card is kept", consultant, "card", "5678", "5678"},
+		{"empty card", consultant, "card", "", ""},
+		{"name keeps the first letter", consultant, "last_name", "Иванов", "И***"},
+		{"empty name", consultant, "first_name", "", ""},
+		{"card in interval key", consultant, "interval_key", "0012345678:42", "******5678:42"},
+		{"other columns pass", consultant, "database", "plant-1", "plant-1"},
+		{"no masking", MaskingProfile{}, "card", "0012345678", "0012345678"},
+		{"names only", MaskingProfile{MaskNames: true}, "card", "0012345678", "0012345678"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.masked, tt.profile.Column(tt.column, tt.value))
+		})
+	}
+}
+
+func TestMaskingProfileTimesheet(t *testing.T) {
+	sheets := []TimesheetSheet{{Department: "Цех 1", Rows: []TimesheetRow{{Card: "0012345678", Name: "Иванов Иван", Position: "Токарь"}}}}
+
+	MaskingProfiles["anonymous"].Timesheet(sheets)
+
+	assert.Equal(t, TimesheetRow{Card: "********78", Name: "И***", Position: "Токарь"}, sheets[0].Rows[0])
+	assert.Equal(t, "Цех 1", sheets[0].Department)
+}
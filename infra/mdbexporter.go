@@ -2,9 +2,15 @@ package infra
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os/exec"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spooky-finn/piek-attendance-prod/entity"
 	"golang.org/x/text/encoding/charmap"
@@ -13,51 +19,225 @@ import (
 type MdbExporter struct {
 	dblocation  string
 	mdbToolsBin string
+	mdbVerBin   string
+	archiveDir  string
+	accdbBin    string
+
+	schema           entity.SchemaProfile
+	onUnknownColumns func(table string, columns []string)
+	mu               sync.Mutex
+	reported         map[string]bool
 }
 
 func NewMdbExporter(mdbpath string) *MdbExporter {
 	mdbToolsBin := "mdb-export"
+	mdbVerBin := "mdb-ver"
 
 	if runtime.GOOS == "windows" {
 		mdbToolsBin = "./mdbtools-win/mdb-export"
+		mdbVerBin = "./mdbtools-win/mdb-ver"
 	}
 
-	return &MdbExporter{dblocation: mdbpath, mdbToolsBin: mdbToolsBin}
+	return &MdbExporter{dblocation: mdbpath, mdbToolsBin: mdbToolsBin, mdbVerBin: mdbVerBin, schema: entity.DefaultSchemaProfile()}
 }
 
-func (e *MdbExporter) ExportEventsFromDB(selectFor int) ([]entity.Event, error) {
-	out, errout, err := e.mdbExport(e.dblocation, "acc_monitor_log")
+// Keeps a compressed copy of every raw events export in dir
+func (e *MdbExporter) SetArchiveDir(dir string) {
+	e.archiveDir = dir
+}
+
+// ACCDB files are not parsed here, every table is read by shelling out to an
+// mdb-export compatible command, mdb-export itself unless bin is set. bin gets
+// the arguments of mdb-export and must print the same CSV, for hosts whose
+// mdbtools predate ACCDB support. Takes effect on DetectFormat.
+func (e *MdbExporter) SetACCDBExportBin(bin string) {
+	e.accdbBin = bin
+}
+
+// Reads the file signature and picks the export command for it
+func (e *MdbExporter) DetectFormat() (AccessFormat, error) {
+	format, err := DetectAccessFormat(e.dblocation)
+	if err != nil {
+		return "", err
+	}
+	if format == AccessFormatACCDB && e.accdbBin != "" {
+		e.mdbToolsBin = e.accdbBin
+	}
+	return format, nil
+}
 
+// Checks password against the one stored in the database file. mdbtools reads
+// Jet3 and Jet4 files regardless of their password and decodes RC4 encoded pages
+// itself, a matching password is all it takes to export a protected database.
+func (e *MdbExporter) Unlock(password string) error {
+	header, err := ReadJetHeader(e.dblocation)
+	if err != nil {
+		return err
+	}
+	if header.Version >= 2 {
+		return fmt.Errorf("%w: decrypt %s with Access first", ErrEncryptedACCDB, e.dblocation)
+	}
+	if header.Password != password {
+		return fmt.Errorf("%w for %s", ErrWrongMdbPassword, e.dblocation)
+	}
+	return nil
+}
+
+// Checks the database against the MDB_PASSWORD secret. Deployments exporting
+// unprotected files leave it out and skip the check.
+func (e *MdbExporter) UnlockWith(secrets SecretProvider) error {
+	password, err := OptionalSecret(secrets, "MDB_PASSWORD")
+	if err != nil || password == "" {
+		return err
+	}
+	return e.Unlock(password)
+}
+
+func (e *MdbExporter) ExportEventsFromDB(selectFor int) ([]entity.Event, error) {
+	events := make([]entity.Event, 0)
+	err := e.StreamEvents(context.Background(), selectFor, DefaultStreamBatchSize, func(batch []entity.Event) error {
+		events = append(events, batch...)
+		return nil
+	})
 	if err != nil {
-		log.Fatalln("err: exec: ", errout, err)
 		return nil, err
 	}
+	return events, nil
+}
 
-	events, err := SerializeCSVInput(out, entity.NewEventFromDBRecord)
-	events = entity.SelectEventsForNLastMonths(events, selectFor+1)
+// Streams events of the last selectFor months to sink in batches of batchSize
+// without holding the whole mdb-export output in memory. Cancelling ctx stops
+// mdb-export, the batch already handed to sink is completed first.
+func (e *MdbExporter) StreamEvents(ctx context.Context, selectFor, batchSize int, sink func([]entity.Event) error) error {
+	cmd := exec.CommandContext(ctx, e.mdbToolsBin, e.dblocation, e.schema.Table(entity.TableEvents))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Fatalln("err", err)
+		return fmt.Errorf("exec: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("exec: %w", err)
 	}
 
-	return events, nil
+	var out io.Reader = stdout
+	if runtime.GOOS == "windows" {
+		// reencode stdout to UTF-8 from windows-1251
+		out = charmap.Windows1251.NewDecoder().Reader(stdout)
+	}
+
+	var archive *EventArchiveWriter
+	if e.archiveDir != "" {
+		archive, err = NewEventArchiveWriter(e.archiveDir, time.Now())
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+		out = io.TeeReader(out, archive)
+	}
+
+	streamErr := StreamCSVInputMapped(out, e.headerMapper(entity.TableEvents), entity.NewEventFromDBRecord, batchSize, func(batch []entity.Event) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch = entity.SelectEventsForNLastMonths(batch, selectFor+1)
+		if len(batch) == 0 {
+			return nil
+		}
+		return sink(batch)
+	})
+	if streamErr != nil {
+		// drain the pipe so the process can exit
+		io.Copy(io.Discard, stdout)
+	}
+
+	waitErr := cmd.Wait()
+	if archive != nil {
+		// incomplete exports are not archived
+		if err := archive.Close(streamErr == nil && waitErr == nil); err != nil && streamErr == nil {
+			streamErr = err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// the killed process leaves a truncated export, its errors are noise
+		return err
+	}
+	if waitErr != nil {
+		return fmt.Errorf("exec: %s %w", stderr.String(), waitErr)
+	}
+	return streamErr
 }
 
 func (e *MdbExporter) ExportUsersFromDB() ([]*entity.User, error) {
-	out, errout, err := e.mdbExport(e.dblocation, "USERINFO")
+	out, errout, err := e.mdbExport(e.dblocation, e.schema.Table(entity.TableUsers))
+	if err != nil {
+		return nil, fmt.Errorf("exec: %s %w", errout, err)
+	}
+
+	users, err := SerializeCSVInputMapped(out, e.headerMapper(entity.TableUsers), entity.UserFromCSV)
+	if err != nil {
+		return nil, fmt.Errorf("reading users: %w", err)
+	}
+
+	departments, err := e.exportDepartments()
+	if err != nil {
+		log.Println("departments are not exported:", err)
+	}
+	for _, user := range users {
+		user.Department = departments[user.DepartmentID]
+	}
 
+	return users, nil
+}
+
+// Department names by id
+func (e *MdbExporter) exportDepartments() (map[string]string, error) {
+	out, errout, err := e.mdbExport(e.dblocation, e.schema.Table(entity.TableDepartments))
+	if err != nil {
+		return nil, fmt.Errorf("exec: %s %w", errout, err)
+	}
+	rows, err := SerializeCSVInputMapped(out, e.headerMapper(entity.TableDepartments), csvRow)
 	if err != nil {
-		log.Fatalln("err: exec: ", errout)
 		return nil, err
 	}
 
-	events, err := SerializeCSVInput(out, entity.UserFromCSV)
+	result := make(map[string]string, len(rows))
+	for _, row := range rows {
+		result[row["DEPTID"]] = row["DEPTNAME"]
+	}
+	return result, nil
+}
 
+// Exports an arbitrary table as column name to value rows
+func (e *MdbExporter) ExportTable(table string) ([]map[string]string, error) {
+	out, errout, err := e.mdbExport(e.dblocation, table)
 	if err != nil {
-		log.Fatalln("err", err)
+		return nil, fmt.Errorf("exec: %s %w", errout, err)
 	}
 
-	return events, nil
+	return SerializeCSVInput(out, csvRow)
+}
+
+func csvRow(record []string, index map[string]int) (map[string]string, error) {
+	row := make(map[string]string, len(index))
+	for column, i := range index {
+		row[column] = record[i]
+	}
+	return row, nil
+}
+
+// Jet engine version of the database file, e.g. JET4
+func (e *MdbExporter) JetVersion() (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(e.mdbVerBin, e.dblocation)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("exec: %s %w", stderr.String(), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
 }
 
 func (e *MdbExporter) mdbExport(command ...string) (string, string, error) {
@@ -0,0 +1,176 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+type MdbColumn struct {
+	Name string
+	Type string
+}
+
+var (
+	mdbCreateTablePattern = regexp.MustCompile(`^CREATE TABLE \[(.+)\]`)
+	mdbColumnPattern      = regexp.MustCompile(`^\s*\[(.+?)\]\s+(.*?)\s*,?\s*$`)
+)
+
+// Columns by table of the Access DDL mdb-schema prints
+func ParseMdbSchema(ddl string) map[string][]MdbColumn {
+	tables := make(map[string][]MdbColumn)
+	table := ""
+	scanner := bufio.NewScanner(strings.NewReader(ddl))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := mdbCreateTablePattern.FindStringSubmatch(line); m != nil {
+			table = m[1]
+			tables[table] = []MdbColumn{}
+			continue
+		}
+		if table == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), ");") {
+			table = ""
+			continue
+		}
+		if m := mdbColumnPattern.FindStringSubmatch(line); m != nil {
+			tables[table] = append(tables[table], MdbColumn{Name: m[1], Type: m[2]})
+		}
+	}
+	return tables
+}
+
+// Tables and columns of the database file
+func (e *MdbExporter) Schema() (map[string][]MdbColumn, error) {
+	bin := "mdb-schema"
+	if runtime.GOOS == "windows" {
+		bin = "./mdbtools-win/mdb-schema"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, e.dblocation)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("exec: %s %w", stderr.String(), err)
+	}
+	ddl := stdout.Bytes()
+	if runtime.GOOS == "windows" {
+		ddl = DecodeWindows1251(ddl)
+	}
+	return ParseMdbSchema(string(ddl)), nil
+}
+
+// Schema profiles of a JSON array, see schema.example.json
+func ReadSchemaProfiles(path string) ([]entity.SchemaProfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema profiles: %w", err)
+	}
+	var profiles []entity.SchemaProfile
+	if err := json.Unmarshal(content, &profiles); err != nil {
+		return nil, fmt.Errorf("decoding schema profiles %s: %w", path, err)
+	}
+	for _, p := range profiles {
+		if p.Name == "" {
+			return nil, fmt.Errorf("schema profiles %s: profile without a name", path)
+		}
+	}
+	return profiles, nil
+}
+
+/*
+ * Selects the schema profile by name, or with "auto" or an empty name the first
+ * one the tables of the database file resolve with. Profiles are tried in
+ * order, the built-in zkaccess profile last.
+ */
+func (e *MdbExporter) SelectSchema(name string, profiles []entity.SchemaProfile) (entity.SchemaProfile, error) {
+	profiles = append(profiles, entity.DefaultSchemaProfile())
+	if name != "" && name != "auto" {
+		for _, p := range profiles {
+			if p.Name == name {
+				e.SetSchema(p)
+				return p, nil
+			}
+		}
+		return entity.SchemaProfile{}, fmt.Errorf("unknown schema profile %s", name)
+	}
+
+	schema, err := e.Schema()
+	if err != nil {
+		return entity.SchemaProfile{}, fmt.Errorf("reading the schema of %s: %w", e.dblocation, err)
+	}
+	tables := make(map[string][]string, len(schema))
+	for table, columns := range schema {
+		for _, c := range columns {
+			tables[table] = append(tables[table], c.Name)
+		}
+	}
+	p, err := entity.DetectSchemaProfile(profiles, tables)
+	if err != nil {
+		return entity.SchemaProfile{}, err
+	}
+	e.SetSchema(p)
+	return p, nil
+}
+
+// Reads the tables with the names of the profile
+func (e *MdbExporter) SetSchema(profile entity.SchemaProfile) {
+	e.schema = profile
+}
+
+// Called with the columns of a table the parsers don't read, once per table
+func (e *MdbExporter) OnUnknownColumns(cb func(table string, columns []string)) {
+	e.onUnknownColumns = cb
+}
+
+// Maps the exported header of a table to the parser column names. Unknown
+// columns stay under their own name for readers of the whole row.
+func (e *MdbExporter) headerMapper(table string) HeaderMapper {
+	return func(header map[string]int) (map[string]int, error) {
+		index, unknown, err := mapSourceColumns(e.schema, table, header)
+		if err != nil {
+			return nil, err
+		}
+		e.reportUnknownColumns(table, unknown)
+		return index, nil
+	}
+}
+
+func mapSourceColumns(profile entity.SchemaProfile, table string, header map[string]int) (map[string]int, []string, error) {
+	index, unknown, err := profile.Index(table, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range unknown {
+		if _, ok := index[name]; !ok {
+			index[name] = header[name]
+		}
+	}
+	return index, unknown, nil
+}
+
+func (e *MdbExporter) reportUnknownColumns(table string, columns []string) {
+	if len(columns) == 0 || e.onUnknownColumns == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.reported == nil {
+		e.reported = make(map[string]bool)
+	}
+	if e.reported[table] {
+		return
+	}
+	e.reported[table] = true
+	e.onUnknownColumns(e.schema.Table(table), columns)
+}
@@ -0,0 +1,142 @@
+package infra
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const AuditMergeEmployees = "merge_employees"
+
+var (
+	ErrEmployeeNotFound = errors.New("employee not found")
+	ErrMergeSameCard    = errors.New("an employee is not merged into itself")
+)
+
+// Outcome of merging a duplicate employee into the canonical one, recorded in
+// the audit log as it is
+type EmployeeMerge struct {
+	Card          string    `json:"card"`
+	DuplicateCard string    `json:"duplicate_card"`
+	MergedBy      string    `json:"merged_by"`
+	MergedAt      time.Time `json:"merged_at"`
+	Events        int64     `json:"events"`
+	Intervals     int64     `json:"intervals"`
+	CardHistory   int64     `json:"card_history"`
+	// the duplicate as it was before the merge
+	DuplicateFirstName string `json:"duplicate_firstname"`
+	DuplicateLastName  string `json:"duplicate_lastname"`
+}
+
+// Moves events, intervals and card history of the duplicate card to the
+// canonical employee and deletes the duplicate record in one transaction. The
+// duplicate card becomes an assignment of the canonical employee, so later
+// runs attach its events to the canonical employee too.
+func (db *Repository) MergeEmployees(card, duplicateCard, by string) (EmployeeMerge, error) {
+	merge := EmployeeMerge{Card: card, DuplicateCard: duplicateCard, MergedBy: by, MergedAt: time.Now()}
+	if card == duplicateCard {
+		return merge, ErrMergeSameCard
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return merge, fmt.Errorf("merging employees: %w", err)
+	}
+	defer tx.Rollback()
+
+	var canonical, duplicate Employee
+	for _, e := range []struct {
+		card string
+		into *Employee
+	}{{card, &canonical}, {duplicateCard, &duplicate}} {
+		err := tx.Get(e.into, `SELECT id, firstname, lastname, card FROM attendance.employees WHERE card = $1 FOR UPDATE`, e.card)
+		if errors.Is(err, sql.ErrNoRows) {
+			return merge, fmt.Errorf("%w: %s", ErrEmployeeNotFound, e.card)
+		}
+		if err != nil {
+			return merge, fmt.Errorf("merging employees: %w", err)
+		}
+	}
+	merge.DuplicateFirstName, merge.DuplicateLastName = duplicate.FirstName, duplicate.LastName
+
+	var firstEvent time.Time
+	err = tx.Get(&firstEvent, `SELECT coalesce(min(timestamp), $2) FROM attendance.events WHERE card = $1`,
+		duplicateCard, merge.MergedAt)
+	if err != nil {
+		return merge, fmt.Errorf("merging employees: %w", err)
+	}
+
+	// interval keys embed the card, corrections follow their intervals to the new key
+	steps := []struct {
+		query    string
+		affected *int64
+	}{
+		{`UPDATE attendance.interval_corrections c SET interval_key = $1 || ':' || i.ent_event_id
+		FROM attendance.intervals i
+		WHERE i.database = c.database AND i.interval_key = c.interval_key AND i.card = $2`, nil},
+		{`UPDATE attendance.intervals SET card = $1, interval_key = $1 || ':' || ent_event_id WHERE card = $2`, &merge.Intervals},
+		{`UPDATE attendance.events SET card = $1 WHERE card = $2`, &merge.Events},
+		{`UPDATE attendance.card_history SET card = $1 WHERE card = $2`, &merge.CardHistory},
+	}
+	for _, step := range steps {
+		res, err := tx.Exec(step.query, card, duplicateCard)
+		if err != nil {
+			return merge, fmt.Errorf("merging employees: %w", err)
+		}
+		if step.affected != nil {
+			*step.affected, _ = res.RowsAffected()
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM attendance.duplicate_employees WHERE card = $1 OR duplicate_card = $1`, duplicateCard)
+	if err != nil {
+		return merge, fmt.Errorf("merging employees: %w", err)
+	}
+
+	if err := mergeCardAssignments(tx, canonical.ID, duplicate.ID, duplicateCard, firstEvent); err != nil {
+		return merge, err
+	}
+	if _, err := tx.Exec(`DELETE FROM attendance.employees WHERE id = $1`, duplicate.ID); err != nil {
+		return merge, fmt.Errorf("deleting merged employee: %w", err)
+	}
+	if err := writeAudit(tx, by, AuditMergeEmployees, merge); err != nil {
+		return merge, err
+	}
+	return merge, tx.Commit()
+}
+
+// The duplicate's assignments move to the canonical employee and the duplicate
+// card itself is assigned from its first event on
+func mergeCardAssignments(tx *sqlx.Tx, canonicalID, duplicateID int, duplicateCard string, validFrom time.Time) error {
+	_, err := tx.Exec(`INSERT INTO attendance.employee_cards (employee_id, card, valid_from, valid_to)
+	SELECT $1, card, valid_from, valid_to FROM attendance.employee_cards WHERE employee_id = $2
+	ON CONFLICT DO NOTHING`, canonicalID, duplicateID)
+	if err != nil {
+		return fmt.Errorf("moving card assignments: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM attendance.employee_cards WHERE employee_id = $1`, duplicateID); err != nil {
+		return fmt.Errorf("moving card assignments: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO attendance.employee_cards (employee_id, card, valid_from)
+	SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM attendance.employee_cards WHERE employee_id = $1 AND card = $2)`,
+		canonicalID, duplicateCard, validFrom)
+	if err != nil {
+		return fmt.Errorf("assigning merged card: %w", err)
+	}
+	return nil
+}
+
+func writeAudit(tx *sqlx.Tx, actor, action string, details any) error {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO attendance.audit_log (actor, action, details) VALUES ($1, $2, $3)`, actor, action, data)
+	if err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package infra
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+type ControllerMetadata struct {
+	CapturedAt  time.Time `db:"captured_at"`
+	Database    string    `db:"database"`
+	JetVersion  string    `db:"jet_version"`
+	SourceTable string    `db:"source_table"`
+	Fingerprint string    `db:"fingerprint"`
+	Metadata    string    `db:"metadata"`
+}
+
+func NewControllerMetadata(database, jetVersion, table string, rows []map[string]string) (ControllerMetadata, error) {
+	// maps are marshalled with sorted keys, so equal metadata gives equal fingerprints
+	doc, err := json.Marshal(rows)
+	if err != nil {
+		return ControllerMetadata{}, err
+	}
+	sum := sha256.Sum256(append([]byte(jetVersion), doc...))
+
+	return ControllerMetadata{
+		CapturedAt:  time.Now(),
+		Database:    database,
+		JetVersion:  jetVersion,
+		SourceTable: table,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		Metadata:    string(doc),
+	}, nil
+}
+
+// Records the metadata of this run and warns when it differs from the previous one
+func (db *Repository) RecordControllerMetadata(m ControllerMetadata) error {
+	var previous string
+	err := db.Get(&previous, `SELECT fingerprint FROM attendance.controller_metadata
+	WHERE database = $1 ORDER BY captured_at DESC LIMIT 1`, m.Database)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("loading controller metadata: %w", err)
+	}
+	if previous != "" && previous != m.Fingerprint {
+		log.Printf("warning: controller metadata of %s changed since the previous run, software may have been upgraded", m.Database)
+	}
+
+	_, err = db.NamedExec(`INSERT INTO attendance.controller_metadata
+	(captured_at, database, jet_version, source_table, fingerprint, metadata)
+	VALUES (:captured_at, :database, :jet_version, :source_table, :fingerprint, :metadata)`, m)
+	if err != nil {
+		return fmt.Errorf("inserting controller metadata: %w", err)
+	}
+	return nil
+}
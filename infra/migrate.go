@@ -0,0 +1,168 @@
+package infra
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Key of the advisory lock held while migrating, so concurrent runs against a
+// fresh database don't apply the same migration twice
+const migrationLockKey = 0x6d696772
+
+type Migration struct {
+	Version string
+	SQL     string
+}
+
+type MigrationStatus struct {
+	Version   string
+	AppliedAt *time.Time
+}
+
+// Migrations in version order, the version is the file name without extension
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Migration{
+			Version: strings.TrimSuffix(entry.Name(), ".sql"),
+			SQL:     string(content),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// The repository, or the connection of Migrate holding the lock
+type migrationsConn interface {
+	sqlx.QueryerContext
+	sqlx.ExecerContext
+}
+
+func ensureMigrationsTable(ctx context.Context, db migrationsConn) error {
+	_, err := db.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS attendance;
+	CREATE TABLE IF NOT EXISTS attendance.schema_migrations (
+		version text PRIMARY KEY,
+		applied_at timestamp NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, db migrationsConn) (map[string]time.Time, error) {
+	var rows []struct {
+		Version   string    `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	err := sqlx.SelectContext(ctx, db, &rows, "SELECT version, applied_at FROM attendance.schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("loading applied migrations: %w", err)
+	}
+
+	applied := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+/*
+ * Applies pending migrations, each one in its own transaction. Runs of other
+ * hosts wait for the advisory lock and find the migrations applied. The lock
+ * belongs to the session, so everything runs on the connection holding it,
+ * which also keeps single connection pools from waiting on themselves.
+ */
+func (db *Repository) Migrate() error {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("locking migrations: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		tx, err := conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %s: %w", m.Version, err)
+		}
+		_, err = tx.Exec("INSERT INTO attendance.schema_migrations (version, applied_at) VALUES ($1, $2)", m.Version, time.Now())
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %s: %w", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("applying migration %s: %w", m.Version, err)
+		}
+		log.Printf("applied migration %s", m.Version)
+	}
+	return nil
+}
+
+func (db *Repository) MigrationsStatus() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	if err := ensureMigrationsTable(ctx, db); err != nil {
+		return nil, err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		result[i] = MigrationStatus{Version: m.Version}
+		if at, ok := applied[m.Version]; ok {
+			result[i].AppliedAt = &at
+		}
+	}
+	return result, nil
+}
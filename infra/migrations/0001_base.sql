@@ -0,0 +1,26 @@
+CREATE SCHEMA IF NOT EXISTS attendance;
+
+CREATE TABLE IF NOT EXISTS attendance.employees (
+	id serial PRIMARY KEY,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	card text NOT NULL UNIQUE,
+	created_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS attendance.events (
+	id integer PRIMARY KEY,
+	card text NOT NULL,
+	timestamp timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS attendance.intervals (
+	id serial PRIMARY KEY,
+	ent timestamp NOT NULL,
+	ext timestamp,
+	card text NOT NULL,
+	database text NOT NULL,
+	ent_event_id integer NOT NULL,
+	ext_event_id integer,
+	UNIQUE (database, ent_event_id)
+);
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS attendance.division_config (
+	division text NOT NULL,
+	key text NOT NULL,
+	value text NOT NULL,
+	PRIMARY KEY (division, key)
+);
+CREATE TABLE IF NOT EXISTS attendance.readers (
+	division text NOT NULL,
+	name text NOT NULL,
+	PRIMARY KEY (division, name)
+);
+CREATE TABLE IF NOT EXISTS attendance.shifts (
+	division text NOT NULL,
+	name text NOT NULL,
+	start_time time NOT NULL,
+	end_time time NOT NULL,
+	PRIMARY KEY (division, name)
+);
+CREATE TABLE IF NOT EXISTS attendance.presence (
+	card text NOT NULL,
+	database text NOT NULL,
+	name text NOT NULL,
+	since timestamp NOT NULL,
+	PRIMARY KEY (database, card)
+);
+CREATE TABLE IF NOT EXISTS attendance.anomalies (
+	event_id integer NOT NULL,
+	card text NOT NULL,
+	database text NOT NULL,
+	timestamp timestamp NOT NULL,
+	reason text NOT NULL,
+	PRIMARY KEY (database, event_id, reason)
+);
+CREATE TABLE IF NOT EXISTS attendance.controller_metadata (
+	id serial PRIMARY KEY,
+	captured_at timestamp NOT NULL,
+	database text NOT NULL,
+	jet_version text NOT NULL,
+	source_table text NOT NULL,
+	fingerprint text NOT NULL,
+	metadata jsonb NOT NULL
+);
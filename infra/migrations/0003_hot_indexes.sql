@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS events_card_timestamp_idx ON attendance.events (card, "timestamp");
+CREATE INDEX IF NOT EXISTS intervals_card_ent_idx ON attendance.intervals (card, ent);
+CREATE INDEX IF NOT EXISTS employees_card_idx ON attendance.employees (card);
@@ -0,0 +1 @@
+ALTER TABLE attendance.intervals ADD COLUMN IF NOT EXISTS confidence real;
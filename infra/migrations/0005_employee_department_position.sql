@@ -0,0 +1,2 @@
+ALTER TABLE attendance.employees ADD COLUMN IF NOT EXISTS department text NOT NULL DEFAULT '';
+ALTER TABLE attendance.employees ADD COLUMN IF NOT EXISTS position text NOT NULL DEFAULT '';
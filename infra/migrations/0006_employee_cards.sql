@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS attendance.employee_cards (
+	employee_id integer NOT NULL REFERENCES attendance.employees (id),
+	card text NOT NULL,
+	valid_from timestamp NOT NULL,
+	valid_to timestamp,
+	PRIMARY KEY (employee_id, card, valid_from)
+);
@@ -0,0 +1,2 @@
+-- ON CONFLICT (card) needs a unique index, older hand made schemas lack it
+CREATE UNIQUE INDEX IF NOT EXISTS employees_card_key ON attendance.employees (card);
@@ -0,0 +1 @@
+ALTER TABLE attendance.events ADD COLUMN IF NOT EXISTS direction text;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS attendance.annotations (
+	id serial PRIMARY KEY,
+	idempotency_key text NOT NULL UNIQUE,
+	database text NOT NULL,
+	card text NOT NULL,
+	day date NOT NULL,
+	-- set when the annotation is about an interval rather than the whole day
+	ent_event_id integer,
+	author text NOT NULL DEFAULT '',
+	note text NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now(),
+	updated_at timestamp NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS annotations_card_day_idx ON attendance.annotations (card, day);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS attendance.employee_conflicts (
+	database text NOT NULL,
+	card text NOT NULL,
+	field text NOT NULL,
+	controller_value text NOT NULL,
+	hr_value text NOT NULL,
+	detected_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS employee_conflicts_database_card_idx ON attendance.employee_conflicts (database, card);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS attendance.overtime (
+	database text NOT NULL,
+	card text NOT NULL,
+	day date NOT NULL,
+	reason text NOT NULL,
+	hours real NOT NULL,
+	rate real NOT NULL,
+	PRIMARY KEY (database, card, day, reason)
+);
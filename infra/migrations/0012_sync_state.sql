@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS attendance.sync_state (
+	division text NOT NULL,
+	source text NOT NULL,
+	destination_fingerprint text NOT NULL,
+	destination_db text NOT NULL,
+	destination_host text NOT NULL,
+	last_sync timestamp NOT NULL,
+	PRIMARY KEY (division, source)
+);
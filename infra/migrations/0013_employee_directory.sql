@@ -0,0 +1,16 @@
+-- explicit card to directory account links for names that do not match
+CREATE TABLE IF NOT EXISTS attendance.directory_mapping (
+	card text PRIMARY KEY,
+	account text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS attendance.employee_directory (
+	card text PRIMARY KEY,
+	account text NOT NULL,
+	email text NOT NULL,
+	employee_id text NOT NULL,
+	manager_account text NOT NULL,
+	manager_email text NOT NULL,
+	matched_by text NOT NULL,
+	updated_at timestamp NOT NULL
+);
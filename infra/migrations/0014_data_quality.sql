@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS attendance.data_quality (
+	database text NOT NULL,
+	day date NOT NULL,
+	events integer NOT NULL,
+	duplicates integer NOT NULL,
+	anomalies integer NOT NULL,
+	intervals integer NOT NULL,
+	closed_intervals integer NOT NULL,
+	score real NOT NULL,
+	PRIMARY KEY (database, day)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS attendance.etl_runs (
+	id serial PRIMARY KEY,
+	division text NOT NULL,
+	source text NOT NULL,
+	started_at timestamp NOT NULL,
+	finished_at timestamp NOT NULL,
+	window_from timestamp NOT NULL,
+	window_to timestamp NOT NULL,
+	users_synced integer NOT NULL,
+	events_exported integer NOT NULL,
+	events_inserted integer NOT NULL,
+	intervals_formed integer NOT NULL,
+	intervals_inserted integer NOT NULL,
+	anomalies integer NOT NULL,
+	error text,
+	version text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS etl_runs_division_started_idx ON attendance.etl_runs (division, started_at);
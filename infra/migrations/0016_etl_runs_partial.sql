@@ -0,0 +1 @@
+ALTER TABLE attendance.etl_runs ADD COLUMN IF NOT EXISTS partial boolean NOT NULL DEFAULT false;
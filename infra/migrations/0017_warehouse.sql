@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS attendance.site_employees (
+	site text NOT NULL,
+	card text NOT NULL,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	department text NOT NULL DEFAULT '',
+	position text NOT NULL DEFAULT '',
+	updated_at timestamp NOT NULL,
+	PRIMARY KEY (site, card)
+);
+
+CREATE TABLE IF NOT EXISTS attendance.site_events (
+	site text NOT NULL,
+	id integer NOT NULL,
+	card text NOT NULL,
+	timestamp timestamp NOT NULL,
+	direction text,
+	PRIMARY KEY (site, id)
+);
+
+CREATE TABLE IF NOT EXISTS attendance.site_intervals (
+	site text NOT NULL,
+	ent timestamp NOT NULL,
+	ext timestamp,
+	card text NOT NULL,
+	ent_event_id integer NOT NULL,
+	ext_event_id integer,
+	confidence real,
+	PRIMARY KEY (site, ent_event_id)
+);
+
+CREATE INDEX IF NOT EXISTS site_events_card_timestamp_idx ON attendance.site_events (card, timestamp);
+CREATE INDEX IF NOT EXISTS site_intervals_card_ent_idx ON attendance.site_intervals (card, ent);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS attendance.etl_state (
+	division text NOT NULL,
+	source text NOT NULL,
+	source_size bigint NOT NULL,
+	source_mtime timestamp NOT NULL,
+	source_sha256 text NOT NULL,
+	recorded_at timestamp NOT NULL,
+	PRIMARY KEY (division, source)
+);
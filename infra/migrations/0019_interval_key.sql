@@ -0,0 +1,22 @@
+-- deterministic key of an interval, recomputed intervals overwrite the stored one
+ALTER TABLE attendance.intervals ADD COLUMN IF NOT EXISTS interval_key text;
+UPDATE attendance.intervals SET interval_key = card || ':' || ent_event_id WHERE interval_key IS NULL;
+ALTER TABLE attendance.intervals ALTER COLUMN interval_key SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS intervals_database_key_idx ON attendance.intervals (database, interval_key);
+
+-- the old unique key is named after the table, which may have been created under another name
+DO $$
+DECLARE
+	old_key text;
+BEGIN
+	SELECT conname INTO old_key FROM pg_constraint
+	WHERE conrelid = 'attendance.intervals'::regclass AND contype = 'u'
+		AND conkey = ARRAY[
+			(SELECT attnum FROM pg_attribute WHERE attrelid = 'attendance.intervals'::regclass AND attname = 'database'),
+			(SELECT attnum FROM pg_attribute WHERE attrelid = 'attendance.intervals'::regclass AND attname = 'ent_event_id')
+		]::smallint[];
+	IF old_key IS NOT NULL THEN
+		EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', 'attendance.intervals'::regclass, old_key);
+	END IF;
+END $$;
@@ -0,0 +1,21 @@
+-- holders of reissued cards over time, the row without valid_to is the current holder
+CREATE TABLE IF NOT EXISTS attendance.card_history (
+	id serial PRIMARY KEY,
+	card text NOT NULL,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	valid_from timestamp NOT NULL,
+	valid_to timestamp
+);
+
+CREATE INDEX IF NOT EXISTS card_history_card_idx ON attendance.card_history (card, valid_from);
+
+-- intervals with the name of whoever held the card at the entry
+CREATE OR REPLACE VIEW attendance.interval_holders AS
+SELECT i.id, i.database, i.card, i.ent, i.ext,
+	coalesce(h.firstname, e.firstname) AS firstname,
+	coalesce(h.lastname, e.lastname) AS lastname
+FROM attendance.intervals i
+LEFT JOIN attendance.card_history h
+	ON h.card = i.card AND i.ent >= h.valid_from AND (h.valid_to IS NULL OR i.ent < h.valid_to)
+LEFT JOIN attendance.employees e ON e.card = i.card;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS attendance.absences (
+	database text NOT NULL,
+	day date NOT NULL,
+	card text NOT NULL,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	department text NOT NULL DEFAULT '',
+	PRIMARY KEY (database, day, card)
+);
@@ -0,0 +1,25 @@
+-- rollups for dashboards, refreshed by every run for the days it loaded
+CREATE TABLE IF NOT EXISTS attendance.rollup_daily_hours (
+	database text NOT NULL,
+	card text NOT NULL,
+	day date NOT NULL,
+	hours real NOT NULL,
+	intervals integer NOT NULL,
+	PRIMARY KEY (database, card, day)
+);
+
+CREATE TABLE IF NOT EXISTS attendance.rollup_monthly_hours (
+	database text NOT NULL,
+	card text NOT NULL,
+	month date NOT NULL,
+	hours real NOT NULL,
+	days integer NOT NULL,
+	PRIMARY KEY (database, card, month)
+);
+
+CREATE TABLE IF NOT EXISTS attendance.rollup_hourly_headcount (
+	database text NOT NULL,
+	hour timestamp NOT NULL,
+	headcount integer NOT NULL,
+	PRIMARY KEY (database, hour)
+);
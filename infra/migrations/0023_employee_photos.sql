@@ -0,0 +1,8 @@
+-- badge photos extracted from the controller USERINFO table
+CREATE TABLE IF NOT EXISTS attendance.employee_photos (
+	card text PRIMARY KEY,
+	content_type text NOT NULL,
+	sha256 text NOT NULL,
+	photo bytea NOT NULL,
+	updated_at timestamp NOT NULL
+);
@@ -0,0 +1,37 @@
+-- HR corrections laid over the ETL intervals, which are never modified. Intervals
+-- are referenced by their key so corrections survive recomputation.
+CREATE TABLE IF NOT EXISTS attendance.interval_corrections (
+	id serial PRIMARY KEY,
+	database text NOT NULL,
+	interval_key text NOT NULL,
+	-- close sets the exit, split ends the interval at split_at and starts another
+	-- at resume_at, annotate only records the reason
+	kind text NOT NULL CHECK (kind IN ('close', 'split', 'annotate')),
+	ext timestamp,
+	split_at timestamp,
+	resume_at timestamp,
+	reason text NOT NULL,
+	author text NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS interval_corrections_key_idx ON attendance.interval_corrections (database, interval_key);
+
+-- intervals with the latest close or split applied, a split interval yields two rows
+CREATE OR REPLACE VIEW attendance.corrected_intervals AS
+WITH latest AS (
+	SELECT DISTINCT ON (database, interval_key) *
+	FROM attendance.interval_corrections
+	WHERE kind IN ('close', 'split')
+	ORDER BY database, interval_key, created_at DESC, id DESC
+)
+SELECT i.id, i.database, i.interval_key, i.card, i.ent,
+	CASE c.kind WHEN 'close' THEN c.ext WHEN 'split' THEN c.split_at ELSE i.ext END AS ext,
+	c.id AS correction_id
+FROM attendance.intervals i
+LEFT JOIN latest c ON c.database = i.database AND c.interval_key = i.interval_key
+UNION ALL
+SELECT i.id, i.database, i.interval_key, i.card, c.resume_at AS ent, i.ext, c.id AS correction_id
+FROM attendance.intervals i
+JOIN latest c ON c.database = i.database AND c.interval_key = i.interval_key
+WHERE c.kind = 'split';
@@ -0,0 +1,30 @@
+-- corrections recorded before the approval workflow stay in effect
+ALTER TABLE attendance.interval_corrections RENAME COLUMN author TO requested_by;
+ALTER TABLE attendance.interval_corrections
+	ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved'
+		CHECK (status IN ('pending', 'approved', 'rejected')),
+	ADD COLUMN IF NOT EXISTS approved_by text,
+	ADD COLUMN IF NOT EXISTS decided_at timestamp,
+	ADD COLUMN IF NOT EXISTS decision_note text NOT NULL DEFAULT '';
+UPDATE attendance.interval_corrections SET approved_by = requested_by, decided_at = created_at
+WHERE approved_by IS NULL;
+ALTER TABLE attendance.interval_corrections ALTER COLUMN status SET DEFAULT 'pending';
+
+-- only approved corrections change the intervals, the last approval wins
+CREATE OR REPLACE VIEW attendance.corrected_intervals AS
+WITH latest AS (
+	SELECT DISTINCT ON (database, interval_key) *
+	FROM attendance.interval_corrections
+	WHERE kind IN ('close', 'split') AND status = 'approved'
+	ORDER BY database, interval_key, decided_at DESC, id DESC
+)
+SELECT i.id, i.database, i.interval_key, i.card, i.ent,
+	CASE c.kind WHEN 'close' THEN c.ext WHEN 'split' THEN c.split_at ELSE i.ext END AS ext,
+	c.id AS correction_id
+FROM attendance.intervals i
+LEFT JOIN latest c ON c.database = i.database AND c.interval_key = i.interval_key
+UNION ALL
+SELECT i.id, i.database, i.interval_key, i.card, c.resume_at AS ent, i.ext, c.id AS correction_id
+FROM attendance.intervals i
+JOIN latest c ON c.database = i.database AND c.interval_key = i.interval_key
+WHERE c.kind = 'split';
@@ -0,0 +1,8 @@
+-- canonical employee names per card imported from HR spreadsheets, preferred over controller names
+CREATE TABLE IF NOT EXISTS attendance.card_mappings (
+	card text PRIMARY KEY,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	employee_number text NOT NULL DEFAULT '',
+	imported_at timestamp NOT NULL
+);
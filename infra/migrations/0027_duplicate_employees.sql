@@ -0,0 +1,8 @@
+-- likely duplicate employees found by the last sync, candidates for a merge
+CREATE TABLE IF NOT EXISTS attendance.duplicate_employees (
+	card text NOT NULL,
+	duplicate_card text NOT NULL,
+	reason text NOT NULL,
+	detected_at timestamp NOT NULL,
+	PRIMARY KEY (card, duplicate_card)
+);
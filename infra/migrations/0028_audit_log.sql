@@ -0,0 +1,10 @@
+-- operations changing attendance history by hand, e.g. employee merges
+CREATE TABLE IF NOT EXISTS attendance.audit_log (
+	id serial PRIMARY KEY,
+	at timestamp NOT NULL DEFAULT now(),
+	actor text NOT NULL,
+	action text NOT NULL,
+	details jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_at_idx ON attendance.audit_log (at);
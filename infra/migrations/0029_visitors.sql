@@ -0,0 +1,22 @@
+-- visitor and contractor cards, kept apart from employees and their timesheets
+CREATE TABLE IF NOT EXISTS attendance.visitors (
+	card text PRIMARY KEY,
+	kind text NOT NULL,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	updated_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS attendance.visits (
+	id serial PRIMARY KEY,
+	interval_key text NOT NULL,
+	database text NOT NULL,
+	card text NOT NULL,
+	ent timestamp NOT NULL,
+	ext timestamp,
+	ent_event_id integer NOT NULL,
+	ext_event_id integer,
+	UNIQUE (database, interval_key)
+);
+
+CREATE INDEX IF NOT EXISTS visits_card_ent_idx ON attendance.visits (card, ent);
@@ -0,0 +1,8 @@
+-- last completed stage of a run in progress, a resumed run continues after it
+CREATE TABLE IF NOT EXISTS attendance.etl_checkpoints (
+	division text PRIMARY KEY,
+	run_started timestamp NOT NULL,
+	stage text NOT NULL,
+	stage_dir text NOT NULL,
+	completed_at timestamp NOT NULL
+);
@@ -0,0 +1,11 @@
+-- doors of the controllers exported from acc_door into the readers seeded at
+-- onboarding, and the door of every event. perimeter is set by the operators.
+ALTER TABLE attendance.readers ADD COLUMN IF NOT EXISTS reader_id integer;
+ALTER TABLE attendance.readers ADD COLUMN IF NOT EXISTS device_id integer NOT NULL DEFAULT 0;
+ALTER TABLE attendance.readers ADD COLUMN IF NOT EXISTS door_no integer NOT NULL DEFAULT 0;
+ALTER TABLE attendance.readers ADD COLUMN IF NOT EXISTS perimeter boolean NOT NULL DEFAULT false;
+ALTER TABLE attendance.readers ADD COLUMN IF NOT EXISTS updated_at timestamp NOT NULL DEFAULT now();
+CREATE UNIQUE INDEX IF NOT EXISTS readers_reader_id_idx ON attendance.readers (division, reader_id);
+
+ALTER TABLE attendance.events ADD COLUMN IF NOT EXISTS reader_id integer;
+CREATE INDEX IF NOT EXISTS events_reader_idx ON attendance.events (reader_id, timestamp);
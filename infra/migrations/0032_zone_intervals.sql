@@ -0,0 +1,13 @@
+-- stays of employees in the zones of the flow policy, open while ext is null
+CREATE TABLE IF NOT EXISTS attendance.zone_intervals (
+	database text NOT NULL,
+	card text NOT NULL,
+	zone text NOT NULL,
+	ent timestamp NOT NULL,
+	ext timestamp,
+	ent_event_id integer NOT NULL,
+	ext_event_id integer,
+	inferred boolean NOT NULL DEFAULT false,
+	PRIMARY KEY (database, card, ent_event_id)
+);
+CREATE INDEX IF NOT EXISTS zone_intervals_ent_idx ON attendance.zone_intervals (database, ent);
@@ -0,0 +1,11 @@
+-- planned shifts per employee and day, imported from the shift roster
+CREATE TABLE IF NOT EXISTS attendance.roster (
+	card text NOT NULL,
+	day date NOT NULL,
+	shift text NOT NULL DEFAULT '',
+	start_time text NOT NULL DEFAULT '',
+	end_time text NOT NULL DEFAULT '',
+	hours numeric NOT NULL,
+	imported_at timestamp NOT NULL,
+	PRIMARY KEY (card, day)
+);
@@ -0,0 +1,11 @@
+-- vacations, sick leaves and other explained absences imported from HR or 1C
+CREATE TABLE IF NOT EXISTS attendance.leaves (
+	card text NOT NULL,
+	kind text NOT NULL,
+	date_from date NOT NULL,
+	date_to date NOT NULL,
+	source text NOT NULL DEFAULT '',
+	imported_at timestamp NOT NULL,
+	PRIMARY KEY (card, date_from, kind)
+);
+CREATE INDEX IF NOT EXISTS leaves_days_idx ON attendance.leaves (date_from, date_to);
@@ -0,0 +1,7 @@
+-- monthly reports mailed to department managers, so a rerun doesn't mail them twice
+CREATE TABLE IF NOT EXISTS attendance.report_mailings (
+	month date NOT NULL,
+	recipient text NOT NULL,
+	sent_at timestamp NOT NULL,
+	PRIMARY KEY (month, recipient)
+);
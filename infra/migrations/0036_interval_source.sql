@@ -0,0 +1,2 @@
+-- where an interval comes from: paired by the ETL or imported from the old attendance system
+ALTER TABLE attendance.intervals ADD COLUMN IF NOT EXISTS source text NOT NULL DEFAULT 'etl';
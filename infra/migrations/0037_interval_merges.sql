@@ -0,0 +1,13 @@
+-- short exits the grace period of the flow policy merged into continuous intervals, kept for the audit
+CREATE TABLE IF NOT EXISTS attendance.interval_merges (
+	database text NOT NULL,
+	card text NOT NULL,
+	interval_key text NOT NULL,
+	exit_at timestamp NOT NULL,
+	reentry_at timestamp NOT NULL,
+	exit_event_id integer NOT NULL,
+	reentry_event_id integer NOT NULL,
+	gap_sec integer NOT NULL,
+	PRIMARY KEY (database, card, exit_event_id)
+);
+CREATE INDEX IF NOT EXISTS interval_merges_interval_idx ON attendance.interval_merges (database, interval_key);
@@ -0,0 +1,11 @@
+-- first entry and last exit per card and day, written when the flow policy enables the daily summary
+CREATE TABLE IF NOT EXISTS attendance.daily_attendance (
+	database text NOT NULL,
+	card text NOT NULL,
+	day date NOT NULL,
+	first_in timestamp NOT NULL,
+	last_out timestamp,
+	gross_hours double precision NOT NULL,
+	PRIMARY KEY (database, card, day)
+);
+CREATE INDEX IF NOT EXISTS daily_attendance_day_idx ON attendance.daily_attendance (database, day);
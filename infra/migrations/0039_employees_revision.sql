@@ -0,0 +1,20 @@
+-- revision of the employees table, bumped by every statement writing it, so a long-lived
+-- process can tell whether the employees it loaded before are still current
+CREATE TABLE IF NOT EXISTS attendance.employees_revision (
+	singleton boolean PRIMARY KEY DEFAULT true CHECK (singleton),
+	-- tells apart destinations whose revisions could coincide
+	instance text NOT NULL DEFAULT md5(random()::text || clock_timestamp()::text),
+	revision bigint NOT NULL DEFAULT 0
+);
+INSERT INTO attendance.employees_revision DEFAULT VALUES ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION attendance.bump_employees_revision() RETURNS trigger AS $$
+BEGIN
+	UPDATE attendance.employees_revision SET revision = revision + 1;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS employees_revision ON attendance.employees;
+CREATE TRIGGER employees_revision AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON attendance.employees
+	FOR EACH STATEMENT EXECUTE PROCEDURE attendance.bump_employees_revision();
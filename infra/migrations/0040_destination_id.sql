@@ -0,0 +1,7 @@
+-- id written into the destination once at onboarding, runs refuse destinations
+-- whose id is not the configured DESTINATION_ID
+CREATE TABLE IF NOT EXISTS attendance.destination (
+	singleton boolean PRIMARY KEY DEFAULT true CHECK (singleton),
+	id text NOT NULL,
+	created_at timestamp NOT NULL
+);
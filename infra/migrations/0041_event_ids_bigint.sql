@@ -0,0 +1,11 @@
+-- ids derived for sources without numeric ids of their own are 64 bit, kept
+-- apart from the 32 bit ids of MDB and the other sources. Rewrites the tables once.
+ALTER TABLE attendance.events ALTER COLUMN id TYPE bigint;
+ALTER TABLE attendance.intervals ALTER COLUMN ent_event_id TYPE bigint, ALTER COLUMN ext_event_id TYPE bigint;
+ALTER TABLE attendance.anomalies ALTER COLUMN event_id TYPE bigint;
+ALTER TABLE attendance.annotations ALTER COLUMN ent_event_id TYPE bigint;
+ALTER TABLE attendance.visits ALTER COLUMN ent_event_id TYPE bigint, ALTER COLUMN ext_event_id TYPE bigint;
+ALTER TABLE attendance.zone_intervals ALTER COLUMN ent_event_id TYPE bigint, ALTER COLUMN ext_event_id TYPE bigint;
+ALTER TABLE attendance.interval_merges ALTER COLUMN exit_event_id TYPE bigint, ALTER COLUMN reentry_event_id TYPE bigint;
+ALTER TABLE attendance.site_events ALTER COLUMN id TYPE bigint;
+ALTER TABLE attendance.site_intervals ALTER COLUMN ent_event_id TYPE bigint, ALTER COLUMN ext_event_id TYPE bigint;
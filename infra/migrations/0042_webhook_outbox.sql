@@ -0,0 +1,10 @@
+-- webhook payloads of runs not delivered yet, every run retries them oldest first
+CREATE TABLE IF NOT EXISTS attendance.webhook_outbox (
+	id serial PRIMARY KEY,
+	url text NOT NULL,
+	body bytea NOT NULL,
+	created_at timestamp NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	last_attempt timestamp
+);
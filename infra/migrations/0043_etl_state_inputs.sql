@@ -0,0 +1,3 @@
+-- a run is only skipped when the policy, calendar, norms, leaves and site day it
+-- derives from are unchanged as well, states recorded before never match
+ALTER TABLE attendance.etl_state ADD COLUMN IF NOT EXISTS inputs_sha256 text NOT NULL DEFAULT '';
@@ -0,0 +1,18 @@
+-- the holder name as the source reports it, card reissues are detected on it
+-- rather than on names HR master data, the directory or card mappings set
+ALTER TABLE attendance.employees ADD COLUMN IF NOT EXISTS source_name text;
+
+-- corrected intervals with whoever held the card at the entry, what reports are
+-- built from. employee_id is null for cards that aren't employees.
+DROP VIEW IF EXISTS attendance.interval_holders;
+CREATE VIEW attendance.interval_holders AS
+SELECT i.id, i.database, i.interval_key, i.card, i.ent, i.ext, i.correction_id,
+	e.id AS employee_id,
+	coalesce(h.firstname, e.firstname, '') AS firstname,
+	coalesce(h.lastname, e.lastname, '') AS lastname,
+	coalesce(e.department, '') AS department,
+	coalesce(e.position, '') AS position
+FROM attendance.corrected_intervals i
+LEFT JOIN attendance.card_history h
+	ON h.card = i.card AND i.ent >= h.valid_from AND (h.valid_to IS NULL OR i.ent < h.valid_to)
+LEFT JOIN attendance.employees e ON e.card = i.card;
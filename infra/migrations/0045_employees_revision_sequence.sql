@@ -0,0 +1,11 @@
+-- revisions come from a sequence, which a rolled back transaction doesn't rewind,
+-- so the revision an atomic run saw before rolling back is never reused by a later write
+CREATE SEQUENCE IF NOT EXISTS attendance.employees_revision_seq;
+SELECT setval('attendance.employees_revision_seq', greatest((SELECT revision FROM attendance.employees_revision), 1));
+
+CREATE OR REPLACE FUNCTION attendance.bump_employees_revision() RETURNS trigger AS $$
+BEGIN
+	UPDATE attendance.employees_revision SET revision = nextval('attendance.employees_revision_seq');
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
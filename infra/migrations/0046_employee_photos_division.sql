@@ -0,0 +1,17 @@
+-- cards are numbered per controller, photos are keyed by the division as well.
+-- Stored photos don't tell their division, the next run of each division extracts them again
+DELETE FROM attendance.employee_photos;
+ALTER TABLE attendance.employee_photos ADD COLUMN IF NOT EXISTS database text NOT NULL;
+
+-- the primary key is named after the table, which may have been created under another name
+DO $$
+DECLARE
+	old_key text;
+BEGIN
+	SELECT conname INTO old_key FROM pg_constraint
+	WHERE conrelid = 'attendance.employee_photos'::regclass AND contype = 'p';
+	IF old_key IS NOT NULL THEN
+		EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', 'attendance.employee_photos'::regclass, old_key);
+	END IF;
+END $$;
+ALTER TABLE attendance.employee_photos ADD PRIMARY KEY (database, card);
@@ -0,0 +1,229 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Payload fields holding the event attributes, nested fields are dot separated
+type PayloadMapping struct {
+	ID        string
+	Card      string
+	Time      string
+	Point     string
+	Direction string
+	EventType string
+}
+
+func DefaultPayloadMapping() PayloadMapping {
+	return PayloadMapping{
+		ID:        "id",
+		Card:      "card",
+		Time:      "time",
+		Point:     "point",
+		Direction: "direction",
+		EventType: "event_type",
+	}
+}
+
+// Overrides defaults with a spec like card=uid,time=data.ts
+func ParsePayloadMapping(spec string) (PayloadMapping, error) {
+	mapping := DefaultPayloadMapping()
+	if spec == "" {
+		return mapping, nil
+	}
+	fields := map[string]*string{
+		"id":         &mapping.ID,
+		"card":       &mapping.Card,
+		"time":       &mapping.Time,
+		"point":      &mapping.Point,
+		"direction":  &mapping.Direction,
+		"event_type": &mapping.EventType,
+	}
+	for _, part := range strings.Split(spec, ",") {
+		name, field, ok := strings.Cut(strings.TrimSpace(part), "=")
+		target, known := fields[name]
+		if !ok || !known || field == "" {
+			return mapping, fmt.Errorf("invalid payload mapping %q", part)
+		}
+		*target = field
+	}
+	return mapping, nil
+}
+
+// Decodes a JSON payload into an event. Times are RFC3339, unix seconds or
+// site wall clock without zone and end up as site wall clock like MDB events.
+// Payloads without an id get one derived from card, time and point, so a
+// redelivered message maps to the same event.
+func (m PayloadMapping) Event(payload []byte, siteTime func(time.Time) time.Time) (entity.Event, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return entity.Event{}, fmt.Errorf("decoding payload: %w", err)
+	}
+
+	event := entity.Event{
+		Card:      payloadString(doc, m.Card),
+		PointName: payloadString(doc, m.Point),
+	}
+	if event.Card == "" {
+		return event, fmt.Errorf("payload without %s", m.Card)
+	}
+
+	raw := payloadString(doc, m.Time)
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		event.Time = siteTime(time.Unix(seconds, 0))
+	} else if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		event.Time = siteTime(t)
+	} else if t, err := time.Parse("2006-01-02 15:04:05", raw); err == nil {
+		event.Time = t
+	} else {
+		return event, fmt.Errorf("payload time %q: unknown format", raw)
+	}
+
+	switch strings.ToLower(payloadString(doc, m.Direction)) {
+	case "in", "ent", "entry", "0":
+		event.ReaderDirection = entity.EventTypeEnt
+	case "out", "ext", "exit", "1":
+		event.ReaderDirection = entity.EventTypeExt
+	}
+	if value := payloadString(doc, m.EventType); value != "" {
+		eventType, err := strconv.Atoi(value)
+		if err != nil {
+			return event, fmt.Errorf("payload event type: %w", err)
+		}
+		event.EventType = eventType
+	}
+
+	if value := payloadString(doc, m.ID); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return event, fmt.Errorf("payload id: %w", err)
+		}
+		event.ID = id
+	} else {
+		event.ID = DerivedEventID(event.Card, event.Time.Format(time.RFC3339), event.PointName)
+	}
+	return event, nil
+}
+
+func payloadString(doc map[string]any, path string) string {
+	var value any = doc
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+type MQTTConfig struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	Topics   []string
+	Mapping  PayloadMapping
+}
+
+// MQTT_PASSWORD is resolved as a secret
+func MQTTConfigFromEnv(secrets SecretProvider) (MQTTConfig, error) {
+	config := MQTTConfig{
+		Broker:   os.Getenv("MQTT_BROKER"),
+		ClientID: os.Getenv("MQTT_CLIENT_ID"),
+		Username: os.Getenv("MQTT_USERNAME"),
+	}
+	if config.Broker == "" {
+		return config, errors.New("MQTT_BROKER is not set")
+	}
+	if config.ClientID == "" {
+		config.ClientID = "attendance-etl"
+	}
+	for _, topic := range strings.Split(os.Getenv("MQTT_TOPICS"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			config.Topics = append(config.Topics, topic)
+		}
+	}
+	if len(config.Topics) == 0 {
+		return config, errors.New("MQTT_TOPICS is not set")
+	}
+
+	var err error
+	if config.Password, err = OptionalSecret(secrets, "MQTT_PASSWORD"); err != nil {
+		return config, err
+	}
+	config.Mapping, err = ParsePayloadMapping(os.Getenv("MQTT_PAYLOAD_MAPPING"))
+	return config, err
+}
+
+// Hands every event received on the configured topics to handle until ctx is
+// cancelled. The session is persistent and subscriptions use QoS 1, so the
+// broker keeps events published while the subscriber is down. A message is
+// acknowledged once handle returns nil, so handle must not return before the
+// event is stored. Messages are handled concurrently, in no particular order.
+func SubscribeMQTT(ctx context.Context, config MQTTConfig, siteTime func(time.Time) time.Time, handle func(entity.Event) error) error {
+	filters := make(map[string]byte, len(config.Topics))
+	for _, topic := range config.Topics {
+		filters[topic] = 1
+	}
+	onMessage := func(_ mqtt.Client, msg mqtt.Message) {
+		event, err := config.Mapping.Event(msg.Payload(), siteTime)
+		if err != nil {
+			// a malformed message must not block the ones after it
+			log.Printf("mqtt: %s: %v", msg.Topic(), err)
+			msg.Ack()
+			return
+		}
+		// an event not stored is delivered again after reconnecting
+		if err := handle(event); err != nil {
+			log.Printf("mqtt: %s: %v", msg.Topic(), err)
+			return
+		}
+		msg.Ack()
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetAutoAckDisabled(true).
+		// handlers wait for the spool, concurrent ones share its writes
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// resubscribe after every reconnect
+			if token := c.SubscribeMultiple(filters, onMessage); token.Wait() && token.Error() != nil {
+				log.Printf("mqtt: subscribing: %v", token.Error())
+			}
+		})
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("connecting to mqtt broker: %w", token.Error())
+	}
+	<-ctx.Done()
+	client.Disconnect(1000)
+	return nil
+}
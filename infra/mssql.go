@@ -0,0 +1,141 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Separates the columns of sqlcmd output, a byte no name or card contains
+const sqlcmdSeparator = "\x1f"
+
+// SQL Server database of the controller software, queried with sqlcmd
+type mssqlClient struct {
+	sqlcmd    string
+	server    string
+	database  string
+	user      string
+	password  string
+	trustCert bool
+}
+
+/*
+ * Reads MSSQL_SERVER like host,1433 or host\instance, MSSQL_DATABASE, MSSQL_USER,
+ * the MSSQL_PASSWORD secret and the MSSQL_USERS_QUERY and MSSQL_EVENTS_QUERY
+ * queries. Without MSSQL_USER sqlcmd signs in with Windows authentication.
+ * MSSQL_TRUST_SERVER_CERTIFICATE=true accepts self-signed server certificates.
+ */
+func NewMSSQLSourceFromEnv(secrets SecretProvider) (*QuerySource, error) {
+	client := &mssqlClient{
+		sqlcmd:    os.Getenv("MSSQL_SQLCMD"),
+		server:    os.Getenv("MSSQL_SERVER"),
+		database:  os.Getenv("MSSQL_DATABASE"),
+		user:      os.Getenv("MSSQL_USER"),
+		trustCert: os.Getenv("MSSQL_TRUST_SERVER_CERTIFICATE") == "true",
+	}
+	if client.server == "" || client.database == "" {
+		return nil, errors.New("MSSQL_SERVER and MSSQL_DATABASE are required")
+	}
+	if client.sqlcmd == "" {
+		client.sqlcmd = "sqlcmd"
+	}
+	if client.user != "" {
+		password, err := secrets.Secret("MSSQL_PASSWORD")
+		if err != nil {
+			return nil, err
+		}
+		client.password = password
+	}
+
+	source := &QuerySource{
+		client:      client,
+		usersQuery:  os.Getenv("MSSQL_USERS_QUERY"),
+		eventsQuery: os.Getenv("MSSQL_EVENTS_QUERY"),
+	}
+	if source.usersQuery == "" || source.eventsQuery == "" {
+		return nil, errors.New("MSSQL_USERS_QUERY and MSSQL_EVENTS_QUERY are required")
+	}
+	return source, nil
+}
+
+// Runs the query with UTF-8 output and trimmed columns, the password goes
+// through SQLCMDPASSWORD so it stays out of the process list
+func (c *mssqlClient) query(ctx context.Context, query string, row func(header, record []string) error) error {
+	args := []string{"-S", c.server, "-d", c.database, "-b", "-W", "-f", "65001", "-s", sqlcmdSeparator,
+		"-Q", "SET NOCOUNT ON; " + query}
+	if c.user != "" {
+		args = append(args, "-U", c.user)
+	} else {
+		args = append(args, "-E")
+	}
+	if c.trustCert {
+		args = append(args, "-C")
+	}
+	cmd := exec.CommandContext(ctx, c.sqlcmd, args...)
+	cmd.Env = append(os.Environ(), "SQLCMDPASSWORD="+c.password)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	readErr := readSqlcmdOutput(stdout, row)
+	if readErr != nil {
+		io.Copy(io.Discard, stdout)
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("exec: %s %w", stderr.String(), err)
+	}
+	return readErr
+}
+
+/*
+ * Reads sqlcmd output: the header, a line of dashes under it, then a line per
+ * row up to the first blank line. NULL reads as empty.
+ */
+func readSqlcmdOutput(r io.Reader, row func(header, record []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var header []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case header == nil:
+			if line == "" {
+				continue
+			}
+			header = strings.Split(line, sqlcmdSeparator)
+			if !scanner.Scan() || strings.Trim(scanner.Text(), "-"+sqlcmdSeparator+" \r") != "" {
+				return errors.New("sqlcmd output has no header underline")
+			}
+		case line == "":
+			return scanner.Err()
+		default:
+			record := strings.Split(line, sqlcmdSeparator)
+			if len(record) != len(header) {
+				return fmt.Errorf("sqlcmd row has %d columns, expected %d", len(record), len(header))
+			}
+			for i, value := range record {
+				if value == "NULL" {
+					record[i] = ""
+				}
+			}
+			if err := row(header, record); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
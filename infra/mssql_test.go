@@ -0,0 +1,92 @@
+package infra
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Rows the reader hands over, header first
+func collectRows(read func(io.Reader, func(header, record []string) error) error, output string) ([][]string, error) {
+	var rows [][]string
+	err := read(strings.NewReader(output), func(header, record []string) error {
+		if len(rows) == 0 {
+			rows = append(rows, header)
+		}
+		rows = append(rows, record)
+		return nil
+	})
+	return rows, err
+}
+
+// Lines as sqlcmd prints them on Windows, | stands for the separator
+func readSqlcmdFixture(lines ...string) ([][]string, error) {
+	return collectRows(readSqlcmdOutput, strings.ReplaceAll(strings.Join(lines, "\r\n"), "|", sqlcmdSeparator))
+}
+
+func TestReadSqlcmdOutput(t *testing.T) {
+	t.Run("rows", func(t *testing.T) {
+		rows, err := readSqlcmdFixture(
+			"card|name|time",
+			"----|----|----",
+			"1001|Petrov Ivan|2024-03-01 08:00:00.000",
+			"1002|Smirnova, Anna; Welding|2024-03-01 08:05:00.000",
+			"",
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, [][]string{
+			{"card", "name", "time"},
+			{"1001", "Petrov Ivan", "2024-03-01 08:00:00.000"},
+			{"1002", "Smirnova, Anna; Welding", "2024-03-01 08:05:00.000"},
+		}, rows)
+	})
+
+	t.Run("null reads as empty", func(t *testing.T) {
+		rows, err := readSqlcmdFixture(
+			"card|name|time",
+			"----|----|----",
+			"1001|NULL|2024-03-01 08:00:00.000",
+			"1002||2024-03-01 08:05:00.000",
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1001", "", "2024-03-01 08:00:00.000"}, rows[1])
+		assert.Equal(t, []string{"1002", "", "2024-03-01 08:05:00.000"}, rows[2])
+	})
+
+	t.Run("empty result", func(t *testing.T) {
+		rows, err := readSqlcmdFixture("card|name|time", "----|----|----", "")
+
+		assert.Nil(t, err)
+		assert.Empty(t, rows)
+	})
+
+	t.Run("no output", func(t *testing.T) {
+		rows, err := readSqlcmdFixture("")
+
+		assert.Nil(t, err)
+		assert.Empty(t, rows)
+	})
+
+	t.Run("output after the result is ignored", func(t *testing.T) {
+		rows, err := readSqlcmdFixture("card", "----", "1001", "", "Warning: Null value is eliminated")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(rows))
+	})
+
+	t.Run("separator in a value", func(t *testing.T) {
+		_, err := readSqlcmdFixture("card|name", "----|----", "1001|Petrov|Ivan")
+
+		assert.EqualError(t, err, "sqlcmd row has 3 columns, expected 2")
+	})
+
+	t.Run("header without underline", func(t *testing.T) {
+		_, err := readSqlcmdFixture("Msg 208, Level 16, State 1", "Invalid object name 'events'.")
+
+		assert.EqualError(t, err, "sqlcmd output has no header underline")
+	})
+}
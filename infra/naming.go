@@ -0,0 +1,289 @@
+package infra
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const defaultSchema = "attendance"
+
+var (
+	// a name followed by a parenthesis is a function call
+	qualifiedNamePattern = regexp.MustCompile(`\battendance\.([A-Za-z_][A-Za-z0-9_]*)(\()?`)
+	schemaPattern        = regexp.MustCompile(`(?i)(SCHEMA IF NOT EXISTS )attendance\b`)
+	// literals naming a relation, e.g. for to_regclass, nextval and ::regclass
+	relationLiteralPattern = regexp.MustCompile(`^attendance\.([A-Za-z_][A-Za-z0-9_]*)$`)
+	dollarTagPattern       = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+)
+
+// Destination schema and table names. Queries and migrations are written against
+// attendance.<table> and rewritten to the configured names on their way to the
+// server, names are quoted so any identifier is safe.
+type Naming struct {
+	Schema string
+	// table name overrides by default name
+	Tables map[string]string
+}
+
+func DefaultNaming() Naming {
+	return Naming{Schema: defaultSchema, Tables: map[string]string{}}
+}
+
+// DESTINATION_SCHEMA replaces the attendance schema, DESTINATION_TABLES renames
+// tables with a spec like employees=staff,events=badge_events
+func NamingFromEnv() (Naming, error) {
+	naming := DefaultNaming()
+	if schema := os.Getenv("DESTINATION_SCHEMA"); schema != "" {
+		naming.Schema = schema
+	}
+	spec := os.Getenv("DESTINATION_TABLES")
+	if spec == "" {
+		return naming, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		table, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || table == "" || name == "" {
+			return naming, fmt.Errorf("invalid DESTINATION_TABLES entry %q, expected table=name", pair)
+		}
+		naming.Tables[table] = name
+	}
+	return naming, nil
+}
+
+func (n Naming) IsDefault() bool {
+	return n.Schema == defaultSchema && len(n.Tables) == 0
+}
+
+// Quoted schema qualified name of a table known by its default name
+func (n Naming) Table(table string) string {
+	name, ok := n.Tables[table]
+	if !ok {
+		name = table
+	}
+	return pq.QuoteIdentifier(n.Schema) + "." + pq.QuoteIdentifier(name)
+}
+
+/*
+ * Rewrites the default names of a statement to the configured ones. Only SQL
+ * is rewritten: string literals, quoted identifiers and comments pass as they
+ * are, except a literal holding nothing but a qualified name, which names a
+ * relation as in to_regclass('attendance.events'). Bodies of dollar quotes,
+ * such as DO blocks, are SQL as well. Functions keep their name and move with
+ * the schema, so a table referenced with a column list needs a space before
+ * the parenthesis.
+ */
+func (n Naming) Rewrite(query string) string {
+	if n.IsDefault() {
+		return query
+	}
+	var b strings.Builder
+	var prev byte
+	for len(query) > 0 {
+		code := strings.IndexAny(query, `'"$-`)
+		if code < 0 {
+			code = len(query)
+		}
+		b.WriteString(n.rewriteCode(query[:code]))
+		if code > 0 {
+			prev = query[code-1]
+		}
+		query = query[code:]
+		if query == "" {
+			break
+		}
+		token := quotedToken(query, prev)
+		switch {
+		case token == "":
+			// a minus or a dollar that quotes nothing
+			token = query[:1]
+			b.WriteString(token)
+		case token[0] == '\'':
+			b.WriteString(n.rewriteLiteral(token))
+		case token[0] == '$':
+			tag := dollarTagPattern.FindString(token)
+			body := token[len(tag):]
+			closed := len(token) >= 2*len(tag) && strings.HasSuffix(body, tag)
+			if closed {
+				body = body[:len(body)-len(tag)]
+			}
+			b.WriteString(tag + n.Rewrite(body))
+			if closed {
+				b.WriteString(tag)
+			}
+		default:
+			b.WriteString(token)
+		}
+		prev = token[len(token)-1]
+		query = query[len(token):]
+	}
+	return b.String()
+}
+
+func (n Naming) rewriteCode(code string) string {
+	code = qualifiedNamePattern.ReplaceAllStringFunc(code, func(match string) string {
+		name := strings.TrimPrefix(match, defaultSchema+".")
+		if function, ok := strings.CutSuffix(name, "("); ok {
+			return pq.QuoteIdentifier(n.Schema) + "." + function + "("
+		}
+		return n.Table(name)
+	})
+	return schemaPattern.ReplaceAllString(code, "${1}"+strings.ReplaceAll(pq.QuoteIdentifier(n.Schema), "$", "$$"))
+}
+
+func (n Naming) rewriteLiteral(literal string) string {
+	m := relationLiteralPattern.FindStringSubmatch(literal[1 : len(literal)-1])
+	if m == nil {
+		return literal
+	}
+	return "'" + strings.ReplaceAll(n.Table(m[1]), "'", "''") + "'"
+}
+
+/*
+ * The quoted token query starts with: a string literal, a quoted identifier,
+ * a line comment or a dollar quote, up to its end or the end of the query.
+ * Empty when query starts with none of these, like $1. prev is the byte
+ * before query, E'' literals escape with backslashes and a dollar within an
+ * identifier quotes nothing.
+ */
+func quotedToken(query string, prev byte) string {
+	switch query[0] {
+	case '\'', '"':
+		quote := query[0]
+		escapes := quote == '\'' && (prev == 'E' || prev == 'e')
+		for i := 1; i < len(query); i++ {
+			if escapes && query[i] == '\\' {
+				i++
+				continue
+			}
+			if query[i] != quote {
+				continue
+			}
+			// a doubled quote stands for itself
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return query[:i+1]
+		}
+		return query
+	case '-':
+		if !strings.HasPrefix(query, "--") {
+			return ""
+		}
+		if end := strings.IndexByte(query, '\n'); end >= 0 {
+			return query[:end]
+		}
+		return query
+	case '$':
+		if prev == '_' || prev >= '0' && prev <= '9' || prev >= 'A' && prev <= 'Z' || prev >= 'a' && prev <= 'z' {
+			return ""
+		}
+		tag := dollarTagPattern.FindString(query)
+		if tag == "" {
+			return ""
+		}
+		if end := strings.Index(query[len(tag):], tag); end >= 0 {
+			return query[:len(tag)+end+len(tag)]
+		}
+		return query
+	}
+	return ""
+}
+
+// Rewrites every statement of the connections it opens
+type namingConnector struct {
+	driver.Connector
+	naming Naming
+}
+
+func (c namingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &namingConn{conn: conn, naming: c.naming}, nil
+}
+
+// The driver connection implements the context interfaces and those of pooling
+// and argument checks, they are forwarded as is
+type namingConn struct {
+	conn   driver.Conn
+	naming Naming
+}
+
+func (c *namingConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(c.naming.Rewrite(query))
+}
+
+func (c *namingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, c.naming.Rewrite(query))
+	}
+	return c.Prepare(query)
+}
+
+func (c *namingConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *namingConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c *namingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.conn.Begin()
+}
+
+func (c *namingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, c.naming.Rewrite(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *namingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, c.naming.Rewrite(query), args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *namingConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// pgx checks its connections before reuse and converts its own argument types,
+// without these the pool would hand out broken connections and pgx types
+// would be refused
+func (c *namingConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *namingConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *namingConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
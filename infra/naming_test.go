@@ -0,0 +1,78 @@
+package infra
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamingRewrite(t *testing.T) {
+	naming := Naming{Schema: "hr", Tables: map[string]string{"events": "badge_events", "intervals": "stays"}}
+
+	t.Run("tables are renamed", func(t *testing.T) {
+		query := naming.Rewrite(`SELECT e.card FROM attendance.events e JOIN attendance.employees AS em USING (card)`)
+
+		assert.Equal(t, `SELECT e.card FROM "hr"."badge_events" e JOIN "hr"."employees" AS em USING (card)`, query)
+	})
+
+	t.Run("string literals are kept", func(t *testing.T) {
+		query := naming.Rewrite(`INSERT INTO attendance.audit_log (action, detail) VALUES ('merge', 'moved attendance.events'), (E'it\'s attendance.events', 'it''s attendance.events')`)
+
+		assert.Equal(t, `INSERT INTO "hr"."audit_log" (action, detail) VALUES ('merge', 'moved attendance.events'), (E'it\'s attendance.events', 'it''s attendance.events')`, query)
+	})
+
+	t.Run("literals naming a relation are renamed", func(t *testing.T) {
+		query := naming.Rewrite(`SELECT to_regclass('attendance.events') IS NOT NULL, to_regclass('attendance.sync_state') IS NOT NULL`)
+
+		assert.Equal(t, `SELECT to_regclass('"hr"."badge_events"') IS NOT NULL, to_regclass('"hr"."sync_state"') IS NOT NULL`, query)
+	})
+
+	t.Run("sequences and functions move with the schema", func(t *testing.T) {
+		query := naming.Rewrite(`SELECT setval('attendance.employees_revision_seq', 1), attendance.bump_employees_revision()`)
+
+		assert.Equal(t, `SELECT setval('"hr"."employees_revision_seq"', 1), "hr".bump_employees_revision()`, query)
+	})
+
+	t.Run("schema creation", func(t *testing.T) {
+		query := naming.Rewrite(`CREATE SCHEMA IF NOT EXISTS attendance;`)
+
+		assert.Equal(t, `CREATE SCHEMA IF NOT EXISTS "hr";`, query)
+	})
+
+	t.Run("quoted identifiers, comments and parameters are kept", func(t *testing.T) {
+		query := naming.Rewrite("-- copies attendance.events\nSELECT \"attendance.events\" FROM attendance.events WHERE id = $1")
+
+		assert.Equal(t, "-- copies attendance.events\nSELECT \"attendance.events\" FROM \"hr\".\"badge_events\" WHERE id = $1", query)
+	})
+
+	t.Run("dollar quoted bodies are rewritten", func(t *testing.T) {
+		migration, err := os.ReadFile("migrations/0019_interval_key.sql")
+		assert.Nil(t, err)
+
+		query := naming.Rewrite(string(migration))
+
+		assert.Contains(t, query, `WHERE conrelid = '"hr"."stays"'::regclass AND contype = 'u'`)
+		assert.Contains(t, query, `WHERE attrelid = '"hr"."stays"'::regclass AND attname = 'ent_event_id'`)
+		assert.Contains(t, query, `EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', '"hr"."stays"'::regclass, old_key);`)
+		assert.Contains(t, query, "END $$;")
+		assert.NotContains(t, query, "attendance.")
+	})
+
+	t.Run("function bodies are rewritten", func(t *testing.T) {
+		migration, err := os.ReadFile("migrations/0045_employees_revision_sequence.sql")
+		assert.Nil(t, err)
+
+		query := naming.Rewrite(string(migration))
+
+		assert.Contains(t, query, `CREATE OR REPLACE FUNCTION "hr".bump_employees_revision() RETURNS trigger AS $$`)
+		assert.Contains(t, query, `UPDATE "hr"."employees_revision" SET revision = nextval('"hr"."employees_revision_seq"');`)
+		assert.NotContains(t, query, "attendance.")
+	})
+
+	t.Run("default names pass unchanged", func(t *testing.T) {
+		query := `SELECT to_regclass('attendance.events')`
+
+		assert.Equal(t, query, DefaultNaming().Rewrite(query))
+	})
+}
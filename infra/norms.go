@@ -0,0 +1,24 @@
+package infra
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Work norms of WORK_NORMS_FILE. Without it everybody has a 40 hour week and
+// configured is false, the shift length of the flow policy stays the overtime
+// threshold.
+func WorkNormsFromEnv() (norms entity.WorkNorms, configured bool, err error) {
+	path := os.Getenv("WORK_NORMS_FILE")
+	if path == "" {
+		return entity.DefaultWorkNorms(), false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entity.WorkNorms{}, false, fmt.Errorf("reading work norms: %w", err)
+	}
+	norms, err = entity.ParseWorkNorms(data)
+	return norms, err == nil, err
+}
@@ -0,0 +1,75 @@
+package infra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Posts run results to a Slack incoming webhook or the Telegram Bot API.
+// A notifier without webhook URL is a no-op.
+type Notifier struct {
+	webhookURL     string
+	telegramChatID string
+	client         *http.Client
+}
+
+func NewNotifier(webhookURL, telegramChatID string) *Notifier {
+	return &Notifier{
+		webhookURL:     webhookURL,
+		telegramChatID: telegramChatID,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func NewNotifierFromEnv() *Notifier {
+	return NewNotifier(os.Getenv("NOTIFY_WEBHOOK_URL"), os.Getenv("NOTIFY_TELEGRAM_CHAT_ID"))
+}
+
+func (n *Notifier) NotifySummary(summary RunSummary) error {
+	return n.send("attendance ETL finished, " + summary.String())
+}
+
+func (n *Notifier) NotifyFailure(division string, err error) error {
+	return n.send(fmt.Sprintf("🚨 attendance ETL FAILED for division %s: %v", division, err))
+}
+
+func (n *Notifier) NotifyAbsences(day time.Time, absences []Absence) error {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d absent on %s", len(absences), day.Format("2006-01-02"))
+	for _, a := range absences {
+		fmt.Fprintf(&text, "\n%s %s %s (%s, card %s)", a.Database, a.FirstName, a.LastName, a.Department, a.Card)
+	}
+	return n.send(text.String())
+}
+
+func (n *Notifier) send(text string) error {
+	if n.webhookURL == "" {
+		return nil
+	}
+
+	// telegram sendMessage requires the chat, slack webhooks are bound to a channel
+	payload := map[string]string{"text": text}
+	if n.telegramChatID != "" {
+		payload["chat_id"] = n.telegramChatID
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("posting notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("posting notification: unexpected status %s", resp.Status)
+	}
+	return nil
+}
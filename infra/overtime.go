@@ -0,0 +1,62 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const overtimeInsertBatchSize = 1000
+
+type Overtime struct {
+	Database string    `db:"database" json:"database"`
+	Card     string    `db:"card" json:"card"`
+	Day      time.Time `db:"day" json:"day"`
+	Reason   string    `db:"reason" json:"reason"`
+	Hours    float64   `db:"hours" json:"hours"`
+	Rate     float64   `db:"rate" json:"rate"`
+}
+
+// Replaces the division's overtime from since on, older days are outside the
+// recomputed window and kept as they are
+func (db *Repository) ReplaceOvertime(database string, since time.Time, overtime []entity.Overtime) error {
+	// users sharing a card would otherwise hit the primary key twice
+	rows := make([]Overtime, 0, len(overtime))
+	index := make(map[string]int)
+	for _, o := range overtime {
+		if o.Day.Before(since) {
+			continue
+		}
+		key := o.Card + "|" + o.Day.Format("2006-01-02") + "|" + o.Reason
+		if i, ok := index[key]; ok {
+			rows[i].Hours += o.Hours
+			continue
+		}
+		index[key] = len(rows)
+		rows = append(rows, Overtime{Database: database, Card: o.Card, Day: o.Day, Reason: o.Reason, Hours: o.Hours, Rate: o.Rate})
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing overtime: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.overtime WHERE database = $1 AND day >= $2", database, since)
+	if err != nil {
+		return fmt.Errorf("replacing overtime: %w", err)
+	}
+	for start := 0; start < len(rows); start += overtimeInsertBatchSize {
+		end := start + overtimeInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err = tx.NamedExec(`INSERT INTO attendance.overtime (database, card, day, reason, hours, rate)
+		VALUES (:database, :card, :day, :reason, :hours, :rate)`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("replacing overtime: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,307 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const lakeColumnsFile = "_columns.json"
+
+// Column of a lake dataset, the type is one of int, double, bool, timestamp or string
+type LakeColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Writes the rows of a table between from and to as Parquet files partitioned
+// Hive style by the month of their time column:
+//
+//	<dir>/<table>/year=2024/month=03/part-<from>-<to>.parquet
+//
+// Exporting a range again replaces its files.
+// The dataset schema is kept in <dir>/<table>/_columns.json. Columns added to
+// the table are appended to it, columns dropped from the table stay in it and
+// are written as nulls, so every file of a dataset can be read with the latest
+// schema. All columns are optional. Cards and names are written as mask
+// leaves them.
+func (db *Repository) ExportParquet(ctx context.Context, table, dir string, from, to time.Time, mask MaskingProfile) ([]string, error) {
+	timeColumn, ok := tableTimeColumns[table]
+	if !ok {
+		return nil, fmt.Errorf("parquet export is not supported for table %s", table)
+	}
+	column := pq.QuoteIdentifier(timeColumn)
+	rows, err := db.QueryxContext(ctx, fmt.Sprintf(`SELECT * FROM attendance.%s WHERE %s >= $1 AND %s < $2 ORDER BY %s`,
+		table, column, column, column), from, to)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	tableColumns := make([]LakeColumn, len(types))
+	timeIndex := -1
+	for i, t := range types {
+		tableColumns[i] = LakeColumn{Name: t.Name(), Type: lakeType(t.DatabaseTypeName())}
+		if t.Name() == timeColumn {
+			timeIndex = i
+		}
+	}
+
+	datasetDir := filepath.Join(dir, table)
+	columns, err := evolveLakeColumns(datasetDir, tableColumns)
+	if err != nil {
+		return nil, err
+	}
+	schema := lakeSchema(columns)
+	// position of every table column in the dataset row
+	position := make(map[string]int, len(columns))
+	for i, c := range columns {
+		position[c.Name] = i
+	}
+
+	files := make([]string, 0)
+	var part *lakePartWriter
+	var partMonth time.Time
+	closePart := func() error {
+		if part == nil {
+			return nil
+		}
+		err := part.Close(true)
+		files = append(files, part.path)
+		part = nil
+		return err
+	}
+	defer func() {
+		if part != nil {
+			part.Close(false)
+		}
+	}()
+
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return files, err
+		}
+		at, ok := values[timeIndex].(time.Time)
+		if !ok {
+			return files, fmt.Errorf("%s.%s is not a timestamp", table, timeColumn)
+		}
+		if month := monthStart(at); part == nil || !month.Equal(partMonth) {
+			if err := closePart(); err != nil {
+				return files, err
+			}
+			partDir := filepath.Join(datasetDir, fmt.Sprintf("year=%d", month.Year()), fmt.Sprintf("month=%02d", month.Month()))
+			part, err = newLakePartWriter(partDir, from, to, schema)
+			if err != nil {
+				return files, err
+			}
+			partMonth = month
+		}
+
+		// columns dropped from the table stay nil
+		row := make([]interface{}, len(columns))
+		for i, v := range values {
+			value, err := lakeValue(tableColumns[i].Type, v)
+			if err != nil {
+				return files, fmt.Errorf("%s.%s: %w", table, tableColumns[i].Name, err)
+			}
+			if text, ok := value.(string); ok {
+				value = mask.Column(tableColumns[i].Name, text)
+			}
+			row[position[tableColumns[i].Name]] = value
+		}
+		if err := part.writer.Write(row); err != nil {
+			return files, fmt.Errorf("writing %s parquet: %w", table, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return files, err
+	}
+	return files, closePart()
+}
+
+// Merges the table columns into the dataset schema and saves it
+func evolveLakeColumns(datasetDir string, tableColumns []LakeColumn) ([]LakeColumn, error) {
+	path := filepath.Join(datasetDir, lakeColumnsFile)
+	columns := make([]LakeColumn, 0)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &columns); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	known := make(map[string]string, len(columns))
+	for _, c := range columns {
+		known[c.Name] = c.Type
+	}
+	evolving := len(columns) > 0
+	changed := !evolving
+	for _, c := range tableColumns {
+		t, ok := known[c.Name]
+		if !ok {
+			if evolving {
+				log.Printf("lake dataset %s: new column %s (%s)", filepath.Base(datasetDir), c.Name, c.Type)
+			}
+			columns = append(columns, c)
+			known[c.Name] = c.Type
+			changed = true
+			continue
+		}
+		if t != c.Type {
+			// files already written can't be read with another type
+			return nil, fmt.Errorf("lake dataset %s: column %s changed type from %s to %s",
+				filepath.Base(datasetDir), c.Name, t, c.Type)
+		}
+	}
+	if !changed {
+		return columns, nil
+	}
+
+	if err := os.MkdirAll(datasetDir, 0o755); err != nil {
+		return nil, err
+	}
+	data, err = json.MarshalIndent(columns, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".part", data, 0o644); err != nil {
+		return nil, err
+	}
+	return columns, os.Rename(path+".part", path)
+}
+
+func lakeType(databaseType string) string {
+	switch strings.ToUpper(databaseType) {
+	case "INT2", "INT4", "INT8":
+		return "int"
+	case "FLOAT4", "FLOAT8", "NUMERIC":
+		return "double"
+	case "BOOL":
+		return "bool"
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ":
+		return "timestamp"
+	default:
+		return "string"
+	}
+}
+
+// Parquet schema of the columns in the metadata notation of the CSV writer
+func lakeSchema(columns []LakeColumn) []string {
+	schema := make([]string, len(columns))
+	for i, c := range columns {
+		var t string
+		switch c.Type {
+		case "int":
+			t = "type=INT64"
+		case "double":
+			t = "type=DOUBLE"
+		case "bool":
+			t = "type=BOOLEAN"
+		case "timestamp":
+			t = "type=INT64, convertedtype=TIMESTAMP_MICROS"
+		default:
+			t = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+		schema[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", c.Name, t)
+	}
+	return schema
+}
+
+// Value of a column as the CSV writer takes it, nil for nulls
+func lakeValue(lakeType string, v any) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	switch lakeType {
+	case "int":
+		switch v := v.(type) {
+		case int64:
+			return v, nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+	case "double":
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		}
+	case "bool":
+		if v, ok := v.(bool); ok {
+			return v, nil
+		}
+	case "timestamp":
+		// site wall clock times are stored as they are, labelled UTC
+		if v, ok := v.(time.Time); ok {
+			return v.UnixMicro(), nil
+		}
+	default:
+		if v, ok := v.(time.Time); ok {
+			return v.Format(time.RFC3339Nano), nil
+		}
+		return fmt.Sprint(v), nil
+	}
+	return nil, fmt.Errorf("unexpected %T value for a %s column", v, lakeType)
+}
+
+type lakePartWriter struct {
+	file   *os.File
+	writer *writer.CSVWriter
+	path   string
+}
+
+func newLakePartWriter(dir string, from, to time.Time, schema []string) (*lakePartWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating partition dir: %w", err)
+	}
+	path := filepath.Join(dir, "part-"+from.Format("20060102")+"-"+to.Format("20060102")+".parquet")
+	// lake readers pick up every .parquet file, partial ones carry another suffix
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, fmt.Errorf("creating parquet file: %w", err)
+	}
+	w, err := writer.NewCSVWriterFromWriter(schema, f, 1)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("creating parquet writer: %w", err)
+	}
+	return &lakePartWriter{file: f, writer: w, path: path}, nil
+}
+
+// Publishes the file when keep is set and discards it otherwise
+func (w *lakePartWriter) Close(keep bool) error {
+	writeErr := w.writer.WriteStop()
+	fileErr := w.file.Close()
+	if !keep {
+		return os.Remove(w.file.Name())
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if fileErr != nil {
+		return fileErr
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
@@ -0,0 +1,110 @@
+package infra
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Font with Cyrillic glyphs shipped by most Linux distributions
+const DefaultReportFont = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+
+// Renders the monthly attendance summary with a page per employee and signature
+// lines for the hard-copy archive. fontPath is a TrueType font covering Cyrillic.
+func WriteAttendancePDF(w io.Writer, month time.Time, sheets []TimesheetSheet, calendar entity.Calendar, fontPath string, locale entity.Locale) error {
+	pdf := fpdf.New("P", "mm", "A4", filepath.Dir(fontPath))
+	pdf.AddUTF8Font("report", "", filepath.Base(fontPath))
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("loading report font %s: %w", fontPath, err)
+	}
+	pdf.SetAutoPageBreak(false, 10)
+
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	days := first.AddDate(0, 1, -1).Day()
+
+	for _, sheet := range sheets {
+		for _, row := range sheet.Rows {
+			writeAttendancePage(pdf, first, days, departmentName(sheet.Department, locale), row, calendar, locale)
+		}
+	}
+
+	if err := pdf.Output(w); err != nil {
+		return fmt.Errorf("writing attendance pdf: %w", err)
+	}
+	return nil
+}
+
+func writeAttendancePage(pdf *fpdf.Fpdf, first time.Time, days int, department string, row TimesheetRow, calendar entity.Calendar, locale entity.Locale) {
+	pdf.AddPage()
+	pdf.SetFont("report", "", 14)
+	pdf.CellFormat(0, 8, locale.T("summary.title", first.Format("01.2006")), "", 1, "C", false, 0, "")
+
+	pdf.SetFont("report", "", 10)
+	pdf.Ln(2)
+	for _, line := range []string{
+		locale.T("summary.employee", row.Name),
+		locale.T("summary.card", row.Card),
+		locale.T("summary.department", department),
+		locale.T("summary.position", row.Position),
+	} {
+		pdf.CellFormat(0, 5, line, "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(3)
+
+	widths := []float64{30, 20, 30, 30}
+	for i, title := range []string{locale.T("summary.date"), locale.T("summary.weekday"), locale.T("summary.hours"), locale.T("summary.marker")} {
+		pdf.CellFormat(widths[i], 6, title, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	var total, dayOff float64
+	var worked, absent int
+	for day := 1; day <= days; day++ {
+		date := first.AddDate(0, 0, day-1)
+		hours := row.Hours[day]
+
+		kind := calendar.Kind(date)
+
+		var hoursText, marker string
+		if kind == entity.DayShortened {
+			marker = locale.T("summary.shortened")
+		}
+		switch {
+		case hours > 0:
+			hoursText = fmt.Sprintf("%.2f", hours)
+			total += hours
+			worked++
+			if kind == entity.DayOff {
+				dayOff += hours
+				marker = locale.T("timesheet.day_off")
+			}
+		case row.Leaves[day] != "":
+			marker = locale.LeaveCode(row.Leaves[day])
+		case kind == entity.DayOff:
+			marker = locale.T("timesheet.day_off")
+		default:
+			marker = locale.T("timesheet.absent")
+			absent++
+		}
+
+		pdf.CellFormat(widths[0], 6, date.Format("02.01.2006"), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[1], 6, locale.Weekday(date.Weekday()), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[2], 6, hoursText, "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, marker, "1", 1, "C", false, 0, "")
+	}
+
+	pdf.Ln(3)
+	pdf.CellFormat(0, 5, locale.T("summary.worked", worked, total), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 5, locale.T("summary.day_off", dayOff), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 5, locale.T("summary.absent", absent), "", 1, "L", false, 0, "")
+	norm := row.Norm(first, calendar)
+	pdf.CellFormat(0, 5, locale.T("summary.norm", norm, total-norm), "", 1, "L", false, 0, "")
+
+	pdf.Ln(12)
+	pdf.CellFormat(95, 5, locale.T("summary.signature"), "", 0, "L", false, 0, "")
+	pdf.CellFormat(95, 5, locale.T("summary.responsible"), "", 1, "L", false, 0, "")
+}
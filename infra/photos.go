@@ -0,0 +1,179 @@
+package infra
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// USERINFO column holding the badge photo as an Access OLE object
+const photoColumn = "PHOTO"
+
+// Cards are numbered per controller, a photo belongs to the card of its division
+type EmployeePhoto struct {
+	Database    string `db:"database"`
+	Card        string `db:"card"`
+	ContentType string `db:"content_type"`
+	SHA256      string `db:"sha256"`
+	Data        []byte `db:"photo"`
+}
+
+// Badge photos of the controller users by card. Users without a photo or with
+// an OLE object that holds no known image format are left out.
+func (e *MdbExporter) ExportPhotos() ([]EmployeePhoto, error) {
+	out, errout, err := e.mdbExport("-b", "hex", e.dblocation, e.schema.Table(entity.TableUsers))
+	if err != nil {
+		return nil, fmt.Errorf("exec: %s %w", errout, err)
+	}
+
+	rows, err := SerializeCSVInputMapped(out, e.headerMapper(entity.TableUsers), func(record []string, index map[string]int) ([]string, error) {
+		card, ok := index["CardNo"]
+		if !ok {
+			return nil, fmt.Errorf("USERINFO has no CardNo column")
+		}
+		photo, ok := index[photoColumn]
+		if !ok {
+			return nil, fmt.Errorf("USERINFO has no %s column", photoColumn)
+		}
+		return []string{record[card], record[photo]}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	photos := make([]EmployeePhoto, 0)
+	for _, row := range rows {
+		if row[0] == "" || row[1] == "" {
+			continue
+		}
+		blob, err := hex.DecodeString(strings.TrimSpace(row[1]))
+		if err != nil {
+			return nil, fmt.Errorf("decoding photo of card %s: %w", row[0], err)
+		}
+		data, contentType, ok := ExtractOLEImage(blob)
+		if !ok {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		photos = append(photos, EmployeePhoto{
+			Card:        row[0],
+			ContentType: contentType,
+			SHA256:      hex.EncodeToString(sum[:]),
+			Data:        data,
+		})
+	}
+	return photos, nil
+}
+
+var (
+	jpegStart = []byte{0xff, 0xd8, 0xff}
+	jpegEnd   = []byte{0xff, 0xd9}
+	pngStart  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+	pngEnd    = []byte("IEND")
+	bmpStart  = []byte("BM")
+)
+
+// BITMAPFILEHEADER, followed by the info header starting with its own size
+const bmpFileHeader = 14
+
+// Access wraps pictures into an OLE package header and trails them with the
+// presentation data, the image is found by its signature and cut at its end marker
+func ExtractOLEImage(blob []byte) (data []byte, contentType string, ok bool) {
+	if i := bytes.Index(blob, jpegStart); i >= 0 {
+		data = blob[i:]
+		if end := bytes.LastIndex(data, jpegEnd); end >= 0 {
+			data = data[:end+len(jpegEnd)]
+		}
+		return data, "image/jpeg", true
+	}
+	if i := bytes.Index(blob, pngStart); i >= 0 {
+		data = blob[i:]
+		// the IEND chunk type is followed by its crc
+		if end := bytes.Index(data, pngEnd); end >= 0 && end+len(pngEnd)+4 <= len(data) {
+			data = data[:end+len(pngEnd)+4]
+		}
+		return data, "image/png", true
+	}
+	for i := 0; ; i++ {
+		next := bytes.Index(blob[i:], bmpStart)
+		if next < 0 {
+			break
+		}
+		i += next
+		if size, ok := bmpSize(blob[i:]); ok {
+			return blob[i : i+size], "image/bmp", true
+		}
+	}
+	return nil, "", false
+}
+
+/*
+ * Size of the bitmap file data starts with. "BM" is common in OLE headers and
+ * names, so the file header must hold: a size within data, zero reserved
+ * bytes, pixels after the headers and an info header of a known version.
+ */
+func bmpSize(data []byte) (int, bool) {
+	if len(data) < bmpFileHeader+4 {
+		return 0, false
+	}
+	size := int(binary.LittleEndian.Uint32(data[2:]))
+	reserved := binary.LittleEndian.Uint32(data[6:])
+	pixels := int(binary.LittleEndian.Uint32(data[10:]))
+	info := int(binary.LittleEndian.Uint32(data[bmpFileHeader:]))
+	switch info {
+	case 12, 40, 52, 56, 64, 108, 124:
+	default:
+		return 0, false
+	}
+	if reserved != 0 || size > len(data) || pixels < bmpFileHeader+info || pixels > size {
+		return 0, false
+	}
+	return size, true
+}
+
+// Stores photos that are new or changed since the last sync, unchanged photos
+// are compared by hash so the blobs aren't rewritten on every run
+func (db *Repository) SyncEmployeePhotos(database string, photos []EmployeePhoto, at time.Time) (int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var changed int
+	for _, photo := range photos {
+		res, err := tx.Exec(`INSERT INTO attendance.employee_photos AS p (database, card, content_type, sha256, photo, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (database, card) DO UPDATE SET content_type = EXCLUDED.content_type, sha256 = EXCLUDED.sha256,
+				photo = EXCLUDED.photo, updated_at = EXCLUDED.updated_at
+			WHERE p.sha256 <> EXCLUDED.sha256`,
+			database, photo.Card, photo.ContentType, photo.SHA256, photo.Data, at)
+		if err != nil {
+			return changed, fmt.Errorf("storing photo of card %s: %w", photo.Card, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return changed, err
+		}
+		changed += int(n)
+	}
+	return changed, tx.Commit()
+}
+
+// Photo of the employee holding card in the division, ok is false when there is none
+func (db *Repository) EmployeePhoto(database, card string) (photo EmployeePhoto, ok bool, err error) {
+	err = db.Get(&photo, `SELECT database, card, content_type, sha256, photo FROM attendance.employee_photos
+	WHERE database = $1 AND card = $2`, database, card)
+	if errors.Is(err, sql.ErrNoRows) {
+		return photo, false, nil
+	}
+	return photo, err == nil, err
+}
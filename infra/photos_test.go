@@ -0,0 +1,91 @@
+package infra
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Package header as Access writes it ahead of the picture, naming the server
+// application, and the presentation data trailing it
+var (
+	oleHeader  = append([]byte{0x15, 0x1c, 0x2f, 0x00, 0x02, 0x00}, "Bitmap Image\x00Paint.Picture\x00BMW\x00"...)
+	oleTrailer = []byte{0x01, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 'M', 'E', 'T', 'A'}
+)
+
+func wrapOLE(image []byte) []byte {
+	blob := append([]byte{}, oleHeader...)
+	blob = append(blob, image...)
+	return append(blob, oleTrailer...)
+}
+
+func testBitmap() []byte {
+	const info = 40
+	pixels := []byte{0x00, 0x00, 0xff, 0x00}
+	bmp := make([]byte, bmpFileHeader+info, bmpFileHeader+info+len(pixels))
+	copy(bmp, "BM")
+	binary.LittleEndian.PutUint32(bmp[2:], uint32(bmpFileHeader+info+len(pixels)))
+	binary.LittleEndian.PutUint32(bmp[10:], bmpFileHeader+info)
+	binary.LittleEndian.PutUint32(bmp[bmpFileHeader:], info)
+	binary.LittleEndian.PutUint32(bmp[bmpFileHeader+4:], 1)
+	binary.LittleEndian.PutUint32(bmp[bmpFileHeader+8:], 1)
+	return append(bmp, pixels...)
+}
+
+func TestExtractOLEImage(t *testing.T) {
+	t.Run("jpeg", func(t *testing.T) {
+		jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0xff, 0xd9}
+
+		data, contentType, ok := ExtractOLEImage(wrapOLE(jpeg))
+
+		assert.True(t, ok)
+		assert.Equal(t, "image/jpeg", contentType)
+		assert.Equal(t, jpeg, data)
+	})
+
+	t.Run("png", func(t *testing.T) {
+		png := append([]byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'},
+			0x00, 0x00, 0x00, 0x00, 'I', 'E', 'N', 'D', 0xae, 0x42, 0x60, 0x82)
+
+		data, contentType, ok := ExtractOLEImage(wrapOLE(png))
+
+		assert.True(t, ok)
+		assert.Equal(t, "image/png", contentType)
+		assert.Equal(t, png, data)
+	})
+
+	t.Run("bmp", func(t *testing.T) {
+		bmp := testBitmap()
+
+		data, contentType, ok := ExtractOLEImage(wrapOLE(bmp))
+
+		assert.True(t, ok)
+		assert.Equal(t, "image/bmp", contentType)
+		assert.Equal(t, bmp, data)
+	})
+
+	t.Run("no image", func(t *testing.T) {
+		_, _, ok := ExtractOLEImage(wrapOLE([]byte("Package\x00BMP file missing\x00")))
+
+		assert.False(t, ok)
+	})
+
+	t.Run("bitmap header with nonzero reserved bytes", func(t *testing.T) {
+		bmp := testBitmap()
+		bmp[6] = 1
+
+		_, _, ok := ExtractOLEImage(wrapOLE(bmp))
+
+		assert.False(t, ok)
+	})
+
+	t.Run("truncated bitmap", func(t *testing.T) {
+		bmp := testBitmap()
+
+		_, _, ok := ExtractOLEImage(oleHeader)
+		assert.False(t, ok)
+		_, _, ok = ExtractOLEImage(append(append([]byte{}, oleHeader...), bmp[:len(bmp)-1]...))
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,118 @@
+package infra
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// Postgres driver and pool settings of a destination. Zero values keep the
+// database/sql defaults.
+type PoolConfig struct {
+	// pq (default) or pgx
+	Driver          string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// prepared statements kept per pgx connection, -1 keeps the pgx default and
+	// 0 disables the cache, for poolers running in transaction mode
+	StatementCache int
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{Driver: "pq", StatementCache: -1}
+}
+
+// Reads DB_DRIVER, DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME,
+// DB_CONN_MAX_IDLE_TIME and DB_STATEMENT_CACHE
+func PoolConfigFromEnv() (PoolConfig, error) {
+	config := DefaultPoolConfig()
+	if d := os.Getenv("DB_DRIVER"); d != "" {
+		config.Driver = d
+	}
+	switch config.Driver {
+	case "pq", "pgx":
+	default:
+		return config, fmt.Errorf("unsupported DB_DRIVER: %s", config.Driver)
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"DB_MAX_OPEN_CONNS", &config.MaxOpenConns},
+		{"DB_MAX_IDLE_CONNS", &config.MaxIdleConns},
+		{"DB_STATEMENT_CACHE", &config.StatementCache},
+	}
+	for _, v := range ints {
+		s := os.Getenv(v.key)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", v.key, err)
+		}
+		*v.dst = n
+	}
+
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"DB_CONN_MAX_LIFETIME", &config.ConnMaxLifetime},
+		{"DB_CONN_MAX_IDLE_TIME", &config.ConnMaxIdleTime},
+	}
+	for _, v := range durations {
+		s := os.Getenv(v.key)
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", v.key, err)
+		}
+		*v.dst = d
+	}
+	return config, nil
+}
+
+func (c PoolConfig) connector(dataSourceName string) (driver.Connector, error) {
+	if c.Driver != "pgx" {
+		return pq.NewConnector(dataSourceName)
+	}
+	config, err := pgx.ParseConfig(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if c.StatementCache == 0 {
+		config.StatementCacheCapacity = 0
+		config.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	} else if c.StatementCache > 0 {
+		config.StatementCacheCapacity = c.StatementCache
+	}
+	return stdlib.GetConnector(*config), nil
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
@@ -0,0 +1,50 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+)
+
+type Presence struct {
+	Card     string    `db:"card" json:"card"`
+	Database string    `db:"database" json:"database"`
+	Name     string    `db:"name" json:"name"`
+	Since    time.Time `db:"since" json:"since"`
+}
+
+// Replaces the division's presence snapshot with the people currently inside
+func (db *Repository) ReplacePresence(database string, presence []Presence) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing presence: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.presence WHERE database = $1", database)
+	if err != nil {
+		return fmt.Errorf("replacing presence: %w", err)
+	}
+	if len(presence) > 0 {
+		_, err = tx.NamedExec(`INSERT INTO attendance.presence (card, database, name, since)
+		VALUES (:card, :database, :name, :since)`, presence)
+		if err != nil {
+			return fmt.Errorf("replacing presence: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Repository) PresenceAll(database string) (presence []Presence, err error) {
+	err = db.Select(&presence, "SELECT * FROM attendance.presence WHERE database = $1 ORDER BY since", database)
+	return presence, err
+}
+
+// Drops people whose entry is older than until, OnSite no longer counts them
+// as inside. Runs skipped for an unchanged source keep the snapshot current.
+func (db *Repository) ExpirePresence(database string, until time.Time) error {
+	_, err := db.Exec("DELETE FROM attendance.presence WHERE database = $1 AND since <= $2", database, until)
+	if err != nil {
+		return fmt.Errorf("expiring presence: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package infra
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const pushgatewayJob = "attendance_etl"
+
+/*
+ * Pushes run metrics of short-lived cron runs to a Prometheus Pushgateway.
+ * A PUT replaces every metric of its group, so the run's own gauges share one
+ * group while the last success and last failure timestamps each get a group
+ * of their own, pushed only by a run of that outcome. A failed run then
+ * leaves the time of the last success in place and the other way round.
+ */
+func PushRunMetrics(gatewayURL string, summary RunSummary, runErr error) error {
+	if gatewayURL == "" {
+		return nil
+	}
+
+	instance := summary.Division
+	if instance == "" {
+		instance = "default"
+	}
+	group := fmt.Sprintf("%s/metrics/job/%s/instance/%s", gatewayURL, pushgatewayJob, url.PathEscape(instance))
+
+	var run metricsBody
+	if runErr != nil {
+		run.gauge("attendance_etl_success", "Whether the last run succeeded", 0)
+	} else {
+		run.gauge("attendance_etl_success", "Whether the last run succeeded", 1)
+		run.gauge("attendance_etl_duration_seconds", "Duration of the last run", summary.Finished.Sub(summary.Started).Seconds())
+	}
+	skipped := 0.0
+	if summary.Skipped {
+		skipped = 1
+	}
+	run.gauge("attendance_etl_skipped", "Whether the last run was skipped for an unchanged source", skipped)
+	run.gauge("attendance_etl_users_synced", "Users synced by the last run", float64(summary.UsersSynced))
+	run.gauge("attendance_etl_events_exported", "Events exported by the last run", float64(summary.EventsExported))
+	run.gauge("attendance_etl_events_inserted", "Events inserted by the last run", float64(summary.EventsInserted))
+	run.gauge("attendance_etl_intervals_formed", "Intervals formed by the last run", float64(summary.IntervalsFormed))
+	run.gauge("attendance_etl_intervals_inserted", "Intervals inserted by the last run", float64(summary.IntervalsInserted))
+	run.gauge("attendance_etl_anomalies", "Anomalies detected by the last run", float64(summary.Anomalies))
+	run.gauge("attendance_etl_data_quality_score", "Data quality score of the last complete day, 0 to 100", summary.QualityScore)
+	if err := pushGroup(group, &run); err != nil {
+		return err
+	}
+
+	var outcome metricsBody
+	if runErr != nil {
+		outcome.gauge("attendance_etl_last_failure_timestamp_seconds", "Unix time of the last failed run", float64(time.Now().Unix()))
+		return pushGroup(group+"/outcome/failure", &outcome)
+	}
+	outcome.gauge("attendance_etl_last_success_timestamp_seconds", "Unix time of the last successful run", float64(summary.Finished.Unix()))
+	return pushGroup(group+"/outcome/success", &outcome)
+}
+
+// Metrics in the Prometheus text format
+type metricsBody struct {
+	bytes.Buffer
+}
+
+func (b *metricsBody) gauge(name, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
+
+// PUT replaces all metrics of the group, so stale values of a failed run don't linger
+func pushGroup(target string, body *metricsBody) error {
+	req, err := http.NewRequest(http.MethodPut, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushing metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushing metrics: unexpected status %s", resp.Status)
+	}
+	return nil
+}
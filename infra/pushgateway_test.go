@@ -0,0 +1,39 @@
+package infra
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushRunMetrics(t *testing.T) {
+	groups := make(map[string]string)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		body, _ := io.ReadAll(r.Body)
+		groups[r.URL.Path] = string(body)
+	}))
+	defer gateway.Close()
+	started := time.Date(2026, 9, 1, 6, 0, 0, 0, time.UTC)
+	summary := RunSummary{Division: "plant", Started: started, Finished: started.Add(time.Minute)}
+
+	t.Run("a failure keeps the last success", func(t *testing.T) {
+		assert.Nil(t, PushRunMetrics(gateway.URL, summary, nil))
+		assert.Nil(t, PushRunMetrics(gateway.URL, summary, errors.New("source unavailable")))
+
+		run := groups["/metrics/job/attendance_etl/instance/plant"]
+		assert.Contains(t, run, "attendance_etl_success 0")
+		assert.False(t, strings.Contains(run, "timestamp_seconds"))
+		assert.Contains(t, groups["/metrics/job/attendance_etl/instance/plant/outcome/success"],
+			fmt.Sprintf("attendance_etl_last_success_timestamp_seconds %g", float64(summary.Finished.Unix())))
+		assert.Contains(t, groups["/metrics/job/attendance_etl/instance/plant/outcome/failure"],
+			"attendance_etl_last_failure_timestamp_seconds")
+	})
+}
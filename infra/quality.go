@@ -0,0 +1,65 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+type DataQuality struct {
+	Database        string  `db:"database" json:"database"`
+	Day             string  `db:"day" json:"day"`
+	Events          int     `db:"events" json:"events"`
+	Duplicates      int     `db:"duplicates" json:"duplicates"`
+	Anomalies       int     `db:"anomalies" json:"anomalies"`
+	Intervals       int     `db:"intervals" json:"intervals"`
+	ClosedIntervals int     `db:"closed_intervals" json:"closed_intervals"`
+	Score           float64 `db:"score" json:"score"`
+}
+
+// Stores the quality of the days from since on, earlier days are outside the recomputed window
+func (db *Repository) UpsertDataQuality(database string, since time.Time, quality []entity.DayQuality) error {
+	rows := make([]DataQuality, 0, len(quality))
+	for _, q := range quality {
+		if q.Day.Before(since) {
+			continue
+		}
+		rows = append(rows, DataQuality{
+			Database:        database,
+			Day:             q.Day.Format("2006-01-02"),
+			Events:          q.Events,
+			Duplicates:      q.Duplicates,
+			Anomalies:       q.Anomalies,
+			Intervals:       q.Intervals,
+			ClosedIntervals: q.ClosedIntervals,
+			Score:           q.Score(),
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	_, err := db.NamedExec(`INSERT INTO attendance.data_quality
+		(database, day, events, duplicates, anomalies, intervals, closed_intervals, score)
+	VALUES (:database, :day, :events, :duplicates, :anomalies, :intervals, :closed_intervals, :score)
+	ON CONFLICT (database, day) DO UPDATE SET
+		events = EXCLUDED.events, duplicates = EXCLUDED.duplicates, anomalies = EXCLUDED.anomalies,
+		intervals = EXCLUDED.intervals, closed_intervals = EXCLUDED.closed_intervals, score = EXCLUDED.score`, rows)
+	if err != nil {
+		return fmt.Errorf("saving data quality: %w", err)
+	}
+	return nil
+}
+
+// Daily quality of every division in [from, to)
+func (db *Repository) DataQuality(from, to time.Time) (quality []DataQuality, err error) {
+	err = db.Select(&quality, `SELECT database, to_char(day, 'YYYY-MM-DD') AS day, events, duplicates,
+		anomalies, intervals, closed_intervals, score
+	FROM attendance.data_quality WHERE day >= $1 AND day < $2
+	ORDER BY database, day`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading data quality: %w", err)
+	}
+	return quality, nil
+}
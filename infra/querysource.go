@@ -0,0 +1,139 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Source of controller users, MDB files and vendor databases have them
+type UserSource interface {
+	ExportUsers() ([]*entity.User, error)
+}
+
+// Runs a query with the command line client of a database, handing every row
+// with the column names to row
+type queryClient interface {
+	query(ctx context.Context, query string, row func(header, record []string) error) error
+}
+
+/*
+ * Users and events of a vendor database read with configured queries. The
+ * queries name their columns like the MDB tables: the users query returns
+ * CardNo, name and lastname, optionally DEFAULTDEPTID, DEPTNAME, TITLE and
+ * USERTYPE, the events query id, card_no, event_point_name and time,
+ * optionally state, event_point_id and event_type. :since in the events query
+ * is replaced with the start of the loaded period.
+ */
+type QuerySource struct {
+	client      queryClient
+	usersQuery  string
+	eventsQuery string
+}
+
+// Layouts of timestamps printed by database clients, mdb-export's last
+var queryTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "02.01.2006 15:04:05", "01/02/06 15:04:05"}
+
+func (s *QuerySource) StreamEvents(ctx context.Context, selectFor, batchSize int, sink func([]entity.Event) error) error {
+	since := time.Now().AddDate(0, -(selectFor + 1), 0)
+	query := strings.ReplaceAll(s.eventsQuery, ":since", "'"+since.Format("2006-01-02")+" 00:00:00'")
+
+	var index map[string]int
+	batch := make([]entity.Event, 0, batchSize)
+	flush := func() error {
+		batch = entity.SelectEventsForNLastMonths(batch, selectFor+1)
+		if len(batch) == 0 {
+			return nil
+		}
+		err := sink(batch)
+		batch = make([]entity.Event, 0, batchSize)
+		return err
+	}
+	err := s.client.query(ctx, query, func(header, record []string) error {
+		if index == nil {
+			var err error
+			if index, err = queryColumns(entity.TableEvents, header); err != nil {
+				return err
+			}
+		}
+		if err := mdbTime(record, index["time"]); err != nil {
+			log.Println("parsing error:", err)
+			return nil
+		}
+		event, err := entity.NewEventFromDBRecord(record, index)
+		if err != nil {
+			log.Println("parsing error:", err)
+			return nil
+		}
+		batch = append(batch, event)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// Users of the users query, DEPTNAME names the department
+func (s *QuerySource) ExportUsers() ([]*entity.User, error) {
+	var index map[string]int
+	users := make([]*entity.User, 0)
+	err := s.client.query(context.Background(), s.usersQuery, func(header, record []string) error {
+		if index == nil {
+			var err error
+			if index, err = queryColumns(entity.TableUsers, header); err != nil {
+				return err
+			}
+		}
+		user, err := entity.UserFromCSV(record, index)
+		if err != nil {
+			log.Println("parsing error:", err)
+			return nil
+		}
+		if i, ok := index["DEPTNAME"]; ok {
+			user.Department = record[i]
+		}
+		users = append(users, user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Column index of a result header by the names of the MDB tables, clients
+// print unquoted names in upper case
+func queryColumns(table string, header []string) (map[string]int, error) {
+	byName := make(map[string]int, len(header))
+	for i, name := range header {
+		byName[name] = i
+	}
+	index, _, err := mapSourceColumns(entity.DefaultSchemaProfile(), table, byName)
+	if err != nil {
+		return nil, fmt.Errorf("query result: %w", err)
+	}
+	for name, i := range byName {
+		index[strings.ToUpper(name)] = i
+	}
+	return index, nil
+}
+
+// Rewrites the timestamp in column i to the mdb-export layout the event parser reads
+func mdbTime(record []string, i int) error {
+	value := strings.TrimSpace(record[i])
+	for _, layout := range queryTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			record[i] = t.Format("01/02/06 15:04:05")
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown time format %q", value)
+}
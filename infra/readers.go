@@ -0,0 +1,128 @@
+package infra
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+var ErrReaderNotFound = errors.New("reader not found")
+
+// Reader of a division. Onboarding seeds readers by the names seen in events,
+// runs over an MDB file add the doors of acc_door with their ids.
+type Reader struct {
+	Division  string    `db:"division" json:"division"`
+	Name      string    `db:"name" json:"name"`
+	ID        *int      `db:"reader_id" json:"id,omitempty"`
+	DeviceID  int       `db:"device_id" json:"device_id"`
+	DoorNo    int       `db:"door_no" json:"door_no"`
+	Perimeter bool      `db:"perimeter" json:"perimeter"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Badge traffic of a door on one day
+type ReaderTraffic struct {
+	Day      time.Time `db:"day" json:"day"`
+	ReaderID int       `db:"reader_id" json:"reader_id"`
+	Name     string    `db:"name" json:"name"`
+	Events   int       `db:"events" json:"events"`
+	Cards    int       `db:"cards" json:"cards"`
+}
+
+const readerColumns = `division, name, reader_id, device_id, door_no, perimeter, updated_at`
+
+// Doors of acc_door, the whole table is a few rows
+func (e *MdbExporter) ExportReaders() ([]entity.Reader, error) {
+	out, errout, err := e.mdbExport(e.dblocation, e.schema.Table(entity.TableDoors))
+	if err != nil {
+		return nil, fmt.Errorf("exec: %s %w", errout, err)
+	}
+	return SerializeCSVInputMapped(out, e.headerMapper(entity.TableDoors), entity.ReaderFromDBRecord)
+}
+
+// Upserts the doors of a division by their id, a reader seeded by name takes
+// the id of the door with that name. Perimeter marks stay as they are.
+func (db *Repository) SyncReaders(division string, readers []entity.Reader, at time.Time) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("syncing readers: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, r := range readers {
+		res, err := tx.Exec(`UPDATE attendance.readers
+		SET name = $3, device_id = $4, door_no = $5, updated_at = $6
+		WHERE division = $1 AND reader_id = $2
+			AND (name, device_id, door_no) IS DISTINCT FROM ($3, $4, $5)`,
+			division, r.ID, r.Name, r.DeviceID, r.DoorNo, at)
+		if err != nil {
+			return fmt.Errorf("syncing readers: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO attendance.readers (division, name, reader_id, device_id, door_no, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE NOT EXISTS (SELECT 1 FROM attendance.readers WHERE division = $1 AND reader_id = $3)
+		ON CONFLICT (division, name) DO UPDATE SET reader_id = excluded.reader_id,
+			device_id = excluded.device_id, door_no = excluded.door_no, updated_at = excluded.updated_at`,
+			division, r.Name, r.ID, r.DeviceID, r.DoorNo, at)
+		if err != nil {
+			return fmt.Errorf("syncing readers: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Repository) Readers(division string) (readers []Reader, err error) {
+	err = db.Select(&readers, `SELECT `+readerColumns+` FROM attendance.readers
+	WHERE division = $1 ORDER BY reader_id NULLS LAST, name`, division)
+	if err != nil {
+		return nil, fmt.Errorf("loading readers: %w", err)
+	}
+	return readers, nil
+}
+
+// Door ids of the readers marked as perimeter doors
+func (db *Repository) PerimeterReaders(division string) (ids []int, err error) {
+	err = db.Select(&ids, `SELECT reader_id FROM attendance.readers
+	WHERE division = $1 AND perimeter AND reader_id IS NOT NULL ORDER BY reader_id`, division)
+	if err != nil {
+		return nil, fmt.Errorf("loading perimeter readers: %w", err)
+	}
+	return ids, nil
+}
+
+func (db *Repository) SetReaderPerimeter(division string, id int, perimeter bool) (Reader, error) {
+	var r Reader
+	err := db.Get(&r, `UPDATE attendance.readers SET perimeter = $3
+	WHERE division = $1 AND reader_id = $2
+	RETURNING `+readerColumns, division, id, perimeter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrReaderNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("updating reader: %w", err)
+	}
+	return r, nil
+}
+
+// Events and distinct cards per door and day in [from, to). Events carry no
+// division, so only doors registered for the division count; events of other
+// doors and of sources that don't tell the door are left out.
+func (db *Repository) ReaderTraffic(division string, from, to time.Time) (traffic []ReaderTraffic, err error) {
+	err = db.Select(&traffic, `SELECT date_trunc('day', ev.timestamp) AS day, ev.reader_id,
+		r.name, count(*) AS events, count(DISTINCT ev.card) AS cards
+	FROM attendance.events ev
+	JOIN attendance.readers r ON r.division = $1 AND r.reader_id = ev.reader_id
+	WHERE ev.timestamp >= $2 AND ev.timestamp < $3 AND ev.reader_id IS NOT NULL
+	GROUP BY 1, 2, 3
+	ORDER BY 1, 2`, division, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading reader traffic: %w", err)
+	}
+	return traffic, nil
+}
@@ -1,6 +1,7 @@
 package infra
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -8,31 +9,40 @@ import (
 	"database/sql"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/spooky-finn/piek-attendance-prod/entity"
 )
 
 type Employee struct {
-	ID        int            `db:"id"`
-	FirstName string         `db:"firstname"`
-	LastName  string         `db:"lastname"`
-	Card      string         `db:"card"`
-	CreatedAt sql.NullString `db:"created_at"`
+	ID         int            `db:"id"`
+	FirstName  string         `db:"firstname"`
+	LastName   string         `db:"lastname"`
+	Card       string         `db:"card"`
+	CreatedAt  sql.NullString `db:"created_at"`
+	Department string         `db:"department"`
+	Position   string         `db:"position"`
+	// holder name as the source reports it, null until a sync records it
+	SourceName sql.NullString `db:"source_name"`
 }
 
 type Event struct {
-	ID        int       `db:"id"`
-	Card      string    `db:"card"`
-	Timestamp time.Time `db:"timestamp"`
+	ID        int            `db:"id"`
+	Card      string         `db:"card"`
+	Timestamp time.Time      `db:"timestamp"`
+	Direction sql.NullString `db:"direction"`
+	ReaderID  sql.NullInt32  `db:"reader_id"`
 }
 
 type Interval struct {
+	Key        string         `db:"interval_key"`
 	Ent        string         `db:"ent"`
 	Ext        sql.NullString `db:"ext"`
 	Card       string         `db:"card"`
 	Database   string         `db:"database"`
 	EntEventID int            `db:"ent_event_id"`
 	ExtEventID sql.NullInt64  `db:"ext_event_id"`
+	// share of interval events confirmed by door sensors, null when not checked
+	Confidence sql.NullFloat64 `db:"confidence"`
 }
 
 type Repository struct {
@@ -40,10 +50,30 @@ type Repository struct {
 }
 
 func Connect(dataSourceName string) (*Repository, error) {
-	db, err := sqlx.Connect("postgres", dataSourceName)
+	return ConnectNamed(dataSourceName, DefaultNaming(), DefaultPoolConfig())
+}
+
+// Connects to a destination whose schema or tables carry other names, through
+// the driver and with the pool limits of the pool config
+func ConnectNamed(dataSourceName string, naming Naming, pool PoolConfig) (*Repository, error) {
+	connector, err := pool.connector(dataSourceName)
 	if err != nil {
 		return nil, err
 	}
+	if !naming.IsDefault() {
+		connector = namingConnector{Connector: connector, naming: naming}
+	}
+
+	driverName := "postgres"
+	if pool.Driver == "pgx" {
+		driverName = "pgx"
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), driverName)
+	pool.apply(db.DB)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return &Repository{db}, nil
 }
 
@@ -52,113 +82,323 @@ func (db *Repository) EmployeesAll() (employees []Employee, err error) {
 	return employees, err
 }
 
-func (db *Repository) InsertEmployees(employees []Employee) error {
+// Employees synced by at. created_at is the local time of the first sync,
+// employees synced before it was recorded are included.
+func (db *Repository) EmployeesAt(at time.Time) (employees []Employee, err error) {
+	err = db.Select(&employees, "SELECT * FROM attendance.employees WHERE created_at IS NULL OR created_at <= $1", at.Local())
+	return employees, err
+}
+
+const employeeUpsertBatchSize = 1000
+
+// Inserts new and updates changed employees with batched INSERT ... ON CONFLICT (card)
+// statements in a single transaction, so a sync applies completely or not at all
+func (db *Repository) UpsertEmployees(employees []Employee) error {
 	if len(employees) == 0 {
 		return nil
 	}
-	tx := db.MustBegin()
+
+	// a card may appear once per statement, the last record wins
+	byCard := make(map[string]int, len(employees))
+	unique := make([]Employee, 0, len(employees))
 	t := time.Now().Local().Format("2006-01-02T15:04:05")
 	for _, user := range employees {
-		tx.MustExec("INSERT INTO attendance.employees (firstname, lastname, card, created_at) VALUES ($1, $2, $3, $4)",
-			user.FirstName, user.LastName, user.Card, t)
+		user.CreatedAt = sql.NullString{String: t, Valid: true}
+		if i, ok := byCard[user.Card]; ok {
+			unique[i] = user
+			continue
+		}
+		byCard[user.Card] = len(unique)
+		unique = append(unique, user)
 	}
-	return tx.Commit()
-}
 
-func (db *Repository) UpdateEmployees(employees []Employee) error {
-	if len(employees) == 0 {
-		return nil
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("upserting employees: %w", err)
 	}
-	tx := db.MustBegin()
-	for _, user := range employees {
-		tx.MustExec("UPDATE attendance.employees SET firstname = $1, lastname = $2 WHERE card = $3",
-			user.FirstName, user.LastName, user.Card)
+	defer tx.Rollback()
+
+	for start := 0; start < len(unique); start += employeeUpsertBatchSize {
+		end := start + employeeUpsertBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		_, err := tx.NamedExec(`INSERT INTO attendance.employees AS e (firstname, lastname, card, created_at, department, position, source_name)
+		VALUES (:firstname, :lastname, :card, :created_at, :department, :position, :source_name)
+		ON CONFLICT (card) DO UPDATE SET firstname = EXCLUDED.firstname, lastname = EXCLUDED.lastname,
+			department = EXCLUDED.department, position = EXCLUDED.position,
+			source_name = coalesce(EXCLUDED.source_name, e.source_name)`, unique[start:end])
+		if err != nil {
+			return fmt.Errorf("upserting employees: %w", err)
+		}
 	}
 	return tx.Commit()
 }
 
-func (db *Repository) InsertIntervals(intervals []Interval) error {
+// Deterministic key of an interval: the card and its entry event. A
+// recomputed interval with the same entry replaces the stored one.
+func IntervalKey(card string, entEventID int) string {
+	return fmt.Sprintf("%s:%d", card, entEventID)
+}
+
+const intervalUpsertBatchSize = 1000
+
+// Upserts intervals by their key. Returns the intervals actually created,
+// stored ones are updated in place when their exit or confidence changed.
+// Intervals of a window the run recomputed are stored with ReplaceIntervals.
+func (db *Repository) InsertIntervals(ctx context.Context, intervals []Interval) ([]Interval, error) {
 	if len(intervals) == 0 {
-		return nil
+		return nil, nil
 	}
-	res, err := db.NamedExec(`INSERT INTO attendance.intervals (ent, ext, card, database, ent_event_id, ext_event_id)
-	VALUES (:ent, :ext, :card, :database, :ent_event_id, :ext_event_id) ON CONFLICT DO NOTHING RETURNING *`, intervals)
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("inserting intervals: %w", err)
+		return nil, fmt.Errorf("inserting intervals: %w", err)
 	}
-	ra, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	created, updated, err := upsertIntervals(ctx, tx, keyIntervals(intervals))
 	if err != nil {
-		return fmt.Errorf("inserting intervals: %w", err)
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("inserting intervals: %w", err)
 	}
-	log.Println("inserted", ra, "intervals")
-	return err
+	log.Println("inserted", len(created), "intervals, updated", updated)
+	return created, nil
+}
 
+/*
+ * Upserts the division's intervals paired from since on and deletes the ones
+ * of that window whose key the run didn't produce, e.g. when re-pairing gave a
+ * pair another entry event. Intervals entered before since and imported ones
+ * are kept. Returns the intervals actually created.
+ */
+func (db *Repository) ReplaceIntervals(ctx context.Context, database string, since time.Time, intervals []Interval) ([]Interval, error) {
+	keyed := keyIntervals(intervals)
+	keys := make([]string, 0, len(keyed))
+	for _, interval := range keyed {
+		if interval.Database == database {
+			keys = append(keys, interval.Key)
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("replacing intervals: %w", err)
+	}
+	defer tx.Rollback()
+
+	created, updated, err := upsertIntervals(ctx, tx, keyed)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM attendance.intervals
+	WHERE database = $1 AND ent >= $2 AND source = 'etl' AND NOT (interval_key = ANY($3))`,
+		database, since, pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("deleting stale intervals: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("replacing intervals: %w", err)
+	}
+	deleted, _ := res.RowsAffected()
+	log.Println("inserted", len(created), "intervals, updated", updated, "deleted", deleted)
+	return created, nil
+}
+
+// Sets the key of every interval, of users sharing a card the last one is
+// kept since a statement may affect a key once only
+func keyIntervals(intervals []Interval) []Interval {
+	type key struct {
+		database string
+		key      string
+	}
+	index := make(map[key]int, len(intervals))
+	keyed := make([]Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		interval.Key = IntervalKey(interval.Card, interval.EntEventID)
+		k := key{interval.Database, interval.Key}
+		if i, ok := index[k]; ok {
+			keyed[i] = interval
+			continue
+		}
+		index[k] = len(keyed)
+		keyed = append(keyed, interval)
+	}
+	return keyed
 }
 
-func (db *Repository) InsertEvents(events []entity.Event) error {
+// Upserts keyed intervals in batches, returns the created ones and the
+// number of updated ones
+func upsertIntervals(ctx context.Context, tx *sqlx.Tx, keyed []Interval) ([]Interval, int, error) {
+	created := make([]Interval, 0)
+	updated := 0
+	for start := 0; start < len(keyed); start += intervalUpsertBatchSize {
+		end := start + intervalUpsertBatchSize
+		if end > len(keyed) {
+			end = len(keyed)
+		}
+		batch := keyed[start:end]
+		rows, err := sqlx.NamedQueryContext(ctx, tx, `INSERT INTO attendance.intervals AS i (interval_key, ent, ext, card, database, ent_event_id, ext_event_id, confidence)
+		VALUES (:interval_key, :ent, :ext, :card, :database, :ent_event_id, :ext_event_id, :confidence)
+		ON CONFLICT (database, interval_key) DO UPDATE SET ent = EXCLUDED.ent, ext = EXCLUDED.ext,
+			ext_event_id = EXCLUDED.ext_event_id, confidence = EXCLUDED.confidence
+		WHERE (i.ent, i.ext, i.ext_event_id, i.confidence)
+			IS DISTINCT FROM (EXCLUDED.ent, EXCLUDED.ext, EXCLUDED.ext_event_id, EXCLUDED.confidence)
+		RETURNING database, interval_key, xmax = 0`, batch)
+		if err != nil {
+			return nil, 0, fmt.Errorf("inserting intervals: %w", err)
+		}
+
+		inserted := make(map[string]bool)
+		for rows.Next() {
+			var database, key string
+			var isNew bool
+			if err := rows.Scan(&database, &key, &isNew); err != nil {
+				rows.Close()
+				return nil, 0, fmt.Errorf("inserting intervals: %w", err)
+			}
+			if !isNew {
+				updated++
+				continue
+			}
+			inserted[database+"|"+key] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("inserting intervals: %w", err)
+		}
+		for _, interval := range batch {
+			if inserted[interval.Database+"|"+interval.Key] {
+				created = append(created, interval)
+			}
+		}
+	}
+	return created, updated, nil
+}
+
+func (db *Repository) InsertEvents(ctx context.Context, events []entity.Event) (int64, error) {
 	if len(events) == 0 {
-		return nil
+		return 0, nil
 	}
-	infraEvents := make([]Event, len(events))
-	for i, e := range events {
-		infraEvents[i] = Event{
+	// an id may appear once per statement, the first one with a reader wins
+	byID := make(map[int]int, len(events))
+	infraEvents := make([]Event, 0, len(events))
+	for _, e := range events {
+		event := Event{
 			ID:        e.ID,
 			Card:      e.Card,
 			Timestamp: e.Time,
+			Direction: sql.NullString{String: string(e.ReaderDirection), Valid: e.ReaderDirection != ""},
+			ReaderID:  sql.NullInt32{Int32: int32(e.ReaderID), Valid: e.ReaderID != 0},
+		}
+		if i, ok := byID[e.ID]; ok {
+			if !infraEvents[i].ReaderID.Valid {
+				infraEvents[i] = event
+			}
+			continue
 		}
+		byID[e.ID] = len(infraEvents)
+		infraEvents = append(infraEvents, event)
 	}
-	res, err := db.NamedExec(`INSERT INTO attendance.events (id, card, timestamp)
-	VALUES (:id, :card, :timestamp) ON CONFLICT DO NOTHING`, infraEvents)
+	// events loaded before the source told the door get it filled in, nothing
+	// else of a stored event changes
+	rows, err := db.NamedQueryContext(ctx, `INSERT INTO attendance.events AS ev (id, card, timestamp, direction, reader_id)
+	VALUES (:id, :card, :timestamp, :direction, :reader_id)
+	ON CONFLICT (id) DO UPDATE SET reader_id = EXCLUDED.reader_id
+	WHERE ev.reader_id IS NULL AND EXCLUDED.reader_id IS NOT NULL
+	RETURNING xmax = 0`, infraEvents)
 	if err != nil {
-		return fmt.Errorf("inserting events: %w", err)
+		return 0, fmt.Errorf("inserting events: %w", err)
 	}
-	ra, err := res.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("inserting events: %w", err)
+	defer rows.Close()
+	var inserted int64
+	for rows.Next() {
+		var isNew bool
+		if err := rows.Scan(&isNew); err != nil {
+			return 0, fmt.Errorf("inserting events: %w", err)
+		}
+		if isNew {
+			inserted++
+		}
 	}
-	log.Println("inserted", ra, "events")
-	return nil
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("inserting events: %w", err)
+	}
+	log.Println("inserted", inserted, "events")
+	return inserted, nil
 }
 
+// Inserts employees of new cards and updates those whose name, department or
+// position changed, looked up by card in the employee index
 func (db *Repository) SyncEmployees(deviceUsers []*entity.User) error {
-	existingEmployees, err := db.EmployeesAll()
+	existingEmployees, err := db.EmployeeIndex()
 	if err != nil {
 		return fmt.Errorf("fail to load employees: %w", err)
 	}
 
 	insert := make([]Employee, 0)
 	update := make([]Employee, 0)
+	reissues := make([]CardReissue, 0)
 
 	for _, deviceUser := range deviceUsers {
-		var found bool
 		user := Employee{
-			FirstName: deviceUser.FirstName,
-			LastName:  deviceUser.LastName,
-			Card:      deviceUser.Card,
-		}
-
-		for _, existing := range existingEmployees {
-			if user.Card == existing.Card {
-				found = true
-
-				if user.FirstName != existing.FirstName || user.LastName != existing.LastName {
-					update = append(update, user)
-				}
-
-				break
-			}
+			FirstName:  deviceUser.FirstName,
+			LastName:   deviceUser.LastName,
+			Card:       deviceUser.Card,
+			Department: deviceUser.Department,
+			Position:   deviceUser.Position,
+			SourceName: sql.NullString{String: deviceUser.SourceName, Valid: deviceUser.SourceName != ""},
 		}
 
+		existing, found := existingEmployees[user.Card]
 		if !found {
 			insert = append(insert, user)
+			continue
+		}
+		sourceRenamed := user.SourceName.Valid && user.SourceName != existing.SourceName
+		if user.FirstName != existing.FirstName || user.LastName != existing.LastName ||
+			user.Department != existing.Department || user.Position != existing.Position || sourceRenamed {
+			update = append(update, user)
+		}
+		// only the source tells a new holder, master data, the directory and card
+		// mappings rename the same person. Employees synced before the source name
+		// was kept get it recorded first.
+		if sourceRenamed && existing.SourceName.Valid {
+			reissues = append(reissues, CardReissue{Card: user.Card, Previous: existing, Current: user})
 		}
 	}
 
+	// the history must be written before the name is overwritten
+	err = db.RecordCardReissues(reissues, time.Now())
+	if err != nil {
+		return err
+	}
+	err = db.UpsertEmployees(append(insert, update...))
+	if err != nil {
+		return err
+	}
+	log.Printf("%d cards changed holder\n", len(reissues))
 	log.Printf("inserted %d employees\n", len(insert))
 	log.Printf("updated %d employees\n", len(update))
-	err = db.UpdateEmployees(update)
+	return nil
+}
+
+// Runs reads inside a read-only REPEATABLE READ transaction so every query
+// sees the same snapshot even while another run is writing into the tables
+func (db *Repository) ReadSnapshot(fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
 	if err != nil {
+		return fmt.Errorf("starting snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
 		return err
 	}
-	return db.InsertEmployees(insert)
+	return tx.Commit()
 }
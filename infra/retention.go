@@ -0,0 +1,233 @@
+package infra
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Time column deciding the age and the partition of the rows of the tables
+// retention and the lake export apply to
+var tableTimeColumns = map[string]string{
+	"events":    "timestamp",
+	"intervals": "ent",
+}
+
+// Years of rows kept in each table, older rows are archived and deleted.
+// Tables missing from the policy are kept forever.
+type RetentionPolicy map[string]int
+
+// Parses a spec like events=3,intervals=5
+func ParseRetentionPolicy(spec string) (RetentionPolicy, error) {
+	policy := RetentionPolicy{}
+	if strings.TrimSpace(spec) == "" {
+		return policy, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		table, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention entry %q, expected table=years", pair)
+		}
+		if _, ok := tableTimeColumns[table]; !ok {
+			return nil, fmt.Errorf("retention is not supported for table %s", table)
+		}
+		years, err := strconv.Atoi(value)
+		if err != nil || years < 1 {
+			return nil, fmt.Errorf("invalid retention years for %s: %q", table, value)
+		}
+		policy[table] = years
+	}
+	return policy, nil
+}
+
+// Rows of one table and month moved out of the destination
+type ArchivedChunk struct {
+	Table string
+	Month time.Time
+	Rows  int
+	// empty on dry runs
+	Path string
+}
+
+// Archives and deletes whole months older than the policy allows, the oldest
+// month first. A month is deleted in the transaction it was read in, after
+// its archive is safely on disk, so an interrupted run never loses rows.
+func (db *Repository) ApplyRetention(ctx context.Context, policy RetentionPolicy, dir string, now time.Time, dryRun bool) ([]ArchivedChunk, error) {
+	tables := make([]string, 0, len(policy))
+	for table := range policy {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	chunks := make([]ArchivedChunk, 0)
+	for _, table := range tables {
+		column := pq.QuoteIdentifier(tableTimeColumns[table])
+		cutoff := monthStart(now.AddDate(-policy[table], 0, 0))
+
+		var oldest sql.NullTime
+		err := db.GetContext(ctx, &oldest, fmt.Sprintf(`SELECT min(%s) FROM attendance.%s WHERE %s < $1`, column, table, column), cutoff)
+		if err != nil {
+			return chunks, fmt.Errorf("finding rows to archive in %s: %w", table, err)
+		}
+		if !oldest.Valid {
+			continue
+		}
+		for month := monthStart(oldest.Time); month.Before(cutoff); month = month.AddDate(0, 1, 0) {
+			if err := ctx.Err(); err != nil {
+				return chunks, err
+			}
+			chunk, err := db.archiveMonth(ctx, table, column, month, dir, now, dryRun)
+			if err != nil {
+				return chunks, err
+			}
+			if chunk.Rows > 0 {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	return chunks, nil
+}
+
+func (db *Repository) archiveMonth(ctx context.Context, table, column string, month time.Time, dir string, now time.Time, dryRun bool) (ArchivedChunk, error) {
+	chunk := ArchivedChunk{Table: table, Month: month}
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return chunk, err
+	}
+	defer tx.Rollback()
+
+	where := fmt.Sprintf(`%s >= $1 AND %s < $2`, column, column)
+	rows, err := tx.QueryxContext(ctx, fmt.Sprintf(`SELECT * FROM attendance.%s WHERE %s ORDER BY %s`, table, where, column),
+		month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return chunk, fmt.Errorf("reading %s of %s: %w", table, month.Format("2006-01"), err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return chunk, err
+	}
+	var archive *RetentionArchiveWriter
+	if !dryRun {
+		// months archived before keep their file, late rows of the month get another
+		name := fmt.Sprintf("%s-%s.%s", table, month.Format("2006-01"), now.UTC().Format(archiveTimeLayout))
+		archive, err = NewRetentionArchiveWriter(dir, name, columns)
+		if err != nil {
+			return chunk, err
+		}
+		defer archive.Close(false)
+	}
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return chunk, err
+		}
+		chunk.Rows++
+		if archive != nil {
+			if err := archive.Write(values); err != nil {
+				return chunk, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return chunk, err
+	}
+	if dryRun || chunk.Rows == 0 {
+		return chunk, nil
+	}
+
+	if err := archive.Close(true); err != nil {
+		return chunk, fmt.Errorf("writing %s archive: %w", table, err)
+	}
+	chunk.Path = archive.path
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM attendance.%s WHERE %s`, table, where), month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return chunk, fmt.Errorf("deleting archived %s: %w", table, err)
+	}
+	return chunk, tx.Commit()
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Gzip CSV of archived rows with a header of the table columns
+type RetentionArchiveWriter struct {
+	file   *os.File
+	gz     *gzip.Writer
+	csv    *csv.Writer
+	path   string
+	closed bool
+}
+
+func NewRetentionArchiveWriter(dir, name string, columns []string) (*RetentionArchiveWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating archive dir: %w", err)
+	}
+	path := filepath.Join(dir, name+".csv.gz")
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, fmt.Errorf("creating archive: %w", err)
+	}
+	gz := gzip.NewWriter(f)
+	w := &RetentionArchiveWriter{file: f, gz: gz, csv: csv.NewWriter(gz), path: path}
+	if err := w.csv.Write(columns); err != nil {
+		w.Close(false)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RetentionArchiveWriter) Write(values []any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatArchiveValue(v)
+	}
+	return w.csv.Write(record)
+}
+
+// Publishes the archive synced to disk when keep is set and discards it otherwise
+func (w *RetentionArchiveWriter) Close(keep bool) error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.csv.Flush()
+	csvErr := w.csv.Error()
+	gzErr := w.gz.Close()
+	syncErr := w.file.Sync()
+	fileErr := w.file.Close()
+	if !keep {
+		return os.Remove(w.file.Name())
+	}
+	for _, err := range []error{csvErr, gzErr, syncErr, fileErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
+
+func formatArchiveValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
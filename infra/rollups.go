@@ -0,0 +1,49 @@
+package infra
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+// Recomputes the dashboard rollups of a division from since on: closed hours
+// per card per day and month, and people inside per hour. Open intervals count
+// as inside for at most a workshift, forgotten exits don't inflate headcount.
+func (db *Repository) RefreshRollups(database string, since time.Time) error {
+	day := since.Format("2006-01-02")
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("refreshing rollups: %w", err)
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM attendance.rollup_daily_hours WHERE database = $1 AND day >= $2::date`,
+		`INSERT INTO attendance.rollup_daily_hours (database, card, day, hours, intervals)
+		SELECT database, card, ent::date, sum(extract(epoch FROM ext - ent)) / 3600, count(*)
+		FROM attendance.intervals
+		WHERE database = $1 AND ent >= $2::date AND ext IS NOT NULL
+		GROUP BY 1, 2, 3`,
+		`DELETE FROM attendance.rollup_monthly_hours WHERE database = $1 AND month >= date_trunc('month', $2::date)`,
+		`INSERT INTO attendance.rollup_monthly_hours (database, card, month, hours, days)
+		SELECT database, card, date_trunc('month', day)::date, sum(hours), count(*)
+		FROM attendance.rollup_daily_hours
+		WHERE database = $1 AND day >= date_trunc('month', $2::date)
+		GROUP BY 1, 2, 3`,
+		`DELETE FROM attendance.rollup_hourly_headcount WHERE database = $1 AND hour >= $2::date`,
+		fmt.Sprintf(`INSERT INTO attendance.rollup_hourly_headcount (database, hour, headcount)
+		SELECT $1, h.hour, count(DISTINCT i.card)
+		FROM generate_series($2::date::timestamp, date_trunc('hour', localtimestamp), interval '1 hour') AS h(hour)
+		JOIN attendance.intervals i ON i.database = $1 AND i.ent >= $2::date - interval '%[1]d hours'
+			AND i.ent < h.hour + interval '1 hour'
+			AND coalesce(i.ext, least(i.ent + interval '%[1]d hours', localtimestamp)) > h.hour
+		GROUP BY 1, 2`, entity.IDEAL_WORKSHIFT_DUR),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, database, day); err != nil {
+			return fmt.Errorf("refreshing rollups: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,280 @@
+package infra
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/xuri/excelize/v2"
+)
+
+// Header names accepted for each roster column
+var rosterColumns = map[string][]string{
+	"card":  {"card", "cardno", "card_number"},
+	"day":   {"day", "date"},
+	"shift": {"shift", "shift_name"},
+	"start": {"start", "start_time", "from"},
+	"end":   {"end", "end_time", "to"},
+	"hours": {"hours", "planned_hours"},
+}
+
+// Day layouts of HR spreadsheets, workbook date cells are read as serial numbers
+var dayLayouts = []string{"2006-01-02", "02.01.2006"}
+
+// Row of the roster comparison with the employee's name
+type RosterComparisonRow struct {
+	entity.RosterComparison
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+}
+
+func ReadRosterCSV(input io.Reader) ([]entity.RosterEntry, error) {
+	reader := csv.NewReader(input)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading roster file: %w", err)
+	}
+	return parseRoster(rows)
+}
+
+// Reads the roster from the first sheet of a workbook
+func ReadRosterXLSX(input io.Reader) ([]entity.RosterEntry, error) {
+	f, err := excelize.OpenReader(input)
+	if err != nil {
+		return nil, fmt.Errorf("opening roster workbook: %w", err)
+	}
+	defer f.Close()
+	// formatted values depend on the locale of the workbook, raw ones don't
+	rows, err := f.GetRows(f.GetSheetName(0), excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, fmt.Errorf("reading roster workbook: %w", err)
+	}
+	return parseRoster(rows)
+}
+
+// Roster rows with a header row. Card and day are required, so is either the
+// hours or the start and end of the shift.
+func parseRoster(rows [][]string) ([]entity.RosterEntry, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("roster file is empty")
+	}
+	index := make(map[string]int)
+	for i, field := range rows[0] {
+		field = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(field, "\ufeff")))
+		for column, names := range rosterColumns {
+			for _, name := range names {
+				if field == name {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for _, column := range []string{"card", "day"} {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("roster file has no %s column", column)
+		}
+	}
+	_, hours := index["hours"]
+	_, start := index["start"]
+	_, end := index["end"]
+	if !hours && !(start && end) {
+		return nil, errors.New("roster file needs an hours column or start and end columns")
+	}
+
+	entries := make([]entity.RosterEntry, 0, len(rows)-1)
+	seen := make(map[string]int)
+	for i, record := range rows[1:] {
+		line := i + 2
+		field := func(column string) string {
+			i, ok := index[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+
+		e := entity.RosterEntry{Card: field("card"), Shift: field("shift"), Start: field("start"), End: field("end")}
+		day, err := parseDay(field("day"))
+		if err != nil {
+			return nil, fmt.Errorf("roster file line %d: %w", line, err)
+		}
+		e.Day = day
+		if h := field("hours"); h != "" {
+			e.Hours, err = strconv.ParseFloat(strings.Replace(h, ",", ".", 1), 64)
+			if err != nil {
+				return nil, fmt.Errorf("roster file line %d: invalid hours %q", line, h)
+			}
+		}
+		if err := e.Normalize(); err != nil {
+			return nil, fmt.Errorf("roster file line %d: %w", line, err)
+		}
+
+		key := e.Card + "|" + e.Day.Format("2006-01-02")
+		if previous, ok := seen[key]; ok {
+			return nil, fmt.Errorf("roster file line %d: card %s is already planned for %s on line %d",
+				line, e.Card, e.Day.Format("2006-01-02"), previous)
+		}
+		seen[key] = line
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseDay(value string) (time.Time, error) {
+	for _, layout := range dayLayouts {
+		if day, err := time.Parse(layout, value); err == nil {
+			return day, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		day, err := excelize.ExcelDateToTime(serial, false)
+		if err == nil {
+			return day.Truncate(24 * time.Hour), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid day %q, expected YYYY-MM-DD or DD.MM.YYYY", value)
+}
+
+// Upserts the planned shifts by card and day
+func (db *Repository) ImportRoster(entries []entity.RosterEntry, at time.Time) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("importing roster: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, e := range entries {
+		_, err := tx.Exec(`INSERT INTO attendance.roster (card, day, shift, start_time, end_time, hours, imported_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (card, day) DO UPDATE SET shift = excluded.shift, start_time = excluded.start_time,
+			end_time = excluded.end_time, hours = excluded.hours, imported_at = excluded.imported_at`,
+			e.Card, e.Day, e.Shift, e.Start, e.End, e.Hours, at)
+		if err != nil {
+			return fmt.Errorf("importing roster entry of %s: %w", e.Card, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Planned shifts of days in [from, to)
+func (db *Repository) Roster(from, to time.Time) (roster []entity.RosterEntry, err error) {
+	err = db.Select(&roster, `SELECT card, day, shift, start_time, end_time, hours
+	FROM attendance.roster WHERE day >= $1 AND day < $2 ORDER BY day, card`, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("loading roster: %w", err)
+	}
+	return roster, nil
+}
+
+// Planned against worked hours of days in [from, to), worked hours rounded by
+// the policy. Differences of at least threshold hours are discrepancies.
+func (db *Repository) RosterComparison(from, to time.Time, threshold float64, policy entity.FlowPolicy) ([]RosterComparisonRow, error) {
+	roster, err := db.Roster(from, to)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := db.DailyHours(from, to, policy)
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]map[time.Time]float64)
+	for _, h := range hours {
+		day, err := time.Parse("2006-01-02", h.Day)
+		if err != nil {
+			return nil, err
+		}
+		if actual[h.Card] == nil {
+			actual[h.Card] = make(map[time.Time]float64)
+		}
+		actual[h.Card][day] += h.Hours
+	}
+	employees, err := db.EmployeesAll()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]Employee, len(employees))
+	for _, e := range employees {
+		names[e.Card] = e
+	}
+
+	comparison := entity.CompareRoster(roster, actual, threshold)
+	rows := make([]RosterComparisonRow, len(comparison))
+	for i, c := range comparison {
+		rows[i] = RosterComparisonRow{RosterComparison: c, FirstName: names[c.Card].FirstName, LastName: names[c.Card].LastName}
+	}
+	return rows, nil
+}
+
+func WriteRosterComparisonCSV(w io.Writer, rows []RosterComparisonRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"day", "card", "firstname", "lastname", "shift", "planned", "actual", "difference", "discrepancy"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Day.Format("2006-01-02"), r.Card, r.FirstName, r.LastName, r.Shift,
+			strconv.FormatFloat(roundHours(r.Planned), 'f', -1, 64),
+			strconv.FormatFloat(roundHours(r.Actual), 'f', -1, 64),
+			strconv.FormatFloat(roundHours(r.Difference), 'f', -1, 64),
+			strconv.FormatBool(r.Discrepancy),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes the comparison as a workbook, rows with a discrepancy are filled red
+// when more hours are missing than planned and amber when more were worked
+func WriteRosterComparisonXLSX(w io.Writer, rows []RosterComparisonRow, locale entity.Locale) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+
+	missing, err := f.NewStyle(&excelize.Style{Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"F4CCCC"}}})
+	if err != nil {
+		return err
+	}
+	extra, err := f.NewStyle(&excelize.Style{Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FCE5CD"}}})
+	if err != nil {
+		return err
+	}
+
+	header := []interface{}{
+		locale.T("roster.day"), locale.T("roster.card"), locale.T("roster.employee"), locale.T("roster.shift"),
+		locale.T("roster.planned"), locale.T("roster.actual"), locale.T("roster.difference"),
+	}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return fmt.Errorf("writing roster comparison header: %w", err)
+	}
+	for i, r := range rows {
+		row := i + 2
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		values := []interface{}{
+			r.Day.Format("02.01.2006"), r.Card, strings.TrimSpace(r.LastName + " " + r.FirstName), r.Shift,
+			roundHours(r.Planned), roundHours(r.Actual), roundHours(r.Difference),
+		}
+		if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+			return fmt.Errorf("writing roster comparison row: %w", err)
+		}
+		if !r.Discrepancy {
+			continue
+		}
+		style := extra
+		if r.Difference < 0 {
+			style = missing
+		}
+		last, _ := excelize.CoordinatesToCellName(len(values), row)
+		if err := f.SetCellStyle(sheet, cell, last, style); err != nil {
+			return fmt.Errorf("writing roster comparison row: %w", err)
+		}
+	}
+	return f.Write(w)
+}
@@ -0,0 +1,134 @@
+package infra
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3 compatible bucket, AWS or MinIO, receiving reports and exports
+type S3Config struct {
+	// https://s3.eu-central-1.amazonaws.com or the MinIO address
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// key prefix template with {site}, {year}, {month} and {day}
+	Prefix string
+}
+
+// Reads S3_ENDPOINT, S3_REGION, S3_BUCKET, S3_ACCESS_KEY_ID, S3_PREFIX and the
+// S3_SECRET_ACCESS_KEY secret. Uploads are disabled when S3_BUCKET is empty.
+func S3ConfigFromEnv(secrets SecretProvider) (S3Config, bool, error) {
+	config := S3Config{
+		Endpoint:     os.Getenv("S3_ENDPOINT"),
+		Region:       os.Getenv("S3_REGION"),
+		Bucket:       os.Getenv("S3_BUCKET"),
+		AccessKey:    os.Getenv("S3_ACCESS_KEY_ID"),
+		SessionToken: os.Getenv("S3_SESSION_TOKEN"),
+		Prefix:       os.Getenv("S3_PREFIX"),
+	}
+	if config.Bucket == "" {
+		return config, false, nil
+	}
+	if config.Region == "" {
+		// MinIO accepts any region, us-east-1 is its default
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	secretKey, err := secrets.Secret("S3_SECRET_ACCESS_KEY")
+	if err != nil {
+		return config, false, err
+	}
+	config.SecretKey = secretKey
+	return config, true, nil
+}
+
+// Key prefix for artifacts of the site covering the period starting at, so a
+// report of March written in April is filed under March
+func (c S3Config) KeyPrefix(site string, at time.Time) string {
+	prefix := strings.NewReplacer(
+		"{site}", site,
+		"{year}", at.Format("2006"),
+		"{month}", at.Format("01"),
+		"{day}", at.Format("02"),
+	).Replace(c.Prefix)
+	return strings.Trim(prefix, "/")
+}
+
+// Puts the file under key with a path style request, which both AWS and MinIO
+// accept regardless of the bucket name
+func (c S3Config) Upload(key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("hashing %s: %w", file, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid S3_ENDPOINT: %w", err)
+	}
+	objectPath := path.Join("/", c.Bucket, key)
+	endpoint.Path = objectPath
+	endpoint.RawPath = s3EscapePath(objectPath)
+
+	req, err := http.NewRequest(http.MethodPut, endpoint.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Host", endpoint.Host)
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	signAWSRequest(req, "s3", c.Region, c.AccessKey, c.SecretKey, payloadHash, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("uploading %s: unexpected status %s: %s", key, resp.Status, body)
+	}
+	return nil
+}
+
+// S3 signs the path with every byte but the unreserved ones and the slashes escaped
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package infra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolves secrets such as POSTGRES_PASSWORD by name
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+// Returned by providers holding no secret of the name
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Resolves a secret the configuration may leave out, such as the password of a
+// server accepting anonymous clients. Missing secrets are empty as with env,
+// fetch and permission failures are still errors.
+func OptionalSecret(secrets SecretProvider, name string) (string, error) {
+	value, err := secrets.Secret(name)
+	if errors.Is(err, ErrSecretNotFound) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Selects the provider from SECRETS_PROVIDER: env (default), file, vault or aws
+func NewSecretProviderFromEnv() (SecretProvider, error) {
+	switch provider := os.Getenv("SECRETS_PROVIDER"); provider {
+	case "", "env":
+		return EnvSecrets{}, nil
+	case "file":
+		return FileSecrets{Dir: os.Getenv("SECRETS_DIR")}, nil
+	case "vault":
+		return newCachedSecrets(&VaultSecrets{
+			Addr:   strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+			Token:  os.Getenv("VAULT_TOKEN"),
+			Path:   os.Getenv("VAULT_SECRET_PATH"),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}), nil
+	case "aws":
+		return newCachedSecrets(&AWSSecrets{
+			Region:       os.Getenv("AWS_REGION"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			SecretID:     os.Getenv("AWS_SECRET_ID"),
+			client:       &http.Client{Timeout: 10 * time.Second},
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER: %s", provider)
+	}
+}
+
+type EnvSecrets struct{}
+
+func (EnvSecrets) Secret(name string) (string, error) {
+	return os.Getenv(name), nil
+}
+
+// One file per secret named after it, as mounted by docker and kubernetes secrets
+type FileSecrets struct {
+	Dir string
+}
+
+func (s FileSecrets) Secret(name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("reading secret %s: %w", name, ErrSecretNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Remote providers hold all secrets in one document which is fetched once per process
+type secretDocument interface {
+	fetch() (map[string]string, error)
+}
+
+type cachedSecrets struct {
+	source secretDocument
+	once   sync.Once
+	values map[string]string
+	err    error
+}
+
+func newCachedSecrets(source secretDocument) *cachedSecrets {
+	return &cachedSecrets{source: source}
+}
+
+func (s *cachedSecrets) Secret(name string) (string, error) {
+	s.once.Do(func() {
+		s.values, s.err = s.source.fetch()
+	})
+	if s.err != nil {
+		return "", s.err
+	}
+	value, ok := s.values[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// Reads a KV version 2 secret, Path is the API path such as secret/data/attendance
+type VaultSecrets struct {
+	Addr   string
+	Token  string
+	Path   string
+	client *http.Client
+}
+
+func (s *VaultSecrets) fetch() (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/%s", s.Addr, s.Path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading vault secret: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading vault secret: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding vault secret: %w", err)
+	}
+	return body.Data.Data, nil
+}
@@ -0,0 +1,116 @@
+package infra
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reads a JSON key/value secret from AWS Secrets Manager
+type AWSSecrets struct {
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	SecretID     string
+	client       *http.Client
+}
+
+func (s *AWSSecrets) fetch() (map[string]string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": s.SecretID})
+	if err != nil {
+		return nil, err
+	}
+
+	host := fmt.Sprintf("secretsmanager.%s.amazonaws.com", s.Region)
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	s.sign(req, host, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading aws secret: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading aws secret: unexpected status %s", resp.Status)
+	}
+
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decoding aws secret: %w", err)
+	}
+	values := make(map[string]string)
+	if err := json.Unmarshal([]byte(out.SecretString), &values); err != nil {
+		return nil, fmt.Errorf("aws secret %s is not a key/value JSON document: %w", s.SecretID, err)
+	}
+	return values, nil
+}
+
+func (s *AWSSecrets) sign(req *http.Request, host string, body []byte, now time.Time) {
+	req.Header.Set("Host", host)
+	payloadHash := sha256.Sum256(body)
+	signAWSRequest(req, "secretsmanager", s.Region, s.AccessKey, s.SecretKey, hex.EncodeToString(payloadHash[:]), now)
+}
+
+// Signature Version 4 of the request, see
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html.
+// The Host header must be set, query parameters are not signed.
+func signAWSRequest(req *http.Request, service, region, accessKey, secretKey, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(req.Header.Get(name)))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,58 @@
+package infra
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubDocument struct {
+	values map[string]string
+	err    error
+}
+
+func (d stubDocument) fetch() (map[string]string, error) {
+	return d.values, d.err
+}
+
+func TestOptionalSecret(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "MDB_PASSWORD"), []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "API_KEYS"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	unreachable := newCachedSecrets(stubDocument{err: errors.New("connection refused")})
+	document := newCachedSecrets(stubDocument{values: map[string]string{"MQTT_PASSWORD": "p"}})
+
+	tests := []struct {
+		name    string
+		secrets SecretProvider
+		secret  string
+		value   string
+		missing bool
+		failed  bool
+	}{
+		{"file present", FileSecrets{Dir: dir}, "MDB_PASSWORD", "secret", false, false},
+		{"file missing", FileSecrets{Dir: dir}, "NATS_TOKEN", "", true, false},
+		{"file unreadable", FileSecrets{Dir: dir}, "API_KEYS", "", false, true},
+		{"document key present", document, "MQTT_PASSWORD", "p", false, false},
+		{"document key missing", document, "NATS_TOKEN", "", true, false},
+		{"document fetch failed", unreachable, "MQTT_PASSWORD", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.secrets.Secret(tt.secret)
+			value, optionalErr := OptionalSecret(tt.secrets, tt.secret)
+
+			assert.Equal(t, tt.value, value)
+			assert.Equal(t, tt.missing, errors.Is(err, ErrSecretNotFound))
+			assert.Equal(t, tt.missing || tt.failed, err != nil)
+			assert.Equal(t, tt.failed, optionalErr != nil)
+		})
+	}
+}
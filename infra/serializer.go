@@ -2,22 +2,34 @@ package infra
 
 import (
 	"encoding/csv"
+	"io"
 	"log"
 	"strings"
 )
 
 type ParserCallback[C any] func(record []string, fieldIndex map[string]int) (C, error)
 
+// Maps the column index of a header to the names the parser reads, nil keeps
+// the header names
+type HeaderMapper func(header map[string]int) (map[string]int, error)
+
 func SerializeCSVInput[C any](input string, cb ParserCallback[C]) (result []C, err error) {
+	return SerializeCSVInputMapped(input, nil, cb)
+}
+
+func SerializeCSVInputMapped[C any](input string, mapper HeaderMapper, cb ParserCallback[C]) (result []C, err error) {
 	data, err := csv.NewReader(strings.NewReader(input)).ReadAll()
 	if err != nil {
 		log.Println("error parsing csv: ", err)
 		return nil, err
 	}
 
-	columnNamesIndex := make(map[string]int)
-	for i, field := range data[0] {
-		columnNamesIndex[field] = i
+	if len(data) == 0 {
+		return nil, nil
+	}
+	columnNamesIndex, err := mapHeader(data[0], mapper)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, line := range data[1:] {
@@ -31,3 +43,69 @@ func SerializeCSVInput[C any](input string, cb ParserCallback[C]) (result []C, e
 
 	return result, nil
 }
+
+const DefaultStreamBatchSize = 5000
+
+// Reads csv records one by one and hands parsed elements to sink in batches
+func StreamCSVInput[C any](input io.Reader, cb ParserCallback[C], batchSize int, sink func([]C) error) error {
+	return StreamCSVInputMapped(input, nil, cb, batchSize, sink)
+}
+
+func StreamCSVInputMapped[C any](input io.Reader, mapper HeaderMapper, cb ParserCallback[C], batchSize int, sink func([]C) error) error {
+	reader := csv.NewReader(input)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		log.Println("error parsing csv: ", err)
+		return err
+	}
+
+	columnNamesIndex, err := mapHeader(header, mapper)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]C, 0, batchSize)
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println("error parsing csv: ", err)
+			return err
+		}
+
+		element, err := cb(line, columnNamesIndex)
+		if err != nil {
+			log.Println("parsing error:", err)
+			continue
+		}
+
+		batch = append(batch, element)
+		if len(batch) >= batchSize {
+			if err := sink(batch); err != nil {
+				return err
+			}
+			batch = make([]C, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		return sink(batch)
+	}
+	return nil
+}
+
+func mapHeader(header []string, mapper HeaderMapper) (map[string]int, error) {
+	columnNamesIndex := make(map[string]int)
+	for i, field := range header {
+		columnNamesIndex[field] = i
+	}
+	if mapper == nil {
+		return columnNamesIndex, nil
+	}
+	return mapper(columnNamesIndex)
+}
@@ -0,0 +1,173 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const (
+	spoolPrefix    = "events-"
+	spoolSuffix    = ".csv"
+	spoolDayLayout = "2006-01-02"
+)
+
+var spoolHeader = []string{"id", "time", "card_no", "event_point_name", "state", "event_type", "event_point_id"}
+
+// Source of badge events streamed in batches, the MDB exporter is one
+type EventSource interface {
+	StreamEvents(ctx context.Context, selectFor, batchSize int, sink func([]entity.Event) error) error
+}
+
+// Derived event ids have bit 62 set. Ids of MDB and other numeric sources are
+// 32 bit, so derived ones never take theirs.
+const derivedEventIDBase = 1 << 62
+
+// Event id for sources without numeric ids of their own, stable for the same
+// parts so redelivered events keep their id. 62 bits of hash make collisions
+// between derived ids negligible.
+func DerivedEventID(parts ...string) int {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(parts, "|")))
+	return int(h.Sum64()&(derivedEventIDBase-1) | derivedEventIDBase)
+}
+
+// Events of sources without a database of their own, such as MQTT turnstiles,
+// kept in one CSV file per day in the acc_monitor_log layout of mdb-export
+type EventSpool struct {
+	Dir string
+}
+
+func NewEventSpool(dir string) (*EventSpool, error) {
+	if dir == "" {
+		return nil, errors.New("EVENT_SPOOL_DIR is not set")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating event spool: %w", err)
+	}
+	return &EventSpool{Dir: dir}, nil
+}
+
+func (s *EventSpool) dayPath(day time.Time) string {
+	return filepath.Join(s.Dir, spoolPrefix+day.Format(spoolDayLayout)+spoolSuffix)
+}
+
+// Appends events to the files of their days, they are synced to disk on return
+func (s *EventSpool) Append(events []entity.Event) error {
+	byDay := make(map[string][]entity.Event)
+	for _, event := range events {
+		path := s.dayPath(event.Time)
+		byDay[path] = append(byDay[path], event)
+	}
+	for path, dayEvents := range byDay {
+		if err := appendSpoolFile(path, dayEvents); err != nil {
+			return fmt.Errorf("appending to event spool: %w", err)
+		}
+	}
+	return nil
+}
+
+// Rows are appended with a single write, a reader in another process sees
+// complete flushes only. The write is synced before returning, callers
+// acknowledge the events to their sender afterwards.
+func appendSpoolFile(path string, events []entity.Event) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if info.Size() == 0 {
+		w.Write(spoolHeader)
+	}
+	for _, event := range events {
+		state := ""
+		switch event.ReaderDirection {
+		case entity.EventTypeEnt:
+			state = "0"
+		case entity.EventTypeExt:
+			state = "1"
+		}
+		w.Write([]string{
+			strconv.Itoa(event.ID), event.Time.Format("01/02/06 15:04:05"), event.Card,
+			event.PointName, state, strconv.Itoa(event.EventType), strconv.Itoa(event.ReaderID),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Streams spooled events of the last selectFor months, days in order
+func (s *EventSpool) StreamEvents(ctx context.Context, selectFor, batchSize int, sink func([]entity.Event) error) error {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return fmt.Errorf("listing event spool: %w", err)
+	}
+
+	from := time.Now().AddDate(0, -(selectFor + 1), 0)
+	days := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, spoolPrefix) || !strings.HasSuffix(name, spoolSuffix) {
+			continue
+		}
+		day, err := time.Parse(spoolDayLayout, strings.TrimSuffix(strings.TrimPrefix(name, spoolPrefix), spoolSuffix))
+		if err != nil || day.AddDate(0, 0, 1).Before(from) {
+			continue
+		}
+		days = append(days, filepath.Join(s.Dir, name))
+	}
+	sort.Strings(days)
+
+	for _, path := range days {
+		if err := s.streamFile(ctx, path, from, batchSize, sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EventSpool) streamFile(ctx context.Context, path string, from time.Time, batchSize int, sink func([]entity.Event) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading event spool: %w", err)
+	}
+	defer f.Close()
+
+	return StreamCSVInput(f, entity.NewEventFromDBRecord, batchSize, func(batch []entity.Event) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch = entity.SelectEventsSince(batch, from)
+		if len(batch) == 0 {
+			return nil
+		}
+		return sink(batch)
+	})
+}
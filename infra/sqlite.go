@@ -0,0 +1,284 @@
+package infra
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	_ "modernc.org/sqlite"
+)
+
+const DestinationDriverSQLite = "sqlite"
+
+// Rows are marked pushed = 0 until the central destination has them
+const sqliteSchema = `
+PRAGMA journal_mode = WAL;
+
+CREATE TABLE IF NOT EXISTS employees (
+	card text PRIMARY KEY,
+	firstname text NOT NULL,
+	lastname text NOT NULL,
+	department text NOT NULL DEFAULT '',
+	position text NOT NULL DEFAULT '',
+	updated_at datetime NOT NULL,
+	revision integer NOT NULL DEFAULT 0,
+	pushed integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id integer PRIMARY KEY,
+	card text NOT NULL,
+	timestamp datetime NOT NULL,
+	direction text,
+	reader_id integer,
+	pushed integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS intervals (
+	ent text NOT NULL,
+	ext text,
+	card text NOT NULL,
+	database text NOT NULL,
+	ent_event_id integer NOT NULL,
+	ext_event_id integer,
+	confidence real,
+	pushed integer NOT NULL DEFAULT 0,
+	PRIMARY KEY (database, ent_event_id)
+);
+
+CREATE INDEX IF NOT EXISTS events_pushed_idx ON events (pushed, id);
+CREATE INDEX IF NOT EXISTS intervals_pushed_idx ON intervals (pushed);
+`
+
+// Local destination for small sites without Postgres. It keeps employees, events
+// and intervals until Push forwards them to the central destination.
+type SQLiteStore struct {
+	*sqlx.DB
+}
+
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite destination needs SQLITE_PATH")
+	}
+	db, err := sqlx.Connect("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite destination: %w", err)
+	}
+	// sqlite has a single writer, one connection avoids SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating sqlite schema: %w", err)
+	}
+	// files created before events kept their door
+	var readerColumn bool
+	if err := db.Get(&readerColumn, "SELECT count(*) > 0 FROM pragma_table_info('events') WHERE name = 'reader_id'"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating sqlite schema: %w", err)
+	}
+	if !readerColumn {
+		if _, err := db.Exec("ALTER TABLE events ADD COLUMN reader_id integer"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating sqlite schema: %w", err)
+		}
+	}
+	return &SQLiteStore{db}, nil
+}
+
+// Upserts employees by card, changed ones are pushed again
+func (s *SQLiteStore) SyncEmployees(users []*entity.User) error {
+	tx, err := s.Beginx()
+	if err != nil {
+		return fmt.Errorf("syncing employees: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, user := range users {
+		_, err := tx.Exec(`INSERT INTO employees (card, firstname, lastname, department, position, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (card) DO UPDATE SET firstname = excluded.firstname, lastname = excluded.lastname,
+			department = excluded.department, position = excluded.position,
+			updated_at = excluded.updated_at, revision = employees.revision + 1, pushed = 0
+		WHERE firstname <> excluded.firstname OR lastname <> excluded.lastname
+			OR department <> excluded.department OR position <> excluded.position`,
+			user.Card, user.FirstName, user.LastName, user.Department, user.Position, now)
+		if err != nil {
+			return fmt.Errorf("syncing employees: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) InsertEvents(events []entity.Event) (int64, error) {
+	tx, err := s.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("inserting events: %w", err)
+	}
+	defer tx.Rollback()
+
+	var inserted int64
+	for _, e := range events {
+		readerID := sql.NullInt32{Int32: int32(e.ReaderID), Valid: e.ReaderID != 0}
+		res, err := tx.Exec(`INSERT INTO events (id, card, timestamp, direction, reader_id) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING`,
+			e.ID, e.Card, e.Time, sql.NullString{String: string(e.ReaderDirection), Valid: e.ReaderDirection != ""}, readerID)
+		if err != nil {
+			return 0, fmt.Errorf("inserting events: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			inserted += n
+			continue
+		}
+		// a stored event without its door gets it and is pushed again
+		if readerID.Valid {
+			_, err := tx.Exec("UPDATE events SET reader_id = $1, pushed = 0 WHERE id = $2 AND reader_id IS NULL", readerID, e.ID)
+			if err != nil {
+				return 0, fmt.Errorf("inserting events: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("inserting events: %w", err)
+	}
+	log.Println("inserted", inserted, "events")
+	return inserted, nil
+}
+
+// Changed intervals are updated and pushed again
+func (s *SQLiteStore) InsertIntervals(intervals []Interval) (int64, error) {
+	tx, err := s.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("inserting intervals: %w", err)
+	}
+	defer tx.Rollback()
+
+	var inserted int64
+	for _, interval := range intervals {
+		res, err := tx.NamedExec(`INSERT INTO intervals (ent, ext, card, database, ent_event_id, ext_event_id, confidence)
+		VALUES (:ent, :ext, :card, :database, :ent_event_id, :ext_event_id, :confidence)
+		ON CONFLICT (database, ent_event_id) DO UPDATE SET ent = excluded.ent, ext = excluded.ext,
+			ext_event_id = excluded.ext_event_id, confidence = excluded.confidence, pushed = 0
+		WHERE (intervals.ent, intervals.ext, intervals.ext_event_id, intervals.confidence)
+			IS NOT (excluded.ent, excluded.ext, excluded.ext_event_id, excluded.confidence)`, interval)
+		if err != nil {
+			return 0, fmt.Errorf("inserting intervals: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		inserted += n
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("inserting intervals: %w", err)
+	}
+	log.Println("inserted", inserted, "intervals")
+	return inserted, nil
+}
+
+type PushResult struct {
+	Employees int
+	Events    int
+	Intervals int
+}
+
+// Forwards everything not pushed yet to the central destination in batches.
+// A batch is marked pushed only after the destination accepted it, so an
+// interrupted push resumes where it stopped; the destination ignores duplicates.
+func (s *SQLiteStore) Push(ctx context.Context, dest *Repository, batchSize int) (PushResult, error) {
+	var result PushResult
+	if batchSize < 1 {
+		batchSize = DefaultStreamBatchSize
+	}
+
+	var employees []struct {
+		Employee
+		Revision int `db:"revision"`
+	}
+	err := s.Select(&employees, `SELECT card, firstname, lastname, department, position, revision
+	FROM employees WHERE pushed = 0`)
+	if err != nil {
+		return result, fmt.Errorf("loading employees to push: %w", err)
+	}
+	if len(employees) > 0 {
+		upsert := make([]Employee, len(employees))
+		for i, e := range employees {
+			upsert[i] = e.Employee
+		}
+		if err := dest.UpsertEmployees(upsert); err != nil {
+			return result, err
+		}
+		for _, e := range employees {
+			// an employee changed meanwhile stays pending
+			_, err := s.Exec("UPDATE employees SET pushed = 1 WHERE card = $1 AND revision = $2", e.Card, e.Revision)
+			if err != nil {
+				return result, fmt.Errorf("marking employees pushed: %w", err)
+			}
+		}
+		result.Employees = len(employees)
+	}
+
+	for {
+		var events []Event
+		err := s.Select(&events, "SELECT id, card, timestamp, direction, reader_id FROM events WHERE pushed = 0 ORDER BY id LIMIT $1", batchSize)
+		if err != nil {
+			return result, fmt.Errorf("loading events to push: %w", err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		batch := make([]entity.Event, len(events))
+		ids := make([]int, len(events))
+		for i, e := range events {
+			batch[i] = entity.Event{ID: e.ID, Card: e.Card, Time: e.Timestamp, ReaderDirection: entity.Direction(e.Direction.String),
+				ReaderID: int(e.ReaderID.Int32)}
+			ids[i] = e.ID
+		}
+		if _, err := dest.InsertEvents(ctx, batch); err != nil {
+			return result, err
+		}
+		query, args, err := sqlx.In("UPDATE events SET pushed = 1 WHERE id IN (?)", ids)
+		if err != nil {
+			return result, fmt.Errorf("marking events pushed: %w", err)
+		}
+		if _, err := s.Exec(query, args...); err != nil {
+			return result, fmt.Errorf("marking events pushed: %w", err)
+		}
+		result.Events += len(events)
+	}
+
+	for {
+		var intervals []Interval
+		err := s.Select(&intervals, `SELECT ent, ext, card, database, ent_event_id, ext_event_id, confidence
+		FROM intervals WHERE pushed = 0 LIMIT $1`, batchSize)
+		if err != nil {
+			return result, fmt.Errorf("loading intervals to push: %w", err)
+		}
+		if len(intervals) == 0 {
+			break
+		}
+		if _, err := dest.InsertIntervals(ctx, intervals); err != nil {
+			return result, err
+		}
+		tx, err := s.Beginx()
+		if err != nil {
+			return result, fmt.Errorf("marking intervals pushed: %w", err)
+		}
+		for _, interval := range intervals {
+			_, err := tx.Exec("UPDATE intervals SET pushed = 1 WHERE database = $1 AND ent_event_id = $2",
+				interval.Database, interval.EntEventID)
+			if err != nil {
+				tx.Rollback()
+				return result, fmt.Errorf("marking intervals pushed: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return result, fmt.Errorf("marking intervals pushed: %w", err)
+		}
+		result.Intervals += len(intervals)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package infra
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteStoreEvents(t *testing.T) {
+	ts := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+
+	t.Run("a stored event gets its door and is pushed again", func(t *testing.T) {
+		store, err := OpenSQLite(filepath.Join(t.TempDir(), "edge.db"))
+		assert.Nil(t, err)
+		defer store.Close()
+
+		inserted, err := store.InsertEvents([]entity.Event{{ID: 1, Card: "1001", Time: ts}})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), inserted)
+		_, err = store.Exec("UPDATE events SET pushed = 1")
+		assert.Nil(t, err)
+
+		inserted, err = store.InsertEvents([]entity.Event{{ID: 1, Card: "1001", Time: ts, ReaderID: 7}})
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), inserted)
+		var stored []Event
+		assert.Nil(t, store.Select(&stored, "SELECT id, card, timestamp, direction, reader_id FROM events WHERE pushed = 0"))
+		assert.Len(t, stored, 1)
+		assert.Equal(t, int32(7), stored[0].ReaderID.Int32)
+	})
+
+	t.Run("files from before the door column are upgraded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "edge.db")
+		old, err := sqlx.Connect("sqlite", path)
+		assert.Nil(t, err)
+		_, err = old.Exec(`CREATE TABLE events (id integer PRIMARY KEY, card text NOT NULL,
+			timestamp datetime NOT NULL, direction text, pushed integer NOT NULL DEFAULT 0)`)
+		assert.Nil(t, err)
+		old.Close()
+
+		store, err := OpenSQLite(path)
+
+		assert.Nil(t, err)
+		defer store.Close()
+		_, err = store.InsertEvents([]entity.Event{{ID: 1, Card: "1001", Time: ts, ReaderID: 7}})
+		assert.Nil(t, err)
+	})
+}
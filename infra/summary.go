@@ -0,0 +1,63 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type DestinationResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// Time a run spent in one of its stages
+type StageTiming struct {
+	Stage    string        `json:"stage"`
+	Duration time.Duration `json:"-"`
+}
+
+func (t StageTiming) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Stage   string  `json:"stage"`
+		Seconds float64 `json:"seconds"`
+	}{t.Stage, t.Duration.Seconds()})
+}
+
+type RunSummary struct {
+	Division          string    `json:"division"`
+	Started           time.Time `json:"started"`
+	Finished          time.Time `json:"finished"`
+	UsersSynced       int       `json:"users_synced"`
+	EventsExported    int       `json:"events_exported"`
+	EventsInserted    int64     `json:"events_inserted"`
+	IntervalsFormed   int       `json:"intervals_formed"`
+	IntervalsInserted int64     `json:"intervals_inserted"`
+	Anomalies         int       `json:"anomalies"`
+	// data quality score of the last complete day
+	QualityScore float64 `json:"quality_score"`
+	// people inside when the run finished
+	OnSite []Presence `json:"on_site"`
+	// load outcome of every destination when dual-writing
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+	// nothing was loaded because the source is unchanged since the last successful run
+	Skipped bool `json:"skipped"`
+	// problems that did not fail the run
+	Warnings []string      `json:"warnings"`
+	Stages   []StageTiming `json:"stages"`
+}
+
+func (s RunSummary) String() string {
+	text := fmt.Sprintf("division %s: users synced %d, events exported %d, events inserted %d, intervals formed %d, intervals inserted %d, anomalies %d, quality %.1f, took %s",
+		s.Division, s.UsersSynced, s.EventsExported, s.EventsInserted, s.IntervalsFormed, s.IntervalsInserted, s.Anomalies, s.QualityScore,
+		s.Finished.Sub(s.Started).Round(time.Second))
+
+	for _, d := range s.Destinations {
+		status := "ok"
+		if d.Error != "" {
+			status = "FAILED: " + d.Error
+		}
+		text += fmt.Sprintf("; destination %s %s", d.Name, status)
+	}
+	return text
+}
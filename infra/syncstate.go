@@ -0,0 +1,118 @@
+package infra
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type DestinationIdentity struct {
+	// written into the destination once, empty until then
+	ID       string
+	Database string
+	Host     string
+}
+
+// Identifies the destination by the id written into it at onboarding, which
+// survives failovers, address changes and grants. Copies of a destination
+// carry its id, DESTINATION_ID tells them apart.
+func (db *Repository) DestinationIdentity() (DestinationIdentity, error) {
+	var id DestinationIdentity
+	var onboarded bool
+	err := db.QueryRow(`SELECT current_database(),
+		coalesce(host(inet_server_addr()), 'local') || ':' || coalesce(inet_server_port()::text, ''),
+		to_regclass('attendance.destination') IS NOT NULL`).Scan(&id.Database, &id.Host, &onboarded)
+	if err != nil {
+		return id, fmt.Errorf("loading destination identity: %w", err)
+	}
+	if !onboarded {
+		return id, nil
+	}
+	err = db.Get(&id.ID, "SELECT id FROM attendance.destination")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return id, fmt.Errorf("loading destination identity: %w", err)
+	}
+	return id, nil
+}
+
+// Writes a new id into a destination that has none, returns whether it did
+func (db *Repository) EnsureDestinationID(at time.Time) (DestinationIdentity, bool, error) {
+	id, err := db.DestinationIdentity()
+	if err != nil || id.ID != "" {
+		return id, false, err
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return id, false, fmt.Errorf("creating destination id: %w", err)
+	}
+	_, err = db.Exec(`INSERT INTO attendance.destination (id, created_at) VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`, hex.EncodeToString(random), at)
+	if err != nil {
+		return id, false, fmt.Errorf("creating destination id: %w", err)
+	}
+	// a concurrent onboarding may have won
+	id, err = db.DestinationIdentity()
+	return id, err == nil, err
+}
+
+func (db *Repository) RecordSyncState(division, source string, id DestinationIdentity, at time.Time) error {
+	_, err := db.Exec(`INSERT INTO attendance.sync_state
+		(division, source, destination_fingerprint, destination_db, destination_host, last_sync)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (division, source) DO UPDATE SET
+		destination_fingerprint = EXCLUDED.destination_fingerprint, destination_db = EXCLUDED.destination_db,
+		destination_host = EXCLUDED.destination_host, last_sync = EXCLUDED.last_sync`,
+		division, source, id.ID, id.Database, id.Host, at)
+	if err != nil {
+		return fmt.Errorf("recording sync state: %w", err)
+	}
+	return nil
+}
+
+// Id of the destination the division and source were last loaded into as
+// recorded in the destination itself, false when never loaded
+func (db *Repository) SyncStateDestination(division, source string) (string, bool, error) {
+	var exists bool
+	if err := db.Get(&exists, "SELECT to_regclass('attendance.sync_state') IS NOT NULL"); err != nil || !exists {
+		return "", false, err
+	}
+	var id string
+	err := db.Get(&id, "SELECT destination_fingerprint FROM attendance.sync_state WHERE division = $1 AND source = $2",
+		division, source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("loading sync state: %w", err)
+	}
+	return id, true, nil
+}
+
+var ErrDestinationChanged = errors.New("destination changed")
+
+/*
+ * Fails with ErrDestinationChanged unless the destination is the expected
+ * one, the id configured on the ETL host, and the division and source were
+ * last loaded into it rather than into a destination it was copied from.
+ * recorded is the id of the sync state row, empty when there is none.
+ */
+func CheckDestination(expected string, id DestinationIdentity, recorded, division, source string) error {
+	switch {
+	case expected == "":
+		return fmt.Errorf("%w: DESTINATION_ID is not set, run onboard-division and configure the id it prints for %s on %s",
+			ErrDestinationChanged, id.Database, id.Host)
+	case id.ID == "":
+		return fmt.Errorf("%w: %s on %s has no destination id, it was never onboarded, expected %s",
+			ErrDestinationChanged, id.Database, id.Host, expected)
+	case id.ID != expected:
+		return fmt.Errorf("%w: %s on %s is destination %s, expected %s",
+			ErrDestinationChanged, id.Database, id.Host, id.ID, expected)
+	case recorded != "" && recorded != id.ID:
+		return fmt.Errorf("%w: division %s from %s was last loaded into destination %s, %s on %s is a copy of it",
+			ErrDestinationChanged, division, source, recorded, id.Database, id.Host)
+	}
+	return nil
+}
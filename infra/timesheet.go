@@ -0,0 +1,302 @@
+package infra
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	// sheet of employees without department, named in the report's language
+	timesheetNoDepartment = "Без подразделения"
+)
+
+type TimesheetRow struct {
+	Card     string
+	Name     string
+	Position string
+	// worked hours by day of month
+	Hours map[int]float64
+	// kinds of leaves by day of month
+	Leaves map[int]string
+	// working time norm of the employee
+	WeeklyHours float64
+}
+
+type TimesheetSheet struct {
+	Department string
+	Rows       []TimesheetRow
+}
+
+// Groups employees by department with their daily hours, leaves and norm of
+// the month. A card reissued during the month also gets a row for each earlier
+// holder with the hours of their days. Sheets and rows are ordered by name.
+func BuildTimesheet(employees []Employee, hours []DailyHours, month time.Time, leaves []entity.Leave, norms entity.WorkNorms) []TimesheetSheet {
+	type holder struct{ card, name string }
+	byHolder := make(map[holder]map[int]float64)
+	holders := make(map[string][]string)
+	for _, h := range hours {
+		day, err := time.Parse("2006-01-02", h.Day)
+		if err != nil {
+			continue
+		}
+		key := holder{h.Card, strings.TrimSpace(h.LastName + " " + h.FirstName)}
+		if byHolder[key] == nil {
+			byHolder[key] = make(map[int]float64)
+			holders[h.Card] = append(holders[h.Card], key.name)
+		}
+		byHolder[key][day.Day()] += h.Hours
+	}
+
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	leaveKinds := make(map[string]map[int]string)
+	for _, l := range leaves {
+		for day := first; day.Month() == first.Month(); day = day.AddDate(0, 0, 1) {
+			if !l.Covers(day) {
+				continue
+			}
+			if leaveKinds[l.Card] == nil {
+				leaveKinds[l.Card] = make(map[int]string)
+			}
+			leaveKinds[l.Card][day.Day()] = l.Kind
+		}
+	}
+
+	departments := make(map[string][]TimesheetRow)
+	for _, e := range employees {
+		department := e.Department
+		if department == "" {
+			department = timesheetNoDepartment
+		}
+		name := strings.TrimSpace(e.LastName + " " + e.FirstName)
+		weekly := norms.Weekly(e.Card, e.Department, e.Position)
+		departments[department] = append(departments[department], TimesheetRow{
+			Card:     e.Card,
+			Name:     name,
+			Position: e.Position,
+			Hours:    byHolder[holder{e.Card, name}],
+			Leaves:   leaveKinds[e.Card],
+
+			WeeklyHours: weekly,
+		})
+		for _, previous := range holders[e.Card] {
+			if previous != name {
+				departments[department] = append(departments[department], TimesheetRow{
+					Card:        e.Card,
+					Name:        previous,
+					Hours:       byHolder[holder{e.Card, previous}],
+					WeeklyHours: weekly,
+				})
+			}
+		}
+	}
+
+	sheets := make([]TimesheetSheet, 0, len(departments))
+	for department, rows := range departments {
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].Name != rows[j].Name {
+				return rows[i].Name < rows[j].Name
+			}
+			return rows[i].Card < rows[j].Card
+		})
+		sheets = append(sheets, TimesheetSheet{Department: department, Rows: rows})
+	}
+	sort.Slice(sheets, func(i, j int) bool { return sheets[i].Department < sheets[j].Department })
+	return sheets
+}
+
+// Cell styles taken from the template: the first sheet's row 1 styles the header,
+// row 2 the employee rows. Column A is the name columns style, B the day columns one.
+type timesheetStyles struct {
+	headerName, headerDay, name, day int
+}
+
+// Writes one sheet per department with a column per day of month. Worked days
+// carry hours, days without intervals a leave, absence or day off marker.
+// Totals are followed by the norm of the month and the balance against it. The
+// template is optional, when given its first sheet is the prototype of every
+// department sheet. Headers and markers are in the language of the locale.
+func WriteTimesheetXLSX(w io.Writer, month time.Time, sheets []TimesheetSheet, calendar entity.Calendar, template string, locale entity.Locale) error {
+	var f *excelize.File
+	var err error
+	if template != "" {
+		f, err = excelize.OpenFile(template)
+		if err != nil {
+			return fmt.Errorf("opening timesheet template: %w", err)
+		}
+	} else {
+		f = excelize.NewFile()
+	}
+	defer f.Close()
+
+	prototype := f.GetSheetName(0)
+	styles := timesheetStyles{}
+	if template != "" {
+		styles.headerName, _ = f.GetCellStyle(prototype, "A1")
+		styles.headerDay, _ = f.GetCellStyle(prototype, "B1")
+		styles.name, _ = f.GetCellStyle(prototype, "A2")
+		styles.day, _ = f.GetCellStyle(prototype, "B2")
+	}
+
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	days := first.AddDate(0, 1, -1).Day()
+
+	used := make(map[string]bool)
+	for _, sheet := range sheets {
+		name := timesheetSheetName(departmentName(sheet.Department, locale), used)
+		index, err := f.NewSheet(name)
+		if err != nil {
+			return fmt.Errorf("creating sheet %s: %w", name, err)
+		}
+		if template != "" {
+			if err := f.CopySheet(0, index); err != nil {
+				return fmt.Errorf("copying template sheet: %w", err)
+			}
+		}
+		if err := writeTimesheetSheet(f, name, first, days, sheet.Rows, calendar, styles, locale); err != nil {
+			return err
+		}
+	}
+
+	if len(sheets) > 0 {
+		f.DeleteSheet(prototype)
+		f.SetActiveSheet(0)
+	}
+	return f.Write(w)
+}
+
+func writeTimesheetSheet(f *excelize.File, sheet string, first time.Time, days int, rows []TimesheetRow, calendar entity.Calendar, styles timesheetStyles, locale entity.Locale) error {
+	set := func(col, row int, value interface{}, style int) error {
+		cell, err := excelize.CoordinatesToCellName(col, row)
+		if err != nil {
+			return err
+		}
+		if err := f.SetCellValue(sheet, cell, value); err != nil {
+			return err
+		}
+		if style != 0 {
+			return f.SetCellStyle(sheet, cell, cell, style)
+		}
+		return nil
+	}
+
+	header := []string{locale.T("timesheet.card"), locale.T("timesheet.employee")}
+	for i, title := range header {
+		if err := set(i+1, 1, title, styles.headerName); err != nil {
+			return fmt.Errorf("writing timesheet header: %w", err)
+		}
+	}
+	for day := 1; day <= days; day++ {
+		if err := set(len(header)+day, 1, day, styles.headerDay); err != nil {
+			return fmt.Errorf("writing timesheet header: %w", err)
+		}
+	}
+	if err := set(len(header)+days+1, 1, locale.T("timesheet.total"), styles.headerName); err != nil {
+		return fmt.Errorf("writing timesheet header: %w", err)
+	}
+	if err := set(len(header)+days+2, 1, locale.T("timesheet.day_off_hours"), styles.headerName); err != nil {
+		return fmt.Errorf("writing timesheet header: %w", err)
+	}
+	if err := set(len(header)+days+3, 1, locale.T("timesheet.norm"), styles.headerName); err != nil {
+		return fmt.Errorf("writing timesheet header: %w", err)
+	}
+	if err := set(len(header)+days+4, 1, locale.T("timesheet.balance"), styles.headerName); err != nil {
+		return fmt.Errorf("writing timesheet header: %w", err)
+	}
+
+	for i, r := range rows {
+		row := i + 2
+		if err := set(1, row, r.Card, styles.name); err != nil {
+			return fmt.Errorf("writing timesheet row: %w", err)
+		}
+		if err := set(2, row, r.Name, styles.name); err != nil {
+			return fmt.Errorf("writing timesheet row: %w", err)
+		}
+
+		var total, dayOff float64
+		for day := 1; day <= days; day++ {
+			var value interface{}
+			working := calendar.IsWorkingDay(first.AddDate(0, 0, day-1))
+			if hours := r.Hours[day]; hours > 0 {
+				value = roundHours(hours)
+				total += hours
+				if !working {
+					dayOff += hours
+				}
+			} else if kind, ok := r.Leaves[day]; ok {
+				value = locale.LeaveCode(kind)
+			} else if !working {
+				value = locale.T("timesheet.day_off")
+			} else {
+				value = locale.T("timesheet.absent")
+			}
+			if err := set(len(header)+day, row, value, styles.day); err != nil {
+				return fmt.Errorf("writing timesheet row: %w", err)
+			}
+		}
+		if err := set(len(header)+days+1, row, roundHours(total), styles.day); err != nil {
+			return fmt.Errorf("writing timesheet row: %w", err)
+		}
+		if err := set(len(header)+days+2, row, roundHours(dayOff), styles.day); err != nil {
+			return fmt.Errorf("writing timesheet row: %w", err)
+		}
+		norm := r.Norm(first, calendar)
+		if err := set(len(header)+days+3, row, roundHours(norm), styles.day); err != nil {
+			return fmt.Errorf("writing timesheet row: %w", err)
+		}
+		if err := set(len(header)+days+4, row, roundHours(total-norm), styles.day); err != nil {
+			return fmt.Errorf("writing timesheet row: %w", err)
+		}
+	}
+	return nil
+}
+
+// Norm hours of the month, days on leave have none
+func (r TimesheetRow) Norm(first time.Time, calendar entity.Calendar) float64 {
+	onLeave := func(day time.Time) bool {
+		_, ok := r.Leaves[day.Day()]
+		return ok
+	}
+	return entity.PeriodNorm(r.WeeklyHours, calendar, first, first.AddDate(0, 1, 0), onLeave)
+}
+
+func departmentName(department string, locale entity.Locale) string {
+	if department == timesheetNoDepartment {
+		return locale.T("timesheet.no_department")
+	}
+	return department
+}
+
+func roundHours(hours float64) float64 {
+	return float64(int(hours*100+0.5)) / 100
+}
+
+// Excel limits sheet names to 31 characters without []:*?/\ and requires them unique
+func timesheetSheetName(department string, used map[string]bool) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, department)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+
+	candidate := name
+	for n := 2; used[candidate]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		runes := []rune(name)
+		if len(runes)+len([]rune(suffix)) > 31 {
+			runes = runes[:31-len([]rune(suffix))]
+		}
+		candidate = string(runes) + suffix
+	}
+	used[candidate] = true
+	return candidate
+}
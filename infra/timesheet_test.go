@@ -0,0 +1,29 @@
+package infra
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTimesheet(t *testing.T) {
+	month := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	employees := []Employee{{Card: "1001", FirstName: "Анна", LastName: "Петрова", Department: "Цех 1", Position: "Токарь"}}
+
+	t.Run("a reissued card has a row per holder", func(t *testing.T) {
+		hours := []DailyHours{
+			{Card: "1001", FirstName: "Иван", LastName: "Сидоров", Day: "2026-09-01", Hours: 8},
+			{Card: "1001", FirstName: "Анна", LastName: "Петрова", Day: "2026-09-15", Hours: 7.5},
+		}
+
+		sheets := BuildTimesheet(employees, hours, month, nil, entity.DefaultWorkNorms())
+
+		assert.Len(t, sheets, 1)
+		assert.Equal(t, []TimesheetRow{
+			{Card: "1001", Name: "Петрова Анна", Position: "Токарь", Hours: map[int]float64{15: 7.5}, WeeklyHours: 40},
+			{Card: "1001", Name: "Сидоров Иван", Hours: map[int]float64{1: 8}, WeeklyHours: 40},
+		}, sheets[0].Rows)
+	})
+}
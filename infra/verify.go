@@ -0,0 +1,75 @@
+package infra
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+type CardDay struct {
+	Card string `db:"card"`
+	Day  string `db:"day"`
+}
+
+type DayCountMismatch struct {
+	CardDay
+	Source      int
+	Destination int
+}
+
+func CountEventsPerDay(events []entity.Event, from time.Time) map[CardDay]int {
+	result := make(map[CardDay]int)
+	for _, event := range events {
+		if event.Card == "" || event.Time.Before(from) {
+			continue
+		}
+		result[CardDay{Card: event.Card, Day: event.Time.Format("2006-01-02")}]++
+	}
+	return result
+}
+
+// Events carry no division, the destination counts are those of the cards
+// the division's source knows, events of other divisions' cards are left out
+func EventCountsPerDay(tx *sqlx.Tx, from time.Time, cards []string) (map[CardDay]int, error) {
+	var rows []struct {
+		CardDay
+		Count int `db:"count"`
+	}
+	err := tx.Select(&rows, `SELECT card, to_char(timestamp, 'YYYY-MM-DD') AS day, count(*) AS count
+	FROM attendance.events WHERE timestamp >= $1 AND card = ANY($2) GROUP BY 1, 2`, from, pq.Array(cards))
+	if err != nil {
+		return nil, fmt.Errorf("counting destination events: %w", err)
+	}
+
+	result := make(map[CardDay]int, len(rows))
+	for _, row := range rows {
+		result[row.CardDay] = row.Count
+	}
+	return result, nil
+}
+
+func CompareDayCounts(source, destination map[CardDay]int) []DayCountMismatch {
+	result := make([]DayCountMismatch, 0)
+	for key, count := range source {
+		if destination[key] != count {
+			result = append(result, DayCountMismatch{CardDay: key, Source: count, Destination: destination[key]})
+		}
+	}
+	for key, count := range destination {
+		if _, ok := source[key]; !ok {
+			result = append(result, DayCountMismatch{CardDay: key, Destination: count})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Day != result[j].Day {
+			return result[i].Day < result[j].Day
+		}
+		return result[i].Card < result[j].Card
+	})
+	return result
+}
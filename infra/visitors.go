@@ -0,0 +1,115 @@
+package infra
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+type Visitor struct {
+	Card      string    `db:"card" json:"card"`
+	Kind      string    `db:"kind" json:"kind"`
+	FirstName string    `db:"firstname" json:"firstname"`
+	LastName  string    `db:"lastname" json:"lastname"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Stay of a visitor or contractor on site, open while ext is null
+type Visit struct {
+	Card      string     `db:"card" json:"card"`
+	Kind      string     `db:"kind" json:"kind"`
+	FirstName string     `db:"firstname" json:"firstname"`
+	LastName  string     `db:"lastname" json:"lastname"`
+	Database  string     `db:"database" json:"database"`
+	Ent       time.Time  `db:"ent" json:"ent"`
+	Ext       *time.Time `db:"ext" json:"ext,omitempty"`
+}
+
+// Upserts visitor cards. Cards that earlier runs loaded as employees are moved
+// out of the employees: their intervals become visits and the employee record
+// is deleted unless it has card assignments.
+func (db *Repository) SyncVisitors(visitors []*entity.User, at time.Time) error {
+	if len(visitors) == 0 {
+		return nil
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("syncing visitors: %w", err)
+	}
+	defer tx.Rollback()
+
+	cards := make([]string, len(visitors))
+	for i, v := range visitors {
+		cards[i] = v.Card
+		_, err := tx.Exec(`INSERT INTO attendance.visitors (card, kind, firstname, lastname, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (card) DO UPDATE SET kind = excluded.kind, firstname = excluded.firstname,
+			lastname = excluded.lastname, updated_at = excluded.updated_at
+		WHERE (visitors.kind, visitors.firstname, visitors.lastname)
+			IS DISTINCT FROM (excluded.kind, excluded.firstname, excluded.lastname)`,
+			v.Card, v.Kind, v.FirstName, v.LastName, at)
+		if err != nil {
+			return fmt.Errorf("syncing visitors: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO attendance.visits (interval_key, database, card, ent, ext, ent_event_id, ext_event_id)
+	SELECT interval_key, database, card, ent, ext, ent_event_id, ext_event_id
+	FROM attendance.intervals WHERE card = ANY($1)
+	ON CONFLICT (database, interval_key) DO NOTHING`, pq.Array(cards))
+	if err != nil {
+		return fmt.Errorf("moving visitor intervals: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM attendance.intervals WHERE card = ANY($1)`, pq.Array(cards))
+	if err != nil {
+		return fmt.Errorf("moving visitor intervals: %w", err)
+	}
+	if moved, _ := res.RowsAffected(); moved > 0 {
+		log.Printf("moved %d intervals of visitor cards to visits", moved)
+	}
+	_, err = tx.Exec(`DELETE FROM attendance.employees e WHERE card = ANY($1)
+	AND NOT EXISTS (SELECT 1 FROM attendance.employee_cards c WHERE c.employee_id = e.id)`, pq.Array(cards))
+	if err != nil {
+		return fmt.Errorf("removing visitor employees: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Upserts visit intervals by their key like InsertIntervals does for employees
+func (db *Repository) InsertVisits(intervals []Interval) error {
+	if len(intervals) == 0 {
+		return nil
+	}
+	keyed := make([]Interval, len(intervals))
+	for i, interval := range intervals {
+		interval.Key = IntervalKey(interval.Card, interval.EntEventID)
+		keyed[i] = interval
+	}
+	_, err := db.NamedExec(`INSERT INTO attendance.visits AS v (interval_key, database, card, ent, ext, ent_event_id, ext_event_id)
+	VALUES (:interval_key, :database, :card, :ent, :ext, :ent_event_id, :ext_event_id)
+	ON CONFLICT (database, interval_key) DO UPDATE SET ent = EXCLUDED.ent, ext = EXCLUDED.ext,
+		ext_event_id = EXCLUDED.ext_event_id
+	WHERE (v.ent, v.ext, v.ext_event_id) IS DISTINCT FROM (EXCLUDED.ent, EXCLUDED.ext, EXCLUDED.ext_event_id)`, keyed)
+	if err != nil {
+		return fmt.Errorf("inserting visits: %w", err)
+	}
+	return nil
+}
+
+// Visits entered in [from, to), the open ones only when onSite is set, for
+// security and evacuation lists
+func (db *Repository) Visits(from, to time.Time, onSite bool) (visits []Visit, err error) {
+	err = db.Select(&visits, `SELECT v.card, coalesce(p.kind, '') AS kind, coalesce(p.firstname, '') AS firstname,
+		coalesce(p.lastname, '') AS lastname, v.database, v.ent, v.ext
+	FROM attendance.visits v
+	LEFT JOIN attendance.visitors p ON p.card = v.card
+	WHERE v.ent >= $1 AND v.ent < $2 AND (NOT $3 OR v.ext IS NULL)
+	ORDER BY v.ent`, from, to, onSite)
+	if err != nil {
+		return nil, fmt.Errorf("loading visits: %w", err)
+	}
+	return visits, nil
+}
@@ -0,0 +1,258 @@
+package infra
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	webhookBatchSize   = 500
+	webhookMaxAttempts = 4
+)
+
+var webhookBackoff = time.Second
+
+// Posts intervals and anomalies created by a run to every configured URL.
+// Bodies are signed with HMAC-SHA256 of the shared secret in X-Signature-256.
+type Webhooks struct {
+	urls   []string
+	secret string
+	client *http.Client
+}
+
+// Nil when WEBHOOK_URLS is empty, the signing key is the WEBHOOK_SECRET secret
+func NewWebhooksFromEnv(secrets SecretProvider) (*Webhooks, error) {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	secret, err := secrets.Secret("WEBHOOK_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	return &Webhooks{urls: urls, secret: secret, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type webhookInterval struct {
+	Database   string   `json:"database"`
+	Card       string   `json:"card"`
+	EntEventID int      `json:"ent_event_id"`
+	ExtEventID *int64   `json:"ext_event_id"`
+	Ent        string   `json:"ent"`
+	Ext        *string  `json:"ext"`
+	Confidence *float64 `json:"confidence,omitempty"`
+}
+
+type webhookPayload struct {
+	Division  string            `json:"division"`
+	RunAt     time.Time         `json:"run_at"`
+	Intervals []webhookInterval `json:"intervals"`
+	Anomalies []Anomaly         `json:"anomalies"`
+}
+
+/*
+ * Queues the intervals and anomalies created by a run for every URL in the
+ * outbox, written together with them. Deliver posts them later, so a receiver
+ * that is down gets them on a following run.
+ */
+func (w *Webhooks) Enqueue(outbox WebhookOutbox, division string, runAt time.Time, intervals []Interval, anomalies []Anomaly) error {
+	converted := make([]webhookInterval, len(intervals))
+	for i, in := range intervals {
+		c := webhookInterval{Database: in.Database, Card: in.Card, EntEventID: in.EntEventID, Ent: in.Ent}
+		if in.ExtEventID.Valid {
+			c.ExtEventID = &in.ExtEventID.Int64
+		}
+		if in.Ext.Valid {
+			c.Ext = &in.Ext.String
+		}
+		if in.Confidence.Valid {
+			c.Confidence = &in.Confidence.Float64
+		}
+		converted[i] = c
+	}
+
+	// large backfills are split so receivers don't get huge bodies
+	deliveries := make([]WebhookDelivery, 0)
+	for start := 0; start < len(converted) || start < len(anomalies); start += webhookBatchSize {
+		payload := webhookPayload{
+			Division:  division,
+			RunAt:     runAt,
+			Intervals: batch(converted, start),
+			Anomalies: batch(anomalies, start),
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		for _, url := range w.urls {
+			deliveries = append(deliveries, WebhookDelivery{URL: url, Body: body, CreatedAt: runAt})
+		}
+	}
+	return outbox.EnqueueWebhooks(deliveries)
+}
+
+/*
+ * Posts the queued payloads, the oldest first. URLs are delivered to
+ * independently: a failing one keeps its payloads queued in order and the
+ * others go on. Payloads of URLs no longer configured are dropped.
+ */
+func (w *Webhooks) Deliver(outbox WebhookOutbox) error {
+	pending, err := outbox.PendingWebhooks()
+	if err != nil {
+		return err
+	}
+	configured := make(map[string]bool, len(w.urls))
+	for _, url := range w.urls {
+		configured[url] = true
+	}
+	failed := make(map[string]bool)
+	errs := make([]error, 0)
+	for _, d := range pending {
+		if !configured[d.URL] {
+			log.Printf("webhook %s is no longer configured, dropping its payload %d", d.URL, d.ID)
+			if err := outbox.WebhookDelivered(d.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if failed[d.URL] {
+			continue
+		}
+		if err := w.post(d.URL, d.Body); err != nil {
+			failed[d.URL] = true
+			errs = append(errs, err)
+			if err := outbox.WebhookFailed(d.ID, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := outbox.WebhookDelivered(d.ID); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func batch[T any](items []T, start int) []T {
+	if start >= len(items) {
+		return []T{}
+	}
+	end := start + webhookBatchSize
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end]
+}
+
+func (w *Webhooks) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Retries network errors, 429 and 5xx responses with exponential backoff
+func (w *Webhooks) post(url string, body []byte) error {
+	var lastErr error
+	delay := webhookBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if attempt > 1 {
+			log.Printf("webhook %s attempt %d failed: %v, retrying in %s", url, attempt-1, lastErr, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if w.secret != "" {
+			req.Header.Set("X-Signature-256", w.sign(body))
+		}
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("unexpected status %s", resp.Status)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			break
+		}
+	}
+	return fmt.Errorf("posting webhook %s: %w", url, lastErr)
+}
+
+// Payload queued for a webhook URL
+type WebhookDelivery struct {
+	ID        int       `db:"id"`
+	URL       string    `db:"url"`
+	Body      []byte    `db:"body"`
+	CreatedAt time.Time `db:"created_at"`
+	// failed delivery runs so far
+	Attempts int `db:"attempts"`
+}
+
+// Payloads waiting for delivery, the destination keeps them in attendance.webhook_outbox
+type WebhookOutbox interface {
+	EnqueueWebhooks(deliveries []WebhookDelivery) error
+	// oldest first
+	PendingWebhooks() ([]WebhookDelivery, error)
+	WebhookDelivered(id int) error
+	WebhookFailed(id int, err error) error
+}
+
+func (db *Repository) EnqueueWebhooks(deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	_, err := db.NamedExec(`INSERT INTO attendance.webhook_outbox (url, body, created_at)
+	VALUES (:url, :body, :created_at)`, deliveries)
+	if err != nil {
+		return fmt.Errorf("queueing webhooks: %w", err)
+	}
+	return nil
+}
+
+func (db *Repository) PendingWebhooks() (deliveries []WebhookDelivery, err error) {
+	err = db.Select(&deliveries, "SELECT id, url, body, created_at, attempts FROM attendance.webhook_outbox ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("loading queued webhooks: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (db *Repository) WebhookDelivered(id int) error {
+	if _, err := db.Exec("DELETE FROM attendance.webhook_outbox WHERE id = $1", id); err != nil {
+		return fmt.Errorf("removing delivered webhook: %w", err)
+	}
+	return nil
+}
+
+func (db *Repository) WebhookFailed(id int, failure error) error {
+	_, err := db.Exec(`UPDATE attendance.webhook_outbox SET attempts = attempts + 1, last_error = $2, last_attempt = now()
+	WHERE id = $1`, id, failure.Error())
+	if err != nil {
+		return fmt.Errorf("recording webhook failure: %w", err)
+	}
+	return nil
+}
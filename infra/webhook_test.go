@@ -0,0 +1,172 @@
+package infra
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Outbox kept in memory in place of attendance.webhook_outbox
+type memoryOutbox struct {
+	deliveries []WebhookDelivery
+	nextID     int
+}
+
+func (o *memoryOutbox) EnqueueWebhooks(deliveries []WebhookDelivery) error {
+	for _, d := range deliveries {
+		o.nextID++
+		d.ID = o.nextID
+		o.deliveries = append(o.deliveries, d)
+	}
+	return nil
+}
+
+func (o *memoryOutbox) PendingWebhooks() ([]WebhookDelivery, error) {
+	return append([]WebhookDelivery{}, o.deliveries...), nil
+}
+
+func (o *memoryOutbox) WebhookDelivered(id int) error {
+	for i, d := range o.deliveries {
+		if d.ID == id {
+			o.deliveries = append(o.deliveries[:i], o.deliveries[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("unknown delivery")
+}
+
+func (o *memoryOutbox) WebhookFailed(id int, err error) error {
+	for i, d := range o.deliveries {
+		if d.ID == id {
+			o.deliveries[i].Attempts++
+			return nil
+		}
+	}
+	return errors.New("unknown delivery")
+}
+
+// Receiver answering with the given statuses in turn, 200 once they run out
+type testReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newTestReceiver(t *testing.T, statuses ...int) *testReceiver {
+	r := &testReceiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		if status < 300 {
+			r.bodies = append(r.bodies, body)
+			r.headers = append(r.headers, req.Header.Clone())
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func testInterval(card string, id int) Interval {
+	return Interval{Database: "plant", Card: card, EntEventID: id, Ent: "2026-09-01T08:00:00",
+		Ext: sql.NullString{String: "2026-09-01T17:00:00", Valid: true}}
+}
+
+func TestWebhooks(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	runAt := time.Date(2026, 9, 1, 18, 0, 0, 0, time.UTC)
+
+	t.Run("bodies are signed with the secret", func(t *testing.T) {
+		receiver := newTestReceiver(t)
+		w := &Webhooks{urls: []string{receiver.URL}, secret: "s3cret", client: receiver.Client()}
+		outbox := &memoryOutbox{}
+
+		assert.Nil(t, w.Enqueue(outbox, "plant", runAt, []Interval{testInterval("1001", 1)}, nil))
+		assert.Nil(t, w.Deliver(outbox))
+
+		assert.Len(t, receiver.bodies, 1)
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(receiver.bodies[0])
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), receiver.headers[0].Get("X-Signature-256"))
+		assert.Empty(t, outbox.deliveries)
+	})
+
+	t.Run("server errors are retried", func(t *testing.T) {
+		receiver := newTestReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		w := &Webhooks{urls: []string{receiver.URL}, client: receiver.Client()}
+		outbox := &memoryOutbox{}
+
+		assert.Nil(t, w.Enqueue(outbox, "plant", runAt, []Interval{testInterval("1001", 1)}, nil))
+		assert.Nil(t, w.Deliver(outbox))
+
+		assert.Len(t, receiver.bodies, 1)
+		assert.Empty(t, outbox.deliveries)
+	})
+
+	t.Run("undelivered payloads stay queued for the next run", func(t *testing.T) {
+		receiver := newTestReceiver(t, http.StatusBadRequest)
+		w := &Webhooks{urls: []string{receiver.URL}, client: receiver.Client()}
+		outbox := &memoryOutbox{}
+
+		assert.Nil(t, w.Enqueue(outbox, "plant", runAt, []Interval{testInterval("1001", 1)}, nil))
+		assert.Nil(t, w.Enqueue(outbox, "plant", runAt.Add(time.Hour), []Interval{testInterval("1001", 2)}, nil))
+		assert.NotNil(t, w.Deliver(outbox))
+
+		assert.Empty(t, receiver.bodies)
+		assert.Len(t, outbox.deliveries, 2)
+		assert.Equal(t, 1, outbox.deliveries[0].Attempts)
+		// the later payload waits for the earlier one
+		assert.Equal(t, 0, outbox.deliveries[1].Attempts)
+
+		assert.Nil(t, w.Deliver(outbox))
+
+		assert.Len(t, receiver.bodies, 2)
+		assert.Contains(t, string(receiver.bodies[0]), `"ent_event_id":1`)
+		assert.Contains(t, string(receiver.bodies[1]), `"ent_event_id":2`)
+		assert.Empty(t, outbox.deliveries)
+	})
+
+	t.Run("a failing url doesn't hold back the others", func(t *testing.T) {
+		failing := newTestReceiver(t, http.StatusNotFound)
+		working := newTestReceiver(t)
+		w := &Webhooks{urls: []string{failing.URL, working.URL}, client: http.DefaultClient}
+		outbox := &memoryOutbox{}
+
+		assert.Nil(t, w.Enqueue(outbox, "plant", runAt, []Interval{testInterval("1001", 1)}, nil))
+		assert.NotNil(t, w.Deliver(outbox))
+
+		assert.Len(t, working.bodies, 1)
+		assert.Len(t, outbox.deliveries, 1)
+		assert.Equal(t, failing.URL, outbox.deliveries[0].URL)
+	})
+
+	t.Run("large runs are split into batches", func(t *testing.T) {
+		w := &Webhooks{urls: []string{"http://receiver"}}
+		outbox := &memoryOutbox{}
+		intervals := make([]Interval, webhookBatchSize+1)
+		for i := range intervals {
+			intervals[i] = testInterval("1001", i+1)
+		}
+
+		assert.Nil(t, w.Enqueue(outbox, "plant", runAt, intervals, nil))
+
+		assert.Len(t, outbox.deliveries, 2)
+	})
+}
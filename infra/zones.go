@@ -0,0 +1,92 @@
+package infra
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+)
+
+const zoneInsertBatchSize = 1000
+
+type ZoneInterval struct {
+	Database   string        `db:"database"`
+	Card       string        `db:"card"`
+	Zone       string        `db:"zone"`
+	Ent        time.Time     `db:"ent"`
+	Ext        sql.NullTime  `db:"ext"`
+	EntEventID int           `db:"ent_event_id"`
+	ExtEventID sql.NullInt64 `db:"ext_event_id"`
+	Inferred   bool          `db:"inferred"`
+}
+
+// Hours a card spent in a zone on one day, by the day of entering it
+type ZoneTime struct {
+	Card      string    `db:"card" json:"card"`
+	FirstName string    `db:"firstname" json:"firstname"`
+	LastName  string    `db:"lastname" json:"lastname"`
+	Day       time.Time `db:"day" json:"day"`
+	Zone      string    `db:"zone" json:"zone"`
+	Hours     float64   `db:"hours" json:"hours"`
+	// stays of the day that are still open or were never closed
+	Open int `db:"open" json:"open"`
+}
+
+// Replaces the division's zone intervals entered from since on
+func (db *Repository) ReplaceZoneIntervals(database string, since time.Time, intervals []entity.ZoneInterval) error {
+	rows := make([]ZoneInterval, 0, len(intervals))
+	for _, i := range intervals {
+		row := ZoneInterval{
+			Database: database, Card: i.Card, Zone: i.Zone, Ent: i.Ent.Time, EntEventID: i.Ent.ID, Inferred: i.Inferred,
+		}
+		if i.Ext != nil {
+			row.Ext = sql.NullTime{Time: i.Ext.Time, Valid: true}
+			row.ExtEventID = sql.NullInt64{Int64: int64(i.Ext.ID), Valid: true}
+		}
+		rows = append(rows, row)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("replacing zone intervals: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM attendance.zone_intervals WHERE database = $1 AND ent >= $2", database, since)
+	if err != nil {
+		return fmt.Errorf("replacing zone intervals: %w", err)
+	}
+	for start := 0; start < len(rows); start += zoneInsertBatchSize {
+		end := start + zoneInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		_, err = tx.NamedExec(`INSERT INTO attendance.zone_intervals
+			(database, card, zone, ent, ext, ent_event_id, ext_event_id, inferred)
+		VALUES (:database, :card, :zone, :ent, :ext, :ent_event_id, :ext_event_id, :inferred)
+		ON CONFLICT DO NOTHING`, rows[start:end])
+		if err != nil {
+			return fmt.Errorf("replacing zone intervals: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Time per card, day and zone of stays entered in [from, to), of a single
+// card when card is not empty
+func (db *Repository) ZoneTimes(card string, from, to time.Time) (times []ZoneTime, err error) {
+	err = db.Select(&times, `SELECT z.card, coalesce(e.firstname, '') AS firstname, coalesce(e.lastname, '') AS lastname,
+		date_trunc('day', z.ent) AS day, z.zone,
+		coalesce(sum(extract(epoch FROM z.ext - z.ent)) / 3600, 0) AS hours,
+		count(*) FILTER (WHERE z.ext IS NULL) AS open
+	FROM attendance.zone_intervals z
+	LEFT JOIN attendance.employees e ON e.card = z.card
+	WHERE z.ent >= $1 AND z.ent < $2 AND ($3 = '' OR z.card = $3)
+	GROUP BY 1, 2, 3, 4, 5
+	ORDER BY 4, 1, 5`, from, to, card)
+	if err != nil {
+		return nil, fmt.Errorf("loading zone times: %w", err)
+	}
+	return times, nil
+}
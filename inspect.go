@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+/*
+ * Lists the tables and columns of an MDB file, guesses which hold the users,
+ * events, departments and doors, and writes the guess as a schema profile for
+ * MDB_SCHEMA_FILE. A profile of the same name in the -o file is replaced,
+ * others are kept.
+ */
+func inspectCommand(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	mdbPath := fs.String("mdb", os.Getenv("ACCESS_MDB_PATH"), "MDB file to inspect")
+	name := fs.String("name", "site", "name of the guessed schema profile")
+	out := fs.String("o", "", "schema profiles file to add the guess to, stdout when empty")
+	all := fs.Bool("all", false, "list the columns of every table, not just the guessed ones")
+	fs.Parse(args)
+
+	exporter, err := openExporter(*mdbPath)
+	if err != nil {
+		log.Fatalf("error opening MDB: %v", err)
+	}
+	schema, err := exporter.Schema()
+	if err != nil {
+		log.Fatalf("error reading the schema of %s: %v", *mdbPath, err)
+	}
+	tables := make(map[string][]string, len(schema))
+	for table, columns := range schema {
+		for _, c := range columns {
+			tables[table] = append(tables[table], c.Name)
+		}
+	}
+	profile, guesses := entity.GuessSchemaProfile(*name, tables)
+
+	guessed := make(map[string]entity.TableGuess)
+	for _, g := range guesses {
+		if g.Source != "" {
+			guessed[g.Source] = g
+		}
+	}
+	names := make([]string, 0, len(schema))
+	for table := range schema {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+	w := os.Stderr
+	fmt.Fprintf(w, "%d tables in %s\n", len(names), *mdbPath)
+	for _, table := range names {
+		g, ok := guessed[table]
+		if !ok {
+			if *all {
+				fmt.Fprintf(w, "\n%s\n", table)
+				printColumns(schema[table], nil)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "\n%s: %s\n", table, g.Table)
+		printColumns(schema[table], g.Columns)
+	}
+	if !*all {
+		fmt.Fprintf(w, "\nother tables: %s\n", strings.Join(otherTables(names, guessed), ", "))
+	}
+
+	fmt.Fprintln(w)
+	complete := true
+	for _, g := range guesses {
+		switch {
+		case g.Source == "":
+			fmt.Fprintf(w, "no table found for %s\n", g.Table)
+			complete = false
+		case len(g.Missing) > 0:
+			fmt.Fprintf(w, "%s lacks %s, map them in the profile by hand\n", g.Source, strings.Join(g.Missing, ", "))
+			complete = false
+		}
+	}
+	if complete {
+		if _, err := entity.DetectSchemaProfile([]entity.SchemaProfile{entity.DefaultSchemaProfile()}, tables); err == nil {
+			fmt.Fprintf(w, "the built-in %s profile reads this database\n", entity.DefaultSchemaProfile().Name)
+		}
+	}
+
+	if err := writeSchemaProfile(*out, profile); err != nil {
+		log.Fatalln(err)
+	}
+	if *out != "" {
+		fmt.Fprintf(w, "profile %s written to %s, select it with MDB_SCHEMA_FILE=%s MDB_SCHEMA_PROFILE=%s\n", profile.Name, *out, *out, profile.Name)
+	}
+}
+
+// Columns with the parser column each was guessed for
+func printColumns(columns []infra.MdbColumn, guessed map[string]string) {
+	for _, c := range columns {
+		line := fmt.Sprintf("  %-28s %s", c.Name, c.Type)
+		if column, ok := guessed[c.Name]; ok {
+			line = fmt.Sprintf("%-50s -> %s", line, column)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+func otherTables(names []string, guessed map[string]entity.TableGuess) []string {
+	other := make([]string, 0, len(names))
+	for _, table := range names {
+		if _, ok := guessed[table]; !ok {
+			other = append(other, table)
+		}
+	}
+	return other
+}
+
+func writeSchemaProfile(path string, profile entity.SchemaProfile) error {
+	profiles := []entity.SchemaProfile{profile}
+	if path != "" {
+		existing, err := infra.ReadSchemaProfiles(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		replaced := false
+		for i, p := range existing {
+			if p.Name == profile.Name {
+				existing[i], replaced = profile, true
+			}
+		}
+		if !replaced {
+			existing = append(existing, profile)
+		}
+		profiles = existing
+	}
+	content, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("writing schema profiles: %w", err)
+	}
+	return nil
+}
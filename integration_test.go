@@ -0,0 +1,203 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+	"github.com/spooky-finn/piek-attendance-prod/server"
+	"github.com/spooky-finn/piek-attendance-prod/server/attendancepb"
+)
+
+// Starts a throwaway Postgres, points the POSTGRES_* env at it and returns a connection
+func startPostgres(t *testing.T) *infra.Repository {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("connecting to docker: %v", err)
+	}
+	resource, err := pool.Run("postgres", "15-alpine", []string{
+		"POSTGRES_USER=attendance",
+		"POSTGRES_PASSWORD=attendance",
+		"POSTGRES_DB=attendance",
+	})
+	if err != nil {
+		t.Fatalf("starting postgres: %v", err)
+	}
+	t.Cleanup(func() { pool.Purge(resource) })
+
+	t.Setenv("POSTGRES_HOST", "localhost")
+	t.Setenv("POSTGRES_PORT", resource.GetPort("5432/tcp"))
+	t.Setenv("POSTGRES_USER", "attendance")
+	t.Setenv("POSTGRES_PASSWORD", "attendance")
+	t.Setenv("POSTGRES_DB", "attendance")
+	t.Setenv("POSTGRES_SSLMODE", "disable")
+
+	var db *infra.Repository
+	pool.MaxWait = time.Minute
+	err = pool.Retry(func() error {
+		db, err = connectDestination()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("waiting for postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func count(t *testing.T, db *infra.Repository, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.Get(&n, query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// Runs the whole ETL against a simulated source, needs a docker daemon:
+// go test -tags integration -run TestPipelineEndToEnd .
+func TestPipelineEndToEnd(t *testing.T) {
+	db := startPostgres(t)
+
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -2)
+	at := func(days int, clock string) time.Time {
+		d, err := time.ParseDuration(clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return day.AddDate(0, 0, days).Add(d)
+	}
+
+	source := newSimulatedSource()
+	source.AddDepartment("1", "Assembly")
+	source.AddUser("1001", "Ivan", "Petrov", "1", "Fitter")
+	source.AddUser("1002", "Anna", "Smirnova", "1", "Welder")
+	source.Visit("1001", "Gate", at(0, "8h"), at(0, "17h"))
+	source.Visit("1001", "Gate", at(1, "8h"), at(1, "12h"))
+	source.Visit("1002", "Gate", at(0, "9h"), at(0, "18h"))
+	// still inside
+	source.Badge("1002", "Gate", at(1, "9h"), 0)
+	// badge nobody owns
+	source.Badge("9999", "Gate", at(1, "10h"), 0)
+
+	t.Setenv("ACCESS_MDB_PATH", source.Install(t))
+	t.Setenv("CONTROLLER_DIVISION_NAME", "simulated")
+	if !assert.NoError(t, db.Migrate()) {
+		return
+	}
+	destination, _, err := db.EnsureDestinationID(time.Now())
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Setenv("DESTINATION_ID", destination.ID)
+
+	summary, err := runETL(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("summary", func(t *testing.T) {
+		assert.Equal(t, 8, summary.EventsExported)
+		assert.Equal(t, 2, summary.UsersSynced)
+	})
+
+	t.Run("destination contents", func(t *testing.T) {
+		assert.Equal(t, 2, count(t, db, "SELECT count(*) FROM attendance.employees"))
+		var department string
+		assert.NoError(t, db.Get(&department, "SELECT department FROM attendance.employees WHERE card = '1001'"))
+		assert.Equal(t, "Assembly", department)
+		assert.Equal(t, 8, count(t, db, "SELECT count(*) FROM attendance.events"))
+		assert.Equal(t, 3, count(t, db, "SELECT count(*) FROM attendance.intervals WHERE ext IS NOT NULL"))
+		assert.Equal(t, 1, count(t, db, "SELECT count(*) FROM attendance.intervals WHERE ext IS NULL AND card = '1002'"))
+		assert.Equal(t, 1, count(t, db, "SELECT count(*) FROM attendance.anomalies WHERE reason = 'unknown_card'"))
+	})
+
+	t.Run("query api over grpc", func(t *testing.T) {
+		s, err := server.New(db, server.Config{Division: "simulated"})
+		if !assert.NoError(t, err) {
+			return
+		}
+		listener := httptest.NewServer(h2c.NewHandler(s, &http2.Server{}))
+		defer listener.Close()
+		conn, err := grpc.NewClient(strings.TrimPrefix(listener.URL, "http://"),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		client := attendancepb.NewAttendanceClient(conn)
+
+		employees, err := client.ListEmployees(context.Background(), &attendancepb.ListEmployeesRequest{Department: "Assembly"})
+		assert.NoError(t, err)
+		assert.Len(t, employees.GetEmployees(), 2)
+
+		intervals, err := client.ListIntervals(context.Background(), &attendancepb.ListIntervalsRequest{
+			From: timestamppb.New(day),
+			To:   timestamppb.New(day.AddDate(0, 0, 2)),
+			Card: "1001",
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, intervals.GetIntervals(), 2) {
+			assert.True(t, intervals.Intervals[0].Ent.AsTime().Equal(at(0, "8h")))
+			assert.True(t, intervals.Intervals[0].Ext.AsTime().Equal(at(0, "17h")))
+		}
+	})
+
+	t.Run("rerun is idempotent", func(t *testing.T) {
+		_, err := runETL(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 8, count(t, db, "SELECT count(*) FROM attendance.events"))
+		assert.Equal(t, 4, count(t, db, "SELECT count(*) FROM attendance.intervals"))
+		assert.Equal(t, 1, count(t, db, "SELECT count(*) FROM attendance.anomalies WHERE reason = 'unknown_card'"))
+	})
+
+	t.Run("interrupted run is recorded as partial", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := runETL(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, count(t, db, "SELECT count(*) FROM attendance.etl_runs WHERE partial"))
+	})
+}
+
+// Hosts starting against a fresh database at once, each with its own pool
+func TestConcurrentMigrate(t *testing.T) {
+	db := startPostgres(t)
+	migrations, err := infra.Migrations()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			other, err := connectDestination()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer other.Close()
+			errs <- other.Migrate()
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	assert.Equal(t, len(migrations), count(t, db, "SELECT count(*) FROM attendance.schema_migrations"))
+}
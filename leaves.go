@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// import-leaves -file leaves.csv loads vacations and sick leaves, from an HR
+// CSV or a semicolon separated 1C:ZUP extract
+func importLeavesCommand(args []string) {
+	fs := flag.NewFlagSet("import-leaves", flag.ExitOnError)
+	file := fs.String("file", "", "CSV with card, kind, from and to columns")
+	source := fs.String("source", "", "where the leaves come from, the file name if empty")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalln("-file is required")
+	}
+	if *source == "" {
+		*source = filepath.Base(*file)
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	leaves, err := infra.ReadLeavesCSV(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.ImportLeaves(leaves, *source, time.Now()); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d leaves imported", len(leaves))
+}
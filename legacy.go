@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// import-legacy -file export.csv loads the intervals of the old attendance
+// system into the division, so its history sits next to the ETL's
+func importLegacyCommand(args []string) {
+	fs := flag.NewFlagSet("import-legacy", flag.ExitOnError)
+	file := fs.String("file", "", "CSV with card, date, in and out columns, optionally lastname, firstname and department")
+	division := fs.String("division", os.Getenv("CONTROLLER_DIVISION_NAME"), "division the intervals belong to")
+	source := fs.String("source", "legacy", "source marker of the imported intervals")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalln("-file is required")
+	}
+	if *division == "" {
+		log.Fatalln("-division or CONTROLLER_DIVISION_NAME is required")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	intervals, err := infra.ReadLegacyCSV(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(intervals) == 0 {
+		log.Println("no intervals in", *file)
+		return
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	// the ETL's history takes over from its first interval
+	first, ok, err := db.FirstETLInterval(*division)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if ok {
+		var trimmed int
+		intervals, trimmed = infra.TrimLegacyIntervals(intervals, first)
+		if trimmed > 0 {
+			warnf("%d legacy intervals at or after the first etl interval at %s skipped", trimmed, first.Format("2006-01-02 15:04"))
+		}
+		if len(intervals) == 0 {
+			log.Println("no legacy intervals before the etl history of", *division)
+			return
+		}
+	}
+
+	if err := db.ImportLegacyIntervals(*division, *source, intervals, time.Now()); err != nil {
+		log.Fatalln(err)
+	}
+	since := intervals[0].Ent
+	for _, l := range intervals {
+		if l.Ent.Before(since) {
+			since = l.Ent
+		}
+	}
+	if err := db.RefreshRollups(*division, since); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d legacy intervals imported into %s since %s", len(intervals), *division, since.Format("2006-01-02"))
+}
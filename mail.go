@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Mails every manager the timesheet and absences of their departments for the
+// month. Meant for a monthly schedule such as cron on the 1st, managers already
+// mailed for the month are skipped so a retried run only mails the rest.
+func mailReportsCommand(args []string) {
+	fs := flag.NewFlagSet("report mail", flag.ExitOnError)
+	month := fs.String("month", time.Now().AddDate(0, -1, 0).Format("2006-01"), "month to report, YYYY-MM")
+	config := fs.String("config", os.Getenv("MAIL_REPORTS_CONFIG"), "JSON file with the recipients and the subject and body templates")
+	template := fs.String("template", os.Getenv("TIMESHEET_TEMPLATE"), "XLSX file whose first sheet styles every department sheet")
+	force := fs.Bool("force", false, "mail managers already mailed for the month again")
+	dryRun := fs.Bool("dry-run", false, "print the mails instead of sending them")
+	policyPath := fs.String("policy", "", "JSON flow policy whose rounding applies to worked hours, no rounding if empty")
+	fs.Parse(args)
+
+	if *config == "" {
+		log.Fatalln("-config or MAIL_REPORTS_CONFIG is required")
+	}
+	from, err := time.Parse("2006-01", *month)
+	if err != nil {
+		log.Fatalf("invalid -month %q: %v", *month, err)
+	}
+	to := from.AddDate(0, 1, 0)
+
+	reports, err := infra.ReadMailReportsConfig(*config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	smtpConfig, enabled, err := infra.MailConfigFromEnv(secrets)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !enabled && !*dryRun {
+		log.Fatalln("SMTP_HOST is not set")
+	}
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	norms, _, err := infra.WorkNormsFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	employees, err := db.EmployeesAll()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	hours, err := db.DailyHours(from, to, policy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	leaves, err := db.Leaves(from, to)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	absences, err := db.Absences("", from, to)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sheets := infra.BuildTimesheet(employees, hours, from, leaves, norms)
+
+	failed := 0
+	for _, recipient := range reports.Recipients {
+		if !*force && !*dryRun {
+			mailed, err := db.ReportMailed(from, recipient.Email)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if mailed {
+				log.Printf("%s already mailed for %s, skipped", recipient.Email, *month)
+				continue
+			}
+		}
+		mail, err := monthReportMail(reports, recipient, from, sheets, absences, calendar, *template)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if mail == nil {
+			warnf("no departments of %s in the timesheet, not mailed", recipient.Email)
+			continue
+		}
+
+		if *dryRun {
+			fmt.Printf("To: %s\nSubject: %s\n\n%s\n", recipient.Email, mail.Subject, mail.Body)
+			for _, a := range mail.Attachments {
+				fmt.Printf("  attachment %s, %d bytes\n", a.Name, len(a.Data))
+			}
+			continue
+		}
+		if err := smtpConfig.Send(*mail); err != nil {
+			// the other managers still get theirs, the next run retries this one
+			log.Printf("error mailing reports to %s: %v", recipient.Email, err)
+			failed++
+			continue
+		}
+		if err := db.RecordReportMailing(from, recipient.Email, time.Now()); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("mailed reports for %s to %s", *month, recipient.Email)
+	}
+	if failed > 0 {
+		log.Fatalf("mailing reports failed for %d recipients", failed)
+	}
+}
+
+// Mail with the recipient's department sheets and absences, nil when none of
+// their departments has employees
+func monthReportMail(reports infra.MailReportsConfig, recipient infra.MailRecipient, month time.Time,
+	sheets []infra.TimesheetSheet, absences []infra.Absence, calendar entity.Calendar, template string) (*infra.Mail, error) {
+	wanted := make(map[string]bool, len(recipient.Departments))
+	for _, d := range recipient.Departments {
+		wanted[d] = true
+	}
+	locale, err := reports.Locale(recipient)
+	if err != nil {
+		return nil, err
+	}
+	data := infra.MailReportData{Recipient: recipient, Locale: locale, Month: month}
+	own := make([]infra.TimesheetSheet, 0)
+	for _, sheet := range sheets {
+		if len(wanted) > 0 && !wanted[sheet.Department] {
+			continue
+		}
+		own = append(own, sheet)
+		data.Departments = append(data.Departments, sheet.Department)
+		data.Employees += len(sheet.Rows)
+	}
+	if len(own) == 0 {
+		return nil, nil
+	}
+	ownAbsences := make([]infra.Absence, 0)
+	for _, a := range absences {
+		if len(wanted) == 0 || wanted[a.Department] {
+			ownAbsences = append(ownAbsences, a)
+		}
+	}
+	data.Absences = len(ownAbsences)
+
+	var timesheet, absenceList bytes.Buffer
+	if err := infra.WriteTimesheetXLSX(&timesheet, month, own, calendar, template, locale); err != nil {
+		return nil, err
+	}
+	if err := infra.WriteAbsenceCSV(&absenceList, ownAbsences); err != nil {
+		return nil, err
+	}
+	subject, body, err := reports.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	suffix := month.Format("2006-01")
+	return &infra.Mail{
+		To:      []string{recipient.Email},
+		Subject: subject,
+		Body:    body,
+		Attachments: []infra.MailAttachment{
+			{Name: "timesheet-" + suffix + ".xlsx", ContentType: xlsxContentType, Data: timesheet.Bytes()},
+			{Name: "absences-" + suffix + ".csv", ContentType: "text/csv; charset=utf-8", Data: absenceList.Bytes()},
+		},
+	}, nil
+}
@@ -1,11 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	entity "github.com/spooky-finn/piek-attendance-prod/entity"
@@ -15,71 +29,1299 @@ import (
 )
 
 var (
-	selectEventsForMonths = flag.Int("selectfor", 2, "select events for last n months")
+	runFlags = flag.NewFlagSet("run", flag.ExitOnError)
+
+	selectEventsForMonths = runFlags.Int("selectfor", 2, "select events for last n months")
+	exportFormat          = runFlags.String("export", "", "additionally write intervals to a file in the given format (clockify, 1c)")
+	exportPath            = runFlags.String("export-path", "intervals.csv", "destination file for -export")
+	exportMask            = runFlags.String("mask", "", "PII masking profile for exports (none, consultant, anonymous or a spec like names,card=4)")
+	createIndexes         = runFlags.Bool("create-indexes", false, "create missing indexes in the destination database")
+	workers               = runFlags.Int("workers", runtime.NumCPU(), "number of users processed concurrently")
+	streamBatchSize       = runFlags.Int("batch", infra.DefaultStreamBatchSize, "number of events exported and inserted at once")
+	policyPath            = runFlags.String("policy", "", "JSON file with interval formation rules, built-in defaults if empty")
+	memoryLimit           = runFlags.Int("memory-limit", 0, "memory ceiling in MB, the run aborts when approaching it (0 disables)")
+	doorCheck             = runFlags.Bool("door-check", false, "cross-check badge events against door sensor openings")
+	allowDestChange       = runFlags.Bool("allow-destination-change", false, "load into a destination other than the one this division and source were loaded into before")
+	daemon                = runFlags.Bool("daemon", false, "keep running and sync on a schedule")
+	daemonInterval        = runFlags.Duration("interval", time.Hour, "sync interval in daemon mode, -resume starts over instead of continuing runs older than it")
+	daemonWatch           = runFlags.Duration("watch", 0, "in daemon mode poll the MDB file this often and sync shortly after it changes (0 disables)")
+	daemonWatchSettle     = runFlags.Duration("watch-settle", 10*time.Second, "how long the MDB file must stay unchanged before a watch triggered sync")
+	daemonListen          = runFlags.String("listen", "", "address for the daemon http endpoints (POST /sync-now, GET /presence/changes), disabled if empty")
+	force                 = runFlags.Bool("force", false, "run even if the MDB file is unchanged since the last successful run")
+	summaryPath           = runFlags.String("summary", "", "write a JSON run summary to this file, - for stdout")
+	stage                 = runFlags.String("stage", "", "run a single stage: extract, sync-employees, load-events or build-intervals")
+	stageDir              = runFlags.String("stage-dir", "stage", "directory the stages hand their data off in")
+	resume                = runFlags.Bool("resume", false, "run stage by stage with checkpoints and continue an interrupted run after its last completed stage")
+	verbose               = runFlags.Bool("v", false, "verbose, also log event batches and stage timings")
+	quiet                 = runFlags.Bool("q", false, "quiet, log warnings and errors only")
+	progressEvery         = runFlags.Duration("progress", 10*time.Second, "log the progress of long stages this often (0 disables)")
+	atomicLoad            = runFlags.Bool("atomic", false, "load employees, events and intervals in one transaction that a failed or interrupted run rolls back")
 )
 
 func main() {
-	log.Println("starting attendance ETL process")
-	flag.Parse()
+	args := os.Args[1:]
+	// without a command, or with flags only, the binary runs the ETL as it did before commands
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"run"}, args...)
+	}
+	if alias, ok := commandAliases[args[0]]; ok {
+		args = append(alias, args[1:]...)
+	}
+	if args[0] == "help" {
+		printCommands(filepath.Base(os.Args[0]), commands)
+		return
+	}
+	c, ok := findCommand(commands, args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", args[0])
+		printCommands(filepath.Base(os.Args[0]), commands)
+		os.Exit(2)
+	}
+	loadEnv()
+	c.run(args[1:])
+}
+
+// run [flags]
+func runCommand(args []string) {
+	runFlags.Parse(args)
+	runOnce()
+}
+
+// backfill [-selectfor n] [run flags] loads a year of history by default and
+// doesn't skip an unchanged source
+func backfillCommand(args []string) {
+	runFlags.Set("selectfor", "12")
+	runFlags.Set("force", "true")
+	runFlags.Parse(args)
+	runOnce()
+}
+
+func runOnce() {
+	setVerbosity(*verbose, *quiet)
+	infof("starting attendance ETL process")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	notifier := infra.NewNotifierFromEnv()
+	if *daemon && *stage != "" {
+		log.Fatalln("-stage runs a single stage once, it can't be combined with -daemon")
+	}
+	if *resume && *stage != "" {
+		log.Fatalln("-resume runs every stage, it can't be combined with -stage")
+	}
+	if *atomicLoad && (*stage != "" || *resume) {
+		log.Fatalln("-atomic loads the whole run in one transaction, stages commit on their own")
+	}
+	if *atomicLoad && os.Getenv("DESTINATION_DRIVER") == infra.DestinationDriverSQLite {
+		log.Fatalln("-atomic needs a Postgres destination")
+	}
+	if *daemon {
+		runDaemon(ctx, *daemonInterval, *daemonListen, *daemonWatch, *daemonWatchSettle, notifier)
+		return
+	}
+
+	summary, err := runETL(ctx)
+	code := exitCode(err)
+	if *summaryPath != "" {
+		if rerr := writeRunReport(*summaryPath, summary, err, code); rerr != nil {
+			log.Printf("error writing run summary: %v", rerr)
+		}
+	}
+	if ctx.Err() == nil {
+		if werr := deliverWebhooks(); werr != nil {
+			// the payloads stay queued for the next run
+			warnf("webhooks not delivered: %v", werr)
+		}
+	}
+	// a skipped run is a successful one as far as freshness alerts go
+	if perr := infra.PushRunMetrics(os.Getenv("PUSHGATEWAY_URL"), summary, err); perr != nil {
+		log.Printf("error pushing metrics: %v", perr)
+	}
+	if summary.Skipped && err == nil {
+		infof("MDB file and run inputs unchanged since the last successful run, nothing to do")
+		return
+	}
+	if perr := publishRun(summary, err, code); perr != nil {
+		log.Printf("error publishing run summary to nats: %v", perr)
+	}
+	if code == exitInterrupted {
+		// a stopped run is recorded as partial, the next one picks up
+		log.Printf("ETL process stopped by signal, partial run recorded: %v", err)
+		os.Exit(code)
+	}
+	if err != nil {
+		if nerr := notifier.NotifyFailure(os.Getenv("CONTROLLER_DIVISION_NAME"), err); nerr != nil {
+			log.Printf("error sending failure notification: %v", nerr)
+		}
+		log.Println(err)
+		os.Exit(code)
+	}
+	if err := notifier.NotifySummary(summary); err != nil {
+		log.Printf("error sending run summary: %v", err)
+	}
+
+	infof("ETL process completed successfully")
+}
+
+// Cancelling ctx ends the run in the stage in progress, its writes are cancelled
+// and the run is recorded as partial and fails as interrupted
+func runETL(ctx context.Context) (summary infra.RunSummary, err error) {
+	runWarnings.reset()
+	defer func() {
+		summary.Warnings = runWarnings.all()
+		err = interruptedRun(ctx, err)
+	}()
+	if os.Getenv("DESTINATION_DRIVER") == infra.DestinationDriverSQLite {
+		return runEdgeETL(ctx)
+	}
+	if *stage != "" {
+		return runStage(ctx, *stage)
+	}
+	if *resume {
+		return runCheckpointed(ctx)
+	}
+
+	summary = infra.RunSummary{
+		Division: os.Getenv("CONTROLLER_DIVISION_NAME"),
+		Started:  time.Now(),
+	}
+	stages := newStageTimer(&summary)
+	defer stages.stop()
+	stages.begin("prepare")
+
+	guard := newMemoryGuard(*memoryLimit)
+
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		return summary, infra.ValidationFailure(err)
+	}
+	norms, normsConfigured, err := infra.WorkNormsFromEnv()
+	if err != nil {
+		return summary, infra.ValidationFailure(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		return summary, infra.ValidationFailure(err)
+	}
+
+	source, err := openRunSource()
+	if err != nil {
+		return summary, infra.SourceFailure(err)
+	}
+
+	var db *infra.Repository
+	var atomicRun *infra.AtomicRun
+	if *atomicLoad {
+		db, atomicRun, err = connectAtomicDestination()
+	} else {
+		db, err = connectDestination()
+	}
+	if err != nil {
+		return summary, infra.DestinationFailure(fmt.Errorf("error connecting to database: %w", err))
+	}
+	defer db.Close()
+	infof("database connection established")
+
+	if err := guardDestination(db, summary.Division, source.name); err != nil {
+		return summary, err
+	}
+
+	err = db.Migrate()
+	if err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+	// only a guarded and migrated destination gets the run recorded, an atomic
+	// run stopped before is rolled back when its connection closes
+	defer func() {
+		if summary.Skipped {
+			return
+		}
+		run := infra.NewETLRun(summary, source.name, summary.Started.AddDate(0, -*selectEventsForMonths, 0), interruptedRun(ctx, err), buildVersion())
+		if rerr := db.RecordETLRun(run); rerr != nil {
+			warnf("%v", rerr)
+		}
+	}()
+	if atomicRun != nil {
+		// finishes the transaction before the run is recorded, which is kept either way
+		defer func() {
+			if err != nil {
+				if rerr := atomicRun.Rollback(); rerr != nil {
+					warnf("%v", rerr)
+				}
+				log.Println("atomic run rolled back, the destination is unchanged")
+				return
+			}
+			if cerr := atomicRun.Commit(); cerr != nil {
+				err = infra.DestinationFailure(cerr)
+			}
+		}()
+	}
+
+	fingerprint, fingerprinted := source.fingerprint()
+	if fingerprinted {
+		if fingerprint.Inputs, err = runInputs(db, policy, calendar, norms); err != nil {
+			return summary, err
+		}
+	}
+	if fingerprinted && !*force {
+		last, ok, err := db.LastSourceFingerprint(summary.Division, source.name)
+		if err != nil {
+			return summary, infra.DestinationFailure(err)
+		}
+		if ok && last.Equal(fingerprint) {
+			summary.Skipped = true
+			summary.Finished = time.Now()
+			return summary, expirePresence(db, summary.Division, calendar)
+		}
+	}
+
+	// users and events are exported by separate mdb-export processes, run them side by side.
+	stages.begin("extract")
+	// mdb-export always dumps a whole table, so events can't be split further by month.
+	infof("exporting users from MDB database")
+	type usersResult struct {
+		users []*entity.User
+		err   error
+	}
+	usersDone := make(chan usersResult, 1)
+	go func() {
+		users, err := source.users(db)
+		usersDone <- usersResult{users, err}
+	}()
+
+	if err := recordDestination(db, summary.Division, source.name); err != nil {
+		return summary, err
+	}
+
+	secondary, err := connectMirror()
+	if err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+	defer secondary.close()
+	secondary.write("migrate", func(db *database.Repository) error { return db.Migrate() })
+
+	err = db.VerifyIndexes(*createIndexes)
+	if err != nil {
+		return summary, infra.DestinationFailure(fmt.Errorf("error verifying indexes: %w", err))
+	}
+
+	infof("streaming events from last %d months into database", *selectEventsForMonths)
+	doorEventTypes, err := parseDoorEventTypes(os.Getenv("DOOR_SENSOR_EVENT_TYPES"))
+	if err != nil {
+		return summary, infra.ValidationFailure(err)
+	}
+	var eventsExported int
+	var eventsInserted int64
+	exported := startProgress("events exported", "", expectedEvents(db, summary, source.name), true, *progressEvery)
+	// events go to their users as they arrive, so the stream waits for the
+	// synced users; closed without a sink when they fail
+	sinkReady := make(chan *eventSink, 1)
+	var sink *eventSink
+	// a failed users export stops the events export as well
+	exportCtx, cancelExport := context.WithCancel(ctx)
+	defer cancelExport()
+	eventsDone := make(chan error, 1)
+	go func() {
+		eventsDone <- source.events.StreamEvents(exportCtx, *selectEventsForMonths, *streamBatchSize, func(batch []entity.Event) error {
+			entity.SortEvents(batch)
+			inserted, err := db.InsertEvents(ctx, batch)
+			if err != nil {
+				return infra.DestinationFailure(err)
+			}
+			secondary.write("insert events", func(db *database.Repository) error {
+				_, err := db.InsertEvents(ctx, batch)
+				return err
+			})
+			eventsExported += len(batch)
+			eventsInserted += inserted
+			exported.add(len(batch))
+			debugf("batch of %d events, %d new", len(batch), inserted)
+			if sink == nil {
+				select {
+				case sink = <-sinkReady:
+				case <-exportCtx.Done():
+					return exportCtx.Err()
+				}
+				if sink == nil {
+					return errors.New("users are not synced")
+				}
+			}
+			sink.add(batch)
+			return guard.check("event export")
+		})
+	}()
+
+	// read while the users and events exports run
+	extras := exportExtras(source, summary.Division)
+
+	res := <-usersDone
+	users := res.users
+	usersErr := res.err
+	var visitors []*entity.User
+	if usersErr == nil {
+		infof("exported %d users", len(users))
+		entity.SortUsers(users)
+		users, visitors, usersErr = syncDirectory(db, secondary, summary.Division, users, extras)
+	} else {
+		usersErr = infra.SourceFailure(fmt.Errorf("error exporting users: %w", usersErr))
+	}
+	// the filter of unknown cards needs the perimeter readers synced with them
+	if usersErr == nil {
+		policy, usersErr = withPerimeterReaders(db, summary.Division, policy)
+	}
+	if usersErr == nil {
+		sinkReady <- newEventSink(users, visitors, policy.Filter, doorEventTypes)
+	} else {
+		cancelExport()
+	}
+	close(sinkReady)
+
+	// the event stream writes to the destination, it must be finished before returning
+	eventsErr := <-eventsDone
+	exported.finish()
+	summary.EventsExported = eventsExported
+	summary.EventsInserted = eventsInserted
+	if usersErr != nil {
+		// export failures are classified as source ones, the rest is syncing
+		return summary, infra.DestinationFailure(usersErr)
+	}
+	summary.UsersSynced = len(users)
+	if eventsErr != nil {
+		return summary, infra.SourceFailure(fmt.Errorf("error exporting events: %w", eventsErr))
+	}
+	if err := interrupted(ctx, "interval computation"); err != nil {
+		return summary, err
+	}
+
+	// no batch arrived when the window is empty
+	if sink == nil {
+		sink = newEventSink(users, visitors, policy.Filter, doorEventTypes)
+	}
+	err = buildIntervals(ctx, db, secondary, &summary, stages, guard, policy, calendar, workNorms{norms, normsConfigured}, sink.data)
+	if err != nil {
+		return summary, err
+	}
 
+	// the fingerprint from before the export, a change during the run triggers the next one
+	if fingerprinted {
+		if err := db.RecordSourceFingerprint(summary.Division, source.name, fingerprint, time.Now()); err != nil {
+			return summary, infra.DestinationFailure(err)
+		}
+	}
+
+	summary.Finished = time.Now()
+	return summary, nil
+}
+
+// Events the run is expected to export going by the last successful run, 0
+// when unknown
+func expectedEvents(db *infra.Repository, summary infra.RunSummary, source string) int {
+	last, ok, err := db.LastSuccessfulETLRun(summary.Division, source)
+	if err != nil || !ok {
+		return 0
+	}
+	return last.ExpectedEvents(summary.Started.AddDate(0, -*selectEventsForMonths, 0), summary.Started)
+}
+
+/*
+ * Hash of what a run derives its tables from besides the source: the flow
+ * policy, calendar and work norms, the leaves of the window and the site day
+ * it ends on. An unchanged source is only skipped while these are unchanged
+ * too, so a new policy, a recorded leave or the next day rebuilds the tables.
+ */
+func runInputs(db *infra.Repository, policy entity.FlowPolicy, calendar entity.Calendar, norms entity.WorkNorms) (string, error) {
+	today := calendar.SiteTime(time.Now()).Truncate(24 * time.Hour)
+	leaves, err := db.Leaves(today.AddDate(0, -*selectEventsForMonths, 0), today.AddDate(0, 0, 1))
+	if err != nil {
+		return "", infra.DestinationFailure(err)
+	}
+	data, err := json.Marshal(struct {
+		Policy   entity.FlowPolicy
+		Calendar entity.Calendar
+		Norms    entity.WorkNorms
+		Months   int
+		Day      string
+		Leaves   []entity.Leave
+	}{policy, calendar, norms, *selectEventsForMonths, today.Format("2006-01-02"), leaves})
+	if err != nil {
+		return "", fmt.Errorf("hashing run inputs: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// The presence snapshot of a skipped run still loses the people OnSite no
+// longer counts as inside, the other tables only change with the run inputs
+func expirePresence(db *infra.Repository, division string, calendar entity.Calendar) error {
+	until := calendar.SiteTime(time.Now()).Add(-entity.IDEAL_WORKSHIFT_DUR * time.Hour)
+	if err := db.ExpirePresence(division, until); err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary, err := connectMirror()
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	defer secondary.close()
+	secondary.write("expire presence", func(db *database.Repository) error {
+		return db.ExpirePresence(division, until)
+	})
+	return nil
+}
+
+// Users of a run with their events once they are in the destination
+type loadedData struct {
+	users    []*entity.User
+	visitors []*entity.User
+	// the first event of every unknown card and day
+	unknown    []entity.Event
+	doorEvents []entity.Event
+}
+
+// Takes the streamed events of a run batch by batch: badge events go to the
+// users collecting them, of unknown cards only the events of their anomalies
+// are kept, so no map of all events is built
+type eventSink struct {
+	router    *entity.EventRouter
+	known     entity.CardIndex
+	filter    entity.EventFilter
+	doorTypes map[int]bool
+	data      loadedData
+}
+
+func newEventSink(users, visitors []*entity.User, filter entity.EventFilter, doorTypes map[int]bool) *eventSink {
+	return &eventSink{
+		router: entity.NewEventRouter(users, visitors),
+		// visitor cards are known, just not employees
+		known:     entity.NewCardIndex(append(append([]*entity.User{}, users...), visitors...)),
+		filter:    filter,
+		doorTypes: doorTypes,
+		data:      loadedData{users: users, visitors: visitors, unknown: make([]entity.Event, 0), doorEvents: make([]entity.Event, 0)},
+	}
+}
+
+func (s *eventSink) add(batch []entity.Event) {
+	unrouted := make([]entity.Event, 0)
+	for _, event := range batch {
+		if *doorCheck && s.doorTypes[event.EventType] {
+			s.data.doorEvents = append(s.data.doorEvents, event)
+			continue
+		}
+		if !s.router.Route(event) {
+			unrouted = append(unrouted, event)
+		}
+	}
+	// filtered out service and test cards are not unknown
+	for _, a := range entity.DetectUnknownCards(s.filter.Apply(unrouted), s.known) {
+		s.data.unknown = append(s.data.unknown, a.Event)
+	}
+}
+
+// Weekly norms of the run, configured when WORK_NORMS_FILE is set
+type workNorms struct {
+	entity.WorkNorms
+	configured bool
+}
+
+// Policy whose shift length is the user's daily norm, the shift length of the
+// policy when no norms are configured
+func (n workNorms) policy(policy entity.FlowPolicy, user *entity.User) entity.FlowPolicy {
+	if n.configured {
+		policy.ShiftHours = n.Weekly(user.Card, user.Department, user.Position) / 5
+	}
+	return policy
+}
+
+// Policy with the perimeter readers of the division when it keeps perimeter
+// readers only, profiles inherit them
+func withPerimeterReaders(db *infra.Repository, division string, policy entity.FlowPolicy) (entity.FlowPolicy, error) {
+	if !policy.Any(func(p entity.FlowPolicy) bool { return p.Filter.PerimeterOnly }) {
+		return policy, nil
+	}
+	perimeter, err := db.PerimeterReaders(division)
+	if err != nil {
+		return policy, infra.DestinationFailure(err)
+	}
+	if len(perimeter) == 0 {
+		return policy, infra.ValidationFailure(fmt.Errorf("flow policy keeps perimeter readers only, but no reader of %s is marked as perimeter", division))
+	}
+	policy.Filter.PerimeterReaders = perimeter
+	return policy, nil
+}
+
+// Derives anomalies, presence, overtime, absences, data quality, intervals,
+// visits and rollups from the loaded users and events and stores them
+func buildIntervals(ctx context.Context, db *infra.Repository, secondary *mirror, summary *infra.RunSummary, stages *stageTimer,
+	guard memoryGuard, policy entity.FlowPolicy, calendar entity.Calendar, norms workNorms, in loadedData) error {
+	users, visitors, doorEvents := in.users, in.visitors, in.doorEvents
+
+	// event times are site wall clock, so is the reference time
+	stages.begin("transform")
+	now := calendar.SiteTime(time.Now())
+	built := startProgress("intervals built", "users", len(users)+len(visitors), false, *progressEvery)
+	defer built.finish()
+	if err := computeUsersFlow(ctx, users, now, *selectEventsForMonths, *workers, policy, built); err != nil {
+		return interrupted(ctx, "anomaly detection")
+	}
+	if err := computeUsersFlow(ctx, visitors, now, *selectEventsForMonths, *workers, policy, built); err != nil {
+		return interrupted(ctx, "visit computation")
+	}
+	built.finish()
+	if err := guard.check("interval computation"); err != nil {
+		return err
+	}
+
+	anomalies := make([]entity.Anomaly, 0)
+	for _, user := range users {
+		anomalies = append(anomalies, user.Anomalies...)
+	}
+	cardholders := append(append([]*entity.User{}, users...), visitors...)
+	// the batches kept a first event per card and day each, these are the first overall
+	anomalies = append(anomalies, entity.DetectUnknownCards(in.unknown, entity.NewCardIndex(cardholders))...)
+	// nil unless door openings are checked
+	var unconfirmed map[int]bool
+	if *doorCheck {
+		unconfirmed = make(map[int]bool)
+		openings := entity.GroupDoorOpenings(doorEvents)
+		for _, user := range users {
+			for _, a := range entity.CrossCheckDoorOpenings(user.Events, openings) {
+				unconfirmed[a.Event.ID] = true
+				anomalies = append(anomalies, a)
+			}
+		}
+		infof("%d badge events without door opening", len(unconfirmed))
+	}
+	entity.SortAnomalies(anomalies)
+	summary.Anomalies = len(anomalies)
+	infof("detected %d anomalies", len(anomalies))
+	if err := interrupted(ctx, "anomaly insert"); err != nil {
+		return err
+	}
+	stages.begin("load")
+	newAnomalies, err := db.InsertAnomalies(summary.Division, anomalies)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("insert anomalies", func(db *database.Repository) error {
+		_, err := db.InsertAnomalies(summary.Division, anomalies)
+		return err
+	})
+
+	// visitors are on the evacuation list like everyone else inside
+	presence := make([]infra.Presence, 0)
+	for _, user := range cardholders {
+		if ent, ok := user.OnSite(now); ok {
+			presence = append(presence, infra.Presence{
+				Card:     user.Card,
+				Database: summary.Division,
+				Name:     fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+				Since:    ent.Time,
+			})
+		}
+	}
+	if err := interrupted(ctx, "presence update"); err != nil {
+		return err
+	}
+	err = db.ReplacePresence(summary.Division, presence)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("replace presence", func(db *database.Repository) error {
+		return db.ReplacePresence(summary.Division, presence)
+	})
+	summary.OnSite = presence
+	infof("%d people currently on site", len(presence))
+
+	overtime := make([]entity.Overtime, 0)
+	for _, user := range users {
+		overtime = append(overtime, user.Overtime(norms.policy(policy.ForUser(user), user), calendar)...)
+	}
+	windowStart := now.AddDate(0, -*selectEventsForMonths, 0)
+	if err := interrupted(ctx, "overtime update"); err != nil {
+		return err
+	}
+	err = db.ReplaceOvertime(summary.Division, windowStart, overtime)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("replace overtime", func(db *database.Repository) error {
+		return db.ReplaceOvertime(summary.Division, windowStart, overtime)
+	})
+	infof("%d overtime entries", len(overtime))
+
+	today := now.Truncate(24 * time.Hour)
+	leaves, err := db.Leaves(windowStart, today.AddDate(0, 0, 1))
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	// vacations and sick leaves explain the days without events
+	absences := entity.ExcludeLeaves(entity.DetectAbsences(users, windowStart, today, calendar), leaves)
+	if err := interrupted(ctx, "absence update"); err != nil {
+		return err
+	}
+	err = db.ReplaceAbsences(summary.Division, windowStart.Truncate(24*time.Hour), absences)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("replace absences", func(db *database.Repository) error {
+		return db.ReplaceAbsences(summary.Division, windowStart.Truncate(24*time.Hour), absences)
+	})
+	infof("%d absences", len(absences))
+
+	if policy.Any(func(p entity.FlowPolicy) bool { return len(p.Zones) > 0 }) {
+		zones := make([]entity.ZoneInterval, 0)
+		for _, user := range users {
+			zones = append(zones, user.Zones...)
+		}
+		if err := interrupted(ctx, "zone interval update"); err != nil {
+			return err
+		}
+		if err := db.ReplaceZoneIntervals(summary.Division, windowStart, zones); err != nil {
+			return infra.DestinationFailure(err)
+		}
+		secondary.write("replace zone intervals", func(db *database.Repository) error {
+			return db.ReplaceZoneIntervals(summary.Division, windowStart, zones)
+		})
+		infof("%d zone intervals", len(zones))
+	}
+
+	// anomalies of users are counted per user, the rest are cards nobody owns
+	unbound := make([]entity.Anomaly, 0)
+	for _, anomaly := range anomalies {
+		if anomaly.Reason == entity.AnomalyUnknownCard {
+			unbound = append(unbound, anomaly)
+		}
+	}
+	quality := entity.ComputeDayQuality(users, unbound)
+	if err := interrupted(ctx, "data quality update"); err != nil {
+		return err
+	}
+	err = db.UpsertDataQuality(summary.Division, windowStart, quality)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("data quality", func(db *database.Repository) error {
+		return db.UpsertDataQuality(summary.Division, windowStart, quality)
+	})
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	for _, q := range quality {
+		if q.Day.Equal(yesterday) {
+			summary.QualityScore = q.Score()
+		}
+	}
+
+	intervals := formIntervals(users, summary.Division, unconfirmed)
+	if err := guard.check("interval formation"); err != nil {
+		return err
+	}
+	infof("formed %d intervals for last %d months", len(intervals), *selectEventsForMonths)
+	summary.IntervalsFormed = len(intervals)
+
+	infof("inserting intervals to database")
+	if err := interrupted(ctx, "interval insert"); err != nil {
+		return err
+	}
+	newIntervals, err := db.ReplaceIntervals(ctx, summary.Division, windowStart, intervals)
+	if err != nil {
+		return infra.DestinationFailure(fmt.Errorf("error inserting intervals: %w", err))
+	}
+	summary.IntervalsInserted = int64(len(newIntervals))
+	secondary.write("replace intervals", func(db *database.Repository) error {
+		_, err := db.ReplaceIntervals(ctx, summary.Division, windowStart, intervals)
+		return err
+	})
+
+	merges := make([]entity.IntervalMerge, 0)
+	for _, user := range users {
+		merges = append(merges, user.Merges...)
+	}
+	if err := db.ReplaceIntervalMerges(summary.Division, windowStart, merges); err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("replace interval merges", func(db *database.Repository) error {
+		return db.ReplaceIntervalMerges(summary.Division, windowStart, merges)
+	})
+	if len(merges) > 0 {
+		infof("%d short exits merged by the grace period", len(merges))
+	}
+
+	if policy.Any(func(p entity.FlowPolicy) bool { return p.DailySummary }) {
+		days := make([]entity.DailyAttendance, 0)
+		summaryStart := windowStart.Truncate(24 * time.Hour)
+		for _, user := range users {
+			if policy.ForUser(user).DailySummary {
+				days = append(days, user.DailyAttendance(summaryStart)...)
+			}
+		}
+		if err := db.ReplaceDailyAttendance(summary.Division, summaryStart, days); err != nil {
+			return infra.DestinationFailure(err)
+		}
+		secondary.write("replace daily attendance", func(db *database.Repository) error {
+			return db.ReplaceDailyAttendance(summary.Division, summaryStart, days)
+		})
+		infof("%d first-in/last-out days", len(days))
+	}
+
+	visits := formIntervals(visitors, summary.Division, unconfirmed)
+	if err := db.InsertVisits(visits); err != nil {
+		return infra.DestinationFailure(fmt.Errorf("error inserting visits: %w", err))
+	}
+	secondary.write("insert visits", func(db *database.Repository) error { return db.InsertVisits(visits) })
+	infof("%d visits of %d visitor cards", len(visits), len(visitors))
+
+	if err := interrupted(ctx, "rollup refresh"); err != nil {
+		return err
+	}
+	err = db.RefreshRollups(summary.Division, windowStart)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	secondary.write("refresh rollups", func(db *database.Repository) error {
+		return db.RefreshRollups(summary.Division, windowStart)
+	})
+	summary.Destinations = secondary.results()
+
+	if err := enqueueWebhooks(db, *summary, newIntervals, newAnomalies); err != nil {
+		return infra.DestinationFailure(fmt.Errorf("error queueing webhooks: %w", err))
+	}
+	if err := publishAnomalies(summary.Division, newAnomalies); err != nil {
+		warnf("anomalies not published to nats: %v", err)
+	}
+
+	if *exportFormat != "" {
+		stages.begin("export")
+		infof("exporting intervals as %s to %s", *exportFormat, *exportPath)
+		err = exportIntervals(*exportFormat, *exportPath, users)
+		if err != nil {
+			return fmt.Errorf("error exporting intervals: %w", err)
+		}
+		// the export is on disk, a failed upload doesn't fail the load
+		if err := uploadArtifact(*exportPath, summary.Started); err != nil {
+			warnf("%v", err)
+		}
+	}
+	return nil
+}
+
+func loadEnv() {
 	err := godotenv.Load(".env")
 	if err != nil {
 		panic("Error loading .env file")
 	}
 	log.Println(".env file loaded")
+}
+
+func connectDestination() (*database.Repository, error) {
+	destDBconnStr, err := destinationDSN()
+	if err != nil {
+		return nil, err
+	}
+	return connectNamed(destDBconnStr)
+}
+
+// Destination whose writes all happen in the transaction of the atomic run
+func connectAtomicDestination() (*database.Repository, *infra.AtomicRun, error) {
+	dsn, err := destinationDSN()
+	if err != nil {
+		return nil, nil, err
+	}
+	naming, err := infra.NamingFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	pool, err := infra.PoolConfigFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	return database.ConnectAtomic(dsn, naming, pool)
+}
+
+func destinationDSN() (string, error) {
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return "", err
+	}
+	config, err := infra.PostgresConfigFromEnv(secrets)
+	if err != nil {
+		return "", err
+	}
+	return config.DSN()
+}
+
+// Destinations take the schema and table names of DESTINATION_SCHEMA and DESTINATION_TABLES,
+// the driver and pool limits of DB_*
+func connectNamed(dsn string) (*database.Repository, error) {
+	naming, err := infra.NamingFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := infra.PoolConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return database.ConnectNamed(dsn, naming, pool)
+}
+
+// Opens the source database and selects its schema profile
+func newExporter(mdbpath string) (*infra.MdbExporter, error) {
+	exporter, err := openExporter(mdbpath)
+	if err != nil {
+		return nil, err
+	}
+	if err := selectSchema(exporter); err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
 
-	mdbpath := os.Getenv("ACCESS_MDB_PATH")
-	log.Printf("initializing MDB exporter with path: %s", mdbpath)
+// Picks the export command by the file signature, MDB_PASSWORD is resolved as a
+// secret and protected databases are checked against it
+func openExporter(mdbpath string) (*infra.MdbExporter, error) {
 	exporter := infra.NewMdbExporter(mdbpath)
+	exporter.SetACCDBExportBin(os.Getenv("ACCDB_EXPORT_BIN"))
+	format, err := exporter.DetectFormat()
+	if err != nil {
+		warnf("%v, assuming a Jet database", err)
+	} else {
+		infof("source database format %s", format)
+	}
 
-	log.Println("exporting users from MDB database")
-	users, err := exporter.ExportUsersFromDB()
+	secrets, err := infra.NewSecretProviderFromEnv()
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	log.Printf("exported %d users", len(users))
+	if err := exporter.UnlockWith(secrets); err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
 
-	log.Printf("exporting events from last %d months", *selectEventsForMonths)
-	events, err := exporter.ExportEventsFromDB(*selectEventsForMonths)
+// MDB_SCHEMA_PROFILE names the table and column names of the controller
+// software version, auto or empty detects them from the file. Profiles of
+// MDB_SCHEMA_FILE come before the built-in one. Without mdb-schema detection
+// falls back to the built-in profile.
+func selectSchema(exporter *infra.MdbExporter) error {
+	exporter.OnUnknownColumns(func(table string, columns []string) {
+		debugf("%s: ignoring columns %s", table, strings.Join(columns, ", "))
+	})
+	var profiles []entity.SchemaProfile
+	if path := os.Getenv("MDB_SCHEMA_FILE"); path != "" {
+		var err error
+		if profiles, err = infra.ReadSchemaProfiles(path); err != nil {
+			return err
+		}
+	}
+	name := os.Getenv("MDB_SCHEMA_PROFILE")
+	profile, err := exporter.SelectSchema(name, profiles)
+	if err != nil && (name == "" || name == "auto") && !errors.Is(err, entity.ErrNoSchemaProfile) {
+		warnf("%v, assuming the %s schema", err, entity.DefaultSchemaProfile().Name)
+		return nil
+	}
 	if err != nil {
-		log.Fatalf("error exporting events: %v", err)
+		return err
 	}
+	infof("source schema profile %s", profile.Name)
+	return nil
+}
+
+// Comma separated controller event codes of door sensor records, 200 is "door opened" in ZKAccess
+func parseDoorEventTypes(value string) (map[int]bool, error) {
+	if value == "" {
+		value = "200"
+	}
+	result := make(map[int]bool)
+	for _, code := range strings.Split(value, ",") {
+		eventType, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DOOR_SENSOR_EVENT_TYPES: %w", err)
+		}
+		result[eventType] = true
+	}
+	return result, nil
+}
 
-	destDBconnStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"),
-		os.Getenv("POSTGRES_HOST"),
-		os.Getenv("POSTGRES_PORT"),
-		os.Getenv("POSTGRES_DB"),
-	)
-	db, err := database.Connect(destDBconnStr)
+func loadFlowPolicy(path string) (entity.FlowPolicy, error) {
+	if path == "" {
+		return entity.DefaultFlowPolicy(), nil
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("error connecting to database: %v", err)
+		return entity.FlowPolicy{}, fmt.Errorf("reading flow policy: %w", err)
+	}
+	return entity.ParseFlowPolicy(data)
+}
+
+func exportControllerMetadata(exporter *infra.MdbExporter, division string) (infra.ControllerMetadata, error) {
+	table := os.Getenv("CONTROLLER_METADATA_TABLE")
+	if table == "" {
+		table = "Machines"
 	}
-	log.Println("database connection established")
 
-	log.Println("syncing employees to database")
-	err = db.SyncEmployees(users)
+	jetVersion, err := exporter.JetVersion()
+	if err != nil {
+		return infra.ControllerMetadata{}, err
+	}
+	rows, err := exporter.ExportTable(table)
 	if err != nil {
-		log.Fatalf("error syncing users: %v", err)
+		return infra.ControllerMetadata{}, err
 	}
+	return infra.NewControllerMetadata(division, jetVersion, table, rows)
+}
+
+// What an MDB source tells besides users and events, read while extracting.
+// A part that couldn't be read is left out and the destination keeps its copy.
+type sourceExtras struct {
+	Metadata *infra.ControllerMetadata `json:"metadata,omitempty"`
+	Readers  []entity.Reader           `json:"readers,omitempty"`
+	Photos   []infra.EmployeePhoto     `json:"photos,omitempty"`
+}
 
-	log.Println("inserting events to database")
-	err = db.InsertEvents(events)
+// Metadata only helps explaining odd numbers, doors and faces are conveniences
+// of the portals, failing to read them doesn't stop the sync
+func exportExtras(source runSource, division string) sourceExtras {
+	var extras sourceExtras
+	if source.exporter == nil {
+		return extras
+	}
+	if metadata, err := exportControllerMetadata(source.exporter, division); err != nil {
+		warnf("controller metadata not captured: %v", err)
+	} else {
+		extras.Metadata = &metadata
+	}
+	if readers, err := source.exporter.ExportReaders(); err != nil {
+		warnf("readers not exported: %v", err)
+	} else {
+		extras.Readers = readers
+	}
+	if photos, err := source.exporter.ExportPhotos(); err != nil {
+		warnf("employee photos not exported: %v", err)
+	} else {
+		extras.Photos = photos
+	}
+	return extras
+}
+
+/*
+ * Syncs everything the source tells about people and doors: visitors,
+ * employees prepared with card assignments and master data, then the extras.
+ * Runs and the sync-employees stage both go through here, so a stage run
+ * leaves the destination as a full run does. Only visitors and employees fail
+ * the sync.
+ */
+func syncDirectory(db *infra.Repository, secondary *mirror, division string, users []*entity.User, extras sourceExtras) (employees, visitors []*entity.User, err error) {
+	employees, visitors = entity.SplitVisitors(users)
+	if err := syncVisitors(db, secondary, visitors); err != nil {
+		return nil, nil, err
+	}
+	employees, err = syncUsers(db, secondary, division, employees)
 	if err != nil {
-		log.Fatalf("error inserting events: %v", err)
+		return nil, nil, err
+	}
+
+	if extras.Metadata != nil {
+		if err := db.RecordControllerMetadata(*extras.Metadata); err != nil {
+			warnf("controller metadata not captured: %v", err)
+		}
+	}
+	if err := syncReaders(db, secondary, division, extras.Readers); err != nil {
+		warnf("readers not synced: %v", err)
+	}
+	if err := syncPhotos(db, secondary, division, extras.Photos); err != nil {
+		warnf("employee photos not synced: %v", err)
 	}
+	return employees, visitors, nil
+}
 
-	eventsmap := make(map[string][]entity.Event)
-	for _, event := range events {
-		eventsmap[event.Card] = append(eventsmap[event.Card], event)
+// Prepares controller users with card assignments and master data and syncs them
+func syncUsers(db *infra.Repository, secondary *mirror, division string, users []*entity.User) ([]*entity.User, error) {
+	assignments, err := db.CardAssignments()
+	if err != nil {
+		return nil, err
 	}
+	users = entity.AttachCardAssignments(users, assignments)
 
-	intervals := make([]infra.Interval, 0)
+	// card reissues are detected on this name
 	for _, user := range users {
-		user.AddEvents(eventsmap[user.Card])
-		user.RunFlow(*selectEventsForMonths)
+		user.SourceName = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	if err := applyHRMasterData(db, division, users); err != nil {
+		// controller data is still a usable employee source
+		warnf("hr master data not applied: %v", err)
+	}
+
+	if err := enrichFromDirectory(db, users); err != nil {
+		warnf("directory enrichment skipped: %v", err)
+	}
+
+	// hand maintained names are the last word on garbage controller names
+	mappings, err := db.CardMappings()
+	if err != nil {
+		return nil, err
+	}
+	if renamed := entity.ApplyCardMappings(users, mappings); renamed > 0 {
+		infof("%d employees renamed by card mappings", renamed)
+	}
 
+	infof("syncing employees to database")
+	if err := db.SyncEmployees(users); err != nil {
+		return nil, fmt.Errorf("error syncing users: %w", err)
+	}
+	secondary.write("sync employees", func(db *database.Repository) error { return db.SyncEmployees(users) })
+
+	// a new card of someone already known is loaded like any other, the pair is
+	// left to be merged by hand
+	if duplicates, err := db.DetectDuplicateEmployees(time.Now()); err != nil {
+		warnf("duplicate employee detection skipped: %v", err)
+	} else if len(duplicates) > 0 {
+		warnf("%d likely duplicate employees, see /api/employees/duplicates", len(duplicates))
+	}
+	return users, nil
+}
+
+// Visitor and contractor cards are kept apart from the employees
+func syncVisitors(db *infra.Repository, secondary *mirror, visitors []*entity.User) error {
+	if len(visitors) == 0 {
+		return nil
+	}
+	infof("syncing %d visitor cards to database", len(visitors))
+	now := time.Now()
+	if err := db.SyncVisitors(visitors, now); err != nil {
+		return fmt.Errorf("error syncing visitors: %w", err)
+	}
+	secondary.write("sync visitors", func(db *database.Repository) error { return db.SyncVisitors(visitors, now) })
+	return nil
+}
+
+func syncReaders(db *infra.Repository, secondary *mirror, division string, readers []entity.Reader) error {
+	if len(readers) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if err := db.SyncReaders(division, readers, now); err != nil {
+		return err
+	}
+	secondary.write("sync readers", func(db *database.Repository) error { return db.SyncReaders(division, readers, now) })
+	infof("synced %d readers", len(readers))
+	return nil
+}
+
+func syncPhotos(db *infra.Repository, secondary *mirror, division string, photos []infra.EmployeePhoto) error {
+	if len(photos) == 0 {
+		return nil
+	}
+	now := time.Now()
+	changed, err := db.SyncEmployeePhotos(division, photos, now)
+	if err != nil {
+		return err
+	}
+	secondary.write("sync photos", func(db *database.Repository) error {
+		_, err := db.SyncEmployeePhotos(division, photos, now)
+		return err
+	})
+	infof("%d of %d employee photos changed", changed, len(photos))
+	return nil
+}
+
+// Refuses to load into a destination other than the configured DESTINATION_ID,
+// staging data must never end up in production. Runs before anything is
+// written to the destination.
+func guardDestination(db *infra.Repository, division, source string) error {
+	id, err := db.DestinationIdentity()
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	recorded, _, err := db.SyncStateDestination(division, source)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	err = infra.CheckDestination(os.Getenv("DESTINATION_ID"), id, recorded, division, source)
+	if err != nil && !*allowDestChange {
+		return infra.ValidationFailure(fmt.Errorf("%w, pass -allow-destination-change if intended", err))
+	}
+	if err != nil {
+		warnf("%v, loading anyway", err)
+	}
+	return nil
+}
+
+// Records the destination the division and source were loaded into, a
+// destination admitted by -allow-destination-change gets an id first
+func recordDestination(db *infra.Repository, division, source string) error {
+	id, created, err := db.EnsureDestinationID(time.Now())
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	if created {
+		warnf("destination %s on %s got id %s, set DESTINATION_ID=%s", id.Database, id.Host, id.ID, id.ID)
+	}
+	if err := db.RecordSyncState(division, source, id, time.Now()); err != nil {
+		return infra.DestinationFailure(err)
+	}
+	return nil
+}
+
+// Queues the new intervals and anomalies for the webhooks with the rest of the run
+func enqueueWebhooks(db *infra.Repository, summary infra.RunSummary, intervals []infra.Interval, anomalies []infra.Anomaly) error {
+	if len(intervals) == 0 && len(anomalies) == 0 {
+		return nil
+	}
+	webhooks, err := webhooksFromEnv()
+	if err != nil || webhooks == nil {
+		return err
+	}
+	return webhooks.Enqueue(db, summary.Division, summary.Started, intervals, anomalies)
+}
+
+// Posts what runs queued for the webhooks, including the payloads earlier
+// runs failed to deliver. Runs after the run is committed, skipped ones too.
+func deliverWebhooks() error {
+	if os.Getenv("DESTINATION_DRIVER") == infra.DestinationDriverSQLite {
+		return nil
+	}
+	webhooks, err := webhooksFromEnv()
+	if err != nil || webhooks == nil {
+		return err
+	}
+	db, err := connectDestination()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return webhooks.Deliver(db)
+}
+
+func webhooksFromEnv() (*infra.Webhooks, error) {
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return infra.NewWebhooksFromEnv(secrets)
+}
+
+func publishAnomalies(division string, anomalies []infra.Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+	publisher, err := natsPublisher()
+	if err != nil || publisher == nil {
+		return err
+	}
+	return publisher.PublishAnomalies(division, anomalies)
+}
+
+func natsPublisher() (*infra.NATSPublisher, error) {
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return infra.NewNATSPublisherFromEnv(secrets)
+}
+
+// Links users to their corporate directory accounts when LDAP is configured
+func enrichFromDirectory(db *infra.Repository, users []*entity.User) error {
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return err
+	}
+	directory, err := infra.NewDirectoryFromEnv(secrets)
+	if err != nil || directory == nil {
+		return err
+	}
+
+	accounts, err := directory.Accounts()
+	if err != nil {
+		return err
+	}
+	mapping, err := db.DirectoryMapping()
+	if err != nil {
+		return err
+	}
+	matches := entity.MatchDirectory(users, accounts, mapping)
+	infof("matched %d of %d users to %d directory accounts", len(matches), len(users), len(accounts))
+	return db.UpsertEmployeeDirectory(matches, time.Now())
+}
+
+// Takes names and departments from the HR API when configured and records
+// where they disagree with the controller
+func applyHRMasterData(db *infra.Repository, division string, users []*entity.User) error {
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return err
+	}
+	source, err := infra.NewHRSourceFromEnv(secrets)
+	if err != nil || source == nil {
+		return err
+	}
+
+	master, err := source.Employees()
+	if err != nil {
+		return err
+	}
+	conflicts := entity.ApplyMasterData(users, master)
+	infof("applied hr master data of %d employees, %d conflicts", len(master), len(conflicts))
+	return db.ReplaceEmployeeConflicts(division, conflicts, time.Now())
+}
+
+func exportIntervals(format, path string, users []*entity.User) error {
+	if format != infra.ExportFormatClockify && format != infra.ExportFormat1C {
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+	mask, err := infra.ParseMaskingProfile(*exportMask)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == infra.ExportFormat1C {
+		// payroll needs real personnel codes, masking does not apply
+		return infra.WritePayroll1C(f, users)
+	}
+	return infra.WriteTimeTrackingCSV(f, os.Getenv("CONTROLLER_DIVISION_NAME"), users, mask)
+}
+
+// Users are independent, so their event flows are computed by a pool of workers.
+// Once ctx is cancelled no further users are dispatched.
+func computeUsersFlow(ctx context.Context, users []*entity.User, now time.Time, months, workers int,
+	policy entity.FlowPolicy, progress *progress) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan *entity.User)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range queue {
+				user.AddEvents(user.Events)
+				user.RunFlowAt(now, months, policy.ForUser(user))
+				progress.add(1)
+			}
+		}()
+	}
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- user
+	}
+	close(queue)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Stored intervals of the computed flows, confidence is set when door openings were checked
+func formIntervals(users []*entity.User, division string, unconfirmed map[int]bool) []infra.Interval {
+	intervals := make([]infra.Interval, 0)
+	for _, user := range users {
 		for _, interval := range user.Intervals {
 			extTime := "nil"
 			extId := 0
@@ -92,22 +1334,37 @@ func main() {
 				Ent:        interval.Ent.Time.Format("2006-01-02T15:04:05"),
 				Card:       user.Card,
 				Ext:        sql.NullString{String: extTime, Valid: extTime != "nil"},
-				Database:   os.Getenv("CONTROLLER_DIVISION_NAME"),
+				Database:   division,
 				EntEventID: interval.Ent.ID,
 				ExtEventID: sql.NullInt64{
 					Int64: int64(extId),
 					Valid: extId != 0,
 				},
+				Confidence: sql.NullFloat64{
+					Float64: entity.IntervalConfidence(interval, unconfirmed),
+					Valid:   unconfirmed != nil,
+				},
 			})
 		}
 	}
-	log.Printf("formed %d intervals for last %d months", len(intervals), *selectEventsForMonths)
+	// workers finish in arbitrary order, keep inserts reproducible
+	sort.Slice(intervals, func(i, j int) bool {
+		if intervals[i].Card != intervals[j].Card {
+			return intervals[i].Card < intervals[j].Card
+		}
+		if intervals[i].Ent != intervals[j].Ent {
+			return intervals[i].Ent < intervals[j].Ent
+		}
+		return intervals[i].EntEventID < intervals[j].EntEventID
+	})
+	return intervals
+}
 
-	log.Println("inserting intervals to database")
-	err = db.InsertIntervals(intervals)
-	if err != nil {
-		log.Fatalf("error getting intervals: %v", err)
+// Checked between stages: a cancelled run stops before its next write, the
+// stage already running is never cut off in the middle of a transaction
+func interrupted(ctx context.Context, stage string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("run interrupted before %s: %w", stage, err)
 	}
-
-	log.Println("ETL process completed successfully")
+	return nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// import-mapping -file mapping.csv [-replace] loads canonical card holder names
+// that the next run prefers over the names stored in the controller
+func importMappingCommand(args []string) {
+	fs := flag.NewFlagSet("import-mapping", flag.ExitOnError)
+	file := fs.String("file", "", "CSV with card, firstname, lastname and employee_number columns")
+	replace := fs.Bool("replace", false, "delete mappings of cards missing from the file")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalln("-file is required")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	mappings, err := infra.ReadCardMappingsCSV(f)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.ImportCardMappings(mappings, *replace, time.Now()); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d card mappings imported, they apply from the next run", len(mappings))
+}
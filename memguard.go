@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+)
+
+// Share of the ceiling at which a run is aborted rather than left to the OOM killer
+const memoryGuardThreshold = 0.9
+
+type memoryGuard struct {
+	limit uint64
+}
+
+// A zero limit disables the guard. The limit is also applied as the GC soft
+// limit so the collector works harder before the guard gives up.
+func newMemoryGuard(limitMB int) memoryGuard {
+	if limitMB <= 0 {
+		return memoryGuard{}
+	}
+	limit := uint64(limitMB) << 20
+	debug.SetMemoryLimit(int64(limit))
+	log.Printf("memory ceiling set to %d MB", limitMB)
+	return memoryGuard{limit: limit}
+}
+
+func (g memoryGuard) check(stage string) error {
+	if g.limit == 0 {
+		return nil
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	used := stats.HeapAlloc + stats.StackInuse
+	if float64(used) < float64(g.limit)*memoryGuardThreshold {
+		return nil
+	}
+
+	return fmt.Errorf("memory guard: %d MB in use during %s is close to the %d MB ceiling, "+
+		"run with a smaller -selectfor window or raise -memory-limit", used>>20, stage, g.limit>>20)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+// merge-employees -card canonical -duplicate card [-by name] folds a duplicate
+// employee record into the canonical one
+func mergeEmployeesCommand(args []string) {
+	fs := flag.NewFlagSet("merge-employees", flag.ExitOnError)
+	card := fs.String("card", "", "card of the canonical employee that is kept")
+	duplicate := fs.String("duplicate", "", "card of the duplicate employee that is merged and deleted")
+	by := fs.String("by", os.Getenv("USER"), "who merges, recorded in the audit log")
+	fs.Parse(args)
+
+	if *card == "" || *duplicate == "" {
+		log.Fatalln("-card and -duplicate are required")
+	}
+	if *by == "" {
+		log.Fatalln("-by is required")
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	merge, err := db.MergeEmployees(*card, *duplicate, *by)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("merged %s %s (%s) into %s: %d events, %d intervals, %d card history records moved",
+		merge.DuplicateLastName, merge.DuplicateFirstName, merge.DuplicateCard, merge.Card,
+		merge.Events, merge.Intervals, merge.CardHistory)
+}
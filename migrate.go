@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// migrate [up|status]
+func migrateCommand(args []string) {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	switch action {
+	case "up":
+		if err := db.Migrate(); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("database schema is up to date")
+	case "status":
+		status, err := db.MigrationsStatus()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, m := range status {
+			if m.AppliedAt == nil {
+				fmt.Printf("%s\tpending\n", m.Version)
+			} else {
+				fmt.Printf("%s\tapplied %s\n", m.Version, m.AppliedAt.Format("2006-01-02T15:04:05"))
+			}
+		}
+	default:
+		log.Fatalf("unknown migrate action: %s, expected up or status", action)
+	}
+}
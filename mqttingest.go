@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// mqtt-ingest subscribes to turnstile events and buffers them into the event
+// spool, runs with EVENT_SOURCE=mqtt load them like MDB events. A message is
+// acknowledged once its event is synced to the spool, after a crash the broker
+// delivers the rest again.
+func mqttIngestCommand(args []string) {
+	fs := flag.NewFlagSet("mqtt-ingest", flag.ExitOnError)
+	flush := fs.Duration("flush", 200*time.Millisecond, "how long received events wait for more before they are written to the spool and acknowledged")
+	batch := fs.Int("batch", 1000, "buffered events that trigger an early flush")
+	fs.Parse(args)
+
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	config, err := infra.MQTTConfigFromEnv(secrets)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	spool, err := infra.NewEventSpool(os.Getenv("EVENT_SPOOL_DIR"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// events of one flush share a spool write, each waits for its result
+	type pending struct {
+		event entity.Event
+		done  chan error
+	}
+	var mu sync.Mutex
+	buffer := make([]pending, 0, *batch)
+	received := make(chan struct{}, 1)
+	flushBuffer := func() {
+		mu.Lock()
+		flushed := buffer
+		buffer = make([]pending, 0, *batch)
+		mu.Unlock()
+		if len(flushed) == 0 {
+			return
+		}
+		events := make([]entity.Event, len(flushed))
+		for i, p := range flushed {
+			events[i] = p.event
+		}
+		err := spool.Append(events)
+		if err != nil {
+			log.Printf("error spooling events: %v", err)
+		} else {
+			log.Printf("spooled %d events", len(events))
+		}
+		for _, p := range flushed {
+			p.done <- err
+		}
+	}
+
+	// the flusher outlives the subscription, messages handled while it
+	// disconnects are still spooled
+	unsubscribed := make(chan struct{})
+	flusherDone := make(chan struct{})
+	go func() {
+		defer close(flusherDone)
+		for {
+			select {
+			case <-received:
+			case <-unsubscribed:
+				flushBuffer()
+				return
+			}
+			// gather the events arriving meanwhile unless the batch is full
+			mu.Lock()
+			n := len(buffer)
+			mu.Unlock()
+			if n < *batch {
+				select {
+				case <-time.After(*flush):
+				case <-unsubscribed:
+				}
+			}
+			flushBuffer()
+		}
+	}()
+
+	log.Printf("subscribing to %v on %s", config.Topics, config.Broker)
+	err = infra.SubscribeMQTT(ctx, config, calendar.SiteTime, func(event entity.Event) error {
+		p := pending{event: event, done: make(chan error, 1)}
+		mu.Lock()
+		buffer = append(buffer, p)
+		mu.Unlock()
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		select {
+		case err := <-p.done:
+			return err
+		case <-flusherDone:
+			// not acknowledged, delivered again on the next start
+			select {
+			case err := <-p.done:
+				return err
+			default:
+				return errors.New("mqtt ingest stopped before spooling the event")
+			}
+		}
+	})
+	close(unsubscribed)
+	<-flusherDone
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("mqtt ingest stopped")
+}
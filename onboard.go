@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+type readinessCheck struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+// Prepares the destination for a new division and prints a readiness report.
+// Nothing but configuration and reference tables is written, the extraction is a dry run.
+func onboardDivision(args []string) {
+	fs := flag.NewFlagSet("onboard-division", flag.ExitOnError)
+	division := fs.String("division", os.Getenv("CONTROLLER_DIVISION_NAME"), "division name")
+	mdbpath := fs.String("mdb", os.Getenv("ACCESS_MDB_PATH"), "path to the division MDB file")
+	months := fs.Int("selectfor", 1, "months of events used for the dry-run extraction")
+	shiftStart := fs.String("shift-start", "08:00", "default shift start")
+	shiftEnd := fs.String("shift-end", "17:00", "default shift end")
+	fs.Parse(args)
+
+	if *division == "" {
+		log.Fatalln("division name is required")
+	}
+
+	checks := make([]readinessCheck, 0)
+	report := func(name string, err error, detail string) bool {
+		c := readinessCheck{name: name, ok: err == nil, detail: detail}
+		if err != nil {
+			c.detail = err.Error()
+		}
+		checks = append(checks, c)
+		return c.ok
+	}
+	defer func() {
+		if !printReadinessReport(*division, checks) {
+			os.Exit(1)
+		}
+	}()
+
+	db, err := connectDestination()
+	if !report("destination connectivity", err, "connected") {
+		return
+	}
+	defer db.Close()
+
+	exporter, err := newExporter(*mdbpath)
+	if !report("source access", err, "readable") {
+		return
+	}
+	users, err := exporter.ExportUsersFromDB()
+	if !report("source users", err, fmt.Sprintf("%d users", len(users))) {
+		return
+	}
+	events, err := exporter.ExportEventsFromDB(*months)
+	if !report("source events", err, fmt.Sprintf("%d events in last %d months", len(events), *months)) {
+		return
+	}
+
+	if !report("schema migrations", db.Migrate(), "applied") {
+		return
+	}
+
+	id, _, err := db.EnsureDestinationID(time.Now())
+	if err == nil && os.Getenv("DESTINATION_ID") != "" && os.Getenv("DESTINATION_ID") != id.ID {
+		err = fmt.Errorf("destination id is %s, DESTINATION_ID is %s", id.ID, os.Getenv("DESTINATION_ID"))
+	}
+	if !report("destination id", err, fmt.Sprintf("DESTINATION_ID=%s", id.ID)) {
+		return
+	}
+
+	err = db.SetDivisionConfig(*division, map[string]string{
+		"mdb_path": *mdbpath,
+	})
+	if !report("division config", err, "mdb_path stored") {
+		return
+	}
+
+	readerNames := make(map[string]bool)
+	for _, event := range events {
+		readerNames[event.PointName] = true
+	}
+	readers := make([]infra.Reader, 0, len(readerNames))
+	for name := range readerNames {
+		readers = append(readers, infra.Reader{Division: *division, Name: name})
+	}
+	sort.Slice(readers, func(i, j int) bool { return readers[i].Name < readers[j].Name })
+	seeded, err := db.SeedReaders(readers)
+	report("readers seeded", err, fmt.Sprintf("%d found, %d new", len(readers), seeded))
+
+	seeded, err = db.SeedShifts([]infra.Shift{{Division: *division, Name: "default", Start: *shiftStart, End: *shiftEnd}})
+	report("shifts seeded", err, fmt.Sprintf("%d new", seeded))
+
+	missing, err := db.MissingIndexes()
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("%d hot indexes missing, run with -create-indexes", len(missing))
+	}
+	report("indexes", err, "present")
+
+	router := entity.NewEventRouter(users)
+	for _, event := range events {
+		router.Route(event)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if !report("calendar", err, fmt.Sprintf("%s, %s", calendar.Country, calendar.Timezone)) {
+		return
+	}
+	computeUsersFlow(context.Background(), users, calendar.SiteTime(time.Now()), *months, 1, entity.DefaultFlowPolicy(), nil)
+	var intervals, open int
+	for _, user := range users {
+		for _, interval := range user.Intervals {
+			intervals++
+			if interval.Ext == nil {
+				open++
+			}
+		}
+	}
+	var dryRunErr error
+	if len(events) > 0 && intervals == 0 {
+		dryRunErr = fmt.Errorf("no intervals formed from %d events", len(events))
+	}
+	report("dry-run extraction", dryRunErr, fmt.Sprintf("%d intervals, %d open", intervals, open))
+}
+
+func printReadinessReport(division string, checks []readinessCheck) bool {
+	ready := true
+	fmt.Printf("readiness report for division %s\n", division)
+	for _, c := range checks {
+		status := "OK"
+		if !c.ok {
+			status = "FAIL"
+			ready = false
+		}
+		fmt.Printf("  [%s] %s: %s\n", status, c.name, c.detail)
+	}
+	if ready {
+		fmt.Println("division is ready for rollout")
+	} else {
+		fmt.Println("division is NOT ready for rollout")
+	}
+	return ready
+}
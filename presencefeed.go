@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+const (
+	presenceFeedCapacity = 1000
+	presenceLongPollWait = 30 * time.Second
+)
+
+type presenceChange struct {
+	Seq   int64     `json:"seq"`
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"` // open or close
+	Card  string    `json:"card"`
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+}
+
+// Keeps recent interval open/close changes derived from consecutive presence
+// snapshots and wakes up waiting clients when new ones arrive
+type presenceFeed struct {
+	mu      sync.Mutex
+	seq     int64
+	current map[string]infra.Presence
+	changes []presenceChange
+	wake    chan struct{}
+}
+
+func newPresenceFeed() *presenceFeed {
+	return &presenceFeed{wake: make(chan struct{})}
+}
+
+func (f *presenceFeed) update(presence []infra.Presence) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	next := make(map[string]infra.Presence, len(presence))
+	added := make([]presenceChange, 0)
+	for _, p := range presence {
+		next[p.Card] = p
+		if prev, ok := f.current[p.Card]; !ok || !prev.Since.Equal(p.Since) {
+			added = append(added, presenceChange{Kind: "open", Card: p.Card, Name: p.Name, Since: p.Since})
+		}
+	}
+	for card, p := range f.current {
+		if cur, ok := next[card]; !ok || !cur.Since.Equal(p.Since) {
+			added = append(added, presenceChange{Kind: "close", Card: p.Card, Name: p.Name, Since: p.Since})
+		}
+	}
+	// the first snapshot is the baseline, not a change
+	first := f.current == nil
+	f.current = next
+	if first || len(added) == 0 {
+		return
+	}
+
+	for i := range added {
+		f.seq++
+		added[i].Seq = f.seq
+		added[i].Time = now
+	}
+	f.changes = append(f.changes, added...)
+	if len(f.changes) > presenceFeedCapacity {
+		f.changes = f.changes[len(f.changes)-presenceFeedCapacity:]
+	}
+
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+// Changes after the cursor and a channel closed on the next update
+func (f *presenceFeed) since(cursor func(presenceChange) bool) ([]presenceChange, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]presenceChange, 0)
+	for _, c := range f.changes {
+		if cursor(c) {
+			result = append(result, c)
+		}
+	}
+	return result, f.wake
+}
+
+// GET /presence/changes?since=<seq or RFC3339 time>, streams server-sent
+// events when the client accepts text/event-stream and long-polls otherwise
+func (f *presenceFeed) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var seq int64
+	var after time.Time
+	if since := r.URL.Query().Get("since"); since != "" {
+		var err error
+		if seq, err = strconv.ParseInt(since, 10, 64); err != nil {
+			if after, err = time.Parse(time.RFC3339, since); err != nil {
+				http.Error(w, "since must be a sequence number or RFC3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	cursor := func(c presenceChange) bool {
+		return c.Seq > seq && c.Time.After(after)
+	}
+
+	if r.Header.Get("Accept") == "text/event-stream" {
+		f.stream(w, r, cursor)
+		return
+	}
+
+	changes, wake := f.since(cursor)
+	if len(changes) == 0 {
+		select {
+		case <-wake:
+			changes, _ = f.since(cursor)
+		case <-time.After(presenceLongPollWait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+func (f *presenceFeed) stream(w http.ResponseWriter, r *http.Request, cursor func(presenceChange) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var last int64
+	for {
+		changes, wake := f.since(func(c presenceChange) bool { return c.Seq > last && cursor(c) })
+		for _, c := range changes {
+			data, _ := json.Marshal(c)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.Seq, c.Kind, data)
+			last = c.Seq
+		}
+		flusher.Flush()
+
+		select {
+		case <-wake:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
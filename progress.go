@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How much a run logs: quiet keeps warnings and errors, verbose adds batch
+// details and stage timings
+const (
+	verbosityQuiet = iota - 1
+	verbosityNormal
+	verbosityVerbose
+)
+
+var verbosity = verbosityNormal
+
+// Logs a step of the run unless quiet
+func infof(format string, args ...any) {
+	if verbosity >= verbosityNormal {
+		log.Printf(format, args...)
+	}
+}
+
+// Logs run details with -v only
+func debugf(format string, args ...any) {
+	if verbosity >= verbosityVerbose {
+		log.Printf(format, args...)
+	}
+}
+
+// Selects the verbosity of -v and -q, -q wins over -v
+func setVerbosity(verbose, quiet bool) {
+	switch {
+	case quiet:
+		verbosity = verbosityQuiet
+	case verbose:
+		verbosity = verbosityVerbose
+	default:
+		verbosity = verbosityNormal
+	}
+}
+
+// Periodic progress of a long stage, so a backfill busy for many minutes
+// doesn't look hung. Total is 0 when unknown, estimated when it comes from an
+// earlier run. A nil progress reports nothing.
+type progress struct {
+	stage     string
+	unit      string
+	total     int64
+	estimated bool
+	done      atomic.Int64
+	stopped   chan struct{}
+	once      sync.Once
+}
+
+// Reports the progress every interval until finish. Returns nil when quiet
+// or interval is 0.
+func startProgress(stage, unit string, total int, estimated bool, interval time.Duration) *progress {
+	if interval <= 0 || verbosity < verbosityNormal {
+		return nil
+	}
+	p := &progress{stage: stage, unit: unit, total: int64(total), estimated: estimated, stopped: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Println(p.String())
+			case <-p.stopped:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) add(n int) {
+	if p != nil {
+		p.done.Add(int64(n))
+	}
+}
+
+// Stops the reports, safe to call more than once
+func (p *progress) finish() {
+	if p != nil {
+		p.once.Do(func() { close(p.stopped) })
+	}
+}
+
+// events exported: 120k/~540k (22%) or intervals built: 40% (1.2k/3k users)
+func (p *progress) String() string {
+	done := p.done.Load()
+	unit := ""
+	if p.unit != "" {
+		unit = " " + p.unit
+	}
+	if p.total <= 0 {
+		return fmt.Sprintf("%s: %s%s", p.stage, shortCount(done), unit)
+	}
+	percent := done * 100 / p.total
+	if p.estimated {
+		return fmt.Sprintf("%s: %s/~%s%s (%d%%)", p.stage, shortCount(done), shortCount(p.total), unit, percent)
+	}
+	return fmt.Sprintf("%s: %d%% (%s/%s%s)", p.stage, percent, shortCount(done), shortCount(p.total), unit)
+}
+
+// 950, 12.3k or 1.5M
+func shortCount(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return trimZero(float64(n)/1_000_000) + "M"
+	case n >= 10_000:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1000:
+		return trimZero(float64(n)/1000) + "k"
+	}
+	return fmt.Sprint(n)
+}
+
+func trimZero(v float64) string {
+	s := fmt.Sprintf("%.1f", v)
+	if len(s) > 2 && s[len(s)-2:] == ".0" {
+		return s[:len(s)-2]
+	}
+	return s
+}
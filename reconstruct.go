@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// reconstruct -as-of date rebuilds intervals from the event archives exactly
+// as the last run before that moment formed them: over its extraction window,
+// for the employees synced by then, with the card assignments and perimeter
+// readers of the destination. Departments and positions are the current ones,
+// the destination keeps no history of them.
+func reconstructCommand(args []string) {
+	fs := flag.NewFlagSet("reconstruct", flag.ExitOnError)
+	archiveDir := fs.String("archive-dir", os.Getenv("EVENT_ARCHIVE_DIR"), "directory with archived event exports")
+	asOfFlag := fs.String("as-of", "", "point in time, YYYY-MM-DD or RFC3339")
+	division := fs.String("division", os.Getenv("CONTROLLER_DIVISION_NAME"), "division whose run is reconstructed")
+	out := fs.String("out", "", "output CSV file, stdout if empty")
+	policyPath := fs.String("policy", "", "JSON file with interval formation rules, built-in defaults if empty")
+	fs.Parse(args)
+
+	if *division == "" {
+		log.Fatalln("-division or CONTROLLER_DIVISION_NAME is required")
+	}
+	asOf, err := parseAsOf(*asOfFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	run, ok, err := db.ETLRunAt(*division, asOf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !ok {
+		log.Fatalf("no successful run of %s by %s", *division, asOf.Format(time.RFC3339))
+	}
+	log.Printf("reconstructing the run of %s started at %s", *division, run.StartedAt.Format(time.RFC3339))
+
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	policy, err = withPerimeterReaders(db, *division, policy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// event times and card validity are site wall clock, so is the run
+	now := calendar.SiteTime(run.StartedAt)
+	from := calendar.SiteTime(run.WindowFrom)
+	assignments, err := db.CardAssignments()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	assignments = entity.CardAssignmentsAt(assignments, now)
+
+	events, archives, err := infra.EventsKnownAt(*archiveDir, run.StartedAt)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d events known at %s from %d archives", len(events), run.StartedAt.Format(time.RFC3339), archives)
+
+	// the employees the run synced, so the policy selects the same profiles;
+	// cards of visitors and unknown cards formed no intervals
+	employees, err := db.EmployeesAt(run.StartedAt)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	users := make([]*entity.User, 0, len(employees))
+	for _, e := range employees {
+		users = append(users, employeeUser(e))
+	}
+	users = entity.AttachCardAssignments(users, assignments)
+	eventsmap := make(map[string][]entity.Event)
+	for _, event := range events {
+		eventsmap[event.Card] = append(eventsmap[event.Card], event)
+	}
+	entity.SortUsers(users)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"card", "ent", "ext", "ent_event_id", "ext_event_id", "duration"})
+	for _, user := range users {
+		user.AddEvents(user.CollectEvents(eventsmap))
+		user.RunFlowAt(now, run.WindowMonths(), policy.ForUser(user))
+
+		for _, interval := range user.Intervals {
+			if interval.Ent.Time.Before(from) {
+				continue
+			}
+			ext, extID := "", ""
+			if interval.Ext != nil {
+				ext = interval.Ext.Time.Format("2006-01-02T15:04:05")
+				extID = strconv.Itoa(interval.Ext.ID)
+			}
+			cw.Write([]string{
+				user.Card, interval.Ent.Time.Format("2006-01-02T15:04:05"), ext,
+				strconv.Itoa(interval.Ent.ID), extID, interval.Dur().String(),
+			})
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// a date means the end of that day
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// retention [-policy spec] [-dir dir] [-dry-run] moves rows older than the
+// policy allows out of the hot tables into compressed monthly archives
+func retentionCommand(args []string) {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	spec := fs.String("policy", os.Getenv("RETENTION_POLICY"), "years kept per table, e.g. events=3,intervals=5")
+	dir := fs.String("dir", envOr("RETENTION_ARCHIVE_DIR", "archive"), "directory receiving the archives")
+	dryRun := fs.Bool("dry-run", false, "only count the rows that would be archived")
+	fs.Parse(args)
+
+	policy, err := infra.ParseRetentionPolicy(*spec)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(policy) == 0 {
+		log.Println("no retention policy configured, nothing to do")
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	chunks, err := db.ApplyRetention(ctx, policy, *dir, time.Now(), *dryRun)
+	for _, chunk := range chunks {
+		if *dryRun {
+			log.Printf("%s %s: %d rows would be archived", chunk.Table, chunk.Month.Format("2006-01"), chunk.Rows)
+		} else {
+			log.Printf("%s %s: %d rows archived to %s", chunk.Table, chunk.Month.Format("2006-01"), chunk.Rows, chunk.Path)
+		}
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if !*dryRun {
+		// an archive is filed under the month it holds
+		for _, chunk := range chunks {
+			if err := uploadArtifacts(*dir, chunk.Month, chunk.Path); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+	log.Printf("retention applied, %d months archived", len(chunks))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// import-roster -file roster.xlsx loads planned shifts per employee and day
+func importRosterCommand(args []string) {
+	fs := flag.NewFlagSet("import-roster", flag.ExitOnError)
+	file := fs.String("file", "", "CSV or XLSX with card, day and hours or start and end columns")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalln("-file is required")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+	var entries []entity.RosterEntry
+	if strings.EqualFold(filepath.Ext(*file), ".xlsx") {
+		entries, err = infra.ReadRosterXLSX(f)
+	} else {
+		entries, err = infra.ReadRosterCSV(f)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.ImportRoster(entries, time.Now()); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d planned shifts imported", len(entries))
+}
+
+// Planned against worked hours per employee and day, differences beyond the
+// threshold are highlighted
+func rosterReportCommand(args []string) {
+	fs := flag.NewFlagSet("report roster", flag.ExitOnError)
+	month := fs.String("month", time.Now().Format("2006-01"), "month to report, YYYY-MM")
+	threshold := fs.Float64("threshold", 1, "hours of difference from which a day is a discrepancy")
+	format := fs.String("format", "xlsx", "output format, xlsx or csv")
+	onlyDiscrepancies := fs.Bool("discrepancies", false, "leave out days that match the roster")
+	lang := fs.String("lang", envOr("REPORT_LANG", string(entity.LocaleRU)), "language of the xlsx report, ru or en")
+	policyPath := fs.String("policy", "", "JSON flow policy whose rounding applies to worked hours, no rounding if empty")
+	out := fs.String("out", "", "output file, stdout if empty")
+	fs.Parse(args)
+
+	if *format != "xlsx" && *format != "csv" {
+		log.Fatalf("unknown -format %q", *format)
+	}
+	locale := reportLocale(*lang)
+	from, err := time.Parse("2006-01", *month)
+	if err != nil {
+		log.Fatalf("invalid -month %q: %v", *month, err)
+	}
+
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.RosterComparison(from, from.AddDate(0, 1, 0), *threshold, policy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	discrepancies := 0
+	kept := rows[:0]
+	for _, r := range rows {
+		if r.Discrepancy {
+			discrepancies++
+		} else if *onlyDiscrepancies {
+			continue
+		}
+		kept = append(kept, r)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		err = infra.WriteRosterComparisonCSV(w, kept)
+	} else {
+		err = infra.WriteRosterComparisonXLSX(w, kept, locale)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := finishReport(w, *out, from); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("roster for %s: %d days compared, %d discrepancies", *month, len(rows), discrepancies)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Exit codes of a failed run, 2 is taken by flag errors
+const (
+	exitFailure            = 1
+	exitSourceFailure      = 3
+	exitDestinationFailure = 4
+	exitValidationFailure  = 5
+	exitInterrupted        = 6
+)
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	switch infra.FailureClassOf(err) {
+	case infra.FailureSource:
+		return exitSourceFailure
+	case infra.FailureDestination:
+		return exitDestinationFailure
+	case infra.FailureValidation:
+		return exitValidationFailure
+	case infra.FailureInterrupted:
+		return exitInterrupted
+	default:
+		return exitFailure
+	}
+}
+
+// A run failing once ctx was cancelled failed because it was stopped, the
+// error of the part that gave up first is only how it ended
+func interruptedRun(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return infra.InterruptedFailure(err)
+	}
+	return err
+}
+
+// Warnings of the run in progress, they end up in its summary
+var runWarnings warnings
+
+type warnings struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (w *warnings) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.list = make([]string, 0)
+}
+
+func (w *warnings) add(message string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.list = append(w.list, message)
+}
+
+func (w *warnings) all() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string{}, w.list...)
+}
+
+// Logs a problem that doesn't fail the run and records it for the summary
+func warnf(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	log.Println("warning: " + message)
+	runWarnings.add(message)
+}
+
+// Records how long each stage of a run takes, a stage ends when the next begins
+type stageTimer struct {
+	summary *infra.RunSummary
+	stage   string
+	started time.Time
+}
+
+func newStageTimer(summary *infra.RunSummary) *stageTimer {
+	return &stageTimer{summary: summary}
+}
+
+func (t *stageTimer) begin(stage string) {
+	t.stop()
+	t.stage = stage
+	t.started = time.Now()
+}
+
+func (t *stageTimer) stop() {
+	if t.stage == "" {
+		return
+	}
+	t.summary.Stages = append(t.summary.Stages, infra.StageTiming{Stage: t.stage, Duration: time.Since(t.started)})
+	debugf("stage %s took %s", t.stage, time.Since(t.started).Round(time.Millisecond))
+	t.stage = ""
+}
+
+// Machine readable outcome of a run for orchestration
+type runReport struct {
+	infra.RunSummary
+	Error    string             `json:"error,omitempty"`
+	Failure  infra.FailureClass `json:"failure,omitempty"`
+	ExitCode int                `json:"exit_code"`
+}
+
+// Publishes the report -summary writes to NATS when NATS_URL is set, failed
+// runs included
+func publishRun(summary infra.RunSummary, err error, code int) error {
+	publisher, perr := natsPublisher()
+	if perr != nil || publisher == nil {
+		return perr
+	}
+	return publisher.PublishRun(summary.Division, summary.Started, newRunReport(summary, err, code))
+}
+
+func newRunReport(summary infra.RunSummary, err error, code int) runReport {
+	report := runReport{RunSummary: summary, Failure: infra.FailureClassOf(err), ExitCode: code}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// Writes the summary as JSON to path, - is stdout
+func writeRunReport(path string, summary infra.RunSummary, err error, code int) error {
+	data, merr := json.MarshalIndent(newRunReport(summary, err, code), "", "  ")
+	if merr != nil {
+		return merr
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, werr := os.Stdout.Write(data)
+		return werr
+	}
+	if werr := os.WriteFile(path+".part", data, 0o644); werr != nil {
+		return werr
+	}
+	return os.Rename(path+".part", path)
+}
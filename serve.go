@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+	"github.com/spooky-finn/piek-attendance-prod/server"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serve [-listen addr] [-ui] [-policy file] [-reports-dir dir] [-reports-ttl duration] [-lang ru|en]
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "http listen address")
+	ui := fs.Bool("ui", false, "serve the HTML dashboard next to the JSON API")
+	policyPath := fs.String("policy", "", "JSON file with flow and day classification rules, built-in defaults if empty")
+	reportsDir := fs.String("reports-dir", os.Getenv("REPORTS_DIR"), "directory for background report jobs, /api/reports is disabled if empty")
+	reportsTTL := fs.Duration("reports-ttl", 7*24*time.Hour, "finished report jobs are removed with their files after this, kept forever if 0")
+	lang := fs.String("lang", envOr("REPORT_LANG", string(entity.LocaleRU)), "language of the dashboard and reports when the request asks for none, ru or en")
+	fs.Parse(args)
+	locale := reportLocale(*lang)
+
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	norms, _, err := infra.WorkNormsFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	auth, err := authenticatorFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	srv, err := server.New(db, server.Config{
+		Division: os.Getenv("CONTROLLER_DIVISION_NAME"),
+		UI:       *ui,
+		Policy:   policy,
+		Calendar: calendar,
+		Reports: server.ReportsConfig{
+			Dir:      *reportsDir,
+			Template: os.Getenv("TIMESHEET_TEMPLATE"),
+			Font:     envOr("REPORT_FONT", infra.DefaultReportFont),
+			Norms:    norms,
+			TTL:      *reportsTTL,
+		},
+		Auth:   auth,
+		Locale: locale,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("serving on %s", *listen)
+	// gRPC clients speak HTTP/2 without TLS on the same port
+	log.Fatalln(http.ListenAndServe(*listen, h2c.NewHandler(srv, &http2.Server{})))
+}
+
+// API_KEYS is resolved as a secret, OIDC_ISSUER enables bearer tokens. Without
+// either the API is served without authentication.
+func authenticatorFromEnv() (*server.Authenticator, error) {
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	spec, err := infra.OptionalSecret(secrets, "API_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	keys, err := server.ParseAPIKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var oidc *server.OIDCConfig
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		roles, err := server.ParseRoleMap(os.Getenv("OIDC_ROLE_MAP"))
+		if err != nil {
+			return nil, err
+		}
+		oidc = &server.OIDCConfig{
+			Issuer:     issuer,
+			Audience:   os.Getenv("OIDC_AUDIENCE"),
+			RolesClaim: os.Getenv("OIDC_ROLES_CLAIM"),
+			NameClaim:  os.Getenv("OIDC_NAME_CLAIM"),
+			RoleMap:    roles,
+		}
+	}
+
+	if len(keys) == 0 && oidc == nil {
+		log.Println("warning: neither API_KEYS nor OIDC_ISSUER is set, the API is not authenticated")
+		return nil, nil
+	}
+	return server.NewAuthenticator(keys, oidc)
+}
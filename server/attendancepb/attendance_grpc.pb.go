@@ -0,0 +1,196 @@
+// Query API of the serve command over gRPC. It is served on the listen address
+// of the JSON API over HTTP/2 without TLS and authenticated like it: send the
+// API key as x-api-key or an OIDC token as authorization metadata.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: attendance.proto
+
+package attendancepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Attendance_ListEmployees_FullMethodName = "/attendance.v1.Attendance/ListEmployees"
+	Attendance_ListIntervals_FullMethodName = "/attendance.v1.Attendance/ListIntervals"
+	Attendance_ListPresence_FullMethodName  = "/attendance.v1.Attendance/ListPresence"
+)
+
+// AttendanceClient is the client API for Attendance service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AttendanceClient interface {
+	// Employees of the destination, by department when one is given
+	ListEmployees(ctx context.Context, in *ListEmployeesRequest, opts ...grpc.CallOption) (*ListEmployeesResponse, error)
+	// Intervals with the HR corrections applied that began in [from, to)
+	ListIntervals(ctx context.Context, in *ListIntervalsRequest, opts ...grpc.CallOption) (*ListIntervalsResponse, error)
+	// People inside the division when the last run finished
+	ListPresence(ctx context.Context, in *ListPresenceRequest, opts ...grpc.CallOption) (*ListPresenceResponse, error)
+}
+
+type attendanceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAttendanceClient(cc grpc.ClientConnInterface) AttendanceClient {
+	return &attendanceClient{cc}
+}
+
+func (c *attendanceClient) ListEmployees(ctx context.Context, in *ListEmployeesRequest, opts ...grpc.CallOption) (*ListEmployeesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListEmployeesResponse)
+	err := c.cc.Invoke(ctx, Attendance_ListEmployees_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *attendanceClient) ListIntervals(ctx context.Context, in *ListIntervalsRequest, opts ...grpc.CallOption) (*ListIntervalsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListIntervalsResponse)
+	err := c.cc.Invoke(ctx, Attendance_ListIntervals_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *attendanceClient) ListPresence(ctx context.Context, in *ListPresenceRequest, opts ...grpc.CallOption) (*ListPresenceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPresenceResponse)
+	err := c.cc.Invoke(ctx, Attendance_ListPresence_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AttendanceServer is the server API for Attendance service.
+// All implementations must embed UnimplementedAttendanceServer
+// for forward compatibility
+type AttendanceServer interface {
+	// Employees of the destination, by department when one is given
+	ListEmployees(context.Context, *ListEmployeesRequest) (*ListEmployeesResponse, error)
+	// Intervals with the HR corrections applied that began in [from, to)
+	ListIntervals(context.Context, *ListIntervalsRequest) (*ListIntervalsResponse, error)
+	// People inside the division when the last run finished
+	ListPresence(context.Context, *ListPresenceRequest) (*ListPresenceResponse, error)
+	mustEmbedUnimplementedAttendanceServer()
+}
+
+// UnimplementedAttendanceServer must be embedded to have forward compatible implementations.
+type UnimplementedAttendanceServer struct {
+}
+
+func (UnimplementedAttendanceServer) ListEmployees(context.Context, *ListEmployeesRequest) (*ListEmployeesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEmployees not implemented")
+}
+func (UnimplementedAttendanceServer) ListIntervals(context.Context, *ListIntervalsRequest) (*ListIntervalsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListIntervals not implemented")
+}
+func (UnimplementedAttendanceServer) ListPresence(context.Context, *ListPresenceRequest) (*ListPresenceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPresence not implemented")
+}
+func (UnimplementedAttendanceServer) mustEmbedUnimplementedAttendanceServer() {}
+
+// UnsafeAttendanceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AttendanceServer will
+// result in compilation errors.
+type UnsafeAttendanceServer interface {
+	mustEmbedUnimplementedAttendanceServer()
+}
+
+func RegisterAttendanceServer(s grpc.ServiceRegistrar, srv AttendanceServer) {
+	s.RegisterService(&Attendance_ServiceDesc, srv)
+}
+
+func _Attendance_ListEmployees_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEmployeesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttendanceServer).ListEmployees(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Attendance_ListEmployees_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttendanceServer).ListEmployees(ctx, req.(*ListEmployeesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Attendance_ListIntervals_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListIntervalsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttendanceServer).ListIntervals(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Attendance_ListIntervals_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttendanceServer).ListIntervals(ctx, req.(*ListIntervalsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Attendance_ListPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPresenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AttendanceServer).ListPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Attendance_ListPresence_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AttendanceServer).ListPresence(ctx, req.(*ListPresenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Attendance_ServiceDesc is the grpc.ServiceDesc for Attendance service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Attendance_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "attendance.v1.Attendance",
+	HandlerType: (*AttendanceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListEmployees",
+			Handler:    _Attendance_ListEmployees_Handler,
+		},
+		{
+			MethodName: "ListIntervals",
+			Handler:    _Attendance_ListIntervals_Handler,
+		},
+		{
+			MethodName: "ListPresence",
+			Handler:    _Attendance_ListPresence_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "attendance.proto",
+}
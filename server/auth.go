@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Roles in increasing order of rights, each role has the rights of the ones before
+type Role int
+
+const (
+	RoleReader Role = iota + 1
+	// requests corrections and writes annotations
+	RoleCorrector
+	// decides corrections
+	RoleAdmin
+)
+
+func ParseRole(s string) (Role, error) {
+	switch s {
+	case "reader":
+		return RoleReader, nil
+	case "corrector":
+		return RoleCorrector, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return 0, fmt.Errorf("unknown role %q, expected reader, corrector or admin", s)
+}
+
+type Identity struct {
+	Name string
+	Role Role
+}
+
+type identityKey struct{}
+
+// Authenticated caller of the request, ok is false when auth is disabled
+func IdentityFrom(r *http.Request) (Identity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+type APIKey struct {
+	Name string
+	Role Role
+	Key  string
+}
+
+// Parses a spec like ci-bot=reader:secret,hr-app=admin:secret
+func ParseAPIKeys(spec string) ([]APIKey, error) {
+	keys := make([]APIKey, 0)
+	if strings.TrimSpace(spec) == "" {
+		return keys, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		role, key, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid API key entry for %q, expected name=role:key", name)
+		}
+		r, err := ParseRole(role)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, APIKey{Name: name, Role: r, Key: key})
+	}
+	return keys, nil
+}
+
+type OIDCConfig struct {
+	// token issuer, its discovery document names the signing keys
+	Issuer string
+	// client id tokens must be issued for, required
+	Audience string
+	// claim holding the groups or roles of the user, "roles" if empty
+	RolesClaim string
+	// role of each claim value, values without a role are ignored. Without a
+	// map the values are taken as role names.
+	RoleMap map[string]Role
+	// claim naming the user in corrections, "preferred_username" if empty
+	NameClaim string
+}
+
+// Parses a spec like attendance-hr=admin,supervisors=corrector
+func ParseRoleMap(spec string) (map[string]Role, error) {
+	roles := make(map[string]Role)
+	if strings.TrimSpace(spec) == "" {
+		return roles, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		value, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid role mapping %q, expected value=role", pair)
+		}
+		r, err := ParseRole(role)
+		if err != nil {
+			return nil, err
+		}
+		roles[value] = r
+	}
+	return roles, nil
+}
+
+// Checks static API keys sent as X-API-Key for machine clients and OIDC bearer
+// tokens for the UI. The dashboard is a plain page, it is expected behind a
+// proxy such as oauth2-proxy that passes the user's token on.
+type Authenticator struct {
+	keys []APIKey
+	oidc *oidcVerifier
+}
+
+func NewAuthenticator(keys []APIKey, config *OIDCConfig) (*Authenticator, error) {
+	a := &Authenticator{keys: keys}
+	if config != nil {
+		// the issuer mints tokens for other clients as well
+		if config.Audience == "" {
+			return nil, errors.New("OIDC_AUDIENCE is required with OIDC_ISSUER")
+		}
+		a.oidc = newOIDCVerifier(*config)
+	}
+	return a, nil
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Identity, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		for _, k := range a.keys {
+			if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+				return Identity{Name: k.Name, Role: k.Role}, nil
+			}
+		}
+		return Identity{}, errors.New("unknown API key")
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Identity{}, errors.New("credentials required")
+	}
+	if a.oidc == nil {
+		return Identity{}, errors.New("bearer tokens are not accepted")
+	}
+	return a.oidc.verify(r.Context(), token)
+}
+
+// Role a request needs: reading is open to every role, writes need a
+// corrector, deciding corrections, merging employees and marking perimeter
+// readers an admin
+func requiredRole(r *http.Request) Role {
+	// the gRPC methods only query
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || isGRPC(r) {
+		return RoleReader
+	}
+	if r.URL.Path == "/api/corrections/decide" || r.URL.Path == "/api/employees/merge" || r.URL.Path == "/api/readers" {
+		return RoleAdmin
+	}
+	return RoleCorrector
+}
+
+func (a *Authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="attendance"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if id.Role < requiredRole(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// Tokens are verified by go-oidc against the keys named in the issuer's
+// discovery document, which is fetched on first use so serve starts while the
+// issuer is unreachable
+type oidcVerifier struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCVerifier(config OIDCConfig) *oidcVerifier {
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "preferred_username"
+	}
+	return &oidcVerifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (v *oidcVerifier) idTokenVerifier() (*oidc.IDTokenVerifier, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.verifier != nil {
+		return v.verifier, nil
+	}
+	// the key set keeps the context for refetching keys, it must outlive the request
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), v.client), v.config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	v.verifier = provider.Verifier(&oidc.Config{ClientID: v.config.Audience})
+	return v.verifier, nil
+}
+
+func (v *oidcVerifier) verify(ctx context.Context, token string) (Identity, error) {
+	verifier, err := v.idTokenVerifier()
+	if err != nil {
+		return Identity{}, err
+	}
+	idToken, err := verifier.Verify(ctx, token)
+	if err != nil {
+		return Identity{}, err
+	}
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+	return v.identity(claims)
+}
+
+// Caller named by the token claims, with the highest role its role claim
+// values map to. Without a role map the values are the role names themselves.
+func (v *oidcVerifier) identity(claims map[string]any) (Identity, error) {
+	id := Identity{}
+	id.Name, _ = claims[v.config.NameClaim].(string)
+	if id.Name == "" {
+		id.Name, _ = claims["sub"].(string)
+	}
+	for _, value := range claimStrings(claims[v.config.RolesClaim]) {
+		role, ok := v.config.RoleMap[value]
+		if !ok && len(v.config.RoleMap) == 0 {
+			role, _ = ParseRole(value)
+		}
+		if role > id.Role {
+			id.Role = role
+		}
+	}
+	if id.Role == 0 {
+		return Identity{}, errors.New("token grants no role")
+	}
+	return id, nil
+}
+
+// Claim values given as a single string or a list
+func claimStrings(claim any) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []any:
+		values := make([]string, 0, len(c))
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// Issuer serving its discovery document and the public key of kid
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, kid: "k1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"jwks_uri":                              issuer.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: issuer.kid, Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) token(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (i *testIssuer) claims(overrides map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss":                i.URL,
+		"aud":                "attendance",
+		"sub":                "u1",
+		"preferred_username": "ivanova",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"roles":              []string{"attendance-hr"},
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	return claims
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/employees", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestOIDCAuthentication(t *testing.T) {
+	issuer := newTestIssuer(t)
+	auth, err := NewAuthenticator(nil, &OIDCConfig{
+		Issuer:   issuer.URL,
+		Audience: "attendance",
+		RoleMap:  map[string]Role{"attendance-hr": RoleAdmin, "supervisors": RoleCorrector},
+	})
+	assert.Nil(t, err)
+
+	t.Run("valid token", func(t *testing.T) {
+		id, err := auth.authenticate(bearer(issuer.token(t, issuer.key, issuer.kid, issuer.claims(nil))))
+
+		assert.Nil(t, err)
+		assert.Equal(t, Identity{Name: "ivanova", Role: RoleAdmin}, id)
+	})
+
+	t.Run("foreign signature", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+
+		_, err = auth.authenticate(bearer(issuer.token(t, other, issuer.kid, issuer.claims(nil))))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unknown key id", func(t *testing.T) {
+		_, err := auth.authenticate(bearer(issuer.token(t, issuer.key, "k2", issuer.claims(nil))))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		claims := issuer.claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})
+
+		_, err := auth.authenticate(bearer(issuer.token(t, issuer.key, issuer.kid, claims)))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("other audience", func(t *testing.T) {
+		claims := issuer.claims(map[string]any{"aud": "payroll"})
+
+		_, err := auth.authenticate(bearer(issuer.token(t, issuer.key, issuer.kid, claims)))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unmapped role name grants nothing", func(t *testing.T) {
+		claims := issuer.claims(map[string]any{"roles": []string{"admin"}})
+
+		_, err := auth.authenticate(bearer(issuer.token(t, issuer.key, issuer.kid, claims)))
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("highest mapped role wins", func(t *testing.T) {
+		claims := issuer.claims(map[string]any{"roles": []string{"admin", "supervisors"}})
+
+		id, err := auth.authenticate(bearer(issuer.token(t, issuer.key, issuer.kid, claims)))
+
+		assert.Nil(t, err)
+		assert.Equal(t, RoleCorrector, id.Role)
+	})
+}
+
+func TestOIDCRoleNames(t *testing.T) {
+	v := newOIDCVerifier(OIDCConfig{Issuer: "https://issuer", Audience: "attendance"})
+
+	t.Run("without a map values are role names", func(t *testing.T) {
+		id, err := v.identity(map[string]any{"sub": "u1", "roles": []any{"reader", "corrector"}})
+
+		assert.Nil(t, err)
+		assert.Equal(t, Identity{Name: "u1", Role: RoleCorrector}, id)
+	})
+
+	t.Run("unknown names grant nothing", func(t *testing.T) {
+		_, err := v.identity(map[string]any{"sub": "u1", "roles": "staff"})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	t.Run("audience is required", func(t *testing.T) {
+		_, err := NewAuthenticator(nil, &OIDCConfig{Issuer: "https://issuer"})
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/spooky-finn/piek-attendance-prod/server/attendancepb"
+)
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/spooky-finn/piek-attendance-prod/server --go-grpc_out=. --go-grpc_opt=module=github.com/spooky-finn/piek-attendance-prod/server attendance.proto
+
+//go:embed attendance.proto
+var attendanceProto []byte
+
+const (
+	grpcService = "/attendance.v1.Attendance/"
+	// requests of the query API are small, responses are not bounded by it
+	grpcMaxRequest    = 1 << 20
+	grpcIntervalLimit = 10000
+)
+
+// gRPC requests are HTTP/2 POSTs of application/grpc content to the service
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.URL.Path, grpcService) &&
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// The Attendance service of attendance.proto, answered with the queries of
+// the JSON API
+type attendanceService struct {
+	attendancepb.UnimplementedAttendanceServer
+	s *Server
+}
+
+// Served through the mux of the JSON API, so requests pass the same
+// authentication on the same listen address
+func newGRPCServer(s *Server) *grpc.Server {
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(grpcMaxRequest))
+	attendancepb.RegisterAttendanceServer(srv, attendanceService{s: s})
+	return srv
+}
+
+// Database errors are logged, clients only learn that the query failed
+func grpcInternal(err error) error {
+	log.Printf("grpc: %v", err)
+	return status.Error(codes.Internal, "internal server error")
+}
+
+func (s *Server) handleProto(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(attendanceProto)
+}
+
+func (a attendanceService) ListEmployees(ctx context.Context, request *attendancepb.ListEmployeesRequest) (*attendancepb.ListEmployeesResponse, error) {
+	employees, err := a.s.db.EmployeesAll()
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	response := &attendancepb.ListEmployeesResponse{}
+	for _, e := range employees {
+		if request.Department != "" && e.Department != request.Department {
+			continue
+		}
+		response.Employees = append(response.Employees, &attendancepb.Employee{
+			Card:       e.Card,
+			FirstName:  e.FirstName,
+			LastName:   e.LastName,
+			Department: e.Department,
+			Position:   e.Position,
+		})
+	}
+	return response, nil
+}
+
+func (a attendanceService) ListIntervals(ctx context.Context, request *attendancepb.ListIntervalsRequest) (*attendancepb.ListIntervalsResponse, error) {
+	var from, to time.Time
+	for _, bound := range []struct {
+		ts *timestamppb.Timestamp
+		t  *time.Time
+	}{{request.From, &from}, {request.To, &to}} {
+		if bound.ts == nil {
+			continue
+		}
+		if err := bound.ts.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		*bound.t = bound.ts.AsTime()
+	}
+	if to.IsZero() {
+		now := time.Now()
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -7)
+	}
+	if !from.Before(to) {
+		return nil, status.Error(codes.InvalidArgument, "from must be before to")
+	}
+	limit := grpcIntervalLimit
+	if n := int(request.Limit); n > 0 && n < limit {
+		limit = n
+	}
+
+	intervals, err := a.s.db.CorrectedIntervals(from, to, request.Card, limit)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	response := &attendancepb.ListIntervalsResponse{}
+	for _, in := range intervals {
+		interval := &attendancepb.Interval{
+			Card:      in.Card,
+			Database:  in.Database,
+			Key:       in.Key,
+			Ent:       timestamppb.New(in.Ent),
+			Corrected: in.CorrectionID.Valid,
+		}
+		if in.Ext.Valid {
+			interval.Ext = timestamppb.New(in.Ext.Time)
+		}
+		response.Intervals = append(response.Intervals, interval)
+	}
+	return response, nil
+}
+
+func (a attendanceService) ListPresence(ctx context.Context, request *attendancepb.ListPresenceRequest) (*attendancepb.ListPresenceResponse, error) {
+	presence, err := a.s.db.PresenceAll(a.s.division)
+	if err != nil {
+		return nil, grpcInternal(err)
+	}
+	response := &attendancepb.ListPresenceResponse{}
+	for _, p := range presence {
+		person := &attendancepb.Presence{Card: p.Card, Database: p.Database, Name: p.Name}
+		if !p.Since.IsZero() {
+			person.Since = timestamppb.New(p.Since)
+		}
+		response.Presence = append(response.Presence, person)
+	}
+	return response, nil
+}
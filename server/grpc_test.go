@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+	"github.com/spooky-finn/piek-attendance-prod/server/attendancepb"
+)
+
+// Client of the server listening without TLS as the serve command does
+func newTestClient(t *testing.T, s *Server) attendancepb.AttendanceClient {
+	listener := httptest.NewServer(h2c.NewHandler(s, &http2.Server{}))
+	t.Cleanup(listener.Close)
+	conn, err := grpc.NewClient(strings.TrimPrefix(listener.URL, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return attendancepb.NewAttendanceClient(conn)
+}
+
+// Repository whose every query fails, nothing listens on the port
+func unreachableRepository(t *testing.T) *infra.Repository {
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &infra.Repository{DB: sqlx.NewDb(db, "postgres")}
+}
+
+func TestGRPC(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("request timestamps reach the service", func(t *testing.T) {
+		s, err := New(unreachableRepository(t), Config{})
+		assert.Nil(t, err)
+		client := newTestClient(t, s)
+
+		_, err = client.ListIntervals(ctx, &attendancepb.ListIntervalsRequest{
+			From: timestamppb.New(day.AddDate(0, 0, 1)),
+			To:   timestamppb.New(day),
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, "from must be before to", status.Convert(err).Message())
+	})
+
+	t.Run("invalid timestamps are refused", func(t *testing.T) {
+		s, err := New(unreachableRepository(t), Config{})
+		assert.Nil(t, err)
+		client := newTestClient(t, s)
+
+		_, err = client.ListIntervals(ctx, &attendancepb.ListIntervalsRequest{
+			From: &timestamppb.Timestamp{Seconds: day.Unix(), Nanos: -1},
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("database errors are not disclosed", func(t *testing.T) {
+		s, err := New(unreachableRepository(t), Config{})
+		assert.Nil(t, err)
+		client := newTestClient(t, s)
+
+		_, err = client.ListEmployees(ctx, &attendancepb.ListEmployeesRequest{})
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Equal(t, "internal server error", status.Convert(err).Message())
+	})
+
+	t.Run("calls are authenticated like the JSON API", func(t *testing.T) {
+		auth, err := NewAuthenticator([]APIKey{{Name: "ci-bot", Role: RoleReader, Key: "k1"}}, nil)
+		assert.Nil(t, err)
+		s, err := New(unreachableRepository(t), Config{Auth: auth})
+		assert.Nil(t, err)
+		client := newTestClient(t, s)
+		request := &attendancepb.ListIntervalsRequest{From: timestamppb.New(day.AddDate(0, 0, 1)), To: timestamppb.New(day)}
+
+		_, err = client.ListIntervals(ctx, request)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = client.ListIntervals(metadata.AppendToOutgoingContext(ctx, "x-api-key", "k1"), request)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// An endpoint of the JSON API as the OpenAPI spec describes it. Request and
+// response are values of the types the handler decodes and encodes, their
+// schemas are generated from the JSON field tags.
+type apiOperation struct {
+	path     string
+	method   string
+	summary  string
+	params   []apiParam
+	request  any
+	response any
+	// content type of responses that aren't JSON
+	media  string
+	status int
+}
+
+type apiParam struct {
+	name        string
+	in          string
+	kind        string
+	description string
+}
+
+var (
+	daysParam = apiParam{"days", "query", "integer", "last N days including today, 1 to 62, 7 by default"}
+	cardParam = apiParam{"card", "query", "string", "only the holder of this card"}
+	jobParam  = apiParam{"id", "path", "string", "report job id"}
+)
+
+// Operations of the JSON API, keep in sync with the routes of New
+var apiOperations = []apiOperation{
+	{path: "/api/status", method: "get", summary: "Last loaded event and table sizes", response: infra.LoadStatus{}},
+	{path: "/api/attendance", method: "get", summary: "Worked hours per employee and day, with HR corrections applied", params: []apiParam{daysParam}, response: []infra.DailyHours{}},
+	{path: "/api/attendance/daily", method: "get", summary: "First entry and last exit per card and day", params: []apiParam{daysParam, cardParam}, response: []infra.DailyAttendance{}},
+	{path: "/api/intervals/open", method: "get", summary: "Intervals of employees still inside, latest first", response: []infra.OpenInterval{}},
+	{path: "/api/anomalies", method: "get", summary: "Latest anomalies of the event flow", response: []infra.Anomaly{}},
+	{path: "/api/presence", method: "get", summary: "People inside when the last run finished", response: []infra.Presence{}},
+	{path: "/api/annotations", method: "get", summary: "Annotations of the range", params: []apiParam{daysParam, cardParam}, response: []infra.Annotation{}},
+	{path: "/api/annotations", method: "post", summary: "Create an annotation or replace the one with the same key", request: infra.Annotation{}, response: infra.Annotation{}},
+	{path: "/api/corrections", method: "get", summary: "Corrections of intervals entered in the range", params: []apiParam{daysParam, cardParam, {"status", "query", "string", "pending, approved or rejected"}}, response: []infra.Correction{}},
+	{path: "/api/corrections", method: "post", summary: "Request a close, split or annotate correction, effective once approved", request: infra.Correction{}, response: infra.Correction{}},
+	{path: "/api/corrections/decide", method: "post", summary: "Approve or reject a pending correction", request: correctionDecision{}, response: infra.Correction{}},
+	{path: "/api/quality", method: "get", summary: "Data quality score per day", params: []apiParam{daysParam}, response: []infra.DataQuality{}},
+	{path: "/api/employees/photo", method: "get", summary: "Badge photo of the card holder", params: []apiParam{{"card", "query", "string", "card of the employee"}, {"database", "query", "string", "division of the card, the served one by default"}}, media: "image/*"},
+	{path: "/api/employees/duplicates", method: "get", summary: "Likely duplicate employees found by the last sync", response: []infra.DuplicateEmployee{}},
+	{path: "/api/employees/merge", method: "post", summary: "Fold a duplicate employee into the canonical one", request: employeeMerge{}, response: infra.EmployeeMerge{}},
+	{path: "/api/visits", method: "get", summary: "Visits of visitor and contractor cards", params: []apiParam{daysParam, {"onsite", "query", "integer", "1 for the visitors still inside"}}, response: []infra.Visit{}},
+	{path: "/api/readers", method: "get", summary: "Readers of the division", response: []infra.Reader{}},
+	{path: "/api/readers", method: "post", summary: "Mark a reader as a perimeter door or unmark it", request: readerPerimeter{}, response: infra.Reader{}},
+	{path: "/api/readers/traffic", method: "get", summary: "Events and cards per door and day", params: []apiParam{daysParam}, response: []infra.ReaderTraffic{}},
+	{path: "/api/intervals/merges", method: "get", summary: "Short exits merged into continuous intervals by the grace period", params: []apiParam{daysParam, cardParam}, response: []infra.IntervalMerge{}},
+	{path: "/api/zones", method: "get", summary: "Hours per card, day and zone", params: []apiParam{daysParam, cardParam}, response: []infra.ZoneTime{}},
+	{path: "/api/roster", method: "get", summary: "Planned against worked hours per employee and day", params: []apiParam{daysParam, {"threshold", "query", "number", "hours of difference that make a discrepancy, 1 by default"}}, response: []infra.RosterComparisonRow{}},
+	{path: "/api/roster", method: "post", summary: "Import planned shifts, replacing those of the same card and day", request: []rosterEntry{}, response: map[string]int{}},
+	{path: "/api/reports", method: "post", summary: "Start a background report job", request: reportRequest{}, response: reportJob{}, status: http.StatusAccepted},
+	{path: "/api/reports/{id}", method: "get", summary: "State of a report job", params: []apiParam{jobParam}, response: reportJob{}},
+	{path: "/api/reports/{id}/download", method: "get", summary: "ZIP archive of a finished report job", params: []apiParam{jobParam}, media: "application/zip"},
+	{path: "/api/reports/{id}/resume", method: "post", summary: "Resume a failed report job", params: []apiParam{jobParam}, response: reportJob{}},
+	{path: "/api/attendance.proto", method: "get", summary: "Protocol buffers definition of the gRPC API", media: "text/plain"},
+}
+
+var (
+	specOnce sync.Once
+	spec     []byte
+	specErr  error
+)
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	specOnce.Do(func() { spec, specErr = json.MarshalIndent(openAPISpec(), "", "  ") })
+	if specErr != nil {
+		serverError(w, specErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, swaggerUI)
+}
+
+// Swagger UI of the spec, its assets come from the unpkg CDN
+const swaggerUI = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Attendance API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui"})</script>
+</body>
+</html>
+`
+
+func openAPISpec() map[string]any {
+	schemas := openAPISchemas{components: map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		operation := map[string]any{"summary": op.summary}
+		if len(op.params) > 0 {
+			params := make([]map[string]any, len(op.params))
+			for i, p := range op.params {
+				params[i] = map[string]any{
+					"name": p.name, "in": p.in, "required": p.in == "path",
+					"description": p.description, "schema": map[string]any{"type": p.kind},
+				}
+			}
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(op.request))}},
+			}
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.media != "":
+			response["content"] = map[string]any{op.media: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+		case op.response != nil:
+			response["content"] = map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(op.response))}}
+		}
+		operation["responses"] = map[string]any{fmt.Sprint(status): response}
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][op.method] = operation
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Attendance API",
+			"version":     "1",
+			"description": "JSON API of the serve command. Reads need the reader role, writes corrector or admin.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"oidc":   map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []map[string][]string{{"apiKey": {}}, {"oidc": {}}},
+	}
+}
+
+// Component schemas by Go type name, generated the way encoding/json encodes
+type openAPISchemas struct {
+	components map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s openAPISchemas) of(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := s.of(t.Elem())
+		if _, ref := schema["$ref"]; ref {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case t.Implements(reflect.TypeOf((*json.Marshaler)(nil)).Elem()):
+		// encodes itself, the schema can't be told from the type
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return s.object(t)
+		}
+		if pkg := t.PkgPath(); pkg != "" && !strings.Contains(pkg, "piek-attendance-prod") {
+			// sql.NullString and the like
+			name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+		}
+		if _, ok := s.components[name]; !ok {
+			// placeholder for types that refer to themselves
+			s.components[name] = map[string]any{}
+			s.components[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (s openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	var fields func(t reflect.Type)
+	fields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || !f.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				fields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = s.of(f.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	fields(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,403 @@
+package server
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+const (
+	reportPending = "pending"
+	reportRunning = "running"
+	reportDone    = "done"
+	reportFailed  = "failed"
+
+	maxReportMonths = 36
+)
+
+type ReportsConfig struct {
+	// jobs and their chunks are kept here, report jobs are disabled if empty
+	Dir      string
+	Template string
+	Font     string
+	// weekly norms the timesheet balances are computed against
+	Norms entity.WorkNorms
+	// finished jobs are removed with their files this long after they
+	// finished, kept forever when zero
+	TTL time.Duration
+}
+
+// Report generated month by month in the background. Every finished month is a
+// chunk file in the job dir, so a failed or interrupted job resumes after the
+// last chunk instead of starting over. The chunks are assembled into a zip.
+type reportJob struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	// language of the report, the server's default when empty
+	Lang    string    `json:"lang,omitempty"`
+	Months  []string  `json:"months"`
+	Done    []string  `json:"done"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	// when the job was done or failed, nil while it runs
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type reportRequest struct {
+	Format string `json:"format"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Lang   string `json:"lang,omitempty"`
+}
+
+type reportJobs struct {
+	config ReportsConfig
+	s      *Server
+	mu     sync.Mutex
+	jobs   map[string]*reportJob
+}
+
+// Loads the jobs left by a previous process and resumes the unfinished ones
+func newReportJobs(s *Server, config ReportsConfig) (*reportJobs, error) {
+	r := &reportJobs{config: config, s: s, jobs: make(map[string]*reportJob)}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating reports dir: %w", err)
+	}
+
+	entries, err := os.ReadDir(config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading reports dir: %w", err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(config.Dir, entry.Name(), "job.json"))
+		if err != nil {
+			continue
+		}
+		var job reportJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Printf("skipping report job %s: %v", entry.Name(), err)
+			continue
+		}
+		r.jobs[job.ID] = &job
+		if r.expired(&job, time.Now()) {
+			r.remove(&job)
+			continue
+		}
+		if job.Status == reportPending || job.Status == reportRunning {
+			go r.run(&job)
+		}
+	}
+	return r, nil
+}
+
+func (r *reportJobs) create(format, from, to string, locale entity.Locale) (*reportJob, error) {
+	if format != "xlsx" && format != "pdf" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	start, err := time.Parse("2006-01", from)
+	if err != nil {
+		return nil, fmt.Errorf("from must be YYYY-MM: %w", err)
+	}
+	end, err := time.Parse("2006-01", to)
+	if err != nil {
+		return nil, fmt.Errorf("to must be YYYY-MM: %w", err)
+	}
+
+	months := make([]string, 0)
+	for m := start; !m.After(end); m = m.AddDate(0, 1, 0) {
+		months = append(months, m.Format("2006-01"))
+	}
+	if len(months) == 0 || len(months) > maxReportMonths {
+		return nil, fmt.Errorf("report must span 1 to %d months", maxReportMonths)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	job := &reportJob{
+		ID:      hex.EncodeToString(id),
+		Format:  format,
+		Lang:    string(locale),
+		Months:  months,
+		Done:    []string{},
+		Status:  reportPending,
+		Created: time.Now(),
+	}
+	if err := os.MkdirAll(r.jobDir(job), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.save(job); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.prune(time.Now())
+	r.jobs[job.ID] = job
+	r.mu.Unlock()
+	go r.run(job)
+	return job, nil
+}
+
+// Done and failed jobs finished longer than the TTL ago, jobs finished before
+// the finish time was recorded count from their creation
+func (r *reportJobs) expired(job *reportJob, now time.Time) bool {
+	if r.config.TTL <= 0 || (job.Status != reportDone && job.Status != reportFailed) {
+		return false
+	}
+	finished := job.Created
+	if job.Finished != nil {
+		finished = *job.Finished
+	}
+	return now.Sub(finished) > r.config.TTL
+}
+
+// Removes the expired jobs, callers hold r.mu
+func (r *reportJobs) prune(now time.Time) {
+	for _, job := range r.jobs {
+		if r.expired(job, now) {
+			r.remove(job)
+		}
+	}
+}
+
+func (r *reportJobs) remove(job *reportJob) {
+	delete(r.jobs, job.ID)
+	if err := os.RemoveAll(r.jobDir(job)); err != nil {
+		log.Printf("removing report job %s: %v", job.ID, err)
+	}
+}
+
+func (r *reportJobs) jobDir(job *reportJob) string {
+	return filepath.Join(r.config.Dir, job.ID)
+}
+
+func (r *reportJobs) chunkPath(job *reportJob, month string) string {
+	return filepath.Join(r.jobDir(job), month+"."+job.Format)
+}
+
+func (r *reportJobs) outputPath(job *reportJob) string {
+	return filepath.Join(r.jobDir(job), "report.zip")
+}
+
+// Persists the job state, callers sharing the job hold r.mu
+func (r *reportJobs) save(job *reportJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(r.jobDir(job), "job.json"), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (r *reportJobs) update(job *reportJob, fn func()) {
+	r.mu.Lock()
+	fn()
+	err := r.save(job)
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("saving report job %s: %v", job.ID, err)
+	}
+}
+
+func (r *reportJobs) run(job *reportJob) {
+	r.update(job, func() { job.Status, job.Error, job.Finished = reportRunning, "", nil })
+
+	err := r.generate(job)
+	finished := time.Now()
+	if err != nil {
+		log.Printf("report job %s failed: %v", job.ID, err)
+		r.update(job, func() { job.Status, job.Error, job.Finished = reportFailed, err.Error(), &finished })
+		return
+	}
+	r.update(job, func() { job.Status, job.Finished = reportDone, &finished })
+}
+
+func (r *reportJobs) generate(job *reportJob) error {
+	for _, month := range job.Months {
+		// chunks of a previous attempt are kept
+		if _, err := os.Stat(r.chunkPath(job, month)); err == nil {
+			continue
+		}
+
+		from, _ := time.Parse("2006-01", month)
+		err := writeFileAtomic(r.chunkPath(job, month), func(w io.Writer) error {
+			return r.s.writeMonthReport(w, from, job.Format, r.config, r.s.jobLocale(job))
+		})
+		if err != nil {
+			return fmt.Errorf("month %s: %w", month, err)
+		}
+		r.update(job, func() { job.Done = append(job.Done, month) })
+	}
+
+	return writeFileAtomic(r.outputPath(job), func(w io.Writer) error {
+		archive := zip.NewWriter(w)
+		for _, month := range job.Months {
+			chunk, err := os.Open(r.chunkPath(job, month))
+			if err != nil {
+				return err
+			}
+			entry, err := archive.Create(filepath.Base(chunk.Name()))
+			if err == nil {
+				_, err = io.Copy(entry, chunk)
+			}
+			chunk.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	})
+}
+
+func (s *Server) jobLocale(job *reportJob) entity.Locale {
+	if locale, err := entity.ParseLocale(job.Lang); err == nil {
+		return locale
+	}
+	return s.locale
+}
+
+func (s *Server) writeMonthReport(w io.Writer, month time.Time, format string, config ReportsConfig, locale entity.Locale) error {
+	employees, err := s.db.EmployeesAll()
+	if err != nil {
+		return err
+	}
+	hours, err := s.db.DailyHours(month, month.AddDate(0, 1, 0), s.policy)
+	if err != nil {
+		return err
+	}
+	leaves, err := s.db.Leaves(month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+	sheets := infra.BuildTimesheet(employees, hours, month, leaves, config.Norms)
+	if format == "pdf" {
+		return infra.WriteAttendancePDF(w, month, sheets, s.calendar, config.Font, locale)
+	}
+	return infra.WriteTimesheetXLSX(w, month, sheets, s.calendar, config.Template, locale)
+}
+
+// Writes to a temp file renamed over path once complete, so a crash never leaves
+// a truncated file that looks finished
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// POST /api/reports {"format": "xlsx", "from": "2025-01", "to": "2025-12", "lang": "en"} starts
+// a job, without lang the report is in the language of the request.
+// GET /api/reports/{id} reports its progress, GET /api/reports/{id}/download returns
+// the assembled zip and POST /api/reports/{id}/resume restarts a failed job.
+func (r *reportJobs) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/reports"), "/")
+	if path == "" {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body reportRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 4<<10)).Decode(&body); err != nil {
+			http.Error(w, "invalid report request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		locale := r.s.requestLocale(req)
+		if body.Lang != "" {
+			var err error
+			locale, err = entity.ParseLocale(body.Lang)
+			if err != nil {
+				http.Error(w, "invalid report request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		job, err := r.create(body.Format, body.From, body.To, locale)
+		if err != nil {
+			http.Error(w, "invalid report request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		r.writeJob(w, job)
+		return
+	}
+
+	id, action, _ := strings.Cut(path, "/")
+	r.mu.Lock()
+	r.prune(time.Now())
+	job, ok := r.jobs[id]
+	r.mu.Unlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		r.writeJob(w, job)
+	case action == "download" && req.Method == http.MethodGet:
+		r.mu.Lock()
+		done := job.Status == reportDone
+		r.mu.Unlock()
+		if !done {
+			http.Error(w, "report is not ready", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="report-%s.zip"`, job.ID))
+		http.ServeFile(w, req, r.outputPath(job))
+	case action == "resume" && req.Method == http.MethodPost:
+		r.mu.Lock()
+		failed := job.Status == reportFailed
+		if failed {
+			job.Status = reportPending
+		}
+		r.mu.Unlock()
+		if !failed {
+			http.Error(w, "only failed reports can be resumed", http.StatusConflict)
+			return
+		}
+		go r.run(job)
+		w.WriteHeader(http.StatusAccepted)
+		r.writeJob(w, job)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *reportJobs) writeJob(w http.ResponseWriter, job *reportJob) {
+	r.mu.Lock()
+	data, err := json.Marshal(job)
+	r.mu.Unlock()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes the job into the reports dir as a previous process left it
+func writeTestJob(t *testing.T, dir string, job reportJob) {
+	if err := os.MkdirAll(filepath.Join(dir, job.ID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, job.ID, "job.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getJob(r *reportJobs, id string) int {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/reports/"+id, nil))
+	return w.Code
+}
+
+func TestReportJobsExpire(t *testing.T) {
+	now := time.Now()
+	finished := func(ago time.Duration) *time.Time {
+		at := now.Add(-ago)
+		return &at
+	}
+
+	t.Run("jobs finished before the ttl are removed on load", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestJob(t, dir, reportJob{ID: "old", Status: reportDone, Created: now.Add(-9 * 24 * time.Hour), Finished: finished(8 * 24 * time.Hour)})
+		writeTestJob(t, dir, reportJob{ID: "legacy", Status: reportFailed, Created: now.Add(-8 * 24 * time.Hour)})
+		writeTestJob(t, dir, reportJob{ID: "recent", Status: reportDone, Created: now.Add(-9 * 24 * time.Hour), Finished: finished(time.Hour)})
+
+		r, err := newReportJobs(&Server{}, ReportsConfig{Dir: dir, TTL: 7 * 24 * time.Hour})
+		assert.Nil(t, err)
+
+		assert.Equal(t, http.StatusNotFound, getJob(r, "old"))
+		assert.Equal(t, http.StatusNotFound, getJob(r, "legacy"))
+		assert.Equal(t, http.StatusOK, getJob(r, "recent"))
+		assert.NoDirExists(t, filepath.Join(dir, "old"))
+		assert.NoDirExists(t, filepath.Join(dir, "legacy"))
+		assert.DirExists(t, filepath.Join(dir, "recent"))
+	})
+
+	t.Run("jobs expiring while served are removed on the next request", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestJob(t, dir, reportJob{ID: "done", Status: reportDone, Created: now, Finished: finished(0)})
+		r, err := newReportJobs(&Server{}, ReportsConfig{Dir: dir, TTL: time.Hour})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, getJob(r, "done"))
+
+		r.jobs["done"].Finished = finished(2 * time.Hour)
+
+		assert.Equal(t, http.StatusNotFound, getJob(r, "done"))
+		assert.Equal(t, 0, len(r.jobs))
+		assert.NoDirExists(t, filepath.Join(dir, "done"))
+	})
+
+	t.Run("unfinished jobs and jobs without ttl are kept", func(t *testing.T) {
+		r := &reportJobs{config: ReportsConfig{TTL: time.Hour}}
+		running := &reportJob{Status: reportRunning, Created: now.Add(-48 * time.Hour)}
+		done := &reportJob{Status: reportDone, Created: now.Add(-48 * time.Hour), Finished: finished(48 * time.Hour)}
+
+		assert.False(t, r.expired(running, now))
+		assert.True(t, r.expired(done, now))
+		r.config.TTL = 0
+		assert.False(t, r.expired(done, now))
+	})
+}
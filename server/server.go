@@ -0,0 +1,582 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+const listLimit = 200
+
+type Config struct {
+	Division string
+	// the JSON API is always served, the HTML dashboard only when UI is set
+	UI     bool
+	Policy entity.FlowPolicy
+	// weekends, holidays and shortened days of the division
+	Calendar entity.Calendar
+	Reports  ReportsConfig
+	// every request is authenticated when set, the API is open otherwise
+	Auth *Authenticator
+	// language of the dashboard and reports when the request asks for none
+	Locale entity.Locale
+}
+
+type Server struct {
+	db       *infra.Repository
+	division string
+	policy   entity.FlowPolicy
+	calendar entity.Calendar
+	locale   entity.Locale
+	mux      *http.ServeMux
+	handler  http.Handler
+	tmpl     *template.Template
+}
+
+func New(db *infra.Repository, config Config) (*Server, error) {
+	s := &Server{
+		db:       db,
+		division: config.Division,
+		policy:   config.Policy,
+		calendar: config.Calendar,
+		locale:   config.Locale,
+		mux:      http.NewServeMux(),
+		tmpl: template.Must(template.New("").Funcs(template.FuncMap{
+			"hours": func(h float64) string { return strconv.FormatFloat(h, 'f', 1, 64) },
+			// replaced per request with the texts of its locale
+			"t":        func(key string, args ...any) string { return key },
+			"describe": func(reason string) string { return reason },
+		}).ParseFS(templates, "templates/*.html")),
+	}
+	if s.locale == "" {
+		s.locale = entity.LocaleRU
+	}
+
+	s.mux.HandleFunc("/api/status", s.handleStatus)
+	s.mux.HandleFunc("/api/attendance", s.handleAttendance)
+	s.mux.HandleFunc("/api/attendance/daily", s.handleDailyAttendance)
+	s.mux.HandleFunc("/api/intervals/open", s.handleOpenIntervals)
+	s.mux.HandleFunc("/api/anomalies", s.handleAnomalies)
+	s.mux.HandleFunc("/api/presence", s.handlePresence)
+	s.mux.HandleFunc("/api/annotations", s.handleAnnotations)
+	s.mux.HandleFunc("/api/corrections", s.handleCorrections)
+	s.mux.HandleFunc("/api/corrections/decide", s.handleDecideCorrection)
+	s.mux.HandleFunc("/api/quality", s.handleQuality)
+	s.mux.HandleFunc("/api/employees/photo", s.handleEmployeePhoto)
+	s.mux.HandleFunc("/api/employees/duplicates", s.handleDuplicateEmployees)
+	s.mux.HandleFunc("/api/employees/merge", s.handleMergeEmployees)
+	s.mux.HandleFunc("/api/visits", s.handleVisits)
+	s.mux.HandleFunc("/api/readers", s.handleReaders)
+	s.mux.HandleFunc("/api/readers/traffic", s.handleReaderTraffic)
+	s.mux.HandleFunc("/api/zones", s.handleZones)
+	s.mux.HandleFunc("/api/intervals/merges", s.handleIntervalMerges)
+	s.mux.HandleFunc("/api/roster", s.handleRoster)
+	s.mux.HandleFunc("/api/attendance.proto", s.handleProto)
+	s.mux.HandleFunc("/api/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/api/docs", s.handleAPIDocs)
+	s.mux.Handle(grpcService, newGRPCServer(s))
+	if config.Reports.Dir != "" {
+		reports, err := newReportJobs(s, config.Reports)
+		if err != nil {
+			return nil, err
+		}
+		s.mux.Handle("/api/reports", reports)
+		s.mux.Handle("/api/reports/", reports)
+	}
+	if config.UI {
+		s.mux.HandleFunc("/", s.handleDashboard)
+	}
+	s.handler = s.mux
+	if config.Auth != nil {
+		s.handler = config.Auth.wrap(s.mux)
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.db.LoadStatus()
+	writeJSON(w, status, err)
+}
+
+func (s *Server) handleAttendance(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	hours, err := s.dailyHours(from, to)
+	writeJSON(w, hours, err)
+}
+
+// First entry and last exit per card and day of the ?days range, empty
+// unless the flow policy of the ETL enables the daily summary
+func (s *Server) handleDailyAttendance(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	days, err := s.db.DailyAttendance(r.URL.Query().Get("card"), from, to)
+	writeJSON(w, days, err)
+}
+
+func (s *Server) dailyHours(from, to time.Time) ([]infra.DailyHours, error) {
+	hours, err := s.db.DailyHours(from, to, s.policy)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hours {
+		day, err := time.Parse("2006-01-02", hours[i].Day)
+		if err != nil {
+			return nil, err
+		}
+		kind := s.calendar.Kind(day)
+		hours[i].DayKind = string(kind)
+		hours[i].Presence = string(entity.ClassifyCalendarDay(hours[i].Hours, kind, s.policy))
+	}
+	return hours, nil
+}
+
+func (s *Server) handleOpenIntervals(w http.ResponseWriter, r *http.Request) {
+	intervals, err := s.db.OpenIntervals(listLimit)
+	writeJSON(w, intervals, err)
+}
+
+func (s *Server) handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	anomalies, err := s.db.RecentAnomalies(listLimit)
+	writeJSON(w, anomalies, err)
+}
+
+func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
+	presence, err := s.db.PresenceAll(s.division)
+	writeJSON(w, presence, err)
+}
+
+// Visits of visitor and contractor cards in the ?days range, with ?onsite=1
+// only the ones still inside
+func (s *Server) handleVisits(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	visits, err := s.db.Visits(from, to, r.URL.Query().Get("onsite") == "1")
+	writeJSON(w, visits, err)
+}
+
+func (s *Server) handleQuality(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	quality, err := s.db.DataQuality(from, to)
+	writeJSON(w, quality, err)
+}
+
+// Likely duplicate employees found by the last sync, candidates for a merge
+func (s *Server) handleDuplicateEmployees(w http.ResponseWriter, r *http.Request) {
+	duplicates, err := s.db.DuplicateEmployees()
+	writeJSON(w, duplicates, err)
+}
+
+type employeeMerge struct {
+	Card          string `json:"card"`
+	DuplicateCard string `json:"duplicate_card"`
+	MergedBy      string `json:"merged_by"`
+}
+
+// POST folds the duplicate employee into the canonical one
+func (s *Server) handleMergeEmployees(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var m employeeMerge
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&m); err != nil {
+		http.Error(w, "invalid merge: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id, ok := IdentityFrom(r); ok {
+		m.MergedBy = id.Name
+	}
+	if m.Card == "" || m.DuplicateCard == "" || m.MergedBy == "" {
+		http.Error(w, "invalid merge: card, duplicate_card and merged_by are required", http.StatusBadRequest)
+		return
+	}
+
+	merge, err := s.db.MergeEmployees(m.Card, m.DuplicateCard, m.MergedBy)
+	switch {
+	case errors.Is(err, infra.ErrEmployeeNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, infra.ErrMergeSameCard):
+		http.Error(w, "invalid merge: "+err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, merge, err)
+	}
+}
+
+type readerPerimeter struct {
+	ID        int  `json:"id"`
+	Perimeter bool `json:"perimeter"`
+}
+
+// GET lists the readers of the division.
+// POST marks a reader as a perimeter door or unmarks it.
+func (s *Server) handleReaders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		readers, err := s.db.Readers(s.division)
+		writeJSON(w, readers, err)
+	case http.MethodPost:
+		var p readerPerimeter
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&p); err != nil {
+			http.Error(w, "invalid reader: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		reader, err := s.db.SetReaderPerimeter(s.division, p.ID, p.Perimeter)
+		if errors.Is(err, infra.ErrReaderNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, reader, err)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Events and cards per door and day of the ?days range
+func (s *Server) handleReaderTraffic(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	traffic, err := s.db.ReaderTraffic(s.division, from, to)
+	writeJSON(w, traffic, err)
+}
+
+// Hours per card, day and zone of the ?days range, optionally of one ?card
+func (s *Server) handleZones(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	times, err := s.db.ZoneTimes(r.URL.Query().Get("card"), from, to)
+	writeJSON(w, times, err)
+}
+
+// Short exits the grace period merged away in the ?days range
+func (s *Server) handleIntervalMerges(w http.ResponseWriter, r *http.Request) {
+	from, to := dayRange(r)
+	merges, err := s.db.IntervalMerges(r.URL.Query().Get("card"), from, to)
+	writeJSON(w, merges, err)
+}
+
+type rosterEntry struct {
+	Card  string  `json:"card"`
+	Day   string  `json:"day"`
+	Shift string  `json:"shift"`
+	Start string  `json:"start"`
+	End   string  `json:"end"`
+	Hours float64 `json:"hours"`
+}
+
+// GET compares planned and worked hours of the ?days range, differences of
+// at least ?threshold hours, 1 by default, are discrepancies.
+// POST imports planned shifts, entries of the same card and day are replaced.
+func (s *Server) handleRoster(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		threshold, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64)
+		if err != nil || threshold <= 0 {
+			threshold = 1
+		}
+		from, to := dayRange(r)
+		comparison, err := s.db.RosterComparison(from, to, threshold, s.policy)
+		writeJSON(w, comparison, err)
+	case http.MethodPost:
+		var posted []rosterEntry
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&posted); err != nil {
+			http.Error(w, "invalid roster: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries := make([]entity.RosterEntry, len(posted))
+		for i, p := range posted {
+			day, err := time.Parse("2006-01-02", p.Day)
+			if err != nil {
+				http.Error(w, "invalid roster: day must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			entries[i] = entity.RosterEntry{Card: p.Card, Day: day, Shift: p.Shift, Start: p.Start, End: p.End, Hours: p.Hours}
+			if err := entries[i].Normalize(); err != nil {
+				http.Error(w, "invalid roster: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		err := s.db.ImportRoster(entries, time.Now())
+		writeJSON(w, map[string]int{"imported": len(entries)}, err)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Badge photo of the ?card holder of the ?database division, the served one
+// by default, as stored by the last sync
+func (s *Server) handleEmployeePhoto(w http.ResponseWriter, r *http.Request) {
+	database := r.URL.Query().Get("database")
+	if database == "" {
+		database = s.division
+	}
+	photo, ok, err := s.db.EmployeePhoto(database, r.URL.Query().Get("card"))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", photo.ContentType)
+	w.Header().Set("ETag", `"`+photo.SHA256+`"`)
+	if r.Header.Get("If-None-Match") == `"`+photo.SHA256+`"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(photo.Data)
+}
+
+// GET lists annotations of the ?days range, optionally of one ?card.
+// POST creates an annotation or replaces the one with the same key.
+func (s *Server) handleAnnotations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		from, to := dayRange(r)
+		annotations, err := s.db.Annotations(r.URL.Query().Get("card"), from, to)
+		writeJSON(w, annotations, err)
+	case http.MethodPost:
+		var a infra.Annotation
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&a); err != nil {
+			http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if a.Database == "" {
+			a.Database = s.division
+		}
+		if id, ok := IdentityFrom(r); ok {
+			a.Author = id.Name
+		}
+		if err := a.Validate(); err != nil {
+			http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		a, err := s.db.UpsertAnnotation(a)
+		writeJSON(w, a, err)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET lists the corrections of intervals entered in the ?days range, optionally
+// of one ?card and ?status. POST requests a close, split or annotate correction,
+// which takes effect once approved.
+func (s *Server) handleCorrections(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		from, to := dayRange(r)
+		query := r.URL.Query()
+		corrections, err := s.db.Corrections(query.Get("card"), query.Get("status"), from, to)
+		writeJSON(w, corrections, err)
+	case http.MethodPost:
+		var c infra.Correction
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&c); err != nil {
+			http.Error(w, "invalid correction: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if c.Database == "" {
+			c.Database = s.division
+		}
+		// authenticated callers can't request in someone else's name
+		if id, ok := IdentityFrom(r); ok {
+			c.RequestedBy = id.Name
+		}
+		if err := c.Validate(); err != nil {
+			http.Error(w, "invalid correction: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, err := s.db.AddCorrection(c)
+		if errors.Is(err, infra.ErrIntervalNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		var invalid infra.InvalidCorrectionError
+		if errors.As(err, &invalid) {
+			http.Error(w, "invalid correction: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, c, err)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type correctionDecision struct {
+	ID         int    `json:"id"`
+	Status     string `json:"status"`
+	ApprovedBy string `json:"approved_by"`
+	Note       string `json:"note"`
+}
+
+// POST approves or rejects a pending correction
+func (s *Server) handleDecideCorrection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var d correctionDecision
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&d); err != nil {
+		http.Error(w, "invalid decision: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id, ok := IdentityFrom(r); ok {
+		d.ApprovedBy = id.Name
+	}
+	if d.Status != infra.CorrectionApproved && d.Status != infra.CorrectionRejected {
+		http.Error(w, "invalid decision: status must be approved or rejected", http.StatusBadRequest)
+		return
+	}
+	if d.ApprovedBy == "" {
+		http.Error(w, "invalid decision: approved_by is required", http.StatusBadRequest)
+		return
+	}
+
+	c, err := s.db.DecideCorrection(d.ID, d.Status == infra.CorrectionApproved, d.ApprovedBy, d.Note)
+	var invalid infra.InvalidCorrectionError
+	switch {
+	case errors.Is(err, infra.ErrCorrectionNotFound), errors.Is(err, infra.ErrIntervalNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, infra.ErrCorrectionNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, infra.ErrCorrectionSelfApprove):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &invalid):
+		http.Error(w, "invalid correction: "+err.Error(), http.StatusUnprocessableEntity)
+	default:
+		writeJSON(w, c, err)
+	}
+}
+
+type gridCell struct {
+	Hours    float64
+	Presence string
+}
+
+type gridRow struct {
+	Card  string
+	Name  string
+	Cells []gridCell
+}
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	from, to := dayRange(r)
+	status, err := s.db.LoadStatus()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	hours, err := s.dailyHours(from, to)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	open, err := s.db.OpenIntervals(listLimit)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	anomalies, err := s.db.RecentAnomalies(listLimit)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	days := make([]string, 0)
+	dayIndex := make(map[string]int)
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		dayIndex[d.Format("2006-01-02")] = len(days)
+		days = append(days, d.Format("2006-01-02"))
+	}
+	rows := make([]*gridRow, 0)
+	rowIndex := make(map[string]*gridRow)
+	for _, h := range hours {
+		row, ok := rowIndex[h.Card]
+		if !ok {
+			row = &gridRow{Card: h.Card, Name: h.LastName + " " + h.FirstName, Cells: make([]gridCell, len(days))}
+			rowIndex[h.Card] = row
+			rows = append(rows, row)
+		}
+		if i, ok := dayIndex[h.Day]; ok {
+			row.Cells[i] = gridCell{Hours: h.Hours, Presence: h.Presence}
+		}
+	}
+
+	locale := s.requestLocale(r)
+	tmpl, err := s.tmpl.Clone()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	tmpl.Funcs(template.FuncMap{
+		"t":        locale.T,
+		"describe": func(reason string) string { return locale.AnomalyDescription(entity.AnomalyReason(reason)) },
+	})
+	err = tmpl.ExecuteTemplate(w, "dashboard.html", map[string]any{
+		"Lang":      locale,
+		"Division":  s.division,
+		"Status":    status,
+		"Days":      days,
+		"Grid":      rows,
+		"Open":      open,
+		"Anomalies": anomalies,
+	})
+	if err != nil {
+		log.Printf("rendering dashboard: %v", err)
+	}
+}
+
+// Language of ?lang=, else the first supported one of Accept-Language, else
+// the server's default
+func (s *Server) requestLocale(r *http.Request) entity.Locale {
+	if locale, err := entity.ParseLocale(r.URL.Query().Get("lang")); err == nil {
+		return locale
+	}
+	return entity.LocaleFromAcceptLanguage(r.Header.Get("Accept-Language"), s.locale)
+}
+
+// Last ?days=N days including today, a week by default
+func dayRange(r *http.Request) (time.Time, time.Time) {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 || days > 62 {
+		days = 7
+	}
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	return to.AddDate(0, 0, -days), to
+}
+
+func writeJSON(w http.ResponseWriter, v any, err error) {
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	log.Printf("http: %v", err)
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,91 @@
+//go:build integration
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Synthetic ZKAccess source. Tables are written as the CSV mdb-export would
+// produce and served by stub mdb-export and mdb-ver scripts, so the pipeline
+// runs unchanged against it.
+type simulatedSource struct {
+	users       [][]string
+	departments [][]string
+	events      [][]string
+	nextEventID int
+}
+
+func newSimulatedSource() *simulatedSource {
+	return &simulatedSource{nextEventID: 1}
+}
+
+func (s *simulatedSource) AddDepartment(id, name string) {
+	s.departments = append(s.departments, []string{id, name})
+}
+
+func (s *simulatedSource) AddUser(card, firstname, lastname, departmentID, title string) {
+	// mdb-export keeps the ZKAccess column naming where lastname holds the first name
+	s.users = append(s.users, []string{firstname, lastname, card, departmentID, title})
+}
+
+// A single reader event, state is 0 for the entry reader and 1 for the exit one
+func (s *simulatedSource) Badge(card, point string, at time.Time, state int) {
+	s.events = append(s.events, []string{
+		fmt.Sprint(s.nextEventID), at.Format("01/02/06 15:04:05"), card, point, fmt.Sprint(state), "0",
+	})
+	s.nextEventID++
+}
+
+// Entry and exit badges through the same point
+func (s *simulatedSource) Visit(card, point string, ent, ext time.Time) {
+	s.Badge(card, point, ent, 0)
+	s.Badge(card, point, ext, 1)
+}
+
+// Writes the tables and the stub tools to a temp dir, puts the stubs first in
+// PATH and returns the path to pass as the MDB location
+func (s *simulatedSource) Install(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	tables := map[string][][]string{
+		"acc_monitor_log": append([][]string{{"id", "time", "card_no", "event_point_name", "state", "event_type"}}, s.events...),
+		"USERINFO":        append([][]string{{"lastname", "name", "CardNo", "DEFAULTDEPTID", "TITLE"}}, s.users...),
+		"DEPARTMENTS":     append([][]string{{"DEPTID", "DEPTNAME"}}, s.departments...),
+	}
+	for table, rows := range tables {
+		f, err := os.Create(filepath.Join(dir, table+".csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := csv.NewWriter(f)
+		w.WriteAll(rows)
+		if err := w.Error(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	stubs := map[string]string{
+		"mdb-export": "#!/bin/sh\nexec cat \"$1/$2.csv\"\n",
+		"mdb-ver":    "#!/bin/sh\necho JET4\n",
+	}
+	for name, script := range stubs {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return dir
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+const (
+	eventSourceMDB       = "mdb"
+	eventSourceMQTT      = "mqtt"
+	eventSourceHikvision = "hikvision"
+	eventSourceFirebird  = "firebird"
+	eventSourceMSSQL     = "mssql"
+)
+
+// Where a run takes users and events from, selected by EVENT_SOURCE
+type runSource struct {
+	// identifies the source in the run audit and the sync state
+	name   string
+	events infra.EventSource
+	// nil unless events come from an MDB file
+	exporter *infra.MdbExporter
+	// users of vendor databases, nil for MDB files and sources without users
+	userSource infra.UserSource
+}
+
+func openRunSource() (runSource, error) {
+	switch kind := envOr("EVENT_SOURCE", eventSourceMDB); kind {
+	case eventSourceMDB:
+		mdbpath := os.Getenv("ACCESS_MDB_PATH")
+		infof("initializing MDB exporter with path: %s", mdbpath)
+		exporter, err := newExporter(mdbpath)
+		if err != nil {
+			return runSource{}, err
+		}
+		exporter.SetArchiveDir(os.Getenv("EVENT_ARCHIVE_DIR"))
+		return runSource{name: mdbpath, events: exporter, exporter: exporter}, nil
+	case eventSourceMQTT:
+		spool, err := infra.NewEventSpool(os.Getenv("EVENT_SPOOL_DIR"))
+		if err != nil {
+			return runSource{}, err
+		}
+		infof("reading events spooled by mqtt-ingest from %s", spool.Dir)
+		return runSource{name: eventSourceMQTT + ":" + spool.Dir, events: spool}, nil
+	case eventSourceHikvision:
+		spool, err := infra.NewEventSpool(os.Getenv("EVENT_SPOOL_DIR"))
+		if err != nil {
+			return runSource{}, err
+		}
+		secrets, err := infra.NewSecretProviderFromEnv()
+		if err != nil {
+			return runSource{}, err
+		}
+		calendar, err := infra.CalendarFromEnv()
+		if err != nil {
+			return runSource{}, err
+		}
+		source, err := infra.NewHikvisionSourceFromEnv(secrets, spool, calendar.SiteTime)
+		if err != nil {
+			return runSource{}, err
+		}
+		return runSource{name: eventSourceHikvision + ":" + spool.Dir, events: source}, nil
+	case eventSourceFirebird:
+		secrets, err := infra.NewSecretProviderFromEnv()
+		if err != nil {
+			return runSource{}, err
+		}
+		source, err := infra.NewFirebirdSourceFromEnv(secrets)
+		if err != nil {
+			return runSource{}, err
+		}
+		infof("reading users and events from firebird database %s", os.Getenv("FIREBIRD_DATABASE"))
+		return runSource{name: eventSourceFirebird + ":" + os.Getenv("FIREBIRD_DATABASE"), events: source, userSource: source}, nil
+	case eventSourceMSSQL:
+		secrets, err := infra.NewSecretProviderFromEnv()
+		if err != nil {
+			return runSource{}, err
+		}
+		source, err := infra.NewMSSQLSourceFromEnv(secrets)
+		if err != nil {
+			return runSource{}, err
+		}
+		name := os.Getenv("MSSQL_SERVER") + "/" + os.Getenv("MSSQL_DATABASE")
+		infof("reading users and events from sql server database %s", name)
+		return runSource{name: eventSourceMSSQL + ":" + name, events: source, userSource: source}, nil
+	default:
+		return runSource{}, fmt.Errorf("unknown EVENT_SOURCE: %s", kind)
+	}
+}
+
+// Controller users of MDB files and vendor databases. Other sources only know
+// cards, their users are the employees already in the destination.
+func (s runSource) users(db *infra.Repository) ([]*entity.User, error) {
+	if s.exporter != nil {
+		return s.exporter.ExportUsersFromDB()
+	}
+	if s.userSource != nil {
+		return s.userSource.ExportUsers()
+	}
+	employees, err := db.EmployeeIndex()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*entity.User, 0, len(employees))
+	for _, e := range employees {
+		users = append(users, employeeUser(e))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Card < users[j].Card })
+	return users, nil
+}
+
+// User of a destination employee with what the flow policy selects profiles by
+func employeeUser(e infra.Employee) *entity.User {
+	return &entity.User{
+		FirstName:  e.FirstName,
+		LastName:   e.LastName,
+		Card:       e.Card,
+		Department: e.Department,
+		Position:   e.Position,
+		Intervals:  make([]entity.Interval, 0),
+	}
+}
+
+// Change detection only saves work, an unreadable fingerprint means a full run.
+// Spooled sources grow all the time and are always loaded.
+func (s runSource) fingerprint() (infra.SourceFingerprint, bool) {
+	if s.exporter == nil {
+		return infra.SourceFingerprint{}, false
+	}
+	fingerprint, err := infra.FingerprintFile(s.name)
+	if err != nil {
+		warnf("%v", err)
+		return fingerprint, false
+	}
+	return fingerprint, true
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+	database "github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Stages of a run that can be executed one at a time, each one an
+// orchestrator task with its own retries. They hand off through files in the
+// stage directory:
+//
+//	extract          source -> manifest.json, users.json, extras.json, events/
+//	sync-employees   users.json, extras.json -> destination employees, readers and photos, prepared.json
+//	load-events      events/ -> destination events
+//	build-intervals  prepared.json, events/ -> everything derived
+const (
+	stageExtract        = "extract"
+	stageSyncEmployees  = "sync-employees"
+	stageLoadEvents     = "load-events"
+	stageBuildIntervals = "build-intervals"
+)
+
+var runStages = []string{stageExtract, stageSyncEmployees, stageLoadEvents, stageBuildIntervals}
+
+const (
+	stageManifestFile = "manifest.json"
+	stageUsersFile    = "users.json"
+	stageExtrasFile   = "extras.json"
+	stagePreparedFile = "prepared.json"
+	stageEventsDir    = "events"
+)
+
+// What the extract stage read and from where
+type stageManifest struct {
+	Division    string                   `json:"division"`
+	Source      string                   `json:"source"`
+	ExtractedAt time.Time                `json:"extracted_at"`
+	Fingerprint *infra.SourceFingerprint `json:"fingerprint,omitempty"`
+}
+
+type stagedUser struct {
+	FirstName    string                  `json:"firstname"`
+	LastName     string                  `json:"lastname"`
+	Card         string                  `json:"card"`
+	DepartmentID string                  `json:"department_id,omitempty"`
+	Department   string                  `json:"department,omitempty"`
+	Position     string                  `json:"position,omitempty"`
+	Kind         string                  `json:"kind,omitempty"`
+	Cards        []entity.CardAssignment `json:"cards,omitempty"`
+}
+
+func runStage(ctx context.Context, stage string) (summary infra.RunSummary, err error) {
+	summary = infra.RunSummary{
+		Division: os.Getenv("CONTROLLER_DIVISION_NAME"),
+		Started:  time.Now(),
+	}
+	stages := newStageTimer(&summary)
+	defer stages.stop()
+	stages.begin(stage)
+	defer func() { summary.Finished = time.Now() }()
+
+	return summary, executeStage(ctx, stage, *stageDir, &summary, stages)
+}
+
+func executeStage(ctx context.Context, stage, dir string, summary *infra.RunSummary, stages *stageTimer) error {
+	switch stage {
+	case stageExtract:
+		return extractStage(ctx, dir, summary)
+	case stageSyncEmployees:
+		return syncEmployeesStage(dir, summary)
+	case stageLoadEvents:
+		return loadEventsStage(ctx, dir, summary)
+	case stageBuildIntervals:
+		return buildIntervalsStage(ctx, dir, summary, stages)
+	default:
+		return infra.ValidationFailure(fmt.Errorf("unknown stage %q, expected one of %v", stage, runStages))
+	}
+}
+
+// Runs every stage in turn through the stage directory and checkpoints each
+// completed one. A run that died continues after its last completed stage,
+// so a crash before interval building doesn't extract and load again.
+func runCheckpointed(ctx context.Context) (summary infra.RunSummary, err error) {
+	summary = infra.RunSummary{
+		Division: os.Getenv("CONTROLLER_DIVISION_NAME"),
+		Started:  time.Now(),
+	}
+	stages := newStageTimer(&summary)
+	defer stages.stop()
+	defer func() { summary.Finished = time.Now() }()
+
+	dir, err := filepath.Abs(*stageDir)
+	if err != nil {
+		return summary, err
+	}
+	db, err := connectDestination()
+	if err != nil {
+		return summary, infra.DestinationFailure(fmt.Errorf("error connecting to database: %w", err))
+	}
+	defer db.Close()
+	source, err := openRunSource()
+	if err != nil {
+		return summary, infra.SourceFailure(err)
+	}
+	if err := guardDestination(db, summary.Division, source.name); err != nil {
+		return summary, err
+	}
+	if err := db.Migrate(); err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+
+	checkpoint, ok, err := db.LoadCheckpoint(summary.Division)
+	if err != nil {
+		return summary, infra.DestinationFailure(err)
+	}
+	next := 0
+	runStarted := summary.Started
+	stale := ""
+	if ok {
+		stale = staleCheckpoint(checkpoint, dir, source)
+	}
+	switch {
+	case ok && checkpoint.StageDir != dir:
+		warnf("checkpoint of the run started %s is in %s, starting over in %s",
+			checkpoint.RunStarted.Format(time.RFC3339), checkpoint.StageDir, dir)
+	case ok && stale != "":
+		warnf("checkpoint of the run started %s is stale, %s, starting over",
+			checkpoint.RunStarted.Format(time.RFC3339), stale)
+	case ok:
+		for i, stage := range runStages {
+			if stage == checkpoint.Stage {
+				next = i + 1
+			}
+		}
+		runStarted = checkpoint.RunStarted
+		infof("resuming the run started %s after its %s stage", runStarted.Format(time.RFC3339), checkpoint.Stage)
+	}
+	if next == 0 && !*force {
+		unchanged, calendar, err := sourceUnchanged(db, summary.Division)
+		if err != nil {
+			return summary, err
+		}
+		if unchanged {
+			summary.Skipped = true
+			return summary, expirePresence(db, summary.Division, calendar)
+		}
+	}
+
+	for _, stage := range runStages[next:] {
+		if err := interrupted(ctx, stage); err != nil {
+			return summary, err
+		}
+		stages.begin(stage)
+		if err := executeStage(ctx, stage, dir, &summary, stages); err != nil {
+			return summary, err
+		}
+		err := db.SaveCheckpoint(infra.Checkpoint{
+			Division: summary.Division, RunStarted: runStarted, Stage: stage, StageDir: dir, CompletedAt: time.Now(),
+		})
+		if err != nil {
+			return summary, infra.DestinationFailure(err)
+		}
+	}
+	return summary, infra.DestinationFailure(db.ClearCheckpoint(summary.Division))
+}
+
+// Why the unfinished run must not be resumed, empty when it may. Its stage
+// files are a snapshot of the source: by the next scheduled run they are
+// outdated, and once the source changed, finishing the run would load what
+// the source no longer holds.
+func staleCheckpoint(checkpoint infra.Checkpoint, dir string, source runSource) string {
+	if age := time.Since(checkpoint.RunStarted); age > *daemonInterval {
+		return fmt.Sprintf("started %s ago, longer than the %s schedule interval", age.Round(time.Second), *daemonInterval)
+	}
+	manifest, err := readStageManifest(dir)
+	if err != nil || manifest.Fingerprint == nil {
+		return ""
+	}
+	current, ok := source.fingerprint()
+	if ok && !current.SameSource(*manifest.Fingerprint) {
+		return "the source changed since it was extracted"
+	}
+	return ""
+}
+
+// Reports whether the source and the run inputs are unchanged since the last
+// successful run, with the calendar the inputs were hashed with
+func sourceUnchanged(db *infra.Repository, division string) (bool, entity.Calendar, error) {
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		return false, entity.Calendar{}, infra.ValidationFailure(err)
+	}
+	norms, _, err := infra.WorkNormsFromEnv()
+	if err != nil {
+		return false, entity.Calendar{}, infra.ValidationFailure(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		return false, calendar, infra.ValidationFailure(err)
+	}
+	source, err := openRunSource()
+	if err != nil {
+		return false, calendar, infra.SourceFailure(err)
+	}
+	fingerprint, ok := source.fingerprint()
+	if !ok {
+		return false, calendar, nil
+	}
+	if fingerprint.Inputs, err = runInputs(db, policy, calendar, norms); err != nil {
+		return false, calendar, err
+	}
+	last, ok, err := db.LastSourceFingerprint(division, source.name)
+	if err != nil {
+		return false, calendar, infra.DestinationFailure(err)
+	}
+	return ok && last.Equal(fingerprint), calendar, nil
+}
+
+// Reads users and events from the source into the stage directory, the
+// destination is only used for the users of sources without their own
+func extractStage(ctx context.Context, dir string, summary *infra.RunSummary) error {
+	source, err := openRunSource()
+	if err != nil {
+		return infra.SourceFailure(err)
+	}
+	manifest := stageManifest{Division: summary.Division, Source: source.name, ExtractedAt: time.Now()}
+	if fingerprint, ok := source.fingerprint(); ok {
+		manifest.Fingerprint = &fingerprint
+	}
+
+	var db *infra.Repository
+	if source.exporter == nil && source.userSource == nil {
+		if db, err = connectDestination(); err != nil {
+			return infra.DestinationFailure(fmt.Errorf("error connecting to database: %w", err))
+		}
+		defer db.Close()
+	}
+	users, err := source.users(db)
+	if err != nil {
+		return infra.SourceFailure(fmt.Errorf("error exporting users: %w", err))
+	}
+	entity.SortUsers(users)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeStageUsers(filepath.Join(dir, stageUsersFile), users); err != nil {
+		return err
+	}
+	summary.UsersSynced = len(users)
+	if err := writeStageJSON(filepath.Join(dir, stageExtrasFile), exportExtras(source, summary.Division)); err != nil {
+		return err
+	}
+
+	// the previous extract stays in place until this one is complete
+	eventsDir := filepath.Join(dir, stageEventsDir)
+	if err := os.RemoveAll(eventsDir + ".part"); err != nil {
+		return err
+	}
+	spool, err := infra.NewEventSpool(eventsDir + ".part")
+	if err != nil {
+		return err
+	}
+	err = source.events.StreamEvents(ctx, *selectEventsForMonths, *streamBatchSize, func(batch []entity.Event) error {
+		summary.EventsExported += len(batch)
+		return spool.Append(batch)
+	})
+	if err != nil {
+		return infra.SourceFailure(fmt.Errorf("error exporting events: %w", err))
+	}
+	if err := os.RemoveAll(eventsDir); err != nil {
+		return err
+	}
+	if err := os.Rename(spool.Dir, eventsDir); err != nil {
+		return err
+	}
+	infof("extracted %d users and %d events to %s", len(users), summary.EventsExported, dir)
+	return writeStageJSON(filepath.Join(dir, stageManifestFile), manifest)
+}
+
+// Syncs the extracted users and keeps them, prepared with card assignments
+// and master data, for interval building
+func syncEmployeesStage(dir string, summary *infra.RunSummary) error {
+	manifest, err := readStageManifest(dir)
+	if err != nil {
+		return err
+	}
+	users, err := readStageUsers(filepath.Join(dir, stageUsersFile), stageExtract)
+	if err != nil {
+		return err
+	}
+	extras, err := readStageExtras(filepath.Join(dir, stageExtrasFile))
+	if err != nil {
+		return err
+	}
+	db, secondary, err := openStageDestination(summary.Division, manifest.Source)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer secondary.close()
+
+	if err := recordDestination(db, summary.Division, manifest.Source); err != nil {
+		return err
+	}
+	users, visitors, err := syncDirectory(db, secondary, summary.Division, users, extras)
+	if err != nil {
+		return infra.DestinationFailure(err)
+	}
+	summary.UsersSynced = len(users)
+	return writeStageUsers(filepath.Join(dir, stagePreparedFile), append(users, visitors...))
+}
+
+func loadEventsStage(ctx context.Context, dir string, summary *infra.RunSummary) error {
+	manifest, err := readStageManifest(dir)
+	if err != nil {
+		return err
+	}
+	spool, err := stageEvents(dir)
+	if err != nil {
+		return err
+	}
+	db, secondary, err := openStageDestination(summary.Division, manifest.Source)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer secondary.close()
+
+	loaded := startProgress("events loaded", "", 0, false, *progressEvery)
+	err = spool.StreamEvents(ctx, *selectEventsForMonths, *streamBatchSize, func(batch []entity.Event) error {
+		entity.SortEvents(batch)
+		inserted, err := db.InsertEvents(ctx, batch)
+		if err != nil {
+			return infra.DestinationFailure(err)
+		}
+		secondary.write("insert events", func(db *database.Repository) error {
+			_, err := db.InsertEvents(ctx, batch)
+			return err
+		})
+		summary.EventsExported += len(batch)
+		summary.EventsInserted += inserted
+		loaded.add(len(batch))
+		debugf("batch of %d events, %d new", len(batch), inserted)
+		return nil
+	})
+	loaded.finish()
+	if err != nil {
+		return err
+	}
+	infof("loaded %d events, %d new", summary.EventsExported, summary.EventsInserted)
+	return nil
+}
+
+// Builds everything derived from the prepared users and the extracted events.
+// As the last stage it records the run and the source fingerprint.
+func buildIntervalsStage(ctx context.Context, dir string, summary *infra.RunSummary, stages *stageTimer) (err error) {
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		return infra.ValidationFailure(err)
+	}
+	norms, normsConfigured, err := infra.WorkNormsFromEnv()
+	if err != nil {
+		return infra.ValidationFailure(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		return infra.ValidationFailure(err)
+	}
+	doorEventTypes, err := parseDoorEventTypes(os.Getenv("DOOR_SENSOR_EVENT_TYPES"))
+	if err != nil {
+		return infra.ValidationFailure(err)
+	}
+	manifest, err := readStageManifest(dir)
+	if err != nil {
+		return err
+	}
+	users, err := readStageUsers(filepath.Join(dir, stagePreparedFile), stageSyncEmployees)
+	if err != nil {
+		return err
+	}
+	spool, err := stageEvents(dir)
+	if err != nil {
+		return err
+	}
+	db, secondary, err := openStageDestination(summary.Division, manifest.Source)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer secondary.close()
+	defer func() {
+		run := infra.NewETLRun(*summary, manifest.Source, summary.Started.AddDate(0, -*selectEventsForMonths, 0), interruptedRun(ctx, err), buildVersion())
+		if rerr := db.RecordETLRun(run); rerr != nil {
+			warnf("%v", rerr)
+		}
+	}()
+
+	policy, err = withPerimeterReaders(db, summary.Division, policy)
+	if err != nil {
+		return err
+	}
+	guard := newMemoryGuard(*memoryLimit)
+	employees, visitors := entity.SplitVisitors(users)
+	summary.UsersSynced = len(employees)
+	sink := newEventSink(employees, visitors, policy.Filter, doorEventTypes)
+	err = spool.StreamEvents(ctx, *selectEventsForMonths, *streamBatchSize, func(batch []entity.Event) error {
+		summary.EventsExported += len(batch)
+		sink.add(batch)
+		return guard.check("event read")
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := buildIntervals(ctx, db, secondary, summary, stages, guard, policy, calendar, workNorms{norms, normsConfigured}, sink.data); err != nil {
+		return err
+	}
+	if manifest.Fingerprint != nil {
+		fingerprint := *manifest.Fingerprint
+		if fingerprint.Inputs, err = runInputs(db, policy, calendar, norms); err != nil {
+			return err
+		}
+		if err := db.RecordSourceFingerprint(summary.Division, manifest.Source, fingerprint, time.Now()); err != nil {
+			return infra.DestinationFailure(err)
+		}
+	}
+	return nil
+}
+
+// Every stage writing to the destination is guarded, before migrating as a
+// full run is
+func openStageDestination(division, source string) (*infra.Repository, *mirror, error) {
+	db, err := connectDestination()
+	if err != nil {
+		return nil, nil, infra.DestinationFailure(fmt.Errorf("error connecting to database: %w", err))
+	}
+	if err := guardDestination(db, division, source); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, nil, infra.DestinationFailure(err)
+	}
+	secondary, err := connectMirror()
+	if err != nil {
+		db.Close()
+		return nil, nil, infra.DestinationFailure(err)
+	}
+	secondary.write("migrate", func(db *database.Repository) error { return db.Migrate() })
+	return db, secondary, nil
+}
+
+// A stage that runs before the one producing its input finds nothing to work
+// on, that is a validation failure rather than a broken destination
+func missingStageInput(path, producer string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return infra.ValidationFailure(fmt.Errorf("%s not found, run the %s stage first", path, producer))
+	}
+	return err
+}
+
+func stageEvents(dir string) (*infra.EventSpool, error) {
+	path := filepath.Join(dir, stageEventsDir)
+	if _, err := os.Stat(path); err != nil {
+		return nil, missingStageInput(path, stageExtract, err)
+	}
+	return infra.NewEventSpool(path)
+}
+
+func readStageManifest(dir string) (stageManifest, error) {
+	var manifest stageManifest
+	path := filepath.Join(dir, stageManifestFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, missingStageInput(path, stageExtract, err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+func writeStageUsers(path string, users []*entity.User) error {
+	staged := make([]stagedUser, len(users))
+	for i, u := range users {
+		staged[i] = stagedUser{
+			FirstName: u.FirstName, LastName: u.LastName, Card: u.Card, DepartmentID: u.DepartmentID,
+			Department: u.Department, Position: u.Position, Kind: u.Kind, Cards: u.Cards,
+		}
+	}
+	return writeStageJSON(path, staged)
+}
+
+func readStageUsers(path, producer string) ([]*entity.User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, missingStageInput(path, producer, err)
+	}
+	var staged []stagedUser
+	if err := json.Unmarshal(data, &staged); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	users := make([]*entity.User, len(staged))
+	for i, s := range staged {
+		users[i] = &entity.User{
+			FirstName: s.FirstName, LastName: s.LastName, Card: s.Card, DepartmentID: s.DepartmentID,
+			Department: s.Department, Position: s.Position, Kind: s.Kind, Cards: s.Cards,
+			Intervals: make([]entity.Interval, 0),
+		}
+	}
+	return users, nil
+}
+
+// Doors of the extract, none when the source has no doors
+func readStageExtras(path string) (sourceExtras, error) {
+	var extras sourceExtras
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return extras, missingStageInput(path, stageExtract, err)
+	}
+	if err := json.Unmarshal(data, &extras); err != nil {
+		return extras, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return extras, nil
+}
+
+// Replaces the file only once it is completely written
+func writeStageJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".part", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".part", path)
+}
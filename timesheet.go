@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/entity"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Monthly per-day timesheet for HR: an XLSX workbook with a sheet per department
+// or a PDF summary with a page per employee for the signed archive
+func timesheetCommand(args []string) {
+	fs := flag.NewFlagSet("report timesheet", flag.ExitOnError)
+	month := fs.String("month", time.Now().AddDate(0, -1, 0).Format("2006-01"), "month to report, YYYY-MM")
+	template := fs.String("template", os.Getenv("TIMESHEET_TEMPLATE"), "XLSX file whose first sheet styles every department sheet")
+	format := fs.String("format", "xlsx", "output format, xlsx or pdf")
+	font := fs.String("font", envOr("REPORT_FONT", infra.DefaultReportFont), "TrueType font with Cyrillic glyphs for pdf output")
+	lang := fs.String("lang", envOr("REPORT_LANG", string(entity.LocaleRU)), "report language, ru or en")
+	policyPath := fs.String("policy", "", "JSON flow policy whose rounding applies to worked hours, no rounding if empty")
+	out := fs.String("out", "", "output file, stdout if empty")
+	maskSpec := fs.String("mask", "", "PII masking profile (none, consultant, anonymous or a spec like names,card=4)")
+	fs.Parse(args)
+
+	if *format != "xlsx" && *format != "pdf" {
+		log.Fatalf("unknown -format %q", *format)
+	}
+	locale := reportLocale(*lang)
+	mask, err := infra.ParseMaskingProfile(*maskSpec)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	from, err := time.Parse("2006-01", *month)
+	if err != nil {
+		log.Fatalf("invalid -month %q: %v", *month, err)
+	}
+
+	policy, err := loadFlowPolicy(*policyPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	norms, _, err := infra.WorkNormsFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	employees, err := db.EmployeesAll()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	hours, err := db.DailyHours(from, from.AddDate(0, 1, 0), policy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	leaves, err := db.Leaves(from, from.AddDate(0, 1, 0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sheets := infra.BuildTimesheet(employees, hours, from, leaves, norms)
+	mask.Timesheet(sheets)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "pdf" {
+		err = infra.WriteAttendancePDF(w, from, sheets, calendar, *font, locale)
+	} else {
+		err = infra.WriteTimesheetXLSX(w, from, sheets, calendar, *template, locale)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := finishReport(w, *out, from); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("timesheet for %s: %d departments, %d employees", *month, len(sheets), len(employees))
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func reportLocale(lang string) entity.Locale {
+	locale, err := entity.ParseLocale(lang)
+	if err != nil {
+		log.Fatalf("invalid -lang: %v", err)
+	}
+	return locale
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Uploads generated files to the S3_BUCKET, keyed by their path below root
+// under the S3_PREFIX of the division and the period the files cover. Without
+// a bucket nothing is uploaded.
+func uploadArtifacts(root string, period time.Time, files ...string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	secrets, err := infra.NewSecretProviderFromEnv()
+	if err != nil {
+		return err
+	}
+	config, enabled, err := infra.S3ConfigFromEnv(secrets)
+	if err != nil || !enabled {
+		return err
+	}
+
+	prefix := config.KeyPrefix(os.Getenv("CONTROLLER_DIVISION_NAME"), period)
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if prefix != "" {
+			key = prefix + "/" + key
+		}
+		if err := config.Upload(key, file); err != nil {
+			return err
+		}
+		log.Printf("uploaded %s to s3://%s/%s", file, config.Bucket, key)
+	}
+	return nil
+}
+
+// Uploads a single file named after its base name
+func uploadArtifact(file string, period time.Time) error {
+	if file == "" {
+		return nil
+	}
+	if err := uploadArtifacts(filepath.Dir(file), period, file); err != nil {
+		return fmt.Errorf("uploading %s: %w", file, err)
+	}
+	return nil
+}
+
+// Closes a report of the period written to the out file and uploads it,
+// reports written to stdout stay local
+func finishReport(w io.Writer, out string, period time.Time) error {
+	if out == "" {
+		return nil
+	}
+	if f, ok := w.(*os.File); ok {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return uploadArtifact(out, period)
+}
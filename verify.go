@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spooky-finn/piek-attendance-prod/infra"
+)
+
+// Compares events per day per card between the source MDB and the destination,
+// over the cards of the division the MDB belongs to
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	days := fs.Int("days", 30, "number of last days to compare")
+	fs.Parse(args)
+
+	calendar, err := infra.CalendarFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// event times are site wall clock, days start at its midnight
+	now := calendar.SiteTime(time.Now())
+	from := time.Date(now.Year(), now.Month(), now.Day()-*days, 0, 0, 0, 0, now.Location())
+	months := *days/30 + 1
+
+	exporter, err := newExporter(os.Getenv("ACCESS_MDB_PATH"))
+	if err != nil {
+		log.Fatalf("error opening MDB: %v", err)
+	}
+	events, err := exporter.ExportEventsFromDB(months)
+	if err != nil {
+		log.Fatalf("error exporting events: %v", err)
+	}
+	source := infra.CountEventsPerDay(events, from)
+	users, err := exporter.ExportUsersFromDB()
+	if err != nil {
+		log.Fatalf("error exporting users: %v", err)
+	}
+	// the destination holds the events of every division
+	known := make(map[string]bool)
+	for _, user := range users {
+		known[user.Card] = true
+	}
+	for _, event := range events {
+		if event.Card != "" {
+			known[event.Card] = true
+		}
+	}
+	cards := make([]string, 0, len(known))
+	for card := range known {
+		cards = append(cards, card)
+	}
+
+	db, err := connectDestination()
+	if err != nil {
+		log.Fatalf("error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	var destination map[infra.CardDay]int
+	err = db.ReadSnapshot(func(tx *sqlx.Tx) error {
+		destination, err = infra.EventCountsPerDay(tx, from, cards)
+		return err
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	mismatches := infra.CompareDayCounts(source, destination)
+	for _, m := range mismatches {
+		fmt.Printf("%s\t%s\tsource %d\tdestination %d\n", m.Day, m.Card, m.Source, m.Destination)
+	}
+	fmt.Printf("compared %d card days since %s, %d mismatches\n", len(source), from.Format("2006-01-02"), len(mismatches))
+
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+}
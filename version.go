@@ -0,0 +1,23 @@
+package main
+
+import "runtime/debug"
+
+// Set at build time with -ldflags "-X main.version=v1.2.3"
+var version string
+
+// Release version, or the VCS revision the binary was built from
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
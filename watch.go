@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Polls the MDB file and calls onChange once its size and modification time
+// have changed and then stayed the same for settle, so a sync never reads the
+// file while the controller software is still flushing events into it
+func watchFile(path string, poll, settle time.Duration, onChange func()) {
+	stat := func() (time.Time, int64, bool) {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("watch: %v", err)
+			return time.Time{}, 0, false
+		}
+		return info.ModTime(), info.Size(), true
+	}
+
+	lastMod, lastSize, _ := stat()
+	var changedAt time.Time
+	pending := false
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for range ticker.C {
+		mod, size, ok := stat()
+		if !ok {
+			continue
+		}
+		if !mod.Equal(lastMod) || size != lastSize {
+			lastMod, lastSize = mod, size
+			changedAt = time.Now()
+			pending = true
+			continue
+		}
+		if pending && time.Since(changedAt) >= settle {
+			pending = false
+			onChange()
+		}
+	}
+}